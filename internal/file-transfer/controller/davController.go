@@ -0,0 +1,97 @@
+package controller
+
+import (
+	"context"
+	"file-transfer/internal/file-transfer/service"
+	"file-transfer/pkg/common"
+	"file-transfer/pkg/log"
+	"file-transfer/pkg/token"
+	"net/http"
+	"sync"
+
+	"golang.org/x/net/webdav"
+)
+
+const DAV_PREFIX = "/dav"
+
+type DavController struct {
+	userService service.UserService
+	fs          webdav.FileSystem
+	locks       *davLocks
+	// the proxy strips the api base path, clients see it in hrefs and Destination headers
+	apiBase string
+}
+
+// davLocks keeps a lock system per user, every user has a tree of its own starting at /
+type davLocks struct {
+	mu      sync.Mutex
+	systems map[string]webdav.LockSystem
+}
+
+func (l *davLocks) get(userId string) webdav.LockSystem {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ls, ok := l.systems[userId]
+	if !ok {
+		ls = webdav.NewMemLS()
+		l.systems[userId] = ls
+	}
+	return ls
+}
+
+func NewDavController(userService service.UserService, fs webdav.FileSystem) DavController {
+	return DavController{
+		userService: userService,
+		fs:          fs,
+		locks:       &davLocks{systems: make(map[string]webdav.LockSystem)},
+		apiBase:     basePath("share.api-base-path", DEFAULT_API_BASE_PATH),
+	}
+}
+
+func (dc *DavController) handler(userId string) *webdav.Handler {
+	return &webdav.Handler{
+		Prefix:     dc.apiBase + DAV_PREFIX,
+		FileSystem: dc.fs,
+		LockSystem: dc.locks.get(userId),
+		Logger: func(r *http.Request, err error) {
+			if err != nil {
+				log.C(r.Context()).Infow("dav request failed", "method", r.Method, "path", r.URL.Path, "err", err)
+			}
+		},
+	}
+}
+
+// Serve authenticates the client with basic auth (app password or token as password) or a bearer token
+func (dc *DavController) Serve(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	userId, _ := ctx.Value(common.Trace_request_uid{}).(string)
+	username, _ := ctx.Value(common.Trace_request_user{}).(string)
+	if user, secret, ok := r.BasicAuth(); len(userId) < 1 && ok {
+		if userInfo, err := dc.userService.AuthenticateClient(ctx, user, secret); err == nil {
+			userId, username = userInfo.Id, userInfo.Username
+		}
+	} else if len(userId) < 1 {
+		userId, username, _ = token.ParseRequest(r)
+	}
+	if len(userId) < 1 {
+		w.Header().Set("WWW-Authenticate", `Basic realm="file-transfer", charset="UTF-8"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// the webdav handler passes the request context to the file system
+	reqCtx := context.WithValue(r.Context(), common.Trace_request_uid{}, userId)
+	reqCtx = context.WithValue(reqCtx, common.Trace_request_user{}, username)
+	reqCtx = context.WithValue(reqCtx, common.REQUEST_ID, ctx.Value(common.REQUEST_ID))
+	reqCtx = context.WithValue(reqCtx, common.RESOURCE_IP, ctx.Value(common.RESOURCE_IP))
+	// a body known to be too large isn't read, the file system stops a longer one at the limit
+	if r.Method == http.MethodPut && r.ContentLength >= service.MAX_SINGLE_FILE_SIZE {
+		http.Error(w, service.ErrDavTooLarge.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	// serve the path as the client sent it
+	davReq := r.WithContext(reqCtx)
+	u := *r.URL
+	u.Path, u.RawPath = dc.apiBase+r.URL.Path, ""
+	davReq.URL = &u
+	dc.handler(userId).ServeHTTP(w, davReq)
+}
@@ -0,0 +1,99 @@
+package controller
+
+import (
+	"context"
+	"file-transfer/internal/file-transfer/repo/repotest"
+	"file-transfer/internal/file-transfer/service"
+	"file-transfer/pkg/common"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+const davLockBody = `<?xml version="1.0" encoding="utf-8"?>
+<D:lockinfo xmlns:D="DAV:"><D:lockscope><D:exclusive/></D:lockscope><D:locktype><D:write/></D:locktype></D:lockinfo>`
+
+func newDavTestServer(t *testing.T) *httptest.Server {
+	viper.Set("upload.path", t.TempDir())
+	fileRepo := repotest.NewMemFileRepo()
+	dc := NewDavController(nil, service.NewDavFileSystem(fileRepo, service.NewFileService(fileRepo, nil, nil, nil)))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the user is told by the test, the auth middleware sets it
+		ctx := context.WithValue(r.Context(), common.Trace_request_uid{}, r.Header.Get("X-Test-User"))
+		dc.Serve(ctx, w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func davRequest(t *testing.T, srv *httptest.Server, user string, method string, path string, body string, header map[string]string) (*http.Response, string) {
+	req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+	assert.Nil(t, err)
+	req.Header.Set("X-Test-User", user)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, err := srv.Client().Do(req)
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	return resp, string(data)
+}
+
+func TestDavLocksPerUser(t *testing.T) {
+	srv := newDavTestServer(t)
+
+	resp, _ := davRequest(t, srv, "alice", "LOCK", "/dav/", davLockBody, map[string]string{"Depth": "infinity"})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	lockToken := resp.Header.Get("Lock-Token")
+	assert.NotEmpty(t, lockToken)
+
+	// the lock is on alice's tree, bob's root is another one
+	resp, _ = davRequest(t, srv, "bob", "PUT", "/dav/a.txt", "bob", nil)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	resp, _ = davRequest(t, srv, "alice", "PUT", "/dav/a.txt", "alice", nil)
+	assert.Equal(t, http.StatusLocked, resp.StatusCode)
+	resp, _ = davRequest(t, srv, "bob", "UNLOCK", "/dav/", "", map[string]string{"Lock-Token": lockToken})
+	assert.NotEqual(t, http.StatusNoContent, resp.StatusCode, "bob can't remove alice's lock")
+
+	resp, _ = davRequest(t, srv, "alice", "PUT", "/dav/a.txt", "alice", map[string]string{"If": "(" + lockToken + ")"})
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	resp, _ = davRequest(t, srv, "alice", "UNLOCK", "/dav/", "", map[string]string{"Lock-Token": lockToken})
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+}
+
+func TestDavBasePath(t *testing.T) {
+	viper.Set("share.api-base-path", "/files/api/")
+	defer viper.Set("share.api-base-path", DEFAULT_API_BASE_PATH)
+	srv := newDavTestServer(t)
+
+	resp, _ := davRequest(t, srv, "alice", "PUT", "/dav/a.txt", "hello", nil)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	resp, body := davRequest(t, srv, "alice", "PROPFIND", "/dav/", "", map[string]string{"Depth": "1"})
+	assert.Equal(t, http.StatusMultiStatus, resp.StatusCode)
+	assert.Contains(t, body, "<D:href>/files/api/dav/a.txt</D:href>")
+
+	// the destination is the url the client knows
+	resp, _ = davRequest(t, srv, "alice", "MOVE", "/dav/a.txt", "", map[string]string{"Destination": srv.URL + "/files/api/dav/b.txt"})
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	resp, body = davRequest(t, srv, "alice", "GET", "/dav/b.txt", "", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "hello", body)
+}
+
+func TestDavPutTooLarge(t *testing.T) {
+	srv := newDavTestServer(t)
+	maxSize := service.MAX_SINGLE_FILE_SIZE
+	service.MAX_SINGLE_FILE_SIZE = 6
+	defer func() { service.MAX_SINGLE_FILE_SIZE = maxSize }()
+
+	resp, _ := davRequest(t, srv, "alice", "PUT", "/dav/big.txt", "hello world", nil)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+	resp, _ = davRequest(t, srv, "alice", "PUT", "/dav/small.txt", "hello", nil)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+}
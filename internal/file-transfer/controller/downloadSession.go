@@ -0,0 +1,46 @@
+package controller
+
+import (
+	"file-transfer/internal/file-transfer/service"
+	"net/http"
+	"strings"
+)
+
+const (
+	// the download session of a limited link, for clients which don't keep cookies
+	HEADER_DOWNLOAD_SESSION = "X-Download-Session"
+	DOWNLOAD_SESSION_COOKIE = "ft_download"
+)
+
+// downloadSession reads the session an earlier request of the download was given. Only range
+// requests, which resume or split the download, are in the session, a whole new download isn't
+func downloadSession(r *http.Request) string {
+	if len(r.Header.Get("Range")) == 0 {
+		return ""
+	}
+	if session := r.Header.Get(HEADER_DOWNLOAD_SESSION); len(session) > 0 {
+		return session
+	}
+	if cookie, err := r.Cookie(DOWNLOAD_SESSION_COOKIE); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+// setDownloadSession gives the client a new session. The browser sends the cookie back for the
+// same file only, so the downloads of the other files of a collection start their own
+func setDownloadSession(w http.ResponseWriter, r *http.Request, session string) {
+	if len(session) == 0 {
+		return
+	}
+	w.Header().Set(HEADER_DOWNLOAD_SESSION, session)
+	http.SetCookie(w, &http.Cookie{
+		Name:     DOWNLOAD_SESSION_COOKIE,
+		Value:    session,
+		Path:     basePath("share.api-base-path", DEFAULT_API_BASE_PATH) + r.URL.Path,
+		MaxAge:   int(service.DOWNLOAD_SESSION_EXPIRE.Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(requestOrigin(r), "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
@@ -0,0 +1,20 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDownloadSession(t *testing.T) {
+	r := httptest.NewRequest("GET", "/fs/key?session=s1", nil)
+	r.AddCookie(&http.Cookie{Name: DOWNLOAD_SESSION_COOKIE, Value: "s2"})
+	assert.Equal(t, "", downloadSession(r), "a whole download uses up the link")
+
+	r.Header.Set("Range", "bytes=100-")
+	assert.Equal(t, "s2", downloadSession(r), "the session isn't read from the url")
+	r.Header.Set(HEADER_DOWNLOAD_SESSION, "s3")
+	assert.Equal(t, "s3", downloadSession(r))
+}
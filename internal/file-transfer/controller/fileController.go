@@ -6,9 +6,7 @@ import (
 	v1 "file-transfer/pkg/api/v1"
 	"file-transfer/pkg/common"
 	"file-transfer/pkg/errno"
-	"file-transfer/pkg/log"
 	"file-transfer/pkg/util"
-	"fmt"
 	"net/http"
 	"strconv"
 
@@ -24,29 +22,50 @@ func NewFileController(fileService service.FileService) FileController {
 }
 
 func (fc *FileController) UploadFile(ctx context.Context, w http.ResponseWriter, r *http.Request) {
-	file, head, err := r.FormFile("file")
+	reader, err := r.MultipartReader()
 	if err != nil {
 		http.Error(w, "Failed to read request body", http.StatusBadRequest)
 		return
 	}
-	defer file.Close()
-	if cl, err := strconv.Atoi(r.Header.Get("Content-Length")); err == nil {
-		if cl > int(service.MAX_SINGLE_FILE_SIZE) {
-			msg := fmt.Sprintf("file size exceed %d", service.MAX_SINGLE_FILE_SIZE)
-			log.C(ctx).Warnw("upload failed, " + msg)
-			http.Error(w, msg, http.StatusBadRequest)
+	uploadId, err := readUploadId(r)
+	if err != nil {
+		errno.WriteErrorResponse(ctx, w, err)
+		return
+	}
+	folder, err := util.NormalizeFolder(r.URL.Query().Get("folder"))
+	if err != nil {
+		errno.WriteErrorResponse(ctx, w, errno.ErrInvalidParameter)
+		return
+	}
+	var expire int64
+	if e := r.URL.Query().Get("expire"); len(e) > 0 {
+		expire, err = strconv.ParseInt(e, 10, 64)
+		if err != nil || expire < 0 {
+			errno.WriteErrorResponse(ctx, w, errno.ErrInvalidParameter)
 			return
 		}
 	}
+	param := &v1.FileUploadParam{
+		UserId:   ctx.Value(common.Trace_request_uid{}).(string),
+		UploadId: uploadId,
+		Folder:   folder,
+		Expire:   expire,
+	}
+	if strip := r.URL.Query().Get("stripMetadata"); len(strip) > 0 {
+		value, err := strconv.ParseBool(strip)
+		if err != nil {
+			errno.WriteErrorResponse(ctx, w, errno.ErrInvalidParameter)
+			return
+		}
+		param.StripMetadata = &value
+	}
 
-	userId := ctx.Value(common.Trace_request_uid{}).(string)
-
-	err = fc.fileService.UploadFile(ctx, file, head, userId)
+	results, err := fc.fileService.UploadFiles(ctx, reader, param)
 	if err != nil {
 		errno.WriteErrorResponse(ctx, w, err)
 		return
 	}
-	w.WriteHeader(http.StatusOK)
+	errno.WriteResponse(ctx, w, results)
 }
 
 func (fc *FileController) QueryUserFile(ctx context.Context, w http.ResponseWriter, r *http.Request) {
@@ -79,10 +98,10 @@ func (fc *FileController) DownloadFile(ctx context.Context, w http.ResponseWrite
 	userId := ctx.Value(common.Trace_request_uid{}).(string)
 	data, err := fc.fileService.DownloadFile(ctx, fId, userId)
 	if err != nil {
-		errno.WriteErrorResponse(ctx, w, err)
+		writeDownloadError(ctx, w, err)
 		return
 	}
-	util.DownloadFileHandler(ctx, w, data)
+	util.DownloadFileHandler(ctx, w, r, data)
 
 }
 
@@ -103,12 +122,12 @@ func (fc *FileController) Share(ctx context.Context, w http.ResponseWriter, r *h
 	}
 	userId := ctx.Value(common.Trace_request_uid{}).(string)
 
-	url, err := fc.fileService.Share(ctx, mId, userId, shareRequest)
+	link, err := fc.fileService.Share(ctx, mId, userId, shareRequest)
 	if err != nil {
 		errno.WriteErrorResponse(ctx, w, err)
 		return
 	}
-	errno.WriteResponse(ctx, w, url)
+	errno.WriteResponse(ctx, w, fillShareLink(r, link))
 }
 
 func (fc *FileController) ReadShare(ctx context.Context, w http.ResponseWriter, r *http.Request) {
@@ -118,12 +137,84 @@ func (fc *FileController) ReadShare(ctx context.Context, w http.ResponseWriter,
 		return
 	}
 
-	data, err := fc.fileService.ReadShare(ctx, key)
+	data, err := fc.fileService.ReadShare(ctx, key, sharePassword(r), downloadSession(r))
+	if err != nil {
+		writeDownloadError(ctx, w, err)
+		return
+	}
+	setDownloadSession(w, r, data.Session)
+	sent := util.DownloadFileHandler(ctx, w, r, data)
+	fc.fileService.RecordShareRead(ctx, key, data.Resumed, sent)
+}
+
+func (fc *FileController) PreviewFile(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	fId := mux.Vars(r)["fId"]
+	if len(fId) < 1 {
+		errno.WriteErrorResponse(ctx, w, &errno.Errno{Message: "invalid"})
+		return
+	}
+	param, err := readPreviewParam(r)
+	if err != nil {
+		errno.WriteErrorResponse(ctx, w, err)
+		return
+	}
+	userId := ctx.Value(common.Trace_request_uid{}).(string)
+	result, err := fc.fileService.PreviewFile(ctx, fId, userId, param)
+	if err != nil {
+		errno.WriteErrorResponse(ctx, w, err)
+		return
+	}
+	errno.WriteResponse(ctx, w, result)
+}
+
+func (fc *FileController) PreviewShare(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	key := mux.Vars(r)["key"]
+	if len(key) < 1 {
+		errno.WriteErrorResponse(ctx, w, &errno.Errno{Message: "invalid"})
+		return
+	}
+	param, err := readPreviewParam(r)
 	if err != nil {
 		errno.WriteErrorResponse(ctx, w, err)
 		return
 	}
-	util.DownloadFileHandler(ctx, w, data)
+	result, err := fc.fileService.PreviewShare(ctx, key, sharePassword(r), param)
+	if err != nil {
+		writeShareError(ctx, w, err)
+		return
+	}
+	errno.WriteResponse(ctx, w, result)
+}
+
+// readPreviewParam reads ?size=KB&mode=head|tail&offset=&charset=&highlight=
+func readPreviewParam(r *http.Request) (*v1.FilePreviewParam, error) {
+	query := r.URL.Query()
+	param := &v1.FilePreviewParam{Offset: -1, Charset: query.Get("charset")}
+	switch query.Get("mode") {
+	case "", "head":
+		param.Offset = 0
+	case "tail":
+		param.Tail = true
+	default:
+		return nil, errno.ErrInvalidParameter
+	}
+	var err error
+	if s := query.Get("size"); len(s) > 0 {
+		if param.Size, err = strconv.ParseInt(s, 10, 64); err != nil || param.Size < 1 {
+			return nil, errno.ErrInvalidParameter
+		}
+	}
+	if o := query.Get("offset"); len(o) > 0 {
+		if param.Offset, err = strconv.ParseInt(o, 10, 64); err != nil || param.Offset < 0 {
+			return nil, errno.ErrInvalidParameter
+		}
+	}
+	if h := query.Get("highlight"); len(h) > 0 {
+		if param.Highlight, err = strconv.ParseBool(h); err != nil {
+			return nil, errno.ErrInvalidParameter
+		}
+	}
+	return param, nil
 }
 
 func (fc *FileController) DeleteFile(ctx context.Context, w http.ResponseWriter, r *http.Request) {
@@ -141,3 +232,142 @@ func (fc *FileController) DeleteFile(ctx context.Context, w http.ResponseWriter,
 	}
 	errno.WriteResponse(ctx, w, nil)
 }
+
+func (fc *FileController) SetExpire(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	fId := mux.Vars(r)["fId"]
+	if len(fId) < 1 {
+		errno.WriteErrorResponse(ctx, w, &errno.Errno{Message: "invalid"})
+		return
+	}
+	request := &v1.FileExpireParam{}
+	err := util.HttpReadBody(r, request)
+	if err != nil {
+		errno.WriteErrorResponse(ctx, w, errno.ErrInvalidParameter)
+		return
+	}
+	userId := ctx.Value(common.Trace_request_uid{}).(string)
+	expireAt, err := fc.fileService.SetFileExpire(ctx, fId, userId, request)
+	if err != nil {
+		errno.WriteErrorResponse(ctx, w, err)
+		return
+	}
+	errno.WriteResponse(ctx, w, &v1.FileExpireResponse{ExpireAt: expireAt})
+}
+
+func (fc *FileController) ShareCollection(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	shareRequest := &v1.CollectionShareParam{
+		MessageShareParam: v1.MessageShareParam{
+			ExpireType: common.SHARE_EXPIRE_TYPE_DURATION,
+			Expire:     1}}
+	err := util.HttpReadBody(r, shareRequest)
+	if err != nil {
+		errno.WriteErrorResponse(ctx, w, err)
+		return
+	}
+	userId := ctx.Value(common.Trace_request_uid{}).(string)
+
+	link, err := fc.fileService.ShareCollection(ctx, userId, shareRequest)
+	if err != nil {
+		errno.WriteErrorResponse(ctx, w, err)
+		return
+	}
+	errno.WriteResponse(ctx, w, fillShareLink(r, link))
+}
+
+func (fc *FileController) ReadCollection(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	key := mux.Vars(r)["key"]
+	if len(key) < 1 {
+		errno.WriteErrorResponse(ctx, w, &errno.Errno{Message: "invalid"})
+		return
+	}
+	result, err := fc.fileService.ReadCollection(ctx, key, sharePassword(r))
+	if err != nil {
+		writeShareError(ctx, w, err)
+		return
+	}
+	errno.WriteResponse(ctx, w, result)
+}
+
+func (fc *FileController) ReadCollectionFile(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	key, fId := vars["key"], vars["fId"]
+	if len(key) < 1 || len(fId) < 1 {
+		errno.WriteErrorResponse(ctx, w, &errno.Errno{Message: "invalid"})
+		return
+	}
+	data, err := fc.fileService.ReadCollectionFile(ctx, key, sharePassword(r), downloadSession(r), fId)
+	if err != nil {
+		writeDownloadError(ctx, w, err)
+		return
+	}
+	setDownloadSession(w, r, data.Session)
+	sent := util.DownloadFileHandler(ctx, w, r, data)
+	fc.fileService.RecordShareRead(ctx, key, data.Resumed, sent)
+}
+
+func (fc *FileController) ReadCollectionZip(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	key := mux.Vars(r)["key"]
+	if len(key) < 1 {
+		errno.WriteErrorResponse(ctx, w, &errno.Errno{Message: "invalid"})
+		return
+	}
+	data, err := fc.fileService.ReadCollectionZip(ctx, key, sharePassword(r), downloadSession(r))
+	if err != nil {
+		writeDownloadError(ctx, w, err)
+		return
+	}
+	setDownloadSession(w, r, data.Session)
+	sent := util.ZipFileHandler(ctx, w, data)
+	fc.fileService.RecordShareRead(ctx, key, data.Resumed, sent)
+}
+
+func (fc *FileController) CreateFileRequest(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	request := &v1.FileRequestParam{}
+	err := util.HttpReadBody(r, request)
+	if err != nil {
+		errno.WriteErrorResponse(ctx, w, err)
+		return
+	}
+	userId := ctx.Value(common.Trace_request_uid{}).(string)
+
+	link, err := fc.fileService.CreateFileRequest(ctx, userId, request)
+	if err != nil {
+		errno.WriteErrorResponse(ctx, w, err)
+		return
+	}
+	errno.WriteResponse(ctx, w, fillShareLink(r, link))
+}
+
+func (fc *FileController) ReadFileRequest(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	key := mux.Vars(r)["key"]
+	if len(key) < 1 {
+		errno.WriteErrorResponse(ctx, w, &errno.Errno{Message: "invalid"})
+		return
+	}
+	result, err := fc.fileService.ReadFileRequest(ctx, key, sharePassword(r))
+	if err != nil {
+		writeShareError(ctx, w, err)
+		return
+	}
+	errno.WriteResponse(ctx, w, result)
+}
+
+// UploadFileRequest takes the password from the header only, the body is the multipart stream
+func (fc *FileController) UploadFileRequest(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	key := mux.Vars(r)["key"]
+	if len(key) < 1 {
+		errno.WriteErrorResponse(ctx, w, &errno.Errno{Message: "invalid"})
+		return
+	}
+	reader, err := r.MultipartReader()
+	if err != nil {
+		errno.WriteErrorResponse(ctx, w, errno.ErrInvalidParameter)
+		return
+	}
+	results, err := fc.fileService.UploadFileRequest(ctx, key, r.Header.Get(HEADER_SHARE_PASSWORD), reader)
+	if err != nil {
+		writeShareError(ctx, w, err)
+		return
+	}
+	errno.WriteResponse(ctx, w, results)
+}
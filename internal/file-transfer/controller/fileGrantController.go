@@ -0,0 +1,93 @@
+package controller
+
+import (
+	"context"
+	"file-transfer/internal/file-transfer/service"
+	v1 "file-transfer/pkg/api/v1"
+	"file-transfer/pkg/common"
+	"file-transfer/pkg/errno"
+	"file-transfer/pkg/util"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+type FileGrantController struct {
+	fileGrantService service.FileGrantService
+}
+
+func NewFileGrantController(fileGrantService service.FileGrantService) FileGrantController {
+	return FileGrantController{fileGrantService: fileGrantService}
+}
+
+// Grant shares an own file or folder with another user by username
+func (gc *FileGrantController) Grant(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	param := &v1.FileGrantParam{}
+	if err := util.HttpReadBody(r, param); err != nil {
+		errno.WriteErrorResponse(ctx, w, err)
+		return
+	}
+	userId := ctx.Value(common.Trace_request_uid{}).(string)
+	info, err := gc.fileGrantService.Grant(ctx, userId, param)
+	if err != nil {
+		errno.WriteErrorResponse(ctx, w, err)
+		return
+	}
+	errno.WriteResponse(ctx, w, info)
+}
+
+func (gc *FileGrantController) ListGrants(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	userId := ctx.Value(common.Trace_request_uid{}).(string)
+	results, err := gc.fileGrantService.ListGrants(ctx, userId)
+	if err != nil {
+		errno.WriteErrorResponse(ctx, w, err)
+		return
+	}
+	errno.WriteResponse(ctx, w, results)
+}
+
+func (gc *FileGrantController) RevokeGrant(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	userId := ctx.Value(common.Trace_request_uid{}).(string)
+	if err := gc.fileGrantService.RevokeGrant(ctx, userId, mux.Vars(r)["gId"]); err != nil {
+		errno.WriteErrorResponse(ctx, w, err)
+		return
+	}
+	errno.WriteResponse(ctx, w, nil)
+}
+
+// SharedWithMe lists what other users shared with the user, the files are downloaded with GET /file/{fId}
+func (gc *FileGrantController) SharedWithMe(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	userId := ctx.Value(common.Trace_request_uid{}).(string)
+	results, err := gc.fileGrantService.SharedWithMe(ctx, userId)
+	if err != nil {
+		errno.WriteErrorResponse(ctx, w, err)
+		return
+	}
+	errno.WriteResponse(ctx, w, results)
+}
+
+func (gc *FileGrantController) SharedFiles(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	userId := ctx.Value(common.Trace_request_uid{}).(string)
+	results, err := gc.fileGrantService.SharedFiles(ctx, userId, mux.Vars(r)["gId"])
+	if err != nil {
+		errno.WriteErrorResponse(ctx, w, err)
+		return
+	}
+	errno.WriteResponse(ctx, w, results)
+}
+
+// UploadShared uploads into a folder shared with write access, "folder" is relative to the shared folder
+func (gc *FileGrantController) UploadShared(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		errno.WriteErrorResponse(ctx, w, errno.ErrInvalidParameter)
+		return
+	}
+	userId := ctx.Value(common.Trace_request_uid{}).(string)
+	results, err := gc.fileGrantService.UploadShared(ctx, userId, mux.Vars(r)["gId"], r.URL.Query().Get("folder"), reader)
+	if err != nil {
+		errno.WriteErrorResponse(ctx, w, err)
+		return
+	}
+	errno.WriteResponse(ctx, w, results)
+}
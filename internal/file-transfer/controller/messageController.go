@@ -54,6 +54,25 @@ func (mc *MessageController) readMessage(ctx context.Context, w http.ResponseWri
 	errno.WriteResponse(ctx, w, result)
 }
 
+// SearchMessage takes the query and the page in the body, like ReadMessageByPage
+func (mc *MessageController) SearchMessage(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	query := &v1.MessageSearchQuery{
+		PageNum:  1,
+		PageSize: 10,
+	}
+	if err := util.HttpReadBody(r, query); err != nil {
+		errno.WriteErrorResponse(ctx, w, errno.ErrInvalidParameter)
+		return
+	}
+	query.UserId = ctx.Value(common.Trace_request_uid{}).(string)
+	result, err := mc.service.SearchMessage(ctx, query)
+	if err != nil {
+		errno.WriteErrorResponse(ctx, w, err)
+		return
+	}
+	errno.WriteResponse(ctx, w, result)
+}
+
 func (mc *MessageController) SendMessage(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	messageRequest := &v1.MessageSendRequest{}
 	err := util.HttpReadBody(r, messageRequest)
@@ -109,12 +128,12 @@ func (mc *MessageController) ShareMessage(ctx context.Context, w http.ResponseWr
 	}
 	userId := ctx.Value(common.Trace_request_uid{}).(string)
 
-	url, err := mc.service.ShareMessage(ctx, mId, userId, shareRequest)
+	link, err := mc.service.ShareMessage(ctx, mId, userId, shareRequest)
 	if err != nil {
 		errno.WriteErrorResponse(ctx, w, err)
 		return
 	}
-	errno.WriteResponse(ctx, w, url)
+	errno.WriteResponse(ctx, w, fillShareLink(r, link))
 }
 
 func (mc *MessageController) ReadShareMessage(ctx context.Context, w http.ResponseWriter, r *http.Request) {
@@ -124,10 +143,59 @@ func (mc *MessageController) ReadShareMessage(ctx context.Context, w http.Respon
 		return
 	}
 
-	msg, err := mc.service.ReadShareMessage(ctx, key)
+	msg, err := mc.service.ReadShareMessage(ctx, key, sharePassword(r))
 	if err != nil {
-		errno.WriteErrorResponse(ctx, w, err)
+		writeShareError(ctx, w, err)
 		return
 	}
 	errno.WriteResponse(ctx, w, msg)
 }
+
+func (mc *MessageController) CreateSecretNote(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	param := &v1.SecretNoteParam{}
+	if err := util.HttpReadBody(r, param); err != nil {
+		errno.WriteErrorResponse(ctx, w, errno.ErrInvalidParameter)
+		return
+	}
+	userId := ctx.Value(common.Trace_request_uid{}).(string)
+	link, err := mc.service.CreateSecretNote(ctx, userId, param)
+	if err != nil {
+		errno.WriteErrorResponse(ctx, w, err)
+		return
+	}
+	fillShareLink(r, &link.ShareLink)
+	link.PageUrl += "#" + link.Secret
+	errno.WriteResponse(ctx, w, link)
+}
+
+// ReadSecretNote takes the key in the body, not in the url, so it isn't in any access log.
+// The passphrase may come in X-Share-Password too
+func (mc *MessageController) ReadSecretNote(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	request := &v1.SecretNoteReadRequest{}
+	if err := util.HttpReadBody(r, request); err != nil {
+		errno.WriteErrorResponse(ctx, w, errno.ErrInvalidParameter)
+		return
+	}
+	if len(request.Passphrase) == 0 {
+		request.Passphrase = r.Header.Get(HEADER_SHARE_PASSWORD)
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	info, err := mc.service.ReadSecretNote(ctx, mux.Vars(r)["key"], request)
+	if err != nil {
+		writeShareError(ctx, w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	errno.WriteResponse(ctx, w, info)
+}
+
+func (mc *MessageController) SecretNoteStatus(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	userId := ctx.Value(common.Trace_request_uid{}).(string)
+	result, err := mc.service.SecretNoteStatus(ctx, userId, mux.Vars(r)["key"])
+	if err != nil {
+		errno.WriteErrorResponse(ctx, w, err)
+		return
+	}
+	errno.WriteResponse(ctx, w, result)
+}
@@ -0,0 +1,84 @@
+package controller
+
+import (
+	"context"
+	v1 "file-transfer/pkg/api/v1"
+	"file-transfer/pkg/common"
+	"file-transfer/pkg/errno"
+	"file-transfer/pkg/util"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+var errIfMatchRequired = &errno.Errno{HTTP: http.StatusPreconditionRequired, Code: "PreconditionRequired.IfMatch", Message: "If-Match with the version of the message is required"}
+
+// ifMatchVersion reads the version from If-Match, sent as the ETag "3" or plain 3
+func ifMatchVersion(r *http.Request) (int64, error) {
+	value := strings.TrimSpace(r.Header.Get("If-Match"))
+	value = strings.Trim(strings.TrimPrefix(value, "W/"), `"`)
+	version, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || version < 0 {
+		return 0, errIfMatchRequired
+	}
+	return version, nil
+}
+
+func writeMessage(ctx context.Context, w http.ResponseWriter, msg *v1.MessageResponse) {
+	w.Header().Set("ETag", `"`+strconv.FormatInt(msg.Version, 10)+`"`)
+	errno.WriteResponse(ctx, w, msg)
+}
+
+// UpdateMessage edits the text, If-Match names the version the edit was made on
+func (mc *MessageController) UpdateMessage(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	version, err := ifMatchVersion(r)
+	if err != nil {
+		errno.WriteErrorResponse(ctx, w, err)
+		return
+	}
+	messageRequest := &v1.MessageSendRequest{}
+	if err := util.HttpReadBody(r, messageRequest); err != nil {
+		errno.WriteErrorResponse(ctx, w, err)
+		return
+	}
+	userId := ctx.Value(common.Trace_request_uid{}).(string)
+	msg, err := mc.service.UpdateMessage(ctx, mux.Vars(r)["mId"], userId, version, messageRequest)
+	if err != nil {
+		errno.WriteErrorResponse(ctx, w, err)
+		return
+	}
+	writeMessage(ctx, w, msg)
+}
+
+func (mc *MessageController) MessageRevisions(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	userId := ctx.Value(common.Trace_request_uid{}).(string)
+	results, err := mc.service.MessageRevisions(ctx, mux.Vars(r)["mId"], userId)
+	if err != nil {
+		errno.WriteErrorResponse(ctx, w, err)
+		return
+	}
+	errno.WriteResponse(ctx, w, results)
+}
+
+// RestoreMessage is an edit back to the text of a revision, with If-Match like any edit
+func (mc *MessageController) RestoreMessage(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	version, err := ifMatchVersion(r)
+	if err != nil {
+		errno.WriteErrorResponse(ctx, w, err)
+		return
+	}
+	revision, err := strconv.ParseInt(mux.Vars(r)["version"], 10, 64)
+	if err != nil {
+		errno.WriteErrorResponse(ctx, w, errno.ErrInvalidParameter)
+		return
+	}
+	userId := ctx.Value(common.Trace_request_uid{}).(string)
+	msg, err := mc.service.RestoreMessage(ctx, mux.Vars(r)["mId"], userId, version, revision)
+	if err != nil {
+		errno.WriteErrorResponse(ctx, w, err)
+		return
+	}
+	writeMessage(ctx, w, msg)
+}
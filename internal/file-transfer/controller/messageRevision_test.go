@@ -0,0 +1,24 @@
+package controller
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIfMatchVersion(t *testing.T) {
+	for value, want := range map[string]int64{`"3"`: 3, `W/"4"`: 4, "5": 5, ` "0" `: 0} {
+		r := httptest.NewRequest("PATCH", "/msg/m1", nil)
+		r.Header.Set("If-Match", value)
+		version, err := ifMatchVersion(r)
+		assert.Nil(t, err, value)
+		assert.Equal(t, want, version, value)
+	}
+	for _, value := range []string{"", "*", `"-1"`, `"a"`} {
+		r := httptest.NewRequest("PATCH", "/msg/m1", nil)
+		r.Header.Set("If-Match", value)
+		_, err := ifMatchVersion(r)
+		assert.Equal(t, errIfMatchRequired, err, value)
+	}
+}
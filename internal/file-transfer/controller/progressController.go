@@ -0,0 +1,108 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"file-transfer/internal/file-transfer/service"
+	"file-transfer/pkg/common"
+	"file-transfer/pkg/errno"
+	"file-transfer/pkg/log"
+	"fmt"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	UPLOAD_ID_PARAM  = "uploadId"
+	UPLOAD_ID_HEADER = "X-Upload-Id"
+)
+
+var (
+	uploadIdPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+	SSE_HEARTBEAT_INTERVAL time.Duration = 15 * time.Second
+)
+
+type ProgressController struct {
+	service service.ProgressService
+}
+
+func NewProgressController(service service.ProgressService) ProgressController {
+	return ProgressController{service: service}
+}
+
+// readUploadId reads the optional client generated upload id from query or header
+func readUploadId(r *http.Request) (string, error) {
+	uploadId := r.URL.Query().Get(UPLOAD_ID_PARAM)
+	if len(uploadId) < 1 {
+		uploadId = r.Header.Get(UPLOAD_ID_HEADER)
+	}
+	if len(uploadId) > 0 && !uploadIdPattern.MatchString(uploadId) {
+		return "", &errno.Errno{HTTP: http.StatusBadRequest, Code: "InvalidParameter.UploadId", Message: "invalid upload id"}
+	}
+	return uploadId, nil
+}
+
+// UploadProgress streams upload events as Server-Sent Events until every file of the request is handled
+func (pc *ProgressController) UploadProgress(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	uploadId := mux.Vars(r)[UPLOAD_ID_PARAM]
+	if !uploadIdPattern.MatchString(uploadId) {
+		errno.WriteErrorResponse(ctx, w, &errno.Errno{HTTP: http.StatusBadRequest, Code: "InvalidParameter.UploadId", Message: "invalid upload id"})
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		errno.WriteErrorResponse(ctx, w, errno.InternalServerError)
+		return
+	}
+	userId := ctx.Value(common.Trace_request_uid{}).(string)
+
+	// the middleware context is not bound to the connection
+	reqCtx := r.Context()
+	events, closeFn, err := pc.service.Subscribe(reqCtx, userId, uploadId)
+	if err != nil {
+		errno.WriteErrorResponse(ctx, w, err)
+		return
+	}
+	defer closeFn()
+
+	// the stream lives longer than the server write timeout
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		log.C(ctx).Debugw("clear write deadline failed", "err", err)
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	// nginx should not buffer the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(SSE_HEARTBEAT_INTERVAL)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-reqCtx.Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Stage, data)
+			flusher.Flush()
+			if event.Stage == common.UPLOAD_STAGE_COMPLETE {
+				return
+			}
+		}
+	}
+}
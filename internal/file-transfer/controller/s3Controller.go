@@ -0,0 +1,378 @@
+package controller
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"file-transfer/internal/file-transfer/service"
+	v1 "file-transfer/pkg/api/v1"
+	"file-transfer/pkg/common"
+	"file-transfer/pkg/errno"
+	"file-transfer/pkg/log"
+	"file-transfer/pkg/sigv4"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	S3_PREFIX = "/s3"
+
+	S3_MAX_KEYS          = 1000
+	S3_MAX_COMPLETE_BODY = 1 << 20
+)
+
+var (
+	errS3AccessDenied          = &errno.Errno{HTTP: http.StatusForbidden, Code: "AccessDenied", Message: "Access Denied"}
+	errS3SignatureDoesNotMatch = &errno.Errno{HTTP: http.StatusForbidden, Code: "SignatureDoesNotMatch", Message: "The request signature we calculated does not match the signature you provided."}
+	errS3RequestTimeTooSkewed  = &errno.Errno{HTTP: http.StatusForbidden, Code: "RequestTimeTooSkewed", Message: "The difference between the request time and the server's time is too large."}
+	errS3ContentSHA256Mismatch = &errno.Errno{HTTP: http.StatusBadRequest, Code: "XAmzContentSHA256Mismatch", Message: "The provided 'x-amz-content-sha256' header does not match what was computed."}
+	errS3IncompleteBody        = &errno.Errno{HTTP: http.StatusBadRequest, Code: "IncompleteBody", Message: "You did not provide the number of bytes specified by the Content-Length HTTP header."}
+	errS3MalformedXML          = &errno.Errno{HTTP: http.StatusBadRequest, Code: "MalformedXML", Message: "The XML you provided was not well-formed."}
+	errS3InvalidArgument       = &errno.Errno{HTTP: http.StatusBadRequest, Code: "InvalidArgument", Message: "Invalid argument."}
+	errS3NotImplemented        = &errno.Errno{HTTP: http.StatusNotImplemented, Code: "NotImplemented", Message: "A header or query you provided implies functionality that is not implemented."}
+)
+
+type S3Controller struct {
+	service service.S3Service
+}
+
+func NewS3Controller(s3Service service.S3Service) S3Controller {
+	return S3Controller{service: s3Service}
+}
+
+// s3Body keeps the error of the signed body, the upload only reports that it failed
+type s3Body struct {
+	r   io.Reader
+	err error
+}
+
+func (b *s3Body) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	if err != nil && err != io.EOF {
+		b.err = err
+	}
+	return n, err
+}
+
+// bodyError prefers the reason the signed body was rejected over err
+func (b *s3Body) bodyError(err error) error {
+	switch b.err {
+	case nil:
+		return err
+	case sigv4.ErrContentMismatch:
+		return errS3ContentSHA256Mismatch
+	case sigv4.ErrSignatureMismatch:
+		return errS3SignatureDoesNotMatch
+	}
+	return errS3IncompleteBody
+}
+
+// Serve is the S3 compatible API with path style addressing: /s3/{bucket}/{key}
+func (sc *S3Controller) Serve(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if rId, ok := ctx.Value(common.REQUEST_ID).(string); ok {
+		w.Header().Set("x-amz-request-id", rId)
+	}
+	userId, body, err := sc.authenticate(ctx, r)
+	if err != nil {
+		writeS3Error(ctx, w, r, err)
+		return
+	}
+	ctx = context.WithValue(ctx, common.Trace_request_uid{}, userId)
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, S3_PREFIX), "/"), "/")
+	query := r.URL.Query()
+	switch {
+	case bucket == "" && r.Method == http.MethodGet:
+		sc.listBuckets(ctx, w, r, userId)
+	case bucket == "":
+		writeS3Error(ctx, w, r, errS3NotImplemented)
+	case key == "" && r.Method == http.MethodGet:
+		sc.listObjects(ctx, w, r, userId, bucket)
+	case key == "" && r.Method == http.MethodHead:
+		err = sc.service.HeadBucket(ctx, userId, bucket)
+		writeS3Status(ctx, w, r, err, http.StatusOK)
+	case key == "" && r.Method == http.MethodPut:
+		err = sc.service.CreateBucket(ctx, userId, bucket)
+		if err == nil {
+			w.Header().Set("Location", "/"+bucket)
+		}
+		writeS3Status(ctx, w, r, err, http.StatusOK)
+	case key == "":
+		writeS3Error(ctx, w, r, errS3NotImplemented)
+	case r.Method == http.MethodPut && query.Has("uploadId"):
+		sc.uploadPart(ctx, w, r, userId, bucket, key, body)
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		writeS3Error(ctx, w, r, errS3NotImplemented)
+	case r.Method == http.MethodPut:
+		object, err := sc.service.PutObject(ctx, userId, bucket, key, body)
+		if err != nil {
+			writeS3Error(ctx, w, r, body.bodyError(err))
+			return
+		}
+		w.Header().Set("ETag", quoteETag(object.ETag))
+		w.WriteHeader(http.StatusOK)
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		sc.createMultipartUpload(ctx, w, r, userId, bucket, key)
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		sc.completeMultipartUpload(ctx, w, r, userId, bucket, key, body)
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		err = sc.service.AbortMultipartUpload(ctx, userId, bucket, key, query.Get("uploadId"))
+		writeS3Status(ctx, w, r, err, http.StatusNoContent)
+	case r.Method == http.MethodDelete:
+		err = sc.service.DeleteObject(ctx, userId, bucket, key)
+		writeS3Status(ctx, w, r, err, http.StatusNoContent)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		sc.getObject(ctx, w, r, userId, bucket, key)
+	default:
+		writeS3Error(ctx, w, r, errS3NotImplemented)
+	}
+}
+
+// authenticate checks the SigV4 header signature, the returned body checks the payload signature while it is read
+func (sc *S3Controller) authenticate(ctx context.Context, r *http.Request) (string, *s3Body, error) {
+	auth, err := sigv4.ParseAuthorization(r.Header.Get("Authorization"))
+	if err != nil {
+		return "", nil, errS3AccessDenied
+	}
+	userId, secret, err := sc.service.LookupAccessKey(ctx, auth.AccessKeyId)
+	if err != nil {
+		return "", nil, err
+	}
+	// the client signed the path it sent, before the proxy stripped the api base path
+	signed := r.WithContext(r.Context())
+	u := *r.URL
+	u.Path, u.RawPath = s3Resource(r), ""
+	signed.URL = &u
+	switch err := sigv4.Verify(signed, auth, secret, time.Now()); err {
+	case nil:
+	case sigv4.ErrSignatureMismatch:
+		log.C(ctx).Warnw("s3 signature mismatch", "accessKeyId", auth.AccessKeyId)
+		return "", nil, errS3SignatureDoesNotMatch
+	case sigv4.ErrRequestTimeSkewed:
+		return "", nil, errS3RequestTimeTooSkewed
+	default:
+		return "", nil, errS3AccessDenied
+	}
+	body, err := sigv4.Body(r, auth, secret)
+	if err != nil {
+		return "", nil, errS3NotImplemented
+	}
+	return userId, &s3Body{r: body}, nil
+}
+
+func (sc *S3Controller) listBuckets(ctx context.Context, w http.ResponseWriter, r *http.Request, userId string) {
+	buckets, err := sc.service.ListBuckets(ctx, userId)
+	if err != nil {
+		writeS3Error(ctx, w, r, err)
+		return
+	}
+	writeS3XML(ctx, w, http.StatusOK, &v1.S3ListAllMyBucketsResult{
+		Xmlns:   v1.S3_XML_NAMESPACE,
+		Owner:   v1.S3Owner{ID: userId, DisplayName: userId},
+		Buckets: buckets,
+	})
+}
+
+// listObjects answers ListObjectsV2 for list-type=2 and ListObjects otherwise
+func (sc *S3Controller) listObjects(ctx context.Context, w http.ResponseWriter, r *http.Request, userId string, bucket string) {
+	query := r.URL.Query()
+	q := &v1.S3ListQuery{
+		Prefix:    query.Get("prefix"),
+		Delimiter: query.Get("delimiter"),
+		MaxKeys:   S3_MAX_KEYS,
+	}
+	if v := query.Get("max-keys"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeS3Error(ctx, w, r, errS3InvalidArgument)
+			return
+		}
+		q.MaxKeys = min(n, S3_MAX_KEYS)
+	}
+	isV2 := query.Get("list-type") == "2"
+	if isV2 {
+		q.Marker = query.Get("start-after")
+		if token := query.Get("continuation-token"); token != "" {
+			decoded, err := base64.URLEncoding.DecodeString(token)
+			if err != nil {
+				writeS3Error(ctx, w, r, errS3InvalidArgument)
+				return
+			}
+			q.Marker = string(decoded)
+		}
+	} else {
+		q.Marker = query.Get("marker")
+	}
+
+	result, err := sc.service.ListObjects(ctx, userId, bucket, q)
+	if err != nil {
+		writeS3Error(ctx, w, r, err)
+		return
+	}
+	resp := &v1.S3ListBucketResult{
+		Xmlns:       v1.S3_XML_NAMESPACE,
+		Name:        bucket,
+		Prefix:      q.Prefix,
+		Delimiter:   q.Delimiter,
+		MaxKeys:     q.MaxKeys,
+		IsTruncated: result.IsTruncated,
+	}
+	for _, o := range result.Objects {
+		resp.Contents = append(resp.Contents, v1.S3Content{
+			Key:          o.Key,
+			LastModified: o.LastModified.UTC(),
+			ETag:         quoteETag(o.ETag),
+			Size:         o.Size,
+			StorageClass: "STANDARD",
+		})
+	}
+	for _, p := range result.CommonPrefixes {
+		resp.CommonPrefixes = append(resp.CommonPrefixes, v1.S3CommonPrefix{Prefix: p})
+	}
+	if isV2 {
+		keyCount := len(resp.Contents) + len(resp.CommonPrefixes)
+		resp.KeyCount = &keyCount
+		resp.StartAfter = query.Get("start-after")
+		resp.ContinuationToken = query.Get("continuation-token")
+		if result.IsTruncated {
+			resp.NextContinuationToken = base64.URLEncoding.EncodeToString([]byte(result.NextMarker))
+		}
+	} else {
+		marker := q.Marker
+		resp.Marker = &marker
+		resp.NextMarker = result.NextMarker
+	}
+	writeS3XML(ctx, w, http.StatusOK, resp)
+}
+
+// getObject serves GET and HEAD, Range and conditional requests are handled by http.ServeContent
+func (sc *S3Controller) getObject(ctx context.Context, w http.ResponseWriter, r *http.Request, userId string, bucket string, key string) {
+	object, err := sc.service.GetObject(ctx, userId, bucket, key)
+	if err != nil {
+		writeS3Error(ctx, w, r, err)
+		return
+	}
+	file, err := os.Open(object.Location)
+	if err != nil {
+		log.C(ctx).Errorw("s3 open object failed", "location", object.Location, "err", err)
+		writeS3Error(ctx, w, r, errno.InternalServerError)
+		return
+	}
+	defer file.Close()
+	w.Header().Set("ETag", quoteETag(object.ETag))
+	http.ServeContent(w, r, object.Key, object.LastModified, file)
+}
+
+func (sc *S3Controller) createMultipartUpload(ctx context.Context, w http.ResponseWriter, r *http.Request, userId string, bucket string, key string) {
+	uploadId, err := sc.service.CreateMultipartUpload(ctx, userId, bucket, key)
+	if err != nil {
+		writeS3Error(ctx, w, r, err)
+		return
+	}
+	writeS3XML(ctx, w, http.StatusOK, &v1.S3InitiateMultipartUploadResult{
+		Xmlns:    v1.S3_XML_NAMESPACE,
+		Bucket:   bucket,
+		Key:      key,
+		UploadId: uploadId,
+	})
+}
+
+func (sc *S3Controller) uploadPart(ctx context.Context, w http.ResponseWriter, r *http.Request, userId string, bucket string, key string, body *s3Body) {
+	query := r.URL.Query()
+	partNumber, err := strconv.Atoi(query.Get("partNumber"))
+	if err != nil {
+		writeS3Error(ctx, w, r, errS3InvalidArgument)
+		return
+	}
+	etag, err := sc.service.UploadPart(ctx, userId, bucket, key, query.Get("uploadId"), partNumber, body)
+	if err != nil {
+		writeS3Error(ctx, w, r, body.bodyError(err))
+		return
+	}
+	w.Header().Set("ETag", quoteETag(etag))
+	w.WriteHeader(http.StatusOK)
+}
+
+func (sc *S3Controller) completeMultipartUpload(ctx context.Context, w http.ResponseWriter, r *http.Request, userId string, bucket string, key string, body *s3Body) {
+	data, err := io.ReadAll(io.LimitReader(body, S3_MAX_COMPLETE_BODY))
+	if err != nil {
+		writeS3Error(ctx, w, r, body.bodyError(err))
+		return
+	}
+	complete := &v1.S3CompleteMultipartUpload{}
+	if err := xml.Unmarshal(data, complete); err != nil {
+		writeS3Error(ctx, w, r, errS3MalformedXML)
+		return
+	}
+	object, err := sc.service.CompleteMultipartUpload(ctx, userId, bucket, key, r.URL.Query().Get("uploadId"), complete.Parts)
+	if err != nil {
+		writeS3Error(ctx, w, r, err)
+		return
+	}
+	writeS3XML(ctx, w, http.StatusOK, &v1.S3CompleteMultipartUploadResult{
+		Xmlns:    v1.S3_XML_NAMESPACE,
+		Location: s3Resource(r),
+		Bucket:   bucket,
+		Key:      key,
+		ETag:     quoteETag(object.ETag),
+	})
+}
+
+// s3Resource is the path of the request as the client sent it
+func s3Resource(r *http.Request) string {
+	return basePath("share.api-base-path", DEFAULT_API_BASE_PATH) + r.URL.Path
+}
+
+func quoteETag(etag string) string {
+	return `"` + etag + `"`
+}
+
+func writeS3XML(ctx context.Context, w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	w.Write([]byte(xml.Header))
+	if err := xml.NewEncoder(w).Encode(v); err != nil {
+		log.C(ctx).Warnw("s3 write response failed", "err", err)
+	}
+}
+
+func writeS3Status(ctx context.Context, w http.ResponseWriter, r *http.Request, err error, status int) {
+	if err != nil {
+		writeS3Error(ctx, w, r, err)
+		return
+	}
+	w.WriteHeader(status)
+}
+
+// writeS3Error answers with the S3 error document, errors of the other services are mapped by status
+func writeS3Error(ctx context.Context, w http.ResponseWriter, r *http.Request, err error) {
+	status, code, message := errno.Decode(err)
+	if code == "" || strings.Contains(code, ".") {
+		switch status {
+		case http.StatusBadRequest:
+			code = "InvalidRequest"
+		case http.StatusForbidden, http.StatusUnauthorized:
+			code = "AccessDenied"
+		case http.StatusNotFound:
+			code = "NoSuchKey"
+		default:
+			code = "InternalError"
+		}
+	}
+	log.C(ctx).Infow("s3 request failed", "method", r.Method, "path", r.URL.Path, "code", code)
+	if r.Method == http.MethodHead {
+		w.WriteHeader(status)
+		return
+	}
+	rId, _ := ctx.Value(common.REQUEST_ID).(string)
+	writeS3XML(ctx, w, status, &v1.S3Error{
+		Code:      code,
+		Message:   message,
+		Resource:  s3Resource(r),
+		RequestId: rId,
+	})
+}
@@ -0,0 +1,197 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"file-transfer/internal/file-transfer/repo"
+	"file-transfer/internal/file-transfer/repo/repotest"
+	"file-transfer/internal/file-transfer/service"
+	"file-transfer/pkg/common"
+	"file-transfer/pkg/encrypt/aesencrypt"
+	"file-transfer/pkg/model"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	testAccessKeyId = "FTTESTACCESSKEY00001"
+	testSecret      = "test-secret-access-key"
+)
+
+type memAccessKeyRepo struct {
+	repo.UserRepo
+	keys map[string]model.AccessKey
+}
+
+func (m *memAccessKeyRepo) FindAccessKey(ctx context.Context, accessKeyId string) (*model.AccessKey, error) {
+	if key, ok := m.keys[accessKeyId]; ok {
+		return &key, nil
+	}
+	return nil, mongo.ErrNoDocuments
+}
+
+func newS3TestClient(t *testing.T, secret string) *s3.Client {
+	return newS3TestClientBase(t, secret, "")
+}
+
+// newS3TestClientBase serves the gateway behind a proxy stripping the api base path
+func newS3TestClientBase(t *testing.T, secret string, apiBase string) *s3.Client {
+	viper.Set("share.api-base-path", apiBase)
+	t.Cleanup(func() { viper.Set("share.api-base-path", DEFAULT_API_BASE_PATH) })
+	viper.Set("upload.path", t.TempDir())
+	viper.Set(common.VIPER_AES_KEY, "0123456789abcdef0123456789abcdef")
+	viper.Set(common.VIPER_AES_IV, "0123456789abcdef")
+	aesencrypt.InitAES()
+	encrypted, err := aesencrypt.GetAESEncrypted(testSecret)
+	assert.Nil(t, err)
+
+	fileRepo := repotest.NewMemFileRepo()
+	userRepo := &memAccessKeyRepo{keys: map[string]model.AccessKey{
+		testAccessKeyId: {UserId: "u1", AccessKeyId: testAccessKeyId, Secret: encrypted},
+	}}
+	fileService := service.NewFileService(fileRepo, nil, nil, nil)
+	sc := NewS3Controller(service.NewS3Service(fileRepo, userRepo, fileService))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.URL.Path = strings.TrimPrefix(r.URL.Path, apiBase)
+		sc.Serve(context.Background(), w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	return s3.New(s3.Options{
+		BaseEndpoint: aws.String(srv.URL + apiBase + S3_PREFIX),
+		Region:       "us-east-1",
+		UsePathStyle: true,
+		Credentials:  credentials.NewStaticCredentialsProvider(testAccessKeyId, secret, ""),
+	})
+}
+
+func readObject(t *testing.T, client *s3.Client, key string, rng *string) string {
+	out, err := client.GetObject(context.Background(), &s3.GetObjectInput{Bucket: aws.String("ci"), Key: aws.String(key), Range: rng})
+	assert.Nil(t, err)
+	if err != nil {
+		return ""
+	}
+	defer out.Body.Close()
+	data, _ := io.ReadAll(out.Body)
+	return string(data)
+}
+
+func TestS3Gateway(t *testing.T) {
+	client := newS3TestClient(t, testSecret)
+	ctx := context.Background()
+
+	_, err := client.PutObject(ctx, &s3.PutObjectInput{Bucket: aws.String("ci"), Key: aws.String("a.txt"), Body: strings.NewReader("x")})
+	var apiErr smithy.APIError
+	assert.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, "NoSuchBucket", apiErr.ErrorCode())
+
+	_, err = client.CreateBucket(ctx, &s3.CreateBucketInput{Bucket: aws.String("ci")})
+	assert.Nil(t, err)
+	for _, key := range []string{"build/app.txt", "build/lib/a.so", "readme.md"} {
+		_, err = client.PutObject(ctx, &s3.PutObjectInput{Bucket: aws.String("ci"), Key: aws.String(key), Body: strings.NewReader("hello " + key)})
+		assert.Nil(t, err)
+	}
+
+	head, err := client.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String("ci"), Key: aws.String("readme.md")})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len("hello readme.md")), aws.ToInt64(head.ContentLength))
+	assert.Equal(t, "hello readme.md", readObject(t, client, "readme.md", nil))
+	assert.Equal(t, "ello", readObject(t, client, "readme.md", aws.String("bytes=1-4")))
+
+	list, err := client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{Bucket: aws.String("ci"), Delimiter: aws.String("/")})
+	assert.Nil(t, err)
+	assert.Len(t, list.Contents, 1)
+	assert.Equal(t, "readme.md", aws.ToString(list.Contents[0].Key))
+	assert.Len(t, list.CommonPrefixes, 1)
+	assert.Equal(t, "build/", aws.ToString(list.CommonPrefixes[0].Prefix))
+
+	keys := make([]string, 0)
+	paginator := s3.NewListObjectsV2Paginator(client, &s3.ListObjectsV2Input{Bucket: aws.String("ci"), MaxKeys: aws.Int32(2)})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		assert.Nil(t, err)
+		if err != nil {
+			break
+		}
+		for _, o := range page.Contents {
+			keys = append(keys, aws.ToString(o.Key))
+		}
+	}
+	assert.Equal(t, []string{"build/app.txt", "build/lib/a.so", "readme.md"}, keys)
+
+	_, err = client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: aws.String("ci"), Key: aws.String("readme.md")})
+	assert.Nil(t, err)
+	_, err = client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String("ci"), Key: aws.String("readme.md")})
+	var noKey *types.NoSuchKey
+	assert.True(t, errors.As(err, &noKey))
+}
+
+func TestS3MultipartUpload(t *testing.T) {
+	client := newS3TestClient(t, testSecret)
+	ctx := context.Background()
+	_, err := client.CreateBucket(ctx, &s3.CreateBucketInput{Bucket: aws.String("ci")})
+	assert.Nil(t, err)
+
+	created, err := client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{Bucket: aws.String("ci"), Key: aws.String("dist/big.bin")})
+	assert.Nil(t, err)
+	parts := make([]types.CompletedPart, 0)
+	for i, data := range []string{"first-", "second"} {
+		out, err := client.UploadPart(ctx, &s3.UploadPartInput{
+			Bucket:     aws.String("ci"),
+			Key:        aws.String("dist/big.bin"),
+			UploadId:   created.UploadId,
+			PartNumber: aws.Int32(int32(i + 1)),
+			Body:       bytes.NewReader([]byte(data)),
+		})
+		assert.Nil(t, err)
+		parts = append(parts, types.CompletedPart{ETag: out.ETag, PartNumber: aws.Int32(int32(i + 1))})
+	}
+	_, err = client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String("ci"),
+		Key:             aws.String("dist/big.bin"),
+		UploadId:        created.UploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "first-second", readObject(t, client, "dist/big.bin", nil))
+
+	// the upload is gone once completed
+	_, err = client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{Bucket: aws.String("ci"), Key: aws.String("dist/big.bin"), UploadId: created.UploadId})
+	var noUpload *types.NoSuchUpload
+	assert.True(t, errors.As(err, &noUpload))
+}
+
+func TestS3BehindProxy(t *testing.T) {
+	client := newS3TestClientBase(t, testSecret, "/api")
+	ctx := context.Background()
+	_, err := client.CreateBucket(ctx, &s3.CreateBucketInput{Bucket: aws.String("ci")})
+	assert.Nil(t, err)
+	_, err = client.PutObject(ctx, &s3.PutObjectInput{Bucket: aws.String("ci"), Key: aws.String("dir/a b.txt"), Body: strings.NewReader("hello")})
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", readObject(t, client, "dir/a b.txt", nil))
+
+	_, err = client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String("ci"), Key: aws.String("missing.txt")})
+	var noKey *types.NoSuchKey
+	assert.True(t, errors.As(err, &noKey))
+}
+
+func TestS3BadSignature(t *testing.T) {
+	client := newS3TestClient(t, "wrong-secret")
+	_, err := client.ListBuckets(context.Background(), &s3.ListBucketsInput{})
+	var apiErr smithy.APIError
+	assert.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, "SignatureDoesNotMatch", apiErr.ErrorCode())
+}
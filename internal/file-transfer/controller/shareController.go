@@ -0,0 +1,217 @@
+package controller
+
+import (
+	"context"
+	"file-transfer/internal/file-transfer/service"
+	v1 "file-transfer/pkg/api/v1"
+	"file-transfer/pkg/bandwidth"
+	"file-transfer/pkg/common"
+	"file-transfer/pkg/errno"
+	"file-transfer/pkg/util"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+const HEADER_SHARE_PASSWORD = "X-Share-Password"
+
+type ShareController struct {
+	shareService service.ShareService
+}
+
+func NewShareController(shareService service.ShareService) ShareController {
+	return ShareController{shareService: shareService}
+}
+
+// sharePassword reads the password of a protected link from the X-Share-Password header,
+// or the "password" field of a posted form so that a plain html form can open the link
+func sharePassword(r *http.Request) string {
+	if password := r.Header.Get(HEADER_SHARE_PASSWORD); len(password) > 0 {
+		return password
+	}
+	if r.Method == http.MethodPost {
+		return r.PostFormValue("password")
+	}
+	return ""
+}
+
+// writeShareError tells the client how to retry a protected link
+func writeShareError(ctx context.Context, w http.ResponseWriter, err error) {
+	switch err {
+	case service.ErrSharePasswordRequired, service.ErrSharePasswordWrong:
+		w.Header().Set("WWW-Authenticate", "SharePassword")
+	case service.ErrSharePasswordLimited:
+		w.Header().Set("Retry-After", strconv.Itoa(int(service.SHARE_PASSWORD_WINDOW.Seconds())))
+	}
+	errno.WriteErrorResponse(ctx, w, err)
+}
+
+// writeDownloadError tells a throttled client when to come back
+func writeDownloadError(ctx context.Context, w http.ResponseWriter, err error) {
+	if limited, ok := err.(*bandwidth.LimitError); ok {
+		w.Header().Set("Retry-After", strconv.Itoa(limited.RetryAfterSeconds()))
+		errno.WriteErrorResponse(ctx, w, service.ErrDownloadLimited)
+		return
+	}
+	writeShareError(ctx, w, err)
+}
+
+// readShareQuery reads ?type=login|message|file&target=
+func readShareQuery(r *http.Request) (*v1.ShareQuery, error) {
+	q := &v1.ShareQuery{
+		Type:   r.URL.Query().Get("type"),
+		Target: r.URL.Query().Get("target"),
+	}
+	if len(q.Type) > 0 {
+		if _, ok := service.ParseShareType(q.Type); !ok {
+			return nil, errno.ErrInvalidParameter
+		}
+	}
+	return q, nil
+}
+
+func (sc *ShareController) ListShare(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	q, err := readShareQuery(r)
+	if err != nil {
+		errno.WriteErrorResponse(ctx, w, err)
+		return
+	}
+	userId := ctx.Value(common.Trace_request_uid{}).(string)
+	result, err := sc.shareService.ListShare(ctx, userId, q)
+	if err != nil {
+		errno.WriteErrorResponse(ctx, w, err)
+		return
+	}
+	for i := range result {
+		fillShareInfo(r, &result[i])
+	}
+	errno.WriteResponse(ctx, w, result)
+}
+
+func (sc *ShareController) GetShare(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	key := mux.Vars(r)["key"]
+	if len(key) < 1 {
+		errno.WriteErrorResponse(ctx, w, &errno.Errno{Message: "invalid"})
+		return
+	}
+	userId := ctx.Value(common.Trace_request_uid{}).(string)
+	result, err := sc.shareService.GetShare(ctx, userId, key)
+	if err != nil {
+		errno.WriteErrorResponse(ctx, w, err)
+		return
+	}
+	fillShareInfo(r, result)
+	errno.WriteResponse(ctx, w, result)
+}
+
+func (sc *ShareController) RevokeShare(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	key := mux.Vars(r)["key"]
+	if len(key) < 1 {
+		errno.WriteErrorResponse(ctx, w, &errno.Errno{Message: "invalid"})
+		return
+	}
+	userId := ctx.Value(common.Trace_request_uid{}).(string)
+	if err := sc.shareService.RevokeShare(ctx, userId, key); err != nil {
+		errno.WriteErrorResponse(ctx, w, err)
+		return
+	}
+	errno.WriteResponse(ctx, w, nil)
+}
+
+// RevokeShares revokes all links of the user, or the ones matching type and target
+func (sc *ShareController) RevokeShares(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	q, err := readShareQuery(r)
+	if err != nil {
+		errno.WriteErrorResponse(ctx, w, err)
+		return
+	}
+	userId := ctx.Value(common.Trace_request_uid{}).(string)
+	revoked, err := sc.shareService.RevokeUserShares(ctx, userId, q)
+	if err != nil {
+		errno.WriteErrorResponse(ctx, w, err)
+		return
+	}
+	errno.WriteResponse(ctx, w, &v1.ShareRevokeResponse{Revoked: revoked})
+}
+
+// QueryShareAccess reads the access log of the user's links, also of the ones already ended
+func (sc *ShareController) QueryShareAccess(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	request := &v1.ShareAccessQuery{
+		PageNum:  1,
+		PageSize: 10,
+	}
+	err := util.HttpReadBody(r, request)
+	if err != nil {
+		errno.WriteErrorResponse(ctx, w, errno.ErrInvalidParameter)
+		return
+	}
+	request.UserId = ctx.Value(common.Trace_request_uid{}).(string)
+
+	result, err := sc.shareService.ListShareAccess(ctx, request)
+	if err != nil {
+		errno.WriteErrorResponse(ctx, w, err)
+		return
+	}
+	errno.WriteResponse(ctx, w, result)
+}
+
+// SetShareLimits changes the download limits of a file or collection link
+func (sc *ShareController) SetShareLimits(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	key := mux.Vars(r)["key"]
+	if len(key) < 1 {
+		errno.WriteErrorResponse(ctx, w, &errno.Errno{Message: "invalid"})
+		return
+	}
+	limits := &v1.ShareLimits{}
+	if err := util.HttpReadBody(r, limits); err != nil {
+		errno.WriteErrorResponse(ctx, w, errno.ErrInvalidParameter)
+		return
+	}
+	userId := ctx.Value(common.Trace_request_uid{}).(string)
+	if err := sc.shareService.SetShareLimits(ctx, userId, key, limits); err != nil {
+		errno.WriteErrorResponse(ctx, w, err)
+		return
+	}
+	errno.WriteResponse(ctx, w, limits)
+}
+
+// CreatePickupCode gives a link of the user a short code to type in instead
+func (sc *ShareController) CreatePickupCode(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	key := mux.Vars(r)["key"]
+	if len(key) < 1 {
+		errno.WriteErrorResponse(ctx, w, &errno.Errno{Message: "invalid"})
+		return
+	}
+	param := &v1.PickupCodeParam{}
+	if r.ContentLength != 0 {
+		if err := util.HttpReadBody(r, param); err != nil {
+			errno.WriteErrorResponse(ctx, w, errno.ErrInvalidParameter)
+			return
+		}
+	}
+	userId := ctx.Value(common.Trace_request_uid{}).(string)
+	result, err := sc.shareService.CreatePickupCode(ctx, userId, key, param)
+	if err != nil {
+		errno.WriteErrorResponse(ctx, w, err)
+		return
+	}
+	result.Url, _ = shareUrls(r, result.Path)
+	errno.WriteResponse(ctx, w, result)
+}
+
+// RedeemPickupCode redirects to the page of the link of the code. A file link opens its landing page,
+// the code alone doesn't use up one of its downloads
+func (sc *ShareController) RedeemPickupCode(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	sharePath, err := sc.shareService.RedeemPickupCode(ctx, mux.Vars(r)["code"], clientIp(r))
+	if err != nil {
+		if err == service.ErrPickupCodeLimited {
+			w.Header().Set("Retry-After", strconv.Itoa(int(service.PICKUP_CODE_WINDOW.Seconds())))
+		}
+		errno.WriteErrorResponse(ctx, w, err)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	_, pageUrl := shareUrls(r, sharePath)
+	http.Redirect(w, r, pageUrl, http.StatusFound)
+}
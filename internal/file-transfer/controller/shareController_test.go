@@ -0,0 +1,63 @@
+package controller
+
+import (
+	"context"
+	"file-transfer/internal/file-transfer/repo/repotest"
+	"file-transfer/internal/file-transfer/service"
+	v1 "file-transfer/pkg/api/v1"
+	"file-transfer/pkg/common"
+	"file-transfer/pkg/encrypt/aesencrypt"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gorilla/mux"
+	"github.com/redis/go-redis/v9"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedeemPickupCode(t *testing.T) {
+	defer viper.Reset()
+	viper.Set(common.VIPER_AES_KEY, "0123456789abcdef0123456789abcdef")
+	viper.Set(common.VIPER_AES_IV, "0123456789abcdef")
+	aesencrypt.InitAES()
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	shareService := service.NewShareService(redis.NewClient(&redis.Options{Addr: mr.Addr()}), repotest.NewMemShareAccessRepo())
+	sc := NewShareController(shareService)
+
+	link, err := shareService.CreateShareUrlWithTimes(ctx, common.SHARE_TYPE_FILE, "u1", "f1", time.Hour, 1, "")
+	assert.Nil(t, err)
+	key := path.Base(link.Path)
+	pickup, err := shareService.CreatePickupCode(ctx, "u1", key, &v1.PickupCodeParam{})
+	assert.Nil(t, err)
+	redeem := func() *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "http://files.example.com/c/"+pickup.Code, nil)
+		r = mux.SetURLVars(r, map[string]string{"code": pickup.Code})
+		w := httptest.NewRecorder()
+		sc.RedeemPickupCode(ctx, w, r)
+		return w
+	}
+
+	// the landing page, not the download, which would use up the only time of the link
+	w := redeem()
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "http://files.example.com/api/p/fs/"+key, w.Header().Get("Location"))
+	info, err := shareService.GetShare(ctx, "u1", key)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), *info.Remaining)
+
+	// behind a proxy with its own paths, the redirect leaves the api like the other links
+	viper.Set(common.VIPER_HOST_URL, "https://share.example.com/")
+	viper.Set("share.api-base-path", "backend")
+	viper.Set("share.page-base-path", "/s/")
+	w = redeem()
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "https://share.example.com/s/p/fs/"+key, w.Header().Get("Location"))
+	url, _ := shareUrls(httptest.NewRequest("GET", "/", nil), pickup.Path)
+	assert.Equal(t, "https://share.example.com/backend"+pickup.Path, url, "the code itself is typed in at the api")
+}
@@ -0,0 +1,158 @@
+package controller
+
+import (
+	"context"
+	"crypto/rand"
+	"embed"
+	"encoding/base64"
+	"file-transfer/pkg/errno"
+	"file-transfer/pkg/log"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/skip2/go-qrcode"
+)
+
+// SHARE_PAGE_PREFIX is put before the path of a file link for its landing page, "/p/fs/{key}"
+const SHARE_PAGE_PREFIX = "/p"
+
+// pixels of the qr code image
+const SHARE_QR_SIZE = 256
+
+//go:embed templates/*.html
+var pageFS embed.FS
+
+var pageTemplates = template.Must(template.ParseFS(pageFS, "templates/*.html"))
+
+type sharePageView struct {
+	Found       bool
+	Protected   bool
+	Title       string
+	Description string
+	Size        string
+	ContentType string
+	ExpireAt    string
+	Remaining   string
+	PageUrl     string
+	DownloadUrl string
+	QrUrl       string
+}
+
+// SharePage shows what a file link holds. Only the download button uses the link,
+// so link previews of chat apps don't use up a one-time link
+func (fc *FileController) SharePage(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	key := mux.Vars(r)["key"]
+	view := &sharePageView{Title: "Link not available"}
+	status := http.StatusNotFound
+	page, err := fc.fileService.ReadSharePage(ctx, key)
+	if err == nil {
+		status = http.StatusOK
+		view.Found = true
+		view.Protected = page.Protected
+		view.DownloadUrl, view.PageUrl = shareUrls(r, page.Path)
+		view.QrUrl = view.PageUrl + "/qr"
+		view.ExpireAt = page.ExpireAt.UTC().Format("2006-01-02 15:04 MST")
+		if page.Remaining != nil {
+			view.Remaining = strconv.FormatInt(*page.Remaining, 10)
+		}
+		if page.Protected {
+			view.Title = "Protected file"
+			view.Description = "Password protected, expires " + view.ExpireAt
+		} else {
+			view.Title = page.Name
+			view.Size = humanSize(page.Size)
+			view.ContentType = page.ContentType
+			view.Description = fmt.Sprintf("%s, %s, expires %s", view.Size, view.ContentType, view.ExpireAt)
+		}
+	} else if httpStatus, _, _ := errno.Decode(err); httpStatus >= http.StatusInternalServerError {
+		status = httpStatus
+		view.Title = "Something went wrong"
+	}
+	origin := requestOrigin(r)
+	writePage(ctx, w, status, "share.html", view, "default-src 'none'; style-src 'unsafe-inline'; img-src 'self' "+origin+
+		"; form-action 'self' "+origin+"; frame-ancestors 'none'")
+}
+
+type notePageView struct {
+	Found       bool
+	Protected   bool
+	Title       string
+	Description string
+	ReadUrl     string
+	Nonce       string
+}
+
+// SecretNotePage asks before a note is shown, its script sends the key from the "#" of the page url
+func (mc *MessageController) SecretNotePage(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	key := mux.Vars(r)["key"]
+	view := &notePageView{Title: "Note not available"}
+	status := http.StatusNotFound
+	page, err := mc.service.ReadSecretNotePage(ctx, key)
+	if err == nil {
+		status = http.StatusOK
+		view.Found = true
+		view.Protected = page.Protected
+		view.Title = "Secret note"
+		view.Description = "It can be read once, until " + page.ExpireAt.UTC().Format("2006-01-02 15:04 MST")
+		view.ReadUrl, _ = shareUrls(r, page.Path)
+	} else if httpStatus, _, _ := errno.Decode(err); httpStatus >= http.StatusInternalServerError {
+		status = httpStatus
+		view.Title = "Something went wrong"
+	}
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	view.Nonce = base64.StdEncoding.EncodeToString(nonce)
+	origin := requestOrigin(r)
+	writePage(ctx, w, status, "note.html", view, "default-src 'none'; style-src 'unsafe-inline'; script-src 'nonce-"+view.Nonce+
+		"'; connect-src 'self' "+origin+"; form-action 'none'; frame-ancestors 'none'")
+}
+
+func writePage(ctx context.Context, w http.ResponseWriter, status int, name string, view any, csp string) {
+	h := w.Header()
+	h.Set("Content-Type", "text/html; charset=utf-8")
+	h.Set("Cache-Control", "no-store")
+	h.Set("X-Robots-Tag", "noindex, nofollow")
+	h.Set("Referrer-Policy", "no-referrer")
+	h.Set("Content-Security-Policy", csp)
+	w.WriteHeader(status)
+	if err := pageTemplates.ExecuteTemplate(w, name, view); err != nil {
+		log.C(ctx).Warnw("render page failed", "page", name, "err", err)
+	}
+}
+
+// SharePageQr is the qr code of the landing page of a link
+func (fc *FileController) SharePageQr(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	key := mux.Vars(r)["key"]
+	page, err := fc.fileService.ReadSharePage(ctx, key)
+	if err != nil {
+		errno.WriteErrorResponse(ctx, w, err)
+		return
+	}
+	_, pageUrl := shareUrls(r, page.Path)
+	png, err := qrcode.Encode(pageUrl, qrcode.Medium, SHARE_QR_SIZE)
+	if err != nil {
+		log.C(ctx).Warnw("encode qr code failed", "err", err)
+		errno.WriteErrorResponse(ctx, w, errno.InternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "private, max-age="+strconv.Itoa(int(time.Until(page.ExpireAt).Seconds())))
+	w.Write(png)
+}
+
+func humanSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	value, exp := float64(size)/unit, 0
+	for value >= unit && exp < 4 {
+		value /= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", value, "KMGTP"[exp])
+}
@@ -0,0 +1,121 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"file-transfer/internal/file-transfer/repo/repotest"
+	"file-transfer/internal/file-transfer/service"
+	v1 "file-transfer/pkg/api/v1"
+	"file-transfer/pkg/common"
+	"file-transfer/pkg/encrypt/aesencrypt"
+	"file-transfer/pkg/model"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gorilla/mux"
+	"github.com/redis/go-redis/v9"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSharePage(t *testing.T) {
+	defer viper.Reset()
+	viper.Set("upload.path", t.TempDir())
+	viper.Set(common.VIPER_AES_KEY, "0123456789abcdef0123456789abcdef")
+	viper.Set(common.VIPER_AES_IV, "0123456789abcdef")
+	aesencrypt.InitAES()
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	shareService := service.NewShareService(redis.NewClient(&redis.Options{Addr: mr.Addr()}), repotest.NewMemShareAccessRepo())
+	fileRepo := repotest.NewMemFileRepo()
+	fileRepo.Metas["m1"] = model.FileMeta{Id: "m1", Size: 3 << 20}
+	fileRepo.Files["f1"] = model.UserFile{Id: "f1", MetaId: "m1", UserId: "u1", Name: "report <q1>.pdf"}
+	fc := NewFileController(service.NewFileService(fileRepo, shareService, nil, nil))
+
+	link, err := shareService.CreateShareUrlWithTimes(ctx, common.SHARE_TYPE_FILE, "u1", "f1", time.Hour, 1, "")
+	assert.Nil(t, err)
+	key := path.Base(link.Path)
+	get := func(handler func(context.Context, http.ResponseWriter, *http.Request), key string) *httptest.ResponseRecorder {
+		r := mux.SetURLVars(httptest.NewRequest("GET", "http://files.example.com/p/fs/"+key, nil), map[string]string{"key": key})
+		w := httptest.NewRecorder()
+		handler(ctx, w, r)
+		return w
+	}
+
+	// a link preview bot and the person, the link is still there
+	for i := 0; i < 2; i++ {
+		w := get(fc.SharePage, key)
+		assert.Equal(t, http.StatusOK, w.Code)
+		body := w.Body.String()
+		assert.Contains(t, body, `<meta property="og:title" content="report &lt;q1&gt;.pdf">`)
+		assert.Contains(t, body, "3.0 MB")
+		assert.Contains(t, body, "application/pdf")
+		assert.Contains(t, body, `action="http://files.example.com/api/fs/`+key+`"`)
+	}
+	w := get(fc.SharePageQr, key)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "image/png", w.Header().Get("Content-Type"))
+	info, err := shareService.GetShare(ctx, "u1", key)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), *info.Remaining)
+
+	_, err = shareService.ConsumeShareUrl(ctx, common.SHARE_TYPE_FILE, key, time.Hour, "")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusNotFound, get(fc.SharePage, key).Code)
+
+	// a protected link doesn't tell the file
+	link, err = shareService.CreateShareUrl(ctx, common.SHARE_TYPE_FILE, "u1", "f1", time.Hour, "secret")
+	assert.Nil(t, err)
+	w = get(fc.SharePage, path.Base(link.Path))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), "report")
+	assert.Contains(t, w.Body.String(), `name="password"`)
+
+	assert.Equal(t, http.StatusNotFound, get(fc.SharePage, "k1.forged").Code)
+}
+
+func TestSecretNotePage(t *testing.T) {
+	defer viper.Reset()
+	viper.Set(common.VIPER_AES_KEY, "0123456789abcdef0123456789abcdef")
+	viper.Set(common.VIPER_AES_IV, "0123456789abcdef")
+	aesencrypt.InitAES()
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	mc := NewMessageController(service.NewMessageService(nil, service.NewShareService(client, repotest.NewMemShareAccessRepo()), client))
+
+	create := httptest.NewRequest("POST", "http://files.example.com/note", strings.NewReader(`{"info":"<b>hi</b>"}`))
+	w := httptest.NewRecorder()
+	mc.CreateSecretNote(context.WithValue(ctx, common.Trace_request_uid{}, "u1"), w, create)
+	assert.Equal(t, http.StatusOK, w.Code)
+	link := &v1.SecretNoteLink{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), link))
+	key := path.Base(link.Path)
+	assert.Equal(t, "http://files.example.com/api/sn/"+key+"#"+link.Secret, link.PageUrl)
+
+	// opening the page twice doesn't read the note
+	for i := 0; i < 2; i++ {
+		w = httptest.NewRecorder()
+		mc.SecretNotePage(ctx, w, mux.SetURLVars(httptest.NewRequest("GET", "http://files.example.com/sn/"+key, nil), map[string]string{"key": key}))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Header().Get("Content-Security-Policy"), "script-src 'nonce-")
+		assert.NotContains(t, w.Body.String(), "hi</b>")
+	}
+
+	read := func() *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "http://files.example.com/sn/"+key, strings.NewReader(`{"secret":"`+link.Secret+`"}`))
+		w := httptest.NewRecorder()
+		mc.ReadSecretNote(ctx, w, mux.SetURLVars(r, map[string]string{"key": key}))
+		return w
+	}
+	w = read()
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "<b>hi</b>", w.Body.String())
+	assert.Equal(t, "nosniff", w.Header().Get("X-Content-Type-Options"))
+	assert.Equal(t, http.StatusNotFound, read().Code)
+}
@@ -0,0 +1,125 @@
+package controller
+
+import (
+	v1 "file-transfer/pkg/api/v1"
+	"file-transfer/pkg/common"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	// nginx serves the api under /api/, see default.conf
+	DEFAULT_API_BASE_PATH = "/api"
+	// the landing pages are served next to the api
+	DEFAULT_PAGE_BASE_PATH = "/api"
+)
+
+// default proxies whose X-Forwarded-Host/Proto are believed, "trusted-proxies" replaces them
+var defaultTrustedProxies = []string{"127.0.0.1/32", "::1/128"}
+
+// shareUrls makes the absolute urls of a link path, for the api and for the page a person opens.
+// File links have a landing page, the others are opened as they are
+func shareUrls(r *http.Request, path string) (string, string) {
+	origin := requestOrigin(r)
+	pagePath := path
+	if strings.HasPrefix(path, "/"+common.FILE_SHARE_PATH+"/") {
+		pagePath = SHARE_PAGE_PREFIX + path
+	}
+	return origin + basePath("share.api-base-path", DEFAULT_API_BASE_PATH) + path,
+		origin + basePath("share.page-base-path", DEFAULT_PAGE_BASE_PATH) + pagePath
+}
+
+func fillShareLink(r *http.Request, link *v1.ShareLink) *v1.ShareLink {
+	link.Url, link.PageUrl = shareUrls(r, link.Path)
+	return link
+}
+
+func fillShareInfo(r *http.Request, info *v1.ShareInfo) {
+	info.Url, info.PageUrl = shareUrls(r, info.Path)
+}
+
+func basePath(key string, def string) string {
+	if !viper.IsSet(key) {
+		return def
+	}
+	p := strings.TrimSuffix(viper.GetString(key), "/")
+	if len(p) > 0 && !strings.HasPrefix(p, "/") {
+		p = "/" + p
+	}
+	return p
+}
+
+// requestOrigin is "host-url" when set. Otherwise the host the request was sent to,
+// as told by X-Forwarded-Host/Proto when it comes through a trusted proxy
+func requestOrigin(r *http.Request) string {
+	if hostUrl := viper.GetString(common.VIPER_HOST_URL); len(hostUrl) > 0 {
+		return strings.TrimSuffix(hostUrl, "/")
+	}
+	scheme, host := "http", r.Host
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if fromTrustedProxy(r) {
+		if proto := firstForwarded(r, "X-Forwarded-Proto"); proto == "http" || proto == "https" {
+			scheme = proto
+		}
+		if forwarded := firstForwarded(r, "X-Forwarded-Host"); len(forwarded) > 0 && !strings.ContainsAny(forwarded, "/\\@ ") {
+			host = forwarded
+		}
+	}
+	return scheme + "://" + host
+}
+
+// firstForwarded reads the value set by the proxy next to the client, "a, b" when there are more
+func firstForwarded(r *http.Request, header string) string {
+	value, _, _ := strings.Cut(r.Header.Get(header), ",")
+	return strings.ToLower(strings.TrimSpace(value))
+}
+
+// clientIp is the address the request came from, or the one a trusted proxy saw.
+// Unlike the logged ip it ignores the headers of clients which could claim any address
+func clientIp(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !fromTrustedProxy(r) {
+		return host
+	}
+	if realIp := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(realIp) != nil {
+		return realIp
+	}
+	// the proxy appends the address it saw, the ones before are told by the client
+	forwarded := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	if last := strings.TrimSpace(forwarded[len(forwarded)-1]); net.ParseIP(last) != nil {
+		return last
+	}
+	return host
+}
+
+func fromTrustedProxy(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	proxies := defaultTrustedProxies
+	if viper.IsSet("trusted-proxies") {
+		proxies = viper.GetStringSlice("trusted-proxies")
+	}
+	for _, proxy := range proxies {
+		if _, network, err := net.ParseCIDR(proxy); err == nil && network.Contains(ip) {
+			return true
+		}
+		if proxyIp := net.ParseIP(proxy); proxyIp != nil && proxyIp.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
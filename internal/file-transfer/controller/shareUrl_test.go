@@ -0,0 +1,62 @@
+package controller
+
+import (
+	"crypto/tls"
+	"file-transfer/pkg/common"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShareUrls(t *testing.T) {
+	defer viper.Reset()
+	r := httptest.NewRequest("GET", "/file/share/f1", nil)
+	r.Host = "backend:8080"
+	r.RemoteAddr = "127.0.0.1:50000"
+	r.Header.Set("X-Forwarded-Host", "files.example.com")
+	r.Header.Set("X-Forwarded-Proto", "https, http")
+
+	url, pageUrl := shareUrls(r, "/fs/key")
+	assert.Equal(t, "https://files.example.com/api/fs/key", url)
+	assert.Equal(t, "https://files.example.com/api/p/fs/key", pageUrl)
+
+	// anyone else can't choose the host of the links
+	r.RemoteAddr = "203.0.113.7:50000"
+	url, _ = shareUrls(r, "/fs/key")
+	assert.Equal(t, "http://backend:8080/api/fs/key", url)
+	r.TLS = &tls.ConnectionState{}
+	url, _ = shareUrls(r, "/fs/key")
+	assert.Equal(t, "https://backend:8080/api/fs/key", url)
+
+	viper.Set("trusted-proxies", []string{"203.0.113.0/24"})
+	r.Header.Set("X-Forwarded-Host", "evil.example.com/path")
+	url, _ = shareUrls(r, "/fs/key")
+	assert.Equal(t, "https://backend:8080/api/fs/key", url)
+
+	viper.Set(common.VIPER_HOST_URL, "https://share.example.com/")
+	viper.Set("share.api-base-path", "")
+	viper.Set("share.page-base-path", "s/")
+	url, pageUrl = shareUrls(r, "/fs/key")
+	assert.Equal(t, "https://share.example.com/fs/key", url)
+	assert.Equal(t, "https://share.example.com/s/p/fs/key", pageUrl)
+	_, pageUrl = shareUrls(r, "/ms/key")
+	assert.Equal(t, "https://share.example.com/s/ms/key", pageUrl)
+}
+
+func TestClientIp(t *testing.T) {
+	defer viper.Reset()
+	r := httptest.NewRequest("GET", "/file/f1", nil)
+	r.RemoteAddr = "203.0.113.7:50000"
+	r.Header.Set("X-Forwarded-For", "10.0.0.1, 198.51.100.2")
+	// a client can't claim another address
+	assert.Equal(t, "203.0.113.7", clientIp(r))
+
+	r.RemoteAddr = "127.0.0.1:50000"
+	assert.Equal(t, "198.51.100.2", clientIp(r))
+	r.Header.Set("X-Real-IP", "198.51.100.3")
+	assert.Equal(t, "198.51.100.3", clientIp(r))
+	r.RemoteAddr = "[2001:db8::1]:50000"
+	assert.Equal(t, "2001:db8::1", clientIp(r))
+}
@@ -0,0 +1,72 @@
+package controller
+
+import (
+	"context"
+	"file-transfer/internal/file-transfer/service"
+	v1 "file-transfer/pkg/api/v1"
+	"file-transfer/pkg/common"
+	"file-transfer/pkg/errno"
+	"file-transfer/pkg/util"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+type SignedUrlController struct {
+	signedUrlService service.SignedUrlService
+	fileService      service.FileService
+}
+
+func NewSignedUrlController(signedUrlService service.SignedUrlService, fileService service.FileService) SignedUrlController {
+	return SignedUrlController{signedUrlService: signedUrlService, fileService: fileService}
+}
+
+// SignUrls signs download urls for a list of own files, for <img>, <video> or wget
+func (sc *SignedUrlController) SignUrls(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	param := &v1.SignedUrlParam{}
+	if err := util.HttpReadBody(r, param); err != nil {
+		errno.WriteErrorResponse(ctx, w, err)
+		return
+	}
+	userId := ctx.Value(common.Trace_request_uid{}).(string)
+	results, err := sc.signedUrlService.SignDownloadUrls(ctx, userId, clientIp(r), param)
+	if err != nil {
+		errno.WriteErrorResponse(ctx, w, err)
+		return
+	}
+	origin, apiBase := requestOrigin(r), basePath("share.api-base-path", DEFAULT_API_BASE_PATH)
+	for i := range results {
+		if len(results[i].Path) > 0 {
+			results[i].Url = origin + apiBase + results[i].Path
+		}
+	}
+	errno.WriteResponse(ctx, w, results)
+}
+
+// RevokeUrls makes all download urls the user signed so far invalid
+func (sc *SignedUrlController) RevokeUrls(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	userId := ctx.Value(common.Trace_request_uid{}).(string)
+	if err := sc.signedUrlService.RevokeDownloadUrls(ctx, userId); err != nil {
+		errno.WriteErrorResponse(ctx, w, err)
+		return
+	}
+	errno.WriteResponse(ctx, w, nil)
+}
+
+// DownloadFile serves GET /file/{fId} when the query carries a signature instead of the auth header
+func (sc *SignedUrlController) DownloadFile(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	fId := mux.Vars(r)["fId"]
+	userId, err := sc.signedUrlService.VerifyDownloadUrl(ctx, fId, r.URL.Query(), clientIp(r))
+	if err != nil {
+		errno.WriteErrorResponse(ctx, w, err)
+		return
+	}
+	data, err := sc.fileService.DownloadFile(ctx, fId, userId)
+	if err != nil {
+		writeDownloadError(ctx, w, err)
+		return
+	}
+	// the url is the credential, pages linking it must not pass it on
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	util.DownloadFileHandler(ctx, w, r, data)
+}
@@ -8,6 +8,7 @@ import (
 	"file-transfer/pkg/common"
 	"file-transfer/pkg/errno"
 	"file-transfer/pkg/model"
+	"file-transfer/pkg/util"
 	"io"
 	"net/http"
 
@@ -51,12 +52,12 @@ func (uc *UserController) Login(ctx context.Context, w http.ResponseWriter, r *h
 
 func (uc *UserController) LoginShare(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	userId := ctx.Value(common.Trace_request_uid{}).(string)
-	url, err := uc.service.CreateLoginUrl(ctx, userId)
+	link, err := uc.service.CreateLoginUrl(ctx, userId)
 	if err != nil {
 		errno.WriteErrorResponse(ctx, w, err)
 		return
 	}
-	errno.WriteResponse(ctx, w, url)
+	errno.WriteResponse(ctx, w, fillShareLink(r, link))
 }
 
 func (uc *UserController) LoginByShareLink(ctx context.Context, w http.ResponseWriter, r *http.Request) {
@@ -79,6 +80,82 @@ func (uc *UserController) UserMe(ctx context.Context, w http.ResponseWriter, r *
 	errno.WriteResponse(ctx, w, nil)
 }
 
+func (uc *UserController) CreateAppPassword(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	request := &v1.AppPasswordCreateRequest{}
+	err := util.HttpReadBody(r, request)
+	if err != nil {
+		errno.WriteErrorResponse(ctx, w, errno.ErrInvalidParameter)
+		return
+	}
+	userId := ctx.Value(common.Trace_request_uid{}).(string)
+	result, err := uc.service.CreateAppPassword(ctx, userId, request.Name)
+	if err != nil {
+		errno.WriteErrorResponse(ctx, w, err)
+		return
+	}
+	errno.WriteResponse(ctx, w, result)
+}
+
+func (uc *UserController) QueryAppPassword(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	userId := ctx.Value(common.Trace_request_uid{}).(string)
+	result, err := uc.service.QueryAppPassword(ctx, userId)
+	if err != nil {
+		errno.WriteErrorResponse(ctx, w, err)
+		return
+	}
+	errno.WriteResponse(ctx, w, result)
+}
+
+func (uc *UserController) DeleteAppPassword(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	aId := mux.Vars(r)["aId"]
+	if len(aId) < 1 {
+		errno.WriteErrorResponse(ctx, w, errno.ErrInvalidParameter)
+		return
+	}
+	userId := ctx.Value(common.Trace_request_uid{}).(string)
+	err := uc.service.DeleteAppPassword(ctx, aId, userId)
+	if err != nil {
+		errno.WriteErrorResponse(ctx, w, err)
+		return
+	}
+	errno.WriteResponse(ctx, w, nil)
+}
+
+func (uc *UserController) CreateAccessKey(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	userId := ctx.Value(common.Trace_request_uid{}).(string)
+	result, err := uc.service.CreateAccessKey(ctx, userId)
+	if err != nil {
+		errno.WriteErrorResponse(ctx, w, err)
+		return
+	}
+	errno.WriteResponse(ctx, w, result)
+}
+
+func (uc *UserController) QueryAccessKey(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	userId := ctx.Value(common.Trace_request_uid{}).(string)
+	result, err := uc.service.QueryAccessKey(ctx, userId)
+	if err != nil {
+		errno.WriteErrorResponse(ctx, w, err)
+		return
+	}
+	errno.WriteResponse(ctx, w, result)
+}
+
+func (uc *UserController) DeleteAccessKey(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	kId := mux.Vars(r)["kId"]
+	if len(kId) < 1 {
+		errno.WriteErrorResponse(ctx, w, errno.ErrInvalidParameter)
+		return
+	}
+	userId := ctx.Value(common.Trace_request_uid{}).(string)
+	err := uc.service.DeleteAccessKey(ctx, kId, userId)
+	if err != nil {
+		errno.WriteErrorResponse(ctx, w, err)
+		return
+	}
+	errno.WriteResponse(ctx, w, nil)
+}
+
 func loginSucResponse(ctx context.Context, w http.ResponseWriter, user *model.UserInfo) {
 	resp := &v1.UserLoginResponse{
 		Username:   user.Username,
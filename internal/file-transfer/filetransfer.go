@@ -13,8 +13,10 @@ import (
 
 	"file-transfer/internal/file-transfer/repo"
 	"file-transfer/internal/file-transfer/service"
+	v1 "file-transfer/pkg/api/v1"
 	"file-transfer/pkg/config"
 	"file-transfer/pkg/db/dbmongo"
+	"file-transfer/pkg/db/dbredis"
 	"file-transfer/pkg/encrypt/aesencrypt"
 	"file-transfer/pkg/log"
 	"file-transfer/pkg/token"
@@ -57,6 +59,54 @@ func createUserCommand() *cobra.Command {
 	return userCmd
 }
 
+func scrubCommand() *cobra.Command {
+	var all bool
+	var bytesPerSecond int64
+	var scrubCmd = &cobra.Command{
+		Use:   "scrub",
+		Short: "re-hash the stored files now and report the damaged ones",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			verflag.PrintAndExitIfRequested()
+
+			config.ReadConfig(cfgFile)
+			log.Init(log.ReadLogOptions())
+			defer log.Sync()
+
+			ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+			defer stop()
+			client := dbmongo.GetClient(context.TODO())
+			defer dbmongo.CloseClient(context.TODO())
+			redisClient := dbredis.GetClient(context.TODO())
+			defer dbredis.CloseClient(context.TODO())
+
+			scrubber := service.NewBlobScrubber(repo.NewFileRepo(client), redisClient)
+			if cmd.Flags().Changed("rate") {
+				scrubber.SetRate(bytesPerSecond)
+			}
+			started := time.Now()
+			var printedAt time.Time
+			report, err := scrubber.Scrub(ctx, all, func(r *v1.BlobScrubReport) {
+				if time.Since(printedAt) < time.Second && r.Checked < r.Total {
+					return
+				}
+				printedAt = time.Now()
+				fmt.Printf("scrubbed %d/%d blobs, %d bytes, %d corrupt, %d missing, %s\n",
+					r.Checked, r.Total, r.Bytes, len(r.Corrupt), len(r.Missing), time.Since(started).Round(time.Second))
+			})
+			if report != nil {
+				jsdata, _ := json.Marshal(report)
+				fmt.Println(string(jsdata))
+			}
+			if err != nil {
+				log.Fatalw(err.Error())
+			}
+		}}
+	scrubCmd.Flags().BoolVar(&all, "all", false, "re-hash every blob, not only the ones older than scrub.age")
+	scrubCmd.Flags().Int64Var(&bytesPerSecond, "rate", 0, "bytes per second read from disk, 0 is unlimited, default scrub.rate")
+	return scrubCmd
+}
+
 func NewCommand() *cobra.Command {
 	log.Debugw("NewCommand begin")
 	cmd := &cobra.Command{
@@ -96,6 +146,7 @@ func NewCommand() *cobra.Command {
 
 	createUserCmd := createUserCommand()
 	cmd.AddCommand(createUserCmd)
+	cmd.AddCommand(scrubCommand())
 	log.Debugw("NewCommand return")
 	return cmd
 }
@@ -123,13 +174,16 @@ func run() error {
 	r := mux.NewRouter()
 
 	// init routers
-	initAllRouters(r)
+	jobCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+	initAllRouters(jobCtx, r)
 
 	http.Handle("/", r)
 	httpsrv := &http.Server{
 		Handler: r,
 		Addr:    addr,
 		// Good practice: enforce timeouts for servers you create!
+		// downloads move their deadline with every chunk, see util.DOWNLOAD_WRITE_TIMEOUT
 		WriteTimeout: 15 * time.Second,
 		ReadTimeout:  15 * time.Second,
 	}
@@ -148,6 +202,7 @@ func run() error {
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 	log.Infow("Shutting down server ...")
+	stopJobs()
 
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	log.Infow("Shutting down server ... in 30 seconds")
@@ -0,0 +1,78 @@
+package repo
+
+import (
+	"context"
+	"file-transfer/pkg/db/dbmongo"
+	"file-transfer/pkg/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func (f *fileRepoImpl) SaveFileGrant(ctx context.Context, m *model.FileGrant) (*model.FileGrant, error) {
+	c := f.db.Database(dbmongo.MONGO_DATABASE).Collection(dbmongo.COLL_FILE_GRANT)
+	// granting the same target to the same user again changes the access
+	filter := bson.M{"ownerId": m.OwnerId, "granteeId": m.GranteeId, "fileId": m.FileId, "folder": m.Folder}
+	update := bson.M{
+		"$set":         bson.M{"access": m.Access},
+		"$setOnInsert": bson.M{"createdAt": m.CreatedAt},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	var result model.FileGrant
+	if err := c.FindOneAndUpdate(ctx, filter, update, opts).Decode(&result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (f *fileRepoImpl) FindFileGrant(ctx context.Context, id string) (*model.FileGrant, error) {
+	c := f.db.Database(dbmongo.MONGO_DATABASE).Collection(dbmongo.COLL_FILE_GRANT)
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	var result model.FileGrant
+	if err := c.FindOne(ctx, bson.M{"_id": objID}).Decode(&result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (f *fileRepoImpl) QueryFileGrantByOwner(ctx context.Context, ownerId string) ([]model.FileGrant, error) {
+	return f.queryFileGrant(ctx, bson.M{"ownerId": ownerId})
+}
+
+func (f *fileRepoImpl) QueryFileGrantByGrantee(ctx context.Context, granteeId string) ([]model.FileGrant, error) {
+	return f.queryFileGrant(ctx, bson.M{"granteeId": granteeId})
+}
+
+func (f *fileRepoImpl) queryFileGrant(ctx context.Context, filter bson.M) ([]model.FileGrant, error) {
+	c := f.db.Database(dbmongo.MONGO_DATABASE).Collection(dbmongo.COLL_FILE_GRANT)
+	cur, err := c.Find(ctx, filter, options.Find().SetSort(bson.M{"createdAt": -1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	results := make([]model.FileGrant, 0)
+	if err := cur.All(ctx, &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (f *fileRepoImpl) DeleteFileGrant(ctx context.Context, id string) error {
+	c := f.db.Database(dbmongo.MONGO_DATABASE).Collection(dbmongo.COLL_FILE_GRANT)
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	_, err = c.DeleteOne(ctx, bson.M{"_id": objID})
+	return err
+}
+
+func (f *fileRepoImpl) DeleteFileGrantByFile(ctx context.Context, fileId string) error {
+	c := f.db.Database(dbmongo.MONGO_DATABASE).Collection(dbmongo.COLL_FILE_GRANT)
+	_, err := c.DeleteMany(ctx, bson.M{"fileId": fileId})
+	return err
+}
@@ -3,9 +3,11 @@ package repo
 import (
 	"context"
 	v1 "file-transfer/pkg/api/v1"
+	"file-transfer/pkg/common"
 	"file-transfer/pkg/db/dbmongo"
 	"file-transfer/pkg/log"
 	"file-transfer/pkg/model"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -19,11 +21,40 @@ type FileRepo interface {
 	FindOneBySha(ctx context.Context, sha string) (*model.FileMeta, error)
 	FindByMetaId(ctx context.Context, ids []string) ([]model.FileMeta, error)
 
-	FindOneByNameAndUser(ctx context.Context, name string, userId string) (*model.UserFile, error)
+	FindOneByNameAndUser(ctx context.Context, name string, folder string, userId string) (*model.UserFile, error)
 	QueryUserFile(ctx context.Context, condition *v1.UserFileQuery) ([]model.UserFile, error)
 	QueryUserFileById(ctx context.Context, userFileId string) (*model.UserFile, error)
+	UpdateUserFileExpire(ctx context.Context, userFileId string, expireAt *time.Time) error
+	FindExpiredUserFile(ctx context.Context, before time.Time, afterId string, limit int64) ([]model.UserFile, error)
 	DeleteUserFile(ctx context.Context, userFileId string) (*model.UserFile, error)
 	DeleteMetaFile(ctx context.Context, metaFileId string) (*model.FileMeta, error)
+	FindMetaToScrub(ctx context.Context, before time.Time, afterId string, limit int64) ([]model.FileMeta, error)
+	CountMetaToScrub(ctx context.Context, before time.Time) (int64, error)
+	UpdateMetaScrub(ctx context.Context, metaId string, status common.ScrubStatus, scrubbedAt time.Time) error
+
+	InsertUserFolder(ctx context.Context, m *model.UserFolder) (*mongo.InsertOneResult, error)
+	FindUserFolder(ctx context.Context, userId string, folder string) (*model.UserFolder, error)
+	QueryUserFolderUnder(ctx context.Context, userId string, folder string) ([]model.UserFolder, error)
+	MoveUserFolder(ctx context.Context, folderId string, path string) error
+	DeleteUserFolderUnder(ctx context.Context, userId string, folder string) error
+	QueryUserFileInFolder(ctx context.Context, userId string, folder string) ([]model.UserFile, error)
+	QueryUserFileUnder(ctx context.Context, userId string, folder string) ([]model.UserFile, error)
+	MoveUserFile(ctx context.Context, userFileId string, folder string, name string) error
+
+	InsertShareCollection(ctx context.Context, m *model.ShareCollection) (string, error)
+	FindShareCollection(ctx context.Context, id string) (*model.ShareCollection, error)
+	InsertFileRequest(ctx context.Context, m *model.FileRequest) (string, error)
+	FindFileRequest(ctx context.Context, id string) (*model.FileRequest, error)
+	// ReserveFileRequestSlot counts one more file unless MaxFiles are received, false when full
+	ReserveFileRequestSlot(ctx context.Context, id string) (bool, error)
+	ReleaseFileRequestSlot(ctx context.Context, id string) error
+	// SaveFileGrant inserts the grant, or updates the access of the same grant made before
+	SaveFileGrant(ctx context.Context, m *model.FileGrant) (*model.FileGrant, error)
+	FindFileGrant(ctx context.Context, id string) (*model.FileGrant, error)
+	QueryFileGrantByOwner(ctx context.Context, ownerId string) ([]model.FileGrant, error)
+	QueryFileGrantByGrantee(ctx context.Context, granteeId string) ([]model.FileGrant, error)
+	DeleteFileGrant(ctx context.Context, id string) error
+	DeleteFileGrantByFile(ctx context.Context, fileId string) error
 
 	CloudinaryNewFile(ctx context.Context, m *model.CloudinaryFile) (*mongo.InsertOneResult, error)
 	CloudinaryQueryAllFile(ctx context.Context, condition *v1.CloudinaryFileReq) ([]model.CloudinaryFile, error)
@@ -61,10 +92,18 @@ func (f *fileRepoImpl) FindOneBySha(ctx context.Context, sha string) (*model.Fil
 	return &result, nil
 }
 
-func (f *fileRepoImpl) FindOneByNameAndUser(ctx context.Context, name string, userId string) (*model.UserFile, error) {
+// folderFilter matches the folder, files uploaded before folders exist are in root
+func folderFilter(folder string) interface{} {
+	if folder == "" {
+		return bson.M{"$in": bson.A{"", nil}}
+	}
+	return folder
+}
+
+func (f *fileRepoImpl) FindOneByNameAndUser(ctx context.Context, name string, folder string, userId string) (*model.UserFile, error) {
 	c := f.db.Database(dbmongo.MONGO_DATABASE).Collection(dbmongo.COLL_USER_FILE)
-	log.C(ctx).Debugw("FindOneByNameAndUser", "name", name, "userId", userId)
-	filter := bson.M{"name": name, "userId": userId}
+	log.C(ctx).Debugw("FindOneByNameAndUser", "name", name, "folder", folder, "userId", userId)
+	filter := bson.M{"name": name, "folder": folderFilter(folder), "userId": userId}
 	var result model.UserFile
 	err := c.FindOne(ctx, filter).Decode(&result)
 	if err != nil {
@@ -114,6 +153,42 @@ func (f *fileRepoImpl) QueryUserFileById(ctx context.Context, userFileId string)
 	return &result, nil
 }
 
+func (f *fileRepoImpl) UpdateUserFileExpire(ctx context.Context, userFileId string, expireAt *time.Time) error {
+	c := f.db.Database(dbmongo.MONGO_DATABASE).Collection(dbmongo.COLL_USER_FILE)
+	objID, err := primitive.ObjectIDFromHex(userFileId)
+	if err != nil {
+		return err
+	}
+	update := bson.M{"$unset": bson.M{"expireAt": ""}}
+	if expireAt != nil {
+		update = bson.M{"$set": bson.M{"expireAt": *expireAt}}
+	}
+	_, err = c.UpdateOne(ctx, bson.M{"_id": objID}, update)
+	return err
+}
+
+// FindExpiredUserFile pages by _id, so files which fail to be removed don't hold up the ones after them
+func (f *fileRepoImpl) FindExpiredUserFile(ctx context.Context, before time.Time, afterId string, limit int64) ([]model.UserFile, error) {
+	c := f.db.Database(dbmongo.MONGO_DATABASE).Collection(dbmongo.COLL_USER_FILE)
+	filter := bson.M{"expireAt": bson.M{"$lte": before}}
+	if len(afterId) > 0 {
+		objID, err := primitive.ObjectIDFromHex(afterId)
+		if err != nil {
+			return nil, err
+		}
+		filter["_id"] = bson.M{"$gt": objID}
+	}
+	opts := options.Find().
+		SetLimit(limit).
+		SetSort(bson.M{"_id": 1})
+	cur, err := c.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	return iterateUserFileResult(ctx, cur)
+}
+
 func iterateUserFileResult(ctx context.Context, cur *mongo.Cursor) ([]model.UserFile, error) {
 	arr := make([]model.UserFile, 0)
 	for cur.Next(ctx) {
@@ -171,6 +246,52 @@ func iterateFileMetaResult(ctx context.Context, cur *mongo.Cursor) ([]model.File
 	return arr, nil
 }
 
+// scrubFilter matches metas never scrubbed or scrubbed before the time
+func scrubFilter(before time.Time) bson.M {
+	return bson.M{"$or": bson.A{
+		bson.M{"scrubbedAt": bson.M{"$exists": false}},
+		bson.M{"scrubbedAt": bson.M{"$lt": before}},
+	}}
+}
+
+// FindMetaToScrub pages by _id, so metas updated during a pass aren't visited twice
+func (f *fileRepoImpl) FindMetaToScrub(ctx context.Context, before time.Time, afterId string, limit int64) ([]model.FileMeta, error) {
+	c := f.db.Database(dbmongo.MONGO_DATABASE).Collection(dbmongo.COLL_FILE_META)
+	filter := scrubFilter(before)
+	if len(afterId) > 0 {
+		objID, err := primitive.ObjectIDFromHex(afterId)
+		if err != nil {
+			return nil, err
+		}
+		filter["_id"] = bson.M{"$gt": objID}
+	}
+	opts := options.Find().
+		SetLimit(limit).
+		SetSort(bson.M{"_id": 1})
+	cur, err := c.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	return iterateFileMetaResult(ctx, cur)
+}
+
+func (f *fileRepoImpl) CountMetaToScrub(ctx context.Context, before time.Time) (int64, error) {
+	c := f.db.Database(dbmongo.MONGO_DATABASE).Collection(dbmongo.COLL_FILE_META)
+	return c.CountDocuments(ctx, scrubFilter(before))
+}
+
+func (f *fileRepoImpl) UpdateMetaScrub(ctx context.Context, metaId string, status common.ScrubStatus, scrubbedAt time.Time) error {
+	c := f.db.Database(dbmongo.MONGO_DATABASE).Collection(dbmongo.COLL_FILE_META)
+	objID, err := primitive.ObjectIDFromHex(metaId)
+	if err != nil {
+		return err
+	}
+	update := bson.M{"$set": bson.M{"scrubStatus": status, "scrubbedAt": scrubbedAt}}
+	_, err = c.UpdateOne(ctx, bson.M{"_id": objID}, update)
+	return err
+}
+
 func (f *fileRepoImpl) DeleteUserFile(ctx context.Context, userFileId string) (*model.UserFile, error) {
 	userC := f.db.Database(dbmongo.MONGO_DATABASE).Collection(dbmongo.COLL_USER_FILE)
 	data, err := f.QueryUserFileById(ctx, userFileId)
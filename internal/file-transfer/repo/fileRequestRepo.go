@@ -0,0 +1,63 @@
+package repo
+
+import (
+	"context"
+	"file-transfer/pkg/db/dbmongo"
+	"file-transfer/pkg/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func (f *fileRepoImpl) InsertFileRequest(ctx context.Context, m *model.FileRequest) (string, error) {
+	c := f.db.Database(dbmongo.MONGO_DATABASE).Collection(dbmongo.COLL_FILE_REQUEST)
+	result, err := c.InsertOne(ctx, m)
+	if err != nil {
+		return "", err
+	}
+	if oid, ok := result.InsertedID.(primitive.ObjectID); ok {
+		return oid.Hex(), nil
+	}
+	return "", err
+}
+
+func (f *fileRepoImpl) FindFileRequest(ctx context.Context, id string) (*model.FileRequest, error) {
+	c := f.db.Database(dbmongo.MONGO_DATABASE).Collection(dbmongo.COLL_FILE_REQUEST)
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	var result model.FileRequest
+	if err := c.FindOne(ctx, bson.M{"_id": objID}).Decode(&result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (f *fileRepoImpl) ReserveFileRequestSlot(ctx context.Context, id string) (bool, error) {
+	c := f.db.Database(dbmongo.MONGO_DATABASE).Collection(dbmongo.COLL_FILE_REQUEST)
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return false, err
+	}
+	// one update checks and counts, concurrent uploads can't pass the limit together
+	filter := bson.M{"_id": objID, "$or": bson.A{
+		bson.M{"maxFiles": 0},
+		bson.M{"$expr": bson.M{"$lt": bson.A{"$received", "$maxFiles"}}},
+	}}
+	result, err := c.UpdateOne(ctx, filter, bson.M{"$inc": bson.M{"received": 1}})
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount == 1, nil
+}
+
+func (f *fileRepoImpl) ReleaseFileRequestSlot(ctx context.Context, id string) error {
+	c := f.db.Database(dbmongo.MONGO_DATABASE).Collection(dbmongo.COLL_FILE_REQUEST)
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	_, err = c.UpdateOne(ctx, bson.M{"_id": objID, "received": bson.M{"$gt": 0}}, bson.M{"$inc": bson.M{"received": -1}})
+	return err
+}
@@ -6,6 +6,8 @@ import (
 	"file-transfer/pkg/db/dbmongo"
 	"file-transfer/pkg/log"
 	"file-transfer/pkg/model"
+	"strings"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -18,6 +20,14 @@ type MessageRepo interface {
 	QueryById(ctx context.Context, mId string) (*model.Message, error)
 	Insert(ctx context.Context, m *model.Message) (*mongo.InsertOneResult, error)
 	Delete(ctx context.Context, mId string, uId string) (*mongo.DeleteResult, error)
+	// Update replaces the text of the message if it is still at version, the text it had is kept
+	// as a revision, at most maxRevisions of them. ErrNoDocuments when the version has changed
+	Update(ctx context.Context, mId string, uId string, version int64, info string, maxRevisions int) (*model.Message, error)
+	// Search finds the messages of the user matching all patterns, newest first. Words narrow
+	// the search down through the text index first, without words the messages of the user are scanned
+	Search(ctx context.Context, q *v1.MessageSearchQuery, patterns []string, words []string) ([]model.Message, error)
+	// EnsureTextIndex creates the text index Search uses for words
+	EnsureTextIndex(ctx context.Context) error
 }
 
 type messageRepoImpl struct {
@@ -51,7 +61,8 @@ func (t *messageRepoImpl) Query(ctx context.Context, condition *v1.MessageQuery)
 	options := options.Find().
 		SetSkip(skip).
 		SetLimit(condition.PageSize).
-		SetSort(bson.M{"createdAt": -1})
+		SetSort(bson.M{"createdAt": -1}).
+		SetProjection(bson.M{"revisions": 0})
 
 	// Call the Find method to retrieve the documents that match the query conditions
 	cur, err := collection.Find(context.Background(), filter, options)
@@ -107,3 +118,87 @@ func (t *messageRepoImpl) QueryById(ctx context.Context, mId string) (*model.Mes
 	}
 	return &result, nil
 }
+
+func (t *messageRepoImpl) Update(ctx context.Context, mId string, uId string, version int64, info string, maxRevisions int) (*model.Message, error) {
+	c := t.db.Database(dbmongo.MONGO_DATABASE).Collection(dbmongo.COLL_MESSAGE)
+	objID, err := primitive.ObjectIDFromHex(mId)
+	if err != nil {
+		return nil, err
+	}
+	filter := bson.M{"_id": objID, "userId": uId, "version": version}
+	if version == 0 {
+		// messages stored before editing have no version
+		filter["version"] = bson.M{"$in": bson.A{0, nil}}
+	}
+	// the pushed revision reads the fields before the update, one pipeline keeps the check and the write together
+	update := bson.A{
+		bson.M{"$set": bson.M{
+			"revisions": bson.M{"$slice": bson.A{
+				bson.M{"$concatArrays": bson.A{
+					bson.M{"$ifNull": bson.A{"$revisions", bson.A{}}},
+					bson.A{bson.M{"version": version, "info": "$info", "updatedAt": "$updatedAt"}},
+				}},
+				-maxRevisions,
+			}},
+			"info":      bson.M{"$literal": info},
+			"updatedAt": time.Now(),
+			"version":   version + 1,
+		}},
+	}
+	var result model.Message
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	if err := c.FindOneAndUpdate(ctx, filter, update, opts).Decode(&result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (t *messageRepoImpl) Search(ctx context.Context, q *v1.MessageSearchQuery, patterns []string, words []string) ([]model.Message, error) {
+	c := t.db.Database(dbmongo.MONGO_DATABASE).Collection(dbmongo.COLL_MESSAGE)
+	filter := bson.M{"userId": q.UserId}
+	and := bson.A{}
+	for _, p := range patterns {
+		and = append(and, bson.M{"info": bson.M{"$regex": p, "$options": "i"}})
+	}
+	if len(and) > 0 {
+		filter["$and"] = and
+	}
+	if len(words) > 0 {
+		filter["$text"] = bson.M{"$search": strings.Join(words, " ")}
+	}
+	created := bson.M{}
+	if q.From != nil {
+		created["$gte"] = *q.From
+	}
+	if q.To != nil {
+		created["$lt"] = *q.To
+	}
+	if len(created) > 0 {
+		filter["createdAt"] = created
+	}
+	opts := options.Find().
+		SetSkip((q.PageNum - 1) * q.PageSize).
+		SetLimit(q.PageSize).
+		SetSort(bson.M{"createdAt": -1}).
+		SetProjection(bson.M{"revisions": 0})
+	cur, err := c.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	results := make([]model.Message, 0)
+	if err := cur.All(ctx, &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (t *messageRepoImpl) EnsureTextIndex(ctx context.Context) error {
+	c := t.db.Database(dbmongo.MONGO_DATABASE).Collection(dbmongo.COLL_MESSAGE)
+	// no stemming and no stop words, a word is found as it was written
+	_, err := c.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "info", Value: "text"}},
+		Options: options.Index().SetName("info_text").SetDefaultLanguage("none"),
+	})
+	return err
+}
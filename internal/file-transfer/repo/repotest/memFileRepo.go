@@ -0,0 +1,385 @@
+// Package repotest provides in-memory repos for tests
+package repotest
+
+import (
+	"context"
+	"file-transfer/internal/file-transfer/repo"
+	"file-transfer/pkg/common"
+	"file-transfer/pkg/model"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// MemFileRepo keeps user files in memory, unused methods panic through the nil FileRepo
+type MemFileRepo struct {
+	repo.FileRepo
+	mu      sync.Mutex
+	Metas   map[string]model.FileMeta
+	Files   map[string]model.UserFile
+	Folders map[string]model.UserFolder
+
+	Collections map[string]model.ShareCollection
+	Requests    map[string]model.FileRequest
+	Grants      map[string]model.FileGrant
+}
+
+func NewMemFileRepo() *MemFileRepo {
+	return &MemFileRepo{
+		Metas:   make(map[string]model.FileMeta),
+		Files:   make(map[string]model.UserFile),
+		Folders: make(map[string]model.UserFolder),
+
+		Collections: make(map[string]model.ShareCollection),
+		Requests:    make(map[string]model.FileRequest),
+		Grants:      make(map[string]model.FileGrant),
+	}
+}
+
+func isUnder(folder string, p string) bool {
+	return p == "" || folder == p || strings.HasPrefix(folder, p+"/")
+}
+
+func (m *MemFileRepo) InsertFileMeta(ctx context.Context, meta *model.FileMeta) (*mongo.InsertOneResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	id := primitive.NewObjectID()
+	meta.Id = id.Hex()
+	m.Metas[meta.Id] = *meta
+	return &mongo.InsertOneResult{InsertedID: id}, nil
+}
+
+func (m *MemFileRepo) InsertUserFile(ctx context.Context, f *model.UserFile) (*mongo.InsertOneResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	id := primitive.NewObjectID()
+	f.Id = id.Hex()
+	m.Files[f.Id] = *f
+	return &mongo.InsertOneResult{InsertedID: id}, nil
+}
+
+func (m *MemFileRepo) FindOneBySha(ctx context.Context, sha string) (*model.FileMeta, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, meta := range m.Metas {
+		if meta.Sha == sha {
+			return &meta, nil
+		}
+	}
+	return nil, mongo.ErrNoDocuments
+}
+
+func (m *MemFileRepo) FindByMetaId(ctx context.Context, ids []string) ([]model.FileMeta, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	result := make([]model.FileMeta, 0)
+	for _, id := range ids {
+		if meta, ok := m.Metas[id]; ok {
+			result = append(result, meta)
+		}
+	}
+	return result, nil
+}
+
+func (m *MemFileRepo) FindOneByNameAndUser(ctx context.Context, name string, folder string, userId string) (*model.UserFile, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, f := range m.Files {
+		if f.Name == name && f.Folder == folder && f.UserId == userId {
+			return &f, nil
+		}
+	}
+	return nil, mongo.ErrNoDocuments
+}
+
+func (m *MemFileRepo) QueryUserFileById(ctx context.Context, userFileId string) (*model.UserFile, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if f, ok := m.Files[userFileId]; ok {
+		return &f, nil
+	}
+	return nil, mongo.ErrNoDocuments
+}
+
+func (m *MemFileRepo) FindExpiredUserFile(ctx context.Context, before time.Time, afterId string, limit int64) ([]model.UserFile, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	list := make([]model.UserFile, 0)
+	for _, f := range m.Files {
+		if f.Id > afterId && f.ExpireAt != nil && !f.ExpireAt.After(before) {
+			list = append(list, f)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Id < list[j].Id })
+	if int64(len(list)) > limit {
+		list = list[:limit]
+	}
+	return list, nil
+}
+
+func (m *MemFileRepo) DeleteUserFile(ctx context.Context, userFileId string) (*model.UserFile, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, ok := m.Files[userFileId]
+	if !ok {
+		return nil, mongo.ErrNoDocuments
+	}
+	delete(m.Files, userFileId)
+	return &f, nil
+}
+
+func (m *MemFileRepo) DeleteMetaFile(ctx context.Context, metaId string) (*model.FileMeta, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, f := range m.Files {
+		if f.MetaId == metaId {
+			return nil, nil
+		}
+	}
+	meta := m.Metas[metaId]
+	delete(m.Metas, metaId)
+	return &meta, nil
+}
+
+func (m *MemFileRepo) FindMetaToScrub(ctx context.Context, before time.Time, afterId string, limit int64) ([]model.FileMeta, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	result := make([]model.FileMeta, 0)
+	for _, meta := range m.Metas {
+		if meta.Id > afterId && (meta.ScrubbedAt == nil || meta.ScrubbedAt.Before(before)) {
+			result = append(result, meta)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Id < result[j].Id })
+	if int64(len(result)) > limit {
+		result = result[:limit]
+	}
+	return result, nil
+}
+
+func (m *MemFileRepo) CountMetaToScrub(ctx context.Context, before time.Time) (int64, error) {
+	list, err := m.FindMetaToScrub(ctx, before, "", int64(len(m.Metas)))
+	return int64(len(list)), err
+}
+
+func (m *MemFileRepo) UpdateMetaScrub(ctx context.Context, metaId string, status common.ScrubStatus, scrubbedAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	meta, ok := m.Metas[metaId]
+	if !ok {
+		return mongo.ErrNoDocuments
+	}
+	meta.ScrubStatus = status
+	meta.ScrubbedAt = &scrubbedAt
+	m.Metas[metaId] = meta
+	return nil
+}
+
+func (m *MemFileRepo) InsertUserFolder(ctx context.Context, f *model.UserFolder) (*mongo.InsertOneResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	id := primitive.NewObjectID()
+	f.Id = id.Hex()
+	m.Folders[f.Id] = *f
+	return &mongo.InsertOneResult{InsertedID: id}, nil
+}
+
+func (m *MemFileRepo) FindUserFolder(ctx context.Context, userId string, folder string) (*model.UserFolder, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, f := range m.Folders {
+		if f.UserId == userId && f.Path == folder {
+			return &f, nil
+		}
+	}
+	return nil, mongo.ErrNoDocuments
+}
+
+func (m *MemFileRepo) QueryUserFolderUnder(ctx context.Context, userId string, folder string) ([]model.UserFolder, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	result := make([]model.UserFolder, 0)
+	for _, f := range m.Folders {
+		if f.UserId == userId && isUnder(f.Path, folder) {
+			result = append(result, f)
+		}
+	}
+	return result, nil
+}
+
+func (m *MemFileRepo) MoveUserFolder(ctx context.Context, folderId string, path string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f := m.Folders[folderId]
+	f.Path = path
+	m.Folders[folderId] = f
+	return nil
+}
+
+func (m *MemFileRepo) DeleteUserFolderUnder(ctx context.Context, userId string, folder string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, f := range m.Folders {
+		if f.UserId == userId && isUnder(f.Path, folder) {
+			delete(m.Folders, id)
+		}
+	}
+	return nil
+}
+
+func (m *MemFileRepo) QueryUserFileInFolder(ctx context.Context, userId string, folder string) ([]model.UserFile, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	result := make([]model.UserFile, 0)
+	for _, f := range m.Files {
+		if f.UserId == userId && f.Folder == folder {
+			result = append(result, f)
+		}
+	}
+	return result, nil
+}
+
+func (m *MemFileRepo) QueryUserFileUnder(ctx context.Context, userId string, folder string) ([]model.UserFile, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	result := make([]model.UserFile, 0)
+	for _, f := range m.Files {
+		if f.UserId == userId && isUnder(f.Folder, folder) {
+			result = append(result, f)
+		}
+	}
+	return result, nil
+}
+
+func (m *MemFileRepo) MoveUserFile(ctx context.Context, userFileId string, folder string, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f := m.Files[userFileId]
+	f.Folder, f.Name = folder, name
+	m.Files[userFileId] = f
+	return nil
+}
+
+func (m *MemFileRepo) InsertShareCollection(ctx context.Context, c *model.ShareCollection) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c.Id = primitive.NewObjectID().Hex()
+	m.Collections[c.Id] = *c
+	return c.Id, nil
+}
+
+func (m *MemFileRepo) FindShareCollection(ctx context.Context, id string) (*model.ShareCollection, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if c, ok := m.Collections[id]; ok {
+		return &c, nil
+	}
+	return nil, mongo.ErrNoDocuments
+}
+
+func (m *MemFileRepo) InsertFileRequest(ctx context.Context, r *model.FileRequest) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r.Id = primitive.NewObjectID().Hex()
+	m.Requests[r.Id] = *r
+	return r.Id, nil
+}
+
+func (m *MemFileRepo) FindFileRequest(ctx context.Context, id string) (*model.FileRequest, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if r, ok := m.Requests[id]; ok {
+		return &r, nil
+	}
+	return nil, mongo.ErrNoDocuments
+}
+
+func (m *MemFileRepo) ReserveFileRequestSlot(ctx context.Context, id string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, ok := m.Requests[id]
+	if !ok || (r.MaxFiles > 0 && r.Received >= r.MaxFiles) {
+		return false, nil
+	}
+	r.Received++
+	m.Requests[id] = r
+	return true, nil
+}
+
+func (m *MemFileRepo) ReleaseFileRequestSlot(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if r, ok := m.Requests[id]; ok && r.Received > 0 {
+		r.Received--
+		m.Requests[id] = r
+	}
+	return nil
+}
+
+func (m *MemFileRepo) SaveFileGrant(ctx context.Context, g *model.FileGrant) (*model.FileGrant, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, exist := range m.Grants {
+		if exist.OwnerId == g.OwnerId && exist.GranteeId == g.GranteeId && exist.FileId == g.FileId && exist.Folder == g.Folder {
+			exist.Access = g.Access
+			m.Grants[id] = exist
+			return &exist, nil
+		}
+	}
+	saved := *g
+	saved.Id = primitive.NewObjectID().Hex()
+	m.Grants[saved.Id] = saved
+	return &saved, nil
+}
+
+func (m *MemFileRepo) FindFileGrant(ctx context.Context, id string) (*model.FileGrant, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if g, ok := m.Grants[id]; ok {
+		return &g, nil
+	}
+	return nil, mongo.ErrNoDocuments
+}
+
+func (m *MemFileRepo) QueryFileGrantByOwner(ctx context.Context, ownerId string) ([]model.FileGrant, error) {
+	return m.queryFileGrant(func(g model.FileGrant) bool { return g.OwnerId == ownerId }), nil
+}
+
+func (m *MemFileRepo) QueryFileGrantByGrantee(ctx context.Context, granteeId string) ([]model.FileGrant, error) {
+	return m.queryFileGrant(func(g model.FileGrant) bool { return g.GranteeId == granteeId }), nil
+}
+
+func (m *MemFileRepo) queryFileGrant(match func(g model.FileGrant) bool) []model.FileGrant {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	results := make([]model.FileGrant, 0)
+	for _, g := range m.Grants {
+		if match(g) {
+			results = append(results, g)
+		}
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Id > results[j].Id })
+	return results
+}
+
+func (m *MemFileRepo) DeleteFileGrant(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.Grants, id)
+	return nil
+}
+
+func (m *MemFileRepo) DeleteFileGrantByFile(ctx context.Context, fileId string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, g := range m.Grants {
+		if g.FileId == fileId {
+			delete(m.Grants, id)
+		}
+	}
+	return nil
+}
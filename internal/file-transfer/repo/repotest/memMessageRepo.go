@@ -0,0 +1,107 @@
+package repotest
+
+import (
+	"context"
+	"file-transfer/internal/file-transfer/repo"
+	v1 "file-transfer/pkg/api/v1"
+	"file-transfer/pkg/model"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// MemMessageRepo keeps messages in memory, unused methods panic through the nil MessageRepo
+type MemMessageRepo struct {
+	repo.MessageRepo
+	mu       sync.Mutex
+	Messages map[string]model.Message
+}
+
+func NewMemMessageRepo() *MemMessageRepo {
+	return &MemMessageRepo{Messages: make(map[string]model.Message)}
+}
+
+func (m *MemMessageRepo) Insert(ctx context.Context, msg *model.Message) (*mongo.InsertOneResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	id := primitive.NewObjectID()
+	msg.Id = id.Hex()
+	m.Messages[msg.Id] = *msg
+	return &mongo.InsertOneResult{InsertedID: id}, nil
+}
+
+func (m *MemMessageRepo) QueryById(ctx context.Context, mId string) (*model.Message, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if msg, ok := m.Messages[mId]; ok {
+		msg.Revisions = append([]model.MessageRevision(nil), msg.Revisions...)
+		return &msg, nil
+	}
+	return nil, mongo.ErrNoDocuments
+}
+
+func (m *MemMessageRepo) Delete(ctx context.Context, mId string, uId string) (*mongo.DeleteResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if msg, ok := m.Messages[mId]; ok && msg.UserId == uId {
+		delete(m.Messages, mId)
+		return &mongo.DeleteResult{DeletedCount: 1}, nil
+	}
+	return &mongo.DeleteResult{}, nil
+}
+
+func (m *MemMessageRepo) Update(ctx context.Context, mId string, uId string, version int64, info string, maxRevisions int) (*model.Message, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	msg, ok := m.Messages[mId]
+	if !ok || msg.UserId != uId || msg.Version != version {
+		return nil, mongo.ErrNoDocuments
+	}
+	revisions := append(append([]model.MessageRevision(nil), msg.Revisions...),
+		model.MessageRevision{Version: msg.Version, Info: msg.Info, UpdatedAt: msg.UpdatedAt})
+	if len(revisions) > maxRevisions {
+		revisions = revisions[len(revisions)-maxRevisions:]
+	}
+	msg.Revisions = revisions
+	msg.Info = info
+	msg.UpdatedAt = time.Now()
+	msg.Version = version + 1
+	m.Messages[mId] = msg
+	return &msg, nil
+}
+
+// Search checks the patterns on every message, like mongo does without the text index
+func (m *MemMessageRepo) Search(ctx context.Context, q *v1.MessageSearchQuery, patterns []string, words []string) ([]model.Message, error) {
+	res := make([]*regexp.Regexp, len(patterns))
+	for i, p := range patterns {
+		res[i] = regexp.MustCompile("(?i)" + p)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	found := make([]model.Message, 0)
+	for _, msg := range m.Messages {
+		if msg.UserId != q.UserId || (q.From != nil && msg.CreatedAt.Before(*q.From)) || (q.To != nil && !msg.CreatedAt.Before(*q.To)) {
+			continue
+		}
+		matched := true
+		for _, re := range res {
+			matched = matched && re.MatchString(msg.Info)
+		}
+		if matched {
+			msg.Revisions = nil
+			found = append(found, msg)
+		}
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].CreatedAt.After(found[j].CreatedAt) })
+	start := min(int((q.PageNum-1)*q.PageSize), len(found))
+	end := min(start+int(q.PageSize), len(found))
+	return found[start:end], nil
+}
+
+func (m *MemMessageRepo) EnsureTextIndex(ctx context.Context) error {
+	return nil
+}
@@ -0,0 +1,58 @@
+package repotest
+
+import (
+	"context"
+	"file-transfer/internal/file-transfer/repo"
+	v1 "file-transfer/pkg/api/v1"
+	"file-transfer/pkg/model"
+	"sort"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemShareAccessRepo keeps share accesses in memory
+type MemShareAccessRepo struct {
+	mu       sync.Mutex
+	Accesses []model.ShareAccess
+}
+
+var _ repo.ShareAccessRepo = (*MemShareAccessRepo)(nil)
+
+func NewMemShareAccessRepo() *MemShareAccessRepo {
+	return &MemShareAccessRepo{}
+}
+
+func (m *MemShareAccessRepo) Insert(ctx context.Context, a *model.ShareAccess) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	a.Id = primitive.NewObjectID().Hex()
+	m.Accesses = append(m.Accesses, *a)
+	return nil
+}
+
+func (m *MemShareAccessRepo) Query(ctx context.Context, q *v1.ShareAccessQuery) ([]model.ShareAccess, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	arr := make([]model.ShareAccess, 0)
+	for _, a := range m.Accesses {
+		if a.UserId != q.UserId || (len(q.ShareKey) > 0 && a.ShareKey != q.ShareKey) ||
+			(len(q.Outcome) > 0 && a.Outcome != q.Outcome) {
+			continue
+		}
+		arr = append(arr, a)
+	}
+	sort.SliceStable(arr, func(i, j int) bool { return arr[i].Time.After(arr[j].Time) })
+	skip := int64(0)
+	if q.PageNum-1 > 0 {
+		skip = (q.PageNum - 1) * q.PageSize
+	}
+	if skip >= int64(len(arr)) {
+		return []model.ShareAccess{}, nil
+	}
+	arr = arr[skip:]
+	if q.PageSize > 0 && int64(len(arr)) > q.PageSize {
+		arr = arr[:q.PageSize]
+	}
+	return arr, nil
+}
@@ -0,0 +1,63 @@
+package repo
+
+import (
+	"context"
+	v1 "file-transfer/pkg/api/v1"
+	"file-transfer/pkg/db/dbmongo"
+	"file-transfer/pkg/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type ShareAccessRepo interface {
+	Insert(ctx context.Context, a *model.ShareAccess) error
+	// Query returns the accesses of the links of q.UserId, the latest first
+	Query(ctx context.Context, q *v1.ShareAccessQuery) ([]model.ShareAccess, error)
+}
+
+type shareAccessRepoImpl struct {
+	db *mongo.Client
+}
+
+var _ ShareAccessRepo = (*shareAccessRepoImpl)(nil)
+
+func NewShareAccessRepo(db *mongo.Client) ShareAccessRepo {
+	return &shareAccessRepoImpl{db}
+}
+
+func (t *shareAccessRepoImpl) Insert(ctx context.Context, a *model.ShareAccess) error {
+	collection := t.db.Database(dbmongo.MONGO_DATABASE).Collection(dbmongo.COLL_SHARE_ACCESS)
+	_, err := collection.InsertOne(ctx, a)
+	return err
+}
+
+func (t *shareAccessRepoImpl) Query(ctx context.Context, q *v1.ShareAccessQuery) ([]model.ShareAccess, error) {
+	collection := t.db.Database(dbmongo.MONGO_DATABASE).Collection(dbmongo.COLL_SHARE_ACCESS)
+	filter := bson.M{"userId": bson.M{"$eq": q.UserId}}
+	if len(q.ShareKey) > 0 {
+		filter["shareKey"] = bson.M{"$eq": q.ShareKey}
+	}
+	if len(q.Outcome) > 0 {
+		filter["outcome"] = bson.M{"$eq": q.Outcome}
+	}
+	var skip int64 = 0
+	if q.PageNum-1 > 0 {
+		skip = (q.PageNum - 1) * q.PageSize
+	}
+	opts := options.Find().
+		SetSkip(skip).
+		SetLimit(q.PageSize).
+		SetSort(bson.M{"time": -1})
+	cur, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	arr := make([]model.ShareAccess, 0)
+	if err := cur.All(ctx, &arr); err != nil {
+		return nil, err
+	}
+	return arr, nil
+}
@@ -0,0 +1,35 @@
+package repo
+
+import (
+	"context"
+	"file-transfer/pkg/db/dbmongo"
+	"file-transfer/pkg/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func (f *fileRepoImpl) InsertShareCollection(ctx context.Context, m *model.ShareCollection) (string, error) {
+	c := f.db.Database(dbmongo.MONGO_DATABASE).Collection(dbmongo.COLL_SHARE_COLLECTION)
+	result, err := c.InsertOne(ctx, m)
+	if err != nil {
+		return "", err
+	}
+	if oid, ok := result.InsertedID.(primitive.ObjectID); ok {
+		return oid.Hex(), nil
+	}
+	return "", err
+}
+
+func (f *fileRepoImpl) FindShareCollection(ctx context.Context, id string) (*model.ShareCollection, error) {
+	c := f.db.Database(dbmongo.MONGO_DATABASE).Collection(dbmongo.COLL_SHARE_COLLECTION)
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	var result model.ShareCollection
+	if err := c.FindOne(ctx, bson.M{"_id": objID}).Decode(&result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
@@ -0,0 +1,113 @@
+package repo
+
+import (
+	"context"
+	"file-transfer/pkg/db/dbmongo"
+	"file-transfer/pkg/log"
+	"file-transfer/pkg/model"
+	"regexp"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// underFolderFilter matches the folder and every folder below it, root matches all
+func underFolderFilter(field string, folder string) bson.M {
+	if folder == "" {
+		return bson.M{}
+	}
+	return bson.M{"$or": bson.A{
+		bson.M{field: folder},
+		bson.M{field: bson.M{"$regex": "^" + regexp.QuoteMeta(folder+"/")}},
+	}}
+}
+
+func (f *fileRepoImpl) InsertUserFolder(ctx context.Context, m *model.UserFolder) (*mongo.InsertOneResult, error) {
+	c := f.db.Database(dbmongo.MONGO_DATABASE).Collection(dbmongo.COLL_USER_FOLDER)
+	return c.InsertOne(ctx, m)
+}
+
+func (f *fileRepoImpl) FindUserFolder(ctx context.Context, userId string, folder string) (*model.UserFolder, error) {
+	c := f.db.Database(dbmongo.MONGO_DATABASE).Collection(dbmongo.COLL_USER_FOLDER)
+	filter := bson.M{"userId": userId, "path": folder}
+	var result model.UserFolder
+	err := c.FindOne(ctx, filter).Decode(&result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (f *fileRepoImpl) QueryUserFolderUnder(ctx context.Context, userId string, folder string) ([]model.UserFolder, error) {
+	c := f.db.Database(dbmongo.MONGO_DATABASE).Collection(dbmongo.COLL_USER_FOLDER)
+	filter := underFolderFilter("path", folder)
+	filter["userId"] = userId
+	cur, err := c.Find(ctx, filter, options.Find().SetSort(bson.M{"path": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	arr := make([]model.UserFolder, 0)
+	for cur.Next(ctx) {
+		var result model.UserFolder
+		if err := cur.Decode(&result); err != nil {
+			log.Errorw(err.Error())
+			return nil, err
+		}
+		arr = append(arr, result)
+	}
+	return arr, cur.Err()
+}
+
+func (f *fileRepoImpl) MoveUserFolder(ctx context.Context, folderId string, path string) error {
+	c := f.db.Database(dbmongo.MONGO_DATABASE).Collection(dbmongo.COLL_USER_FOLDER)
+	objID, err := primitive.ObjectIDFromHex(folderId)
+	if err != nil {
+		return err
+	}
+	_, err = c.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$set": bson.M{"path": path}})
+	return err
+}
+
+func (f *fileRepoImpl) DeleteUserFolderUnder(ctx context.Context, userId string, folder string) error {
+	c := f.db.Database(dbmongo.MONGO_DATABASE).Collection(dbmongo.COLL_USER_FOLDER)
+	filter := underFolderFilter("path", folder)
+	filter["userId"] = userId
+	_, err := c.DeleteMany(ctx, filter)
+	return err
+}
+
+func (f *fileRepoImpl) QueryUserFileInFolder(ctx context.Context, userId string, folder string) ([]model.UserFile, error) {
+	c := f.db.Database(dbmongo.MONGO_DATABASE).Collection(dbmongo.COLL_USER_FILE)
+	filter := bson.M{"userId": userId, "folder": folderFilter(folder)}
+	cur, err := c.Find(ctx, filter, options.Find().SetSort(bson.M{"name": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	return iterateUserFileResult(ctx, cur)
+}
+
+func (f *fileRepoImpl) QueryUserFileUnder(ctx context.Context, userId string, folder string) ([]model.UserFile, error) {
+	c := f.db.Database(dbmongo.MONGO_DATABASE).Collection(dbmongo.COLL_USER_FILE)
+	filter := underFolderFilter("folder", folder)
+	filter["userId"] = userId
+	cur, err := c.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	return iterateUserFileResult(ctx, cur)
+}
+
+func (f *fileRepoImpl) MoveUserFile(ctx context.Context, userFileId string, folder string, name string) error {
+	c := f.db.Database(dbmongo.MONGO_DATABASE).Collection(dbmongo.COLL_USER_FILE)
+	objID, err := primitive.ObjectIDFromHex(userFileId)
+	if err != nil {
+		return err
+	}
+	_, err = c.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$set": bson.M{"folder": folder, "name": name}})
+	return err
+}
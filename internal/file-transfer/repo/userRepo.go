@@ -4,6 +4,7 @@ import (
 	"context"
 	"file-transfer/pkg/db/dbmongo"
 	"file-transfer/pkg/model"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -14,6 +15,16 @@ type UserRepo interface {
 	Create(ctx context.Context, user *model.UserInfo) (string, error)
 	FindById(ctx context.Context, id string) (*model.UserInfo, error)
 	FindByUsername(ctx context.Context, username string) (*model.UserInfo, error)
+	SetUrlSalt(ctx context.Context, id string, salt string) error
+
+	CreateAppPassword(ctx context.Context, m *model.AppPassword) (string, error)
+	QueryAppPassword(ctx context.Context, userId string) ([]model.AppPassword, error)
+	DeleteAppPassword(ctx context.Context, id string, userId string) (*mongo.DeleteResult, error)
+
+	CreateAccessKey(ctx context.Context, m *model.AccessKey) (string, error)
+	QueryAccessKey(ctx context.Context, userId string) ([]model.AccessKey, error)
+	FindAccessKey(ctx context.Context, accessKeyId string) (*model.AccessKey, error)
+	DeleteAccessKey(ctx context.Context, id string, userId string) (*mongo.DeleteResult, error)
 }
 
 type userRepoImpl struct {
@@ -65,3 +76,103 @@ func (u *userRepoImpl) FindByUsername(ctx context.Context, username string) (*mo
 	}
 	return user, nil
 }
+
+func (u *userRepoImpl) SetUrlSalt(ctx context.Context, id string, salt string) error {
+	collection := u.db.Database(dbmongo.MONGO_DATABASE).Collection(dbmongo.COLL_USER)
+
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	result, err := collection.UpdateByID(ctx, objID, bson.M{"$set": bson.M{"urlSalt": salt, "updatedAt": time.Now()}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+func (u *userRepoImpl) CreateAppPassword(ctx context.Context, m *model.AppPassword) (string, error) {
+	collection := u.db.Database(dbmongo.MONGO_DATABASE).Collection(dbmongo.COLL_APP_PASSWORD)
+	result, err := collection.InsertOne(ctx, m)
+	if err != nil {
+		return "", err
+	}
+	if oid, ok := result.InsertedID.(primitive.ObjectID); ok {
+		return oid.Hex(), nil
+	}
+	return "", err
+}
+
+func (u *userRepoImpl) QueryAppPassword(ctx context.Context, userId string) ([]model.AppPassword, error) {
+	collection := u.db.Database(dbmongo.MONGO_DATABASE).Collection(dbmongo.COLL_APP_PASSWORD)
+	cur, err := collection.Find(ctx, bson.M{"userId": bson.M{"$eq": userId}})
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	arr := make([]model.AppPassword, 0)
+	if err := cur.All(ctx, &arr); err != nil {
+		return nil, err
+	}
+	return arr, nil
+}
+
+func (u *userRepoImpl) DeleteAppPassword(ctx context.Context, id string, userId string) (*mongo.DeleteResult, error) {
+	collection := u.db.Database(dbmongo.MONGO_DATABASE).Collection(dbmongo.COLL_APP_PASSWORD)
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	filter := bson.M{"_id": bson.M{"$eq": objID}, "userId": bson.M{"$eq": userId}}
+	return collection.DeleteOne(ctx, filter)
+}
+
+func (u *userRepoImpl) CreateAccessKey(ctx context.Context, m *model.AccessKey) (string, error) {
+	collection := u.db.Database(dbmongo.MONGO_DATABASE).Collection(dbmongo.COLL_ACCESS_KEY)
+	result, err := collection.InsertOne(ctx, m)
+	if err != nil {
+		return "", err
+	}
+	if oid, ok := result.InsertedID.(primitive.ObjectID); ok {
+		return oid.Hex(), nil
+	}
+	return "", err
+}
+
+func (u *userRepoImpl) QueryAccessKey(ctx context.Context, userId string) ([]model.AccessKey, error) {
+	collection := u.db.Database(dbmongo.MONGO_DATABASE).Collection(dbmongo.COLL_ACCESS_KEY)
+	cur, err := collection.Find(ctx, bson.M{"userId": bson.M{"$eq": userId}})
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	arr := make([]model.AccessKey, 0)
+	if err := cur.All(ctx, &arr); err != nil {
+		return nil, err
+	}
+	return arr, nil
+}
+
+func (u *userRepoImpl) FindAccessKey(ctx context.Context, accessKeyId string) (*model.AccessKey, error) {
+	collection := u.db.Database(dbmongo.MONGO_DATABASE).Collection(dbmongo.COLL_ACCESS_KEY)
+	result := &model.AccessKey{}
+	err := collection.FindOne(ctx, bson.M{"accessKeyId": bson.M{"$eq": accessKeyId}}).Decode(result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (u *userRepoImpl) DeleteAccessKey(ctx context.Context, id string, userId string) (*mongo.DeleteResult, error) {
+	collection := u.db.Database(dbmongo.MONGO_DATABASE).Collection(dbmongo.COLL_ACCESS_KEY)
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	filter := bson.M{"_id": bson.M{"$eq": objID}, "userId": bson.M{"$eq": userId}}
+	return collection.DeleteOne(ctx, filter)
+}
@@ -10,6 +10,7 @@ import (
 	"file-transfer/pkg/db/dbmongo"
 	"file-transfer/pkg/db/dbredis"
 	"file-transfer/pkg/middleware"
+	"file-transfer/pkg/urlsign"
 
 	"github.com/gorilla/mux"
 )
@@ -36,43 +37,107 @@ func authWrapper(f middleware.FiletransferContextHandlerFunc) http.HandlerFunc {
 	return handleMuxChainFunc(middleware.RequestFilter(middleware.CheckAuthFilter(handleMuxChain(f))))
 }
 
-func initAllRouters(r *mux.Router) error {
+func initAllRouters(ctx context.Context, r *mux.Router) error {
 	redisClient := dbredis.GetClient(context.Background())
 	mongoClient := dbmongo.GetClient(context.Background())
 	messageRepo := repo.NewMessageRepo(mongoClient)
 	userRepo := repo.NewUserRepo(mongoClient)
 	fileRepo := repo.NewFileRepo(mongoClient)
+	shareAccessRepo := repo.NewShareAccessRepo(mongoClient)
 
-	shareService := service.NewShareService(redisClient)
-	messageService := service.NewMessageService(messageRepo, shareService)
+	shareService := service.NewShareService(redisClient, shareAccessRepo)
+	progressService := service.NewProgressService(redisClient)
+	messageService := service.NewMessageService(messageRepo, shareService, redisClient)
 	userService := service.NewUserService(userRepo, redisClient, shareService)
-	fileService := service.NewFileService(fileRepo, shareService)
+	fileService := service.NewFileService(fileRepo, shareService, progressService, messageService)
+
+	// background jobs stop with ctx
+	go service.NewFileExpireSweeper(fileRepo, fileService, redisClient).Run(ctx)
+	go service.NewBlobScrubber(fileRepo, redisClient).Run(ctx)
 
 	messageController := controller.NewMessageController(messageService)
 	userController := controller.NewUserController(userService)
 	fileController := controller.NewFileController(fileService)
+	progressController := controller.NewProgressController(progressService)
+	shareController := controller.NewShareController(shareService)
+	davController := controller.NewDavController(userService, service.NewDavFileSystem(fileRepo, fileService))
+	s3Controller := controller.NewS3Controller(service.NewS3Service(fileRepo, userRepo, fileService))
+	signedUrlController := controller.NewSignedUrlController(service.NewSignedUrlService(userRepo, fileRepo), fileService)
+	fileGrantController := controller.NewFileGrantController(service.NewFileGrantService(fileRepo, userRepo, fileService))
 
 	// public
 	r.NewRoute().Methods("GET").Path("/home").HandlerFunc(wrapper(controller.Home))
 	r.NewRoute().Methods("POST").Path("/trysignin").HandlerFunc(wrapper(userController.Login))
 	r.NewRoute().Methods("GET").Path("/ls/{loginKey}").HandlerFunc(wrapper(userController.LoginByShareLink))
-	r.NewRoute().Methods("GET").Path("/ms/{key}").HandlerFunc(wrapper(messageController.ReadShareMessage))
-	r.NewRoute().Methods("GET").Path("/fs/{key}").HandlerFunc(wrapper(fileController.ReadShare))
+	r.NewRoute().Methods("GET", "POST").Path("/ms/{key}").HandlerFunc(wrapper(messageController.ReadShareMessage))
+	r.NewRoute().Methods("GET", "POST").Path("/fs/{key}").HandlerFunc(wrapper(fileController.ReadShare))
+	r.NewRoute().Methods("GET").Path("/fs/{key}/preview").HandlerFunc(wrapper(fileController.PreviewShare))
+	r.NewRoute().Methods("GET").Path(controller.SHARE_PAGE_PREFIX + "/fs/{key}").HandlerFunc(wrapper(fileController.SharePage))
+	r.NewRoute().Methods("GET").Path(controller.SHARE_PAGE_PREFIX + "/fs/{key}/qr").HandlerFunc(wrapper(fileController.SharePageQr))
+	r.NewRoute().Methods("GET", "POST").Path("/cs/{key}").HandlerFunc(wrapper(fileController.ReadCollection))
+	r.NewRoute().Methods("GET", "POST").Path("/cs/{key}/zip").HandlerFunc(wrapper(fileController.ReadCollectionZip))
+	r.NewRoute().Methods("GET", "POST").Path("/cs/{key}/file/{fId}").HandlerFunc(wrapper(fileController.ReadCollectionFile))
+	r.NewRoute().Methods("GET").Path("/fr/{key}").HandlerFunc(wrapper(fileController.ReadFileRequest))
+	r.NewRoute().Methods("POST").Path("/fr/{key}").HandlerFunc(wrapper(fileController.UploadFileRequest))
+	r.NewRoute().Methods("GET").Path("/sn/{key}").HandlerFunc(wrapper(messageController.SecretNotePage))
+	r.NewRoute().Methods("POST").Path("/sn/{key}").HandlerFunc(wrapper(messageController.ReadSecretNote))
+	r.NewRoute().Methods("GET").Path("/" + service.PICKUP_CODE_PATH + "/{code}").HandlerFunc(wrapper(shareController.RedeemPickupCode))
+	// a signed download url carries its own authorization, without it the request needs auth below
+	r.NewRoute().Methods("GET").Path("/file/{fId}").Queries(urlsign.PARAM_SIG, "{sig}").HandlerFunc(wrapper(signedUrlController.DownloadFile))
+	// webdav checks app password or token by itself
+	r.NewRoute().Path(controller.DAV_PREFIX).HandlerFunc(wrapper(davController.Serve))
+	r.NewRoute().PathPrefix(controller.DAV_PREFIX + "/").HandlerFunc(wrapper(davController.Serve))
+	// s3 checks the SigV4 signature of an access key by itself
+	r.NewRoute().Path(controller.S3_PREFIX).HandlerFunc(wrapper(s3Controller.Serve))
+	r.NewRoute().PathPrefix(controller.S3_PREFIX + "/").HandlerFunc(wrapper(s3Controller.Serve))
 
 	// need auth
 	r.NewRoute().Methods("GET").Path("/msg").HandlerFunc(authWrapper(messageController.ReadMessageDefault))
 	r.NewRoute().Methods("POST").Path("/msg").HandlerFunc(authWrapper(messageController.ReadMessageByPage))
 	r.NewRoute().Methods("PUT").Path("/msg").HandlerFunc(authWrapper(messageController.SendMessage))
+	r.NewRoute().Methods("POST").Path("/msg/search").HandlerFunc(authWrapper(messageController.SearchMessage))
 	r.NewRoute().Methods("DELETE").Path("/msg/{mId}").HandlerFunc(authWrapper(messageController.DeleteMessage))
+	r.NewRoute().Methods("PATCH").Path("/msg/{mId}").HandlerFunc(authWrapper(messageController.UpdateMessage))
+	r.NewRoute().Methods("GET").Path("/msg/{mId}/revisions").HandlerFunc(authWrapper(messageController.MessageRevisions))
+	r.NewRoute().Methods("POST").Path("/msg/{mId}/revisions/{version}/restore").HandlerFunc(authWrapper(messageController.RestoreMessage))
 	r.NewRoute().Methods("POST").Path("/msg/share/{mId}").HandlerFunc(authWrapper(messageController.ShareMessage))
+	r.NewRoute().Methods("POST").Path("/note").HandlerFunc(authWrapper(messageController.CreateSecretNote))
+	r.NewRoute().Methods("GET").Path("/note/{key}").HandlerFunc(authWrapper(messageController.SecretNoteStatus))
 	r.NewRoute().Methods("GET").Path("/share/login").HandlerFunc(authWrapper(userController.LoginShare))
+	r.NewRoute().Methods("GET").Path("/share").HandlerFunc(authWrapper(shareController.ListShare))
+	r.NewRoute().Methods("DELETE").Path("/share").HandlerFunc(authWrapper(shareController.RevokeShares))
+	r.NewRoute().Methods("POST").Path("/share/access/query").HandlerFunc(authWrapper(shareController.QueryShareAccess))
+	r.NewRoute().Methods("GET").Path("/share/{key}").HandlerFunc(authWrapper(shareController.GetShare))
+	r.NewRoute().Methods("DELETE").Path("/share/{key}").HandlerFunc(authWrapper(shareController.RevokeShare))
+	r.NewRoute().Methods("POST").Path("/share/{key}/code").HandlerFunc(authWrapper(shareController.CreatePickupCode))
+	r.NewRoute().Methods("PUT").Path("/share/{key}/limits").HandlerFunc(authWrapper(shareController.SetShareLimits))
 	r.NewRoute().Methods("GET").Path("/user/me").HandlerFunc(authWrapper(userController.UserMe))
+	r.NewRoute().Methods("GET").Path("/user/app-password").HandlerFunc(authWrapper(userController.QueryAppPassword))
+	r.NewRoute().Methods("POST").Path("/user/app-password").HandlerFunc(authWrapper(userController.CreateAppPassword))
+	r.NewRoute().Methods("DELETE").Path("/user/app-password/{aId}").HandlerFunc(authWrapper(userController.DeleteAppPassword))
+	r.NewRoute().Methods("GET").Path("/user/access-key").HandlerFunc(authWrapper(userController.QueryAccessKey))
+	r.NewRoute().Methods("POST").Path("/user/access-key").HandlerFunc(authWrapper(userController.CreateAccessKey))
+	r.NewRoute().Methods("DELETE").Path("/user/access-key/{kId}").HandlerFunc(authWrapper(userController.DeleteAccessKey))
 	// file
 	r.NewRoute().Methods("POST").Path("/file").HandlerFunc(authWrapper(fileController.UploadFile))
+	r.NewRoute().Methods("GET").Path("/file/progress/{uploadId}").HandlerFunc(authWrapper(progressController.UploadProgress))
 	r.NewRoute().Methods("POST").Path("/file/query").HandlerFunc(authWrapper(fileController.QueryUserFile))
+	r.NewRoute().Methods("POST").Path("/file/signed-url").HandlerFunc(authWrapper(signedUrlController.SignUrls))
+	r.NewRoute().Methods("DELETE").Path("/file/signed-url").HandlerFunc(authWrapper(signedUrlController.RevokeUrls))
+	r.NewRoute().Methods("GET").Path("/file/grant").HandlerFunc(authWrapper(fileGrantController.ListGrants))
+	r.NewRoute().Methods("POST").Path("/file/grant").HandlerFunc(authWrapper(fileGrantController.Grant))
+	r.NewRoute().Methods("DELETE").Path("/file/grant/{gId}").HandlerFunc(authWrapper(fileGrantController.RevokeGrant))
 	r.NewRoute().Methods("DELETE").Path("/file/{fId}").HandlerFunc(authWrapper(fileController.DeleteFile))
+	r.NewRoute().Methods("PUT").Path("/file/{fId}/expire").HandlerFunc(authWrapper(fileController.SetExpire))
+	r.NewRoute().Methods("GET").Path("/file/{fId}/preview").HandlerFunc(authWrapper(fileController.PreviewFile))
 	r.NewRoute().Methods("GET").Path("/file/{fId}").HandlerFunc(authWrapper(fileController.DownloadFile))
 	r.NewRoute().Methods("POST").Path("/file/share/{mId}").HandlerFunc(authWrapper(fileController.Share))
+	r.NewRoute().Methods("POST").Path("/file/share").HandlerFunc(authWrapper(fileController.ShareCollection))
+	r.NewRoute().Methods("POST").Path("/file/request").HandlerFunc(authWrapper(fileController.CreateFileRequest))
+	// shared with me by other users
+	r.NewRoute().Methods("GET").Path("/shared").HandlerFunc(authWrapper(fileGrantController.SharedWithMe))
+	r.NewRoute().Methods("GET").Path("/shared/{gId}").HandlerFunc(authWrapper(fileGrantController.SharedFiles))
+	r.NewRoute().Methods("POST").Path("/shared/{gId}/file").HandlerFunc(authWrapper(fileGrantController.UploadShared))
 	// cloudinary
 	r.NewRoute().Methods("POST").Path("/cloudinary").HandlerFunc(authWrapper(fileController.CloudinaryUploadFile))
 	return nil
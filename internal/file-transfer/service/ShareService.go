@@ -2,30 +2,64 @@ package service
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"file-transfer/internal/file-transfer/repo"
+	v1 "file-transfer/pkg/api/v1"
 	"file-transfer/pkg/common"
 	"file-transfer/pkg/db/dbredis"
 	"file-transfer/pkg/encrypt/aesencrypt"
 	"file-transfer/pkg/errno"
 	"file-transfer/pkg/log"
+	"file-transfer/pkg/model"
+	"file-transfer/pkg/sharekey"
 	"file-transfer/pkg/util"
 	"fmt"
+	"net/http"
+	"sort"
 	"strconv"
 	"time"
 
 	"github.com/redis/go-redis/v9"
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/bcrypt"
 )
 
 var shareTypePathMap = make(map[common.ShareKey]func(encodeKStr string) string)
 var shareTypePrefixMap = make(map[common.ShareKey]string)
+var shareTypeNameMap = make(map[common.ShareKey]string)
 
 func init() {
 	shareTypePathMap[common.SHARE_TYPE_LOGIN] = getSharePathLogin()
 	shareTypePathMap[common.SHARE_TYPE_MESSAGE] = getSharePathMsg()
 	shareTypePathMap[common.SHARE_TYPE_FILE] = getSharePathFile()
+	shareTypePathMap[common.SHARE_TYPE_COLLECTION] = getSharePathCollection()
+	shareTypePathMap[common.SHARE_TYPE_FILE_REQUEST] = getSharePathFileRequest()
+	shareTypePathMap[common.SHARE_TYPE_SECRET_NOTE] = getSharePathSecretNote()
 
 	shareTypePrefixMap[common.SHARE_TYPE_LOGIN] = dbredis.REDIS_LOGIN_SHARE_KEY_PREFIX
 	shareTypePrefixMap[common.SHARE_TYPE_MESSAGE] = dbredis.REDIS_MESSAGE_SHARE_KEY_PREFIX
 	shareTypePrefixMap[common.SHARE_TYPE_FILE] = dbredis.REDIS_FILE_SHARE_KEY_PREFIX
+	shareTypePrefixMap[common.SHARE_TYPE_COLLECTION] = dbredis.REDIS_COLLECTION_SHARE_KEY_PREFIX
+	shareTypePrefixMap[common.SHARE_TYPE_FILE_REQUEST] = dbredis.REDIS_FILE_REQUEST_KEY_PREFIX
+	shareTypePrefixMap[common.SHARE_TYPE_SECRET_NOTE] = dbredis.REDIS_SECRET_NOTE_KEY_PREFIX
+
+	shareTypeNameMap[common.SHARE_TYPE_LOGIN] = "login"
+	shareTypeNameMap[common.SHARE_TYPE_MESSAGE] = "message"
+	shareTypeNameMap[common.SHARE_TYPE_FILE] = "file"
+	shareTypeNameMap[common.SHARE_TYPE_COLLECTION] = "collection"
+	shareTypeNameMap[common.SHARE_TYPE_FILE_REQUEST] = "request"
+	shareTypeNameMap[common.SHARE_TYPE_SECRET_NOTE] = "note"
+}
+
+// ParseShareType is the reverse of the type names in ShareInfo
+func ParseShareType(name string) (common.ShareKey, bool) {
+	for shareType, n := range shareTypeNameMap {
+		if n == name {
+			return shareType, true
+		}
+	}
+	return 0, false
 }
 
 func getSharePathLogin() func(encodeKStr string) string {
@@ -46,23 +80,154 @@ func getSharePathFile() func(encodeKStr string) string {
 	}
 }
 
+func getSharePathCollection() func(encodeKStr string) string {
+	return func(encodeKStr string) string {
+		return "/" + common.COLLECTION_SHARE_PATH + "/" + encodeKStr
+	}
+}
+
+func getSharePathFileRequest() func(encodeKStr string) string {
+	return func(encodeKStr string) string {
+		return "/" + common.FILE_REQUEST_PATH + "/" + encodeKStr
+	}
+}
+
+func getSharePathSecretNote() func(encodeKStr string) string {
+	return func(encodeKStr string) string {
+		return "/" + common.SECRET_NOTE_PATH + "/" + encodeKStr
+	}
+}
+
 type ShareService interface {
-	CreateShareUrl(ctx context.Context, shareType common.ShareKey, value string, expire time.Duration) (string, error)
-	CreateShareUrlWithTimes(ctx context.Context, shareType common.ShareKey, value string, expire time.Duration, times int8) (string, error)
+	// password is optional, a link with one is opened only by Consume/Peek with the same password
+	CreateShareUrl(ctx context.Context, shareType common.ShareKey, userId string, value string, expire time.Duration, password string) (*v1.ShareLink, error)
+	CreateShareUrlWithTimes(ctx context.Context, shareType common.ShareKey, userId string, value string, expire time.Duration, times int8, password string) (*v1.ShareLink, error)
 	CheckShareUrl(ctx context.Context, shareType common.ShareKey, key string, expire time.Duration) (string, error)
-	ConsumeShareUrl(ctx context.Context, shareType common.ShareKey, key string, expire time.Duration) (string, error)
+	ConsumeShareUrl(ctx context.Context, shareType common.ShareKey, key string, expire time.Duration, password string) (string, error)
+	PeekShareUrl(ctx context.Context, shareType common.ShareKey, key string, expire time.Duration, password string) (string, error)
+	// ConsumeShareSession is ConsumeShareUrl for downloads, the ranges and resumes of a download in its session don't use up the link
+	ConsumeShareSession(ctx context.Context, shareType common.ShareKey, key string, item string, expire time.Duration, password string, token string) (*ShareSession, error)
+
+	// ShareStatus reads an open link for its visitors, without opening it
+	ShareStatus(ctx context.Context, shareType common.ShareKey, key string, expire time.Duration) (*v1.ShareInfo, error)
+	ListShare(ctx context.Context, userId string, q *v1.ShareQuery) ([]v1.ShareInfo, error)
+	GetShare(ctx context.Context, userId string, key string) (*v1.ShareInfo, error)
+	RevokeShare(ctx context.Context, userId string, key string) error
+	RevokeUserShares(ctx context.Context, userId string, q *v1.ShareQuery) (int, error)
+	RevokeTargetShares(ctx context.Context, shareType common.ShareKey, target string) error
+
+	// SetShareLimits changes the download limits of a file or collection link of the user
+	SetShareLimits(ctx context.Context, userId string, key string, limits *v1.ShareLimits) error
+	// ShareLimits reads the download limits the owner set, zero when there are none
+	ShareLimits(ctx context.Context, key string) (*v1.ShareLimits, error)
+
+	// RecordAccess logs an access of a link for its owner, Consume records the refused ones itself
+	RecordAccess(ctx context.Context, key string, outcome common.ShareOutcome, bytes int64)
+	ListShareAccess(ctx context.Context, q *v1.ShareAccessQuery) ([]model.ShareAccess, error)
+
+	// CreatePickupCode gives a link of the user a short code, replacing the one it had
+	CreatePickupCode(ctx context.Context, userId string, key string, param *v1.PickupCodeParam) (*v1.PickupCode, error)
+	// RedeemPickupCode returns the path of the link of a code, failed tries are limited per client ip
+	RedeemPickupCode(ctx context.Context, code string, ip string) (string, error)
 }
 
 type shareService struct {
 	redisClient *redis.Client
+	signer      *sharekey.Signer
+	accessRepo  repo.ShareAccessRepo
+}
+
+// the share secret used without "share.keys", derived from the aes key
+const SHARE_KEY_DERIVED_KID = "k0"
+
+const (
+	// bcrypt reads 72 bytes at most
+	SHARE_PASSWORD_MIN_LEN int = 4
+	SHARE_PASSWORD_MAX_LEN int = 72
+)
+
+var (
+	// wrong passwords of one link in the window before it is locked for the rest of the window
+	SHARE_PASSWORD_ATTEMPTS int64         = 5
+	SHARE_PASSWORD_WINDOW   time.Duration = 15 * time.Minute
+	// the info of a link is kept this long after it expired or was used up,
+	// so late accesses are still logged for the owner as expired or exhausted
+	SHARE_ENDED_RETAIN time.Duration = 7 * 24 * time.Hour
+)
+
+var (
+	ErrSharePasswordRequired = &errno.Errno{HTTP: http.StatusUnauthorized, Code: "Unauthorized.SharePassword", Message: "share password required"}
+	ErrSharePasswordWrong    = &errno.Errno{HTTP: http.StatusForbidden, Code: "Forbidden.SharePassword", Message: "wrong share password"}
+	ErrSharePasswordLimited  = &errno.Errno{HTTP: http.StatusTooManyRequests, Code: "TooManyRequests.SharePassword", Message: "too many wrong passwords, try again later"}
+
+	errShareExpired = &errno.Errno{HTTP: http.StatusBadRequest, Code: "InvalidParameter.Expired", Message: "share link expired"}
+
+	errSharePasswordInvalid = &errno.Errno{HTTP: http.StatusBadRequest, Code: "InvalidParameter.Password",
+		Message: fmt.Sprintf("password needs %d to %d characters", SHARE_PASSWORD_MIN_LEN, SHARE_PASSWORD_MAX_LEN)}
+)
+
+// shareAccessError keeps the password challenge for the client, anything else is an invalid link
+func shareAccessError(err error) error {
+	switch err {
+	case ErrSharePasswordRequired, ErrSharePasswordWrong, ErrSharePasswordLimited:
+		return err
+	}
+	return &errno.Errno{HTTP: http.StatusBadRequest, Message: "invalid"}
 }
 
 var _ ShareService = (*shareService)(nil)
 
-func NewShareService(rClient *redis.Client) ShareService {
-	return &shareService{redisClient: rClient}
+func NewShareService(rClient *redis.Client, accessRepo repo.ShareAccessRepo) ShareService {
+	if attempts := viper.GetInt64("share.password-attempts"); attempts > 0 {
+		SHARE_PASSWORD_ATTEMPTS = attempts
+	}
+	if window := viper.GetDuration("share.password-window"); window > 0 {
+		SHARE_PASSWORD_WINDOW = window
+	}
+	if retain := viper.GetDuration("share.ended-retain"); retain > 0 {
+		SHARE_ENDED_RETAIN = retain
+	}
+	loadPickupCodeConfig()
+	loadDownloadSessionConfig()
+	return &shareService{redisClient: rClient, signer: newShareSigner(), accessRepo: accessRepo}
 }
 
+// newShareSigner reads "share.keys" (key id: secret) and "share.active-key".
+// The secret derived from the aes key stays known unless "k0" is configured, so the links
+// made before "share.keys" was set keep working.
+func newShareSigner() *sharekey.Signer {
+	secrets := make(map[string][]byte)
+	for kid, secret := range viper.GetStringMapString("share.keys") {
+		secrets[kid] = []byte(secret)
+	}
+	active := viper.GetString("share.active-key")
+	if len(secrets) == 0 {
+		log.Warnw("share.keys is not configured, share keys are signed with a secret derived from the aes key")
+		active = SHARE_KEY_DERIVED_KID
+	}
+	if _, ok := secrets[SHARE_KEY_DERIVED_KID]; !ok {
+		mac := hmac.New(sha256.New, []byte(viper.GetString(common.VIPER_AES_KEY)))
+		mac.Write([]byte("share-key"))
+		secrets[SHARE_KEY_DERIVED_KID] = mac.Sum(nil)
+	}
+	signer, err := sharekey.NewSigner(secrets, active)
+	if err != nil {
+		log.Fatalw(err.Error())
+	}
+	return signer
+}
+
+func (s *shareService) genShareKey(ctx context.Context) (string, error) {
+	key, err := s.signer.Issue(time.Now())
+	if err != nil {
+		log.C(ctx).Warnw("issue share key failed", "err", err)
+		return "", errno.InternalServerError
+	}
+	return key, nil
+}
+
+// genEncodeString is how keys were made before sharekey: the aes encrypted creation millisecond.
+// It isn't used for new links, checkLegacyKeyExpire still reads the ones already handed out.
 func genEncodeString(ctx context.Context) (string, error) {
 	nowMilli := fmt.Sprint(time.Now().UnixMilli())
 	log.C(ctx).Debugw("now: " + nowMilli)
@@ -78,51 +243,81 @@ func genEncodeString(ctx context.Context) (string, error) {
 	return encodeKStr, nil
 }
 
-func (s *shareService) CreateShareUrl(ctx context.Context, shareType common.ShareKey, value string, expire time.Duration) (string, error) {
+func (s *shareService) CreateShareUrl(ctx context.Context, shareType common.ShareKey, userId string, value string, expire time.Duration, password string) (*v1.ShareLink, error) {
 	if len(value) < 1 {
-		return "", errno.ErrInvalidParameter
+		return nil, errno.ErrInvalidParameter
 	}
-	kStr, err := genEncodeString(ctx)
+	passwordHash, err := hashSharePassword(password)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	kStr, err := s.genShareKey(ctx)
+	if err != nil {
+		return nil, err
 	}
-	// TODO: there could create many link for one user
 	// set origin kStr
 	bc := s.redisClient.Set(ctx, shareTypePrefixMap[shareType]+kStr, value, expire)
 	if bc.Err() != nil {
 		log.C(ctx).Warnw(bc.Err().Error())
-		return "", errno.InternalServerError
+		return nil, errno.InternalServerError
+	}
+	expireAt, err := s.indexShare(ctx, shareType, userId, value, kStr, expire, passwordHash)
+	if err != nil {
+		return nil, err
 	}
-	// return encodeKStr
-	return shareTypePathMap[shareType](kStr), nil
+	return &v1.ShareLink{Path: shareTypePathMap[shareType](kStr), ExpireAt: expireAt}, nil
 }
 
-func (s *shareService) CreateShareUrlWithTimes(ctx context.Context, shareType common.ShareKey, value string, expire time.Duration, times int8) (string, error) {
+func (s *shareService) CreateShareUrlWithTimes(ctx context.Context, shareType common.ShareKey, userId string, value string, expire time.Duration, times int8, password string) (*v1.ShareLink, error) {
 	if len(value) < 1 {
-		return "", errno.ErrInvalidParameter
+		return nil, errno.ErrInvalidParameter
 	}
-	kStr, err := genEncodeString(ctx)
+	passwordHash, err := hashSharePassword(password)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	bc := s.redisClient.Set(ctx, shareTypePrefixMap[shareType]+kStr, value, expire)
-	if bc.Err() != nil {
-		log.C(ctx).Warnw(bc.Err().Error())
-		return "", errno.InternalServerError
+	kStr, err := s.genShareKey(ctx)
+	if err != nil {
+		return nil, err
 	}
-	bc = s.redisClient.Set(ctx, "count-"+kStr, times, expire)
-	if bc.Err() != nil {
-		log.C(ctx).Warnw(bc.Err().Error())
-		return "", errno.InternalServerError
+	// together, a link without its count would open any number of times
+	pipe := s.redisClient.TxPipeline()
+	pipe.Set(ctx, shareTypePrefixMap[shareType]+kStr, value, expire)
+	pipe.Set(ctx, shareCountKey(shareType, kStr), times, expire)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.C(ctx).Warnw(err.Error())
+		return nil, errno.InternalServerError
 	}
-	// return encodeKStr
-	return shareTypePathMap[shareType](kStr), nil
+	expireAt, err := s.indexShare(ctx, shareType, userId, value, kStr, expire, passwordHash)
+	if err != nil {
+		return nil, err
+	}
+	remaining := int64(times)
+	return &v1.ShareLink{Path: shareTypePathMap[shareType](kStr), ExpireAt: expireAt, Remaining: &remaining}, nil
 }
 
-func checkKeyExpire(ctx context.Context, key string, expire time.Duration) error {
+// checkKeyExpire rejects forged and expired keys before they reach redis
+func (s *shareService) checkKeyExpire(ctx context.Context, key string, expire time.Duration) error {
 	if len(key) < 1 {
 		return errno.ErrInvalidParameter
 	}
+	if !sharekey.IsKey(key) {
+		return checkLegacyKeyExpire(ctx, key, expire)
+	}
+	issued, err := s.signer.Verify(key)
+	if err != nil {
+		log.C(ctx).Warnw("share key verify fail", "error", err)
+		return errno.ErrInvalidParameter
+	}
+	if expireTime := issued.Add(expire); expireTime.Before(time.Now()) {
+		log.C(ctx).Infow("share link expired at: " + expireTime.Format(time.RFC3339))
+		return errShareExpired
+	}
+	return nil
+}
+
+// checkLegacyKeyExpire reads the keys of genEncodeString, they stay valid until they expire
+func checkLegacyKeyExpire(ctx context.Context, key string, expire time.Duration) error {
 	key = util.Base64urlDecode(key)
 	// check the 'key' valid, time not expired
 	timeStr, err := aesencrypt.GetAESDecrypted(key)
@@ -141,19 +336,19 @@ func checkKeyExpire(ctx context.Context, key string, expire time.Duration) error
 	expireTime := time.Unix(0, timestamp*int64(time.Millisecond)).Add(expire)
 	if expireTime.Before(time.Now()) {
 		log.Infow("share link expired at: " + expireTime.Format(time.RFC3339))
-		return errno.ErrInvalidParameter
+		return errShareExpired
 	}
 	return nil
 }
 
 func (s *shareService) CheckShareUrl(ctx context.Context, shareType common.ShareKey, key string, expire time.Duration) (string, error) {
-	err := checkKeyExpire(ctx, key, expire)
+	err := s.checkKeyExpire(ctx, key, expire)
 	if err != nil {
 		return "", err
 	}
 
-	// then check redis
-	sc := s.redisClient.Get(ctx, shareTypePrefixMap[shareType]+key)
+	// then check redis, GETDEL so that only one request gets the value
+	sc := s.redisClient.GetDel(ctx, shareTypePrefixMap[shareType]+key)
 	if sc.Err() != nil {
 		log.C(ctx).Warnw(sc.Err().Error())
 		return "", errno.ErrInvalidParameter
@@ -163,7 +358,7 @@ func (s *shareService) CheckShareUrl(ctx context.Context, shareType common.Share
 		log.C(ctx).Infow("[" + fmt.Sprint(shareType) + "] share link not match: " + key)
 		return "", errno.ErrInvalidParameter
 	}
-	ic := s.redisClient.Del(ctx, shareTypePrefixMap[shareType]+key)
+	ic := s.redisClient.Del(ctx, dbredis.REDIS_SHARE_INFO_PREFIX+key)
 	if ic.Err() != nil {
 		log.C(ctx).Warnw(ic.Err().Error())
 		return "", errno.InternalServerError
@@ -172,8 +367,188 @@ func (s *shareService) CheckShareUrl(ctx context.Context, shareType common.Share
 	return value, nil
 }
 
-func (s *shareService) ConsumeShareUrl(ctx context.Context, shareType common.ShareKey, key string, expire time.Duration) (string, error) {
-	err := checkKeyExpire(ctx, key, expire)
+func (s *shareService) ConsumeShareUrl(ctx context.Context, shareType common.ShareKey, key string, expire time.Duration, password string) (string, error) {
+	value, _, err := s.consumeShare(ctx, shareType, key, expire, password)
+	return value, err
+}
+
+// consumeShare returns the value and the remaining times of the link, -1 for a link limited by time only
+func (s *shareService) consumeShare(ctx context.Context, shareType common.ShareKey, key string, expire time.Duration, password string) (string, int64, error) {
+	err := s.checkKeyExpire(ctx, key, expire)
+	if err != nil {
+		if err == errShareExpired {
+			s.RecordAccess(ctx, key, common.SHARE_OUTCOME_EXPIRED, 0)
+		}
+		return "", 0, err
+	}
+	// the password is checked on an open link only, so an ended one is logged as such.
+	// A wrong password doesn't use up the link
+	exists, err := s.redisClient.Exists(ctx, shareTypePrefixMap[shareType]+key).Result()
+	if err != nil {
+		log.C(ctx).Warnw(err.Error())
+		return "", 0, errno.InternalServerError
+	}
+	if exists == 0 {
+		log.C(ctx).Infow("[" + fmt.Sprint(shareType) + "] share link not match: " + key)
+		s.recordEnded(ctx, key)
+		return "", 0, errno.ErrInvalidParameter
+	}
+	if err := s.verifySharePassword(ctx, key, password); err != nil {
+		if err == ErrSharePasswordWrong || err == ErrSharePasswordLimited {
+			s.RecordAccess(ctx, key, common.SHARE_OUTCOME_WRONG_PASSWORD, 0)
+		}
+		return "", 0, err
+	}
+
+	value, remaining, err := s.consume(ctx, shareType, key)
+	if err != nil {
+		log.C(ctx).Warnw("consume share failed", "key", key, "err", err)
+		return "", 0, errno.InternalServerError
+	}
+	if len(value) == 0 {
+		// used up or expired since the check above
+		log.C(ctx).Infow("[" + fmt.Sprint(shareType) + "] share link not match: " + key)
+		s.recordEnded(ctx, key)
+		return "", 0, errno.ErrInvalidParameter
+	}
+	log.C(ctx).Debugw("access key: "+key, "remaining", remaining)
+	if remaining == 0 {
+		s.endShare(ctx, shareType, key)
+	}
+	return value, remaining, nil
+}
+
+// consumeScript reads the value of a link and takes one of its times in one step,
+// the link goes with its last time. KEYS are the value, the count and the legacy count.
+// Returns the value and the remaining times, -1 for a link limited by time only
+var consumeScript = redis.NewScript(`
+local value = redis.call("GET", KEYS[1])
+if not value then
+	return {"", 0}
+end
+local countKey = KEYS[2]
+if redis.call("EXISTS", countKey) == 0 then
+	countKey = KEYS[3]
+	if redis.call("EXISTS", countKey) == 0 then
+		return {value, -1}
+	end
+end
+local remaining = redis.call("DECR", countKey)
+if remaining <= 0 then
+	redis.call("DEL", KEYS[1], countKey)
+end
+if remaining < 0 then
+	return {"", 0}
+end
+return {value, remaining}
+`)
+
+// consume returns an empty value if the link is gone
+func (s *shareService) consume(ctx context.Context, shareType common.ShareKey, key string) (string, int64, error) {
+	keys := []string{shareTypePrefixMap[shareType] + key, shareCountKey(shareType, key), legacyShareCountKey(key)}
+	result, err := consumeScript.Run(ctx, s.redisClient, keys).Slice()
+	if err != nil {
+		return "", 0, err
+	}
+	value, _ := result[0].(string)
+	remaining, _ := result[1].(int64)
+	return value, remaining, nil
+}
+
+// shareCountKey holds the times left of a link, by type like the link itself
+func shareCountKey(shareType common.ShareKey, key string) string {
+	return dbredis.REDIS_SHARE_COUNT_PREFIX + shareTypePrefixMap[shareType] + key
+}
+
+// legacyShareCountKey is the count of links made before the counts had the type, gone once those expired
+func legacyShareCountKey(key string) string {
+	return dbredis.REDIS_SHARE_COUNT_PREFIX + key
+}
+
+// endShare takes a used up link out of the indexes, its info stays to tell late accesses apart
+func (s *shareService) endShare(ctx context.Context, shareType common.ShareKey, key string) {
+	infoKey := dbredis.REDIS_SHARE_INFO_PREFIX + key
+	fields, err := s.redisClient.HMGet(ctx, infoKey, "userId", "target").Result()
+	if err != nil || fields[0] == nil {
+		return
+	}
+	userId, _ := fields[0].(string)
+	target, _ := fields[1].(string)
+	pipe := s.redisClient.TxPipeline()
+	pipe.HSet(ctx, infoKey, "ended", string(common.SHARE_OUTCOME_EXHAUSTED))
+	pipe.ZRem(ctx, userShareKey(userId), key)
+	pipe.ZRem(ctx, targetShareKey(shareType, target), key)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.C(ctx).Warnw("end share failed", "key", key, "err", err)
+	}
+}
+
+// recordEnded logs an access of a link that has no value any more, if it is known to have ended
+func (s *shareService) recordEnded(ctx context.Context, key string) {
+	fields, err := s.redisClient.HMGet(ctx, dbredis.REDIS_SHARE_INFO_PREFIX+key, "ended", "expireAt").Result()
+	if err != nil || fields[1] == nil {
+		return
+	}
+	if ended, _ := fields[0].(string); ended == string(common.SHARE_OUTCOME_EXHAUSTED) {
+		s.RecordAccess(ctx, key, common.SHARE_OUTCOME_EXHAUSTED, 0)
+		return
+	}
+	expireStr, _ := fields[1].(string)
+	if expireAt, _ := strconv.ParseInt(expireStr, 10, 64); expireAt <= time.Now().UnixMilli() {
+		s.RecordAccess(ctx, key, common.SHARE_OUTCOME_EXPIRED, 0)
+	}
+}
+
+func (s *shareService) RecordAccess(ctx context.Context, key string, outcome common.ShareOutcome, bytes int64) {
+	// the download may have been cut by the client, the record is still wanted
+	ctx = context.WithoutCancel(ctx)
+	infoKey := dbredis.REDIS_SHARE_INFO_PREFIX + key
+	fields, err := s.redisClient.HGetAll(ctx, infoKey).Result()
+	if err != nil || len(fields) == 0 {
+		log.C(ctx).Debugw("share access of an unknown link", "key", key, "outcome", outcome)
+		return
+	}
+	now := time.Now()
+	expireAt, _ := strconv.ParseInt(fields["expireAt"], 10, 64)
+	pipe := s.redisClient.TxPipeline()
+	pipe.HIncrBy(ctx, infoKey, "access:"+string(outcome), 1)
+	pipe.HIncrBy(ctx, infoKey, "access:bytes", bytes)
+	pipe.HSet(ctx, infoKey, "access:lastAt", now.UnixMilli())
+	// the hash could have expired in between, don't let it live forever
+	pipe.PExpireAt(ctx, infoKey, time.UnixMilli(expireAt).Add(SHARE_ENDED_RETAIN))
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.C(ctx).Warnw("count share access failed", "key", key, "err", err)
+	}
+
+	typeValue, _ := strconv.Atoi(fields["type"])
+	access := &model.ShareAccess{
+		ShareKey: key,
+		UserId:   fields["userId"],
+		Type:     shareTypeNameMap[common.ShareKey(typeValue)],
+		Target:   fields["target"],
+		Time:     now,
+		Outcome:  outcome,
+		Bytes:    bytes,
+	}
+	access.Ip, _ = ctx.Value(common.RESOURCE_IP).(string)
+	access.UserAgent, _ = ctx.Value(common.USER_AGENT).(string)
+	if err := s.accessRepo.Insert(ctx, access); err != nil {
+		log.C(ctx).Warnw("record share access failed", "key", key, "err", err)
+	}
+}
+
+func (s *shareService) ListShareAccess(ctx context.Context, q *v1.ShareAccessQuery) ([]model.ShareAccess, error) {
+	result, err := s.accessRepo.Query(ctx, q)
+	if err != nil {
+		log.C(ctx).Warnw("query share access failed", "err", err)
+		return nil, errno.InternalServerError
+	}
+	return result, nil
+}
+
+// PeekShareUrl reads the value of a share link without counting it as an access
+func (s *shareService) PeekShareUrl(ctx context.Context, shareType common.ShareKey, key string, expire time.Duration, password string) (string, error) {
+	err := s.checkKeyExpire(ctx, key, expire)
 	if err != nil {
 		return "", err
 	}
@@ -187,25 +562,385 @@ func (s *shareService) ConsumeShareUrl(ctx context.Context, shareType common.Sha
 		log.C(ctx).Infow("[" + fmt.Sprint(shareType) + "] share link not match: " + key)
 		return "", errno.ErrInvalidParameter
 	}
-	sc = s.redisClient.Get(ctx, "count-"+key)
-	// if there is no count here, it's only expired by duration
-	if sc.Err() != nil {
-		log.C(ctx).Debugw(sc.Err().Error())
-		return value, nil
+	if err := s.verifySharePassword(ctx, key, password); err != nil {
+		return "", err
 	}
+	return value, nil
+}
 
-	ic := s.redisClient.Decr(ctx, "count-"+key)
-	// if decr count error, something wrong
-	if ic.Err() != nil {
-		log.C(ctx).Warnw(ic.Err().Error())
-		return value, nil
+func hashSharePassword(password string) (string, error) {
+	if len(password) == 0 {
+		return "", nil
 	}
-	log.C(ctx).Debugw("access key: "+key, "count", ic.Val())
-	if ic.Val() <= 0 {
-		// do clean
-		log.C(ctx).Debugw("Clean cache key: " + key)
-		s.redisClient.Del(ctx, shareTypePrefixMap[shareType]+key)
-		s.redisClient.Del(ctx, "count-"+key)
+	if len(password) < SHARE_PASSWORD_MIN_LEN || len(password) > SHARE_PASSWORD_MAX_LEN {
+		return "", errSharePasswordInvalid
 	}
-	return value, nil
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", errno.InternalServerError
+	}
+	return string(hash), nil
+}
+
+// verifySharePassword passes links without a password, wrong guesses are counted per link and locked out after the limit
+func (s *shareService) verifySharePassword(ctx context.Context, key string, password string) error {
+	hash, err := s.redisClient.HGet(ctx, dbredis.REDIS_SHARE_INFO_PREFIX+key, "password").Result()
+	if err == redis.Nil || (err == nil && len(hash) == 0) {
+		return nil
+	}
+	if err != nil {
+		log.C(ctx).Warnw("read share password failed", "err", err)
+		return errno.InternalServerError
+	}
+	failKey := dbredis.REDIS_SHARE_PASSWORD_FAIL_PREFIX + key
+	if len(password) == 0 {
+		failed, err := s.redisClient.Get(ctx, failKey).Int64()
+		if err != nil && err != redis.Nil {
+			log.C(ctx).Warnw("read share password attempts failed", "err", err)
+			return errno.InternalServerError
+		}
+		if failed >= SHARE_PASSWORD_ATTEMPTS {
+			return ErrSharePasswordLimited
+		}
+		return ErrSharePasswordRequired
+	}
+	// every guess takes an attempt before it is checked, so parallel guesses can't pass the limit together
+	attempts, err := reserveAttemptScript.Run(ctx, s.redisClient, []string{failKey}, SHARE_PASSWORD_WINDOW.Milliseconds()).Int64()
+	if err != nil {
+		log.C(ctx).Warnw("count share password attempt failed", "err", err)
+		return errno.InternalServerError
+	}
+	if attempts > SHARE_PASSWORD_ATTEMPTS {
+		return ErrSharePasswordLimited
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil {
+		// the right password gives the attempt back
+		if err := releaseAttemptScript.Run(ctx, s.redisClient, []string{failKey}).Err(); err != nil {
+			log.C(ctx).Warnw("release share password attempt failed", "err", err)
+		}
+		return nil
+	}
+	log.C(ctx).Infow("wrong share password", "key", key, "attempts", attempts)
+	return ErrSharePasswordWrong
+}
+
+// reserveAttemptScript counts an attempt, the window starts with the first one
+var reserveAttemptScript = redis.NewScript(`
+local n = redis.call("INCR", KEYS[1])
+if n == 1 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return n
+`)
+
+// releaseAttemptScript takes an attempt back, unless the window ended meanwhile
+var releaseAttemptScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 1 then
+	return redis.call("DECR", KEYS[1])
+end
+return 0
+`)
+
+// indexShare records the owner and target of a share link, so it can be listed and revoked. Returns its expire time
+func (s *shareService) indexShare(ctx context.Context, shareType common.ShareKey, userId string, value string, kStr string, expire time.Duration, passwordHash string) (time.Time, error) {
+	now := time.Now()
+	expireAt := now.Add(expire)
+	infoKey := dbredis.REDIS_SHARE_INFO_PREFIX + kStr
+	pipe := s.redisClient.TxPipeline()
+	pipe.HSet(ctx, infoKey,
+		"type", int(shareType),
+		"userId", userId,
+		"target", value,
+		"createdAt", now.UnixMilli(),
+		"expireAt", expireAt.UnixMilli())
+	if len(passwordHash) > 0 {
+		pipe.HSet(ctx, infoKey, "password", passwordHash)
+	}
+	pipe.Expire(ctx, infoKey, expire+SHARE_ENDED_RETAIN)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.C(ctx).Warnw("index share failed", "err", err)
+		return expireAt, errno.InternalServerError
+	}
+	for _, indexKey := range []string{userShareKey(userId), targetShareKey(shareType, value)} {
+		if err := s.addShareIndex(ctx, indexKey, kStr, expireAt); err != nil {
+			log.C(ctx).Warnw("index share failed", "key", indexKey, "err", err)
+			return expireAt, errno.InternalServerError
+		}
+	}
+	return expireAt, nil
+}
+
+func userShareKey(userId string) string {
+	return dbredis.REDIS_USER_SHARE_PREFIX + userId
+}
+
+func targetShareKey(shareType common.ShareKey, target string) string {
+	return fmt.Sprintf("%s%d-%s", dbredis.REDIS_TARGET_SHARE_PREFIX, shareType, target)
+}
+
+// addShareIndex drops the expired links of the index and lets the index expire with its last link
+func (s *shareService) addShareIndex(ctx context.Context, indexKey string, kStr string, expireAt time.Time) error {
+	pipe := s.redisClient.TxPipeline()
+	pipe.ZRemRangeByScore(ctx, indexKey, "-inf", strconv.FormatInt(time.Now().UnixMilli(), 10))
+	pipe.ZAdd(ctx, indexKey, redis.Z{Score: float64(expireAt.UnixMilli()), Member: kStr})
+	last := pipe.ZRangeWithScores(ctx, indexKey, -1, -1)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+	if z := last.Val(); len(z) == 1 {
+		return s.redisClient.PExpireAt(ctx, indexKey, time.UnixMilli(int64(z[0].Score))).Err()
+	}
+	return nil
+}
+
+type shareRecord struct {
+	info      v1.ShareInfo
+	shareType common.ShareKey
+	userId    string
+}
+
+// loadShares reads the info of the links, an ended link keeps it for SHARE_ENDED_RETAIN, a revoked one has none
+func (s *shareService) loadShares(ctx context.Context, kStrs []string) ([]shareRecord, []string, error) {
+	pipe := s.redisClient.Pipeline()
+	infos := make([]*redis.MapStringStringCmd, len(kStrs))
+	for i, kStr := range kStrs {
+		infos[i] = pipe.HGetAll(ctx, dbredis.REDIS_SHARE_INFO_PREFIX+kStr)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, nil, err
+	}
+	records := make([]shareRecord, 0, len(kStrs))
+	gone := make([]string, 0)
+	for i, kStr := range kStrs {
+		fields := infos[i].Val()
+		if len(fields) == 0 {
+			gone = append(gone, kStr)
+			continue
+		}
+		typeValue, _ := strconv.Atoi(fields["type"])
+		createdAt, _ := strconv.ParseInt(fields["createdAt"], 10, 64)
+		expireAt, _ := strconv.ParseInt(fields["expireAt"], 10, 64)
+		shareType := common.ShareKey(typeValue)
+		record := shareRecord{
+			info: v1.ShareInfo{
+				Key:       kStr,
+				Type:      shareTypeNameMap[shareType],
+				Target:    fields["target"],
+				Path:      shareTypePathMap[shareType](kStr),
+				CreatedAt: time.UnixMilli(createdAt),
+				ExpireAt:  time.UnixMilli(expireAt),
+			},
+			shareType: shareType,
+			userId:    fields["userId"],
+		}
+		if len(fields["ended"]) > 0 {
+			remaining := int64(0)
+			record.info.Remaining = &remaining
+		}
+		record.info.Access = readShareAccessStats(fields)
+		record.info.Protected = len(fields["password"]) > 0
+		record.info.Limits = readShareLimits(fields)
+		records = append(records, record)
+	}
+	if err := s.loadShareCounts(ctx, records); err != nil {
+		return nil, nil, err
+	}
+	return records, gone, nil
+}
+
+// loadShareCounts reads the times left of the links, their keys depend on the type read with the info
+func (s *shareService) loadShareCounts(ctx context.Context, records []shareRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+	pipe := s.redisClient.Pipeline()
+	counts := make([]*redis.SliceCmd, len(records))
+	for i, record := range records {
+		counts[i] = pipe.MGet(ctx, shareCountKey(record.shareType, record.info.Key), legacyShareCountKey(record.info.Key))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+	for i := range records {
+		for _, count := range counts[i].Val() {
+			countStr, ok := count.(string)
+			if !ok {
+				continue
+			}
+			if remaining, err := strconv.ParseInt(countStr, 10, 64); err == nil {
+				records[i].info.Remaining = &remaining
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// readShareAccessStats reads the counters RecordAccess keeps in the info of a link
+func readShareAccessStats(fields map[string]string) v1.ShareAccessStats {
+	count := func(field string) int64 {
+		n, _ := strconv.ParseInt(fields["access:"+field], 10, 64)
+		return n
+	}
+	stats := v1.ShareAccessStats{
+		Served:        count(string(common.SHARE_OUTCOME_SERVED)),
+		Expired:       count(string(common.SHARE_OUTCOME_EXPIRED)),
+		Exhausted:     count(string(common.SHARE_OUTCOME_EXHAUSTED)),
+		WrongPassword: count(string(common.SHARE_OUTCOME_WRONG_PASSWORD)),
+		Resumed:       count(string(common.SHARE_OUTCOME_RESUMED)),
+		Bytes:         count("bytes"),
+	}
+	if lastAt := count("lastAt"); lastAt > 0 {
+		t := time.UnixMilli(lastAt)
+		stats.LastAccessAt = &t
+	}
+	return stats
+}
+
+func (s *shareService) queryShares(ctx context.Context, userId string, q *v1.ShareQuery) ([]shareRecord, error) {
+	indexKey := userShareKey(userId)
+	kStrs, err := s.redisClient.ZRangeByScore(ctx, indexKey, &redis.ZRangeBy{
+		Min: strconv.FormatInt(time.Now().UnixMilli(), 10),
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		log.C(ctx).Warnw("query share index failed", "err", err)
+		return nil, errno.InternalServerError
+	}
+	records, gone, err := s.loadShares(ctx, kStrs)
+	if err != nil {
+		log.C(ctx).Warnw("load share failed", "err", err)
+		return nil, errno.InternalServerError
+	}
+	if len(gone) > 0 {
+		s.redisClient.ZRem(ctx, indexKey, gone)
+	}
+	result := make([]shareRecord, 0, len(records))
+	for _, record := range records {
+		if q != nil && len(q.Type) > 0 && record.info.Type != q.Type {
+			continue
+		}
+		if q != nil && len(q.Target) > 0 && record.info.Target != q.Target {
+			continue
+		}
+		result = append(result, record)
+	}
+	return result, nil
+}
+
+// ListShare returns the active links of the user, the latest created first
+func (s *shareService) ListShare(ctx context.Context, userId string, q *v1.ShareQuery) ([]v1.ShareInfo, error) {
+	records, err := s.queryShares(ctx, userId, q)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]v1.ShareInfo, len(records))
+	for i, record := range records {
+		result[i] = record.info
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].CreatedAt.After(result[j].CreatedAt) })
+	return result, nil
+}
+
+func (s *shareService) ShareStatus(ctx context.Context, shareType common.ShareKey, key string, expire time.Duration) (*v1.ShareInfo, error) {
+	if err := s.checkKeyExpire(ctx, key, expire); err != nil {
+		return nil, errno.ErrPageNotFound
+	}
+	records, _, err := s.loadShares(ctx, []string{key})
+	if err != nil {
+		log.C(ctx).Warnw("load share failed", "err", err)
+		return nil, errno.InternalServerError
+	}
+	if len(records) != 1 || records[0].shareType != shareType {
+		return nil, errno.ErrPageNotFound
+	}
+	if err := s.checkShareOpen(ctx, &records[0]); err != nil {
+		return nil, err
+	}
+	return &records[0].info, nil
+}
+
+func (s *shareService) GetShare(ctx context.Context, userId string, key string) (*v1.ShareInfo, error) {
+	record, err := s.ownShare(ctx, userId, key)
+	if err != nil {
+		return nil, err
+	}
+	return &record.info, nil
+}
+
+func (s *shareService) ownShare(ctx context.Context, userId string, key string) (*shareRecord, error) {
+	records, _, err := s.loadShares(ctx, []string{key})
+	if err != nil {
+		log.C(ctx).Warnw("load share failed", "err", err)
+		return nil, errno.InternalServerError
+	}
+	// someone else's link is as unknown as a missing one
+	if len(records) != 1 || records[0].userId != userId {
+		return nil, errno.ErrPageNotFound
+	}
+	return &records[0], nil
+}
+
+func (s *shareService) RevokeShare(ctx context.Context, userId string, key string) error {
+	record, err := s.ownShare(ctx, userId, key)
+	if err != nil {
+		return err
+	}
+	return s.revoke(ctx, record)
+}
+
+func (s *shareService) RevokeUserShares(ctx context.Context, userId string, q *v1.ShareQuery) (int, error) {
+	records, err := s.queryShares(ctx, userId, q)
+	if err != nil {
+		return 0, err
+	}
+	for i := range records {
+		if err := s.revoke(ctx, &records[i]); err != nil {
+			return i, err
+		}
+	}
+	return len(records), nil
+}
+
+// RevokeTargetShares removes every link to a target, called when the target is deleted
+func (s *shareService) RevokeTargetShares(ctx context.Context, shareType common.ShareKey, target string) error {
+	indexKey := targetShareKey(shareType, target)
+	kStrs, err := s.redisClient.ZRange(ctx, indexKey, 0, -1).Result()
+	if err != nil {
+		log.C(ctx).Warnw("query share index failed", "err", err)
+		return errno.InternalServerError
+	}
+	records, _, err := s.loadShares(ctx, kStrs)
+	if err != nil {
+		log.C(ctx).Warnw("load share failed", "err", err)
+		return errno.InternalServerError
+	}
+	for i := range records {
+		if err := s.revoke(ctx, &records[i]); err != nil {
+			return err
+		}
+	}
+	s.redisClient.Del(ctx, indexKey)
+	return nil
+}
+
+func (s *shareService) revoke(ctx context.Context, record *shareRecord) error {
+	kStr := record.info.Key
+	code, _ := s.redisClient.HGet(ctx, dbredis.REDIS_SHARE_INFO_PREFIX+kStr, "code").Result()
+	pipe := s.redisClient.TxPipeline()
+	if len(code) > 0 {
+		pipe.Del(ctx, dbredis.REDIS_PICKUP_CODE_PREFIX+code)
+	}
+	pipe.Del(ctx, shareTypePrefixMap[record.shareType]+kStr,
+		shareCountKey(record.shareType, kStr),
+		legacyShareCountKey(kStr),
+		dbredis.REDIS_SHARE_INFO_PREFIX+kStr)
+	pipe.ZRem(ctx, userShareKey(record.userId), kStr)
+	pipe.ZRem(ctx, targetShareKey(record.shareType, record.info.Target), kStr)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.C(ctx).Warnw("revoke share failed", "key", kStr, "err", err)
+		return errno.InternalServerError
+	}
+	log.C(ctx).Infow("revoked share", "key", kStr, "type", record.info.Type, "target", record.info.Target, "userId", record.userId)
+	return nil
 }
@@ -0,0 +1,407 @@
+package service
+
+import (
+	"context"
+	"file-transfer/internal/file-transfer/repo/repotest"
+	v1 "file-transfer/pkg/api/v1"
+	"file-transfer/pkg/common"
+	"file-transfer/pkg/db/dbredis"
+	"file-transfer/pkg/encrypt/aesencrypt"
+	"file-transfer/pkg/errno"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestShareService(t *testing.T) ShareService {
+	s, _ := newTestShareServiceRedis(t)
+	return s
+}
+
+func newTestShareServiceRedis(t *testing.T) (ShareService, *miniredis.Miniredis) {
+	viper.Set(common.VIPER_AES_KEY, "0123456789abcdef0123456789abcdef")
+	viper.Set(common.VIPER_AES_IV, "0123456789abcdef")
+	aesencrypt.InitAES()
+	mr := miniredis.RunT(t)
+	return NewShareService(redis.NewClient(&redis.Options{Addr: mr.Addr()}), repotest.NewMemShareAccessRepo()), mr
+}
+
+func createTestShare(t *testing.T, create func() (*v1.ShareLink, error)) string {
+	// links are listed newest first by their creation millisecond
+	time.Sleep(2 * time.Millisecond)
+	link, err := create()
+	assert.Nil(t, err)
+	return path.Base(link.Path)
+}
+
+func TestShareIndex(t *testing.T) {
+	ctx := context.Background()
+	s := newTestShareService(t)
+	fileKey := createTestShare(t, func() (*v1.ShareLink, error) {
+		return s.CreateShareUrl(ctx, common.SHARE_TYPE_FILE, "u1", "f1", time.Hour, "")
+	})
+	msgKey := createTestShare(t, func() (*v1.ShareLink, error) {
+		link, err := s.CreateShareUrlWithTimes(ctx, common.SHARE_TYPE_MESSAGE, "u1", "m1", time.Hour, 2, "")
+		assert.Equal(t, int64(2), *link.Remaining)
+		assert.WithinDuration(t, time.Now().Add(time.Hour), link.ExpireAt, time.Second)
+		return link, err
+	})
+	otherKey := createTestShare(t, func() (*v1.ShareLink, error) {
+		return s.CreateShareUrl(ctx, common.SHARE_TYPE_FILE, "u2", "f2", time.Hour, "")
+	})
+
+	list, err := s.ListShare(ctx, "u1", nil)
+	assert.Nil(t, err)
+	assert.Len(t, list, 2)
+	assert.Equal(t, msgKey, list[0].Key)
+	assert.Equal(t, "message", list[0].Type)
+	assert.Equal(t, int64(2), *list[0].Remaining)
+	assert.Equal(t, "/fs/"+fileKey, list[1].Path)
+	assert.Nil(t, list[1].Remaining)
+
+	list, err = s.ListShare(ctx, "u1", &v1.ShareQuery{Type: "file"})
+	assert.Nil(t, err)
+	assert.Len(t, list, 1)
+
+	// a used up link leaves the list
+	for i := 0; i < 2; i++ {
+		_, err = s.ConsumeShareUrl(ctx, common.SHARE_TYPE_MESSAGE, msgKey, time.Hour, "")
+		assert.Nil(t, err)
+	}
+	list, _ = s.ListShare(ctx, "u1", nil)
+	assert.Len(t, list, 1)
+
+	_, err = s.GetShare(ctx, "u1", otherKey)
+	assert.Equal(t, errno.ErrPageNotFound, err)
+	assert.Equal(t, errno.ErrPageNotFound, s.RevokeShare(ctx, "u1", otherKey))
+
+	// deleting the target revokes its links
+	assert.Nil(t, s.RevokeTargetShares(ctx, common.SHARE_TYPE_FILE, "f1"))
+	_, err = s.PeekShareUrl(ctx, common.SHARE_TYPE_FILE, fileKey, time.Hour, "")
+	assert.NotNil(t, err)
+	list, _ = s.ListShare(ctx, "u1", nil)
+	assert.Len(t, list, 0)
+
+	revoked, err := s.RevokeUserShares(ctx, "u2", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, revoked)
+	_, err = s.PeekShareUrl(ctx, common.SHARE_TYPE_FILE, otherKey, time.Hour, "")
+	assert.NotNil(t, err)
+}
+
+func TestSharePassword(t *testing.T) {
+	ctx := context.Background()
+	s := newTestShareService(t)
+	_, err := s.CreateShareUrl(ctx, common.SHARE_TYPE_FILE, "u1", "f1", time.Hour, "abc")
+	assert.Equal(t, errSharePasswordInvalid, err)
+	key := createTestShare(t, func() (*v1.ShareLink, error) {
+		return s.CreateShareUrlWithTimes(ctx, common.SHARE_TYPE_FILE, "u1", "f1", time.Hour, 1, "secret")
+	})
+
+	_, err = s.ConsumeShareUrl(ctx, common.SHARE_TYPE_FILE, key, time.Hour, "")
+	assert.Equal(t, ErrSharePasswordRequired, err)
+	// a wrong password doesn't use up the link
+	_, err = s.ConsumeShareUrl(ctx, common.SHARE_TYPE_FILE, key, time.Hour, "guess")
+	assert.Equal(t, ErrSharePasswordWrong, err)
+	value, err := s.PeekShareUrl(ctx, common.SHARE_TYPE_FILE, key, time.Hour, "secret")
+	assert.Nil(t, err)
+	assert.Equal(t, "f1", value)
+	info, err := s.GetShare(ctx, "u1", key)
+	assert.Nil(t, err)
+	assert.True(t, info.Protected)
+	value, err = s.ConsumeShareUrl(ctx, common.SHARE_TYPE_FILE, key, time.Hour, "secret")
+	assert.Nil(t, err)
+	assert.Equal(t, "f1", value)
+
+	// the right password is refused too once the link is locked
+	key = createTestShare(t, func() (*v1.ShareLink, error) {
+		return s.CreateShareUrl(ctx, common.SHARE_TYPE_FILE, "u1", "f2", time.Hour, "secret")
+	})
+	for i := int64(0); i < SHARE_PASSWORD_ATTEMPTS; i++ {
+		_, err = s.PeekShareUrl(ctx, common.SHARE_TYPE_FILE, key, time.Hour, "guess")
+		assert.Equal(t, ErrSharePasswordWrong, err)
+	}
+	_, err = s.PeekShareUrl(ctx, common.SHARE_TYPE_FILE, key, time.Hour, "secret")
+	assert.Equal(t, ErrSharePasswordLimited, err)
+}
+
+func TestShareKeyRotation(t *testing.T) {
+	ctx := context.Background()
+	s := newTestShareService(t).(*shareService)
+	// a link of the aes keys before sharekey
+	legacy, err := genEncodeString(ctx)
+	assert.Nil(t, err)
+	assert.Nil(t, s.redisClient.Set(ctx, dbredis.REDIS_FILE_SHARE_KEY_PREFIX+legacy, "f0", time.Hour).Err())
+	derived := createTestShare(t, func() (*v1.ShareLink, error) {
+		return s.CreateShareUrl(ctx, common.SHARE_TYPE_FILE, "u1", "f1", time.Hour, "")
+	})
+	assert.True(t, strings.HasPrefix(derived, SHARE_KEY_DERIVED_KID+"."))
+
+	viper.Set("share.keys", map[string]string{"k1": "0123456789abcdef0123456789abcdef"})
+	viper.Set("share.active-key", "k1")
+	defer viper.Set("share.keys", nil)
+	s.signer = newShareSigner()
+	rotated := createTestShare(t, func() (*v1.ShareLink, error) {
+		return s.CreateShareUrl(ctx, common.SHARE_TYPE_FILE, "u1", "f2", time.Hour, "")
+	})
+	assert.True(t, strings.HasPrefix(rotated, "k1."))
+	for key, value := range map[string]string{legacy: "f0", derived: "f1", rotated: "f2"} {
+		v, err := s.PeekShareUrl(ctx, common.SHARE_TYPE_FILE, key, time.Hour, "")
+		assert.Nil(t, err)
+		assert.Equal(t, value, v)
+	}
+
+	// the legacy key is refused once expired
+	_, err = s.PeekShareUrl(ctx, common.SHARE_TYPE_FILE, legacy, -time.Second, "")
+	assert.NotNil(t, err)
+	// a forged key never reaches redis
+	forged := rotated[:len(rotated)-2] + "AA"
+	assert.Nil(t, s.redisClient.Set(ctx, dbredis.REDIS_FILE_SHARE_KEY_PREFIX+forged, "f3", time.Hour).Err())
+	_, err = s.PeekShareUrl(ctx, common.SHARE_TYPE_FILE, forged, time.Hour, "")
+	assert.NotNil(t, err)
+}
+
+func TestShareAccessLog(t *testing.T) {
+	ctx := context.WithValue(context.Background(), common.RESOURCE_IP, "10.0.0.1")
+	s := newTestShareService(t).(*shareService)
+	accessRepo := s.accessRepo.(*repotest.MemShareAccessRepo)
+	key := createTestShare(t, func() (*v1.ShareLink, error) {
+		return s.CreateShareUrlWithTimes(ctx, common.SHARE_TYPE_FILE, "u1", "f1", time.Hour, 1, "secret")
+	})
+
+	_, err := s.ConsumeShareUrl(ctx, common.SHARE_TYPE_FILE, key, time.Hour, "guess")
+	assert.Equal(t, ErrSharePasswordWrong, err)
+	_, err = s.ConsumeShareUrl(ctx, common.SHARE_TYPE_FILE, key, time.Hour, "secret")
+	assert.Nil(t, err)
+	s.RecordAccess(ctx, key, common.SHARE_OUTCOME_SERVED, 100)
+	// used up, still known to its owner
+	_, err = s.ConsumeShareUrl(ctx, common.SHARE_TYPE_FILE, key, time.Hour, "secret")
+	assert.NotNil(t, err)
+	list, _ := s.ListShare(ctx, "u1", nil)
+	assert.Len(t, list, 0)
+	info, err := s.GetShare(ctx, "u1", key)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), *info.Remaining)
+	assert.Equal(t, v1.ShareAccessStats{Served: 1, Exhausted: 1, WrongPassword: 1, Bytes: 100,
+		LastAccessAt: info.Access.LastAccessAt}, info.Access)
+
+	expiring := createTestShare(t, func() (*v1.ShareLink, error) {
+		return s.CreateShareUrl(ctx, common.SHARE_TYPE_MESSAGE, "u1", "m1", time.Millisecond, "")
+	})
+	time.Sleep(2 * time.Millisecond)
+	_, err = s.ConsumeShareUrl(ctx, common.SHARE_TYPE_MESSAGE, expiring, time.Millisecond, "")
+	assert.NotNil(t, err)
+	// a link nobody made isn't logged
+	_, err = s.ConsumeShareUrl(ctx, common.SHARE_TYPE_FILE, "k0.unknown", time.Hour, "")
+	assert.NotNil(t, err)
+
+	accesses, err := s.ListShareAccess(ctx, &v1.ShareAccessQuery{UserId: "u1", PageSize: 10})
+	assert.Nil(t, err)
+	outcomes := make([]common.ShareOutcome, len(accesses))
+	for i, a := range accesses {
+		outcomes[i] = a.Outcome
+	}
+	assert.ElementsMatch(t, []common.ShareOutcome{common.SHARE_OUTCOME_WRONG_PASSWORD, common.SHARE_OUTCOME_SERVED,
+		common.SHARE_OUTCOME_EXHAUSTED, common.SHARE_OUTCOME_EXPIRED}, outcomes)
+	assert.Equal(t, "10.0.0.1", accessRepo.Accesses[0].Ip)
+	accesses, _ = s.ListShareAccess(ctx, &v1.ShareAccessQuery{UserId: "u2", PageSize: 10})
+	assert.Len(t, accesses, 0)
+}
+
+func TestPickupCode(t *testing.T) {
+	ctx := context.Background()
+	s := newTestShareService(t)
+	key := createTestShare(t, func() (*v1.ShareLink, error) {
+		return s.CreateShareUrl(ctx, common.SHARE_TYPE_FILE, "u1", "f1", 5*time.Minute, "")
+	})
+
+	code, err := s.CreatePickupCode(ctx, "u1", key, &v1.PickupCodeParam{})
+	assert.Nil(t, err)
+	assert.Len(t, code.Code, 6)
+	assert.Equal(t, "/code/"+code.Code, code.Path)
+	// not longer than the link
+	assert.True(t, code.ExpireAt.Before(time.Now().Add(5*time.Minute+time.Second)))
+	sharePath, err := s.RedeemPickupCode(ctx, code.Code[:3]+" "+code.Code[3:], "10.0.0.1")
+	assert.Nil(t, err)
+	assert.Equal(t, "/fs/"+key, sharePath)
+
+	_, err = s.CreatePickupCode(ctx, "u2", key, &v1.PickupCodeParam{})
+	assert.Equal(t, errno.ErrPageNotFound, err)
+	_, err = s.CreatePickupCode(ctx, "u1", key, &v1.PickupCodeParam{Style: "emoji"})
+	assert.NotNil(t, err)
+
+	// a new code replaces the old one
+	words, err := s.CreatePickupCode(ctx, "u1", key, &v1.PickupCodeParam{Style: "words", Expire: 30})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(strings.Split(words.Code, "-")))
+	_, err = s.RedeemPickupCode(ctx, code.Code, "10.0.0.1")
+	assert.Equal(t, errPickupCodeNotFound, err)
+	sharePath, err = s.RedeemPickupCode(ctx, strings.ToUpper(words.Code), "10.0.0.1")
+	assert.Nil(t, err)
+	assert.Equal(t, "/fs/"+key, sharePath)
+
+	assert.Nil(t, s.RevokeShare(ctx, "u1", key))
+	_, err = s.RedeemPickupCode(ctx, words.Code, "10.0.0.1")
+	assert.Equal(t, errPickupCodeNotFound, err)
+
+	// wrong codes lock out the ip, even the right code then
+	key = createTestShare(t, func() (*v1.ShareLink, error) {
+		return s.CreateShareUrlWithTimes(ctx, common.SHARE_TYPE_MESSAGE, "u1", "m1", time.Hour, 1, "")
+	})
+	code, err = s.CreatePickupCode(ctx, "u1", key, &v1.PickupCodeParam{})
+	assert.Nil(t, err)
+	// the replaced and the revoked code above count too
+	for i := int64(2); i < PICKUP_CODE_ATTEMPTS; i++ {
+		_, err = s.RedeemPickupCode(ctx, "no-such-code", "10.0.0.1")
+		assert.Equal(t, errPickupCodeNotFound, err)
+	}
+	_, err = s.RedeemPickupCode(ctx, code.Code, "10.0.0.1")
+	assert.Equal(t, ErrPickupCodeLimited, err)
+	sharePath, err = s.RedeemPickupCode(ctx, code.Code, "10.0.0.2")
+	assert.Nil(t, err)
+	assert.Equal(t, "/ms/"+key, sharePath)
+
+	// the code ends with its link
+	_, err = s.ConsumeShareUrl(ctx, common.SHARE_TYPE_MESSAGE, key, time.Hour, "")
+	assert.Nil(t, err)
+	_, err = s.RedeemPickupCode(ctx, code.Code, "10.0.0.2")
+	assert.Equal(t, errPickupCodeNotFound, err)
+}
+
+func TestPickupCodeAttempts(t *testing.T) {
+	ctx := context.Background()
+	s, mr := newTestShareServiceRedis(t)
+	attempts, globalAttempts := PICKUP_CODE_ATTEMPTS, PICKUP_CODE_GLOBAL_ATTEMPTS
+	PICKUP_CODE_ATTEMPTS, PICKUP_CODE_GLOBAL_ATTEMPTS = 3, 5
+	defer func() { PICKUP_CODE_ATTEMPTS, PICKUP_CODE_GLOBAL_ATTEMPTS = attempts, globalAttempts }()
+	key := createTestShare(t, func() (*v1.ShareLink, error) {
+		return s.CreateShareUrl(ctx, common.SHARE_TYPE_FILE, "u1", "f1", time.Hour, "")
+	})
+	code, err := s.CreatePickupCode(ctx, "u1", key, &v1.PickupCodeParam{})
+	assert.Nil(t, err)
+
+	// parallel tries of one ip stop at its limit, and take no more of the global one
+	var checked atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.RedeemPickupCode(ctx, "no-such-code", "10.0.0.1"); err == errPickupCodeNotFound {
+				checked.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, PICKUP_CODE_ATTEMPTS, checked.Load())
+	global, err := mr.Get(dbredis.REDIS_PICKUP_CODE_FAIL_PREFIX + "*")
+	assert.Nil(t, err)
+	assert.Equal(t, "3", global)
+
+	// a right code doesn't count
+	_, err = s.RedeemPickupCode(ctx, code.Code, "10.0.0.2")
+	assert.Nil(t, err)
+	for i := 0; i < 2; i++ {
+		_, err = s.RedeemPickupCode(ctx, "no-such-code", "10.0.0.2")
+		assert.Equal(t, errPickupCodeNotFound, err)
+	}
+	_, err = s.RedeemPickupCode(ctx, code.Code, "10.0.0.3")
+	assert.Equal(t, ErrPickupCodeLimited, err, "locked for everyone")
+	_, err = s.RedeemPickupCode(ctx, code.Code, "10.0.0.2")
+	assert.Equal(t, ErrPickupCodeLimited, err)
+	mr.FastForward(PICKUP_CODE_WINDOW)
+	code, err = s.CreatePickupCode(ctx, "u1", key, &v1.PickupCodeParam{})
+	assert.Nil(t, err)
+	_, err = s.RedeemPickupCode(ctx, code.Code, "10.0.0.3")
+	assert.Nil(t, err)
+}
+
+func TestConsumeShareConcurrent(t *testing.T) {
+	ctx := context.Background()
+	s := newTestShareService(t)
+	const times, clients = 5, 40
+	key := createTestShare(t, func() (*v1.ShareLink, error) {
+		return s.CreateShareUrlWithTimes(ctx, common.SHARE_TYPE_FILE, "u1", "f1", time.Hour, times, "")
+	})
+
+	var served atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if value, err := s.ConsumeShareUrl(ctx, common.SHARE_TYPE_FILE, key, time.Hour, ""); err == nil {
+				assert.Equal(t, "f1", value)
+				served.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(times), served.Load())
+	info, err := s.GetShare(ctx, "u1", key)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), *info.Remaining)
+}
+
+func TestSharePasswordConcurrent(t *testing.T) {
+	ctx := context.Background()
+	s := newTestShareService(t)
+	key := createTestShare(t, func() (*v1.ShareLink, error) {
+		return s.CreateShareUrl(ctx, common.SHARE_TYPE_FILE, "u1", "f1", time.Hour, "secret")
+	})
+
+	// the right password doesn't use up an attempt
+	for i := int64(0); i < SHARE_PASSWORD_ATTEMPTS+1; i++ {
+		_, err := s.PeekShareUrl(ctx, common.SHARE_TYPE_FILE, key, time.Hour, "secret")
+		assert.Nil(t, err)
+	}
+
+	var checked atomic.Int64
+	var wg sync.WaitGroup
+	for i := int64(0); i < 4*SHARE_PASSWORD_ATTEMPTS; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := s.PeekShareUrl(ctx, common.SHARE_TYPE_FILE, key, time.Hour, "guess")
+			if err == ErrSharePasswordWrong {
+				checked.Add(1)
+			} else {
+				assert.Equal(t, ErrSharePasswordLimited, err)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, SHARE_PASSWORD_ATTEMPTS, checked.Load(), "parallel guesses stop at the limit")
+	_, err := s.PeekShareUrl(ctx, common.SHARE_TYPE_FILE, key, time.Hour, "secret")
+	assert.Equal(t, ErrSharePasswordLimited, err)
+	_, err = s.PeekShareUrl(ctx, common.SHARE_TYPE_FILE, key, time.Hour, "")
+	assert.Equal(t, ErrSharePasswordLimited, err)
+}
+
+func TestConsumeLegacyCount(t *testing.T) {
+	ctx := context.Background()
+	s, mr := newTestShareServiceRedis(t)
+	key := createTestShare(t, func() (*v1.ShareLink, error) {
+		return s.CreateShareUrlWithTimes(ctx, common.SHARE_TYPE_MESSAGE, "u1", "m1", time.Hour, 2, "")
+	})
+	// a link made before the counts had the type
+	assert.True(t, mr.Exists("count-ms-"+key))
+	mr.Del("count-ms-" + key)
+	mr.Set("count-"+key, "1")
+
+	info, _ := s.GetShare(ctx, "u1", key)
+	assert.Equal(t, int64(1), *info.Remaining)
+	_, err := s.ConsumeShareUrl(ctx, common.SHARE_TYPE_MESSAGE, key, time.Hour, "")
+	assert.Nil(t, err)
+	_, err = s.ConsumeShareUrl(ctx, common.SHARE_TYPE_MESSAGE, key, time.Hour, "")
+	assert.NotNil(t, err)
+	assert.False(t, mr.Exists("count-"+key))
+}
@@ -0,0 +1,278 @@
+package service
+
+import (
+	"context"
+	"crypto/sha1"
+	"errors"
+	"file-transfer/internal/file-transfer/repo"
+	v1 "file-transfer/pkg/api/v1"
+	"file-transfer/pkg/common"
+	"file-transfer/pkg/db/dbredis"
+	"file-transfer/pkg/errno"
+	"file-transfer/pkg/log"
+	"file-transfer/pkg/model"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/spf13/viper"
+	"golang.org/x/time/rate"
+)
+
+const (
+	BLOB_SCRUB_LOCK       string = "blob-scrubber"
+	BLOB_SCRUB_BATCH_SIZE int64  = 100
+	// bytes hashed per limiter wait, also the limiter burst
+	BLOB_SCRUB_CHUNK int = 256 * 1024
+	// the lock is extended while a pass runs, a crashed instance blocks others for one ttl only
+	BLOB_SCRUB_LOCK_TTL time.Duration = 5 * time.Minute
+)
+
+var (
+	// how often a scrub pass starts, 0 disables the background scrubber
+	BLOB_SCRUB_INTERVAL time.Duration = 24 * time.Hour
+	// blobs hashed more recently are skipped by a pass
+	BLOB_SCRUB_AGE time.Duration = 7 * 24 * time.Hour
+	// bytes per second read from the upload volume, 0 is unlimited
+	BLOB_SCRUB_RATE int64 = 20 * 1024 * 1024
+	// serve damaged blobs with a warning header instead of refusing them
+	SERVE_DAMAGED_BLOB bool
+)
+
+var (
+	ErrBlobScrubRunning = errors.New("blob scrubber is running on another instance")
+	errBlobScrubLost    = errors.New("blob scrubber lock lost")
+
+	errBlobDamaged = &errno.Errno{HTTP: http.StatusInternalServerError, Code: "InternalError.BlobDamaged", Message: "stored file is damaged"}
+)
+
+// BlobScrubber re-hashes stored blobs and records mismatches on their meta, only one instance scrubs at a time
+type BlobScrubber struct {
+	fileRepo    repo.FileRepo
+	redisClient *redis.Client
+	root        string
+	interval    time.Duration
+	age         time.Duration
+	limiter     *rate.Limiter
+}
+
+func NewBlobScrubber(fileRepo repo.FileRepo, rClient *redis.Client) *BlobScrubber {
+	if interval := viper.GetString("scrub.interval"); len(interval) > 0 {
+		BLOB_SCRUB_INTERVAL = viper.GetDuration("scrub.interval")
+	}
+	if age := viper.GetDuration("scrub.age"); age > 0 {
+		BLOB_SCRUB_AGE = age
+	}
+	if viper.IsSet("scrub.rate") {
+		BLOB_SCRUB_RATE = viper.GetInt64("scrub.rate")
+	}
+	log.Infow(fmt.Sprintf("Read blob scrub interval: %s, age: %s, rate: %d", BLOB_SCRUB_INTERVAL, BLOB_SCRUB_AGE, BLOB_SCRUB_RATE))
+	s := &BlobScrubber{
+		fileRepo:    fileRepo,
+		redisClient: rClient,
+		root:        viper.GetString("upload.path"),
+		interval:    BLOB_SCRUB_INTERVAL,
+		age:         BLOB_SCRUB_AGE,
+	}
+	s.SetRate(BLOB_SCRUB_RATE)
+	return s
+}
+
+// SetRate changes the bytes per second, 0 is unlimited
+func (s *BlobScrubber) SetRate(bytesPerSecond int64) {
+	limit := rate.Inf
+	if bytesPerSecond > 0 {
+		limit = rate.Limit(bytesPerSecond)
+	}
+	s.limiter = rate.NewLimiter(limit, BLOB_SCRUB_CHUNK)
+}
+
+// Run scrubs every interval until ctx is done
+func (s *BlobScrubber) Run(ctx context.Context) {
+	if s.interval <= 0 {
+		log.Infow("blob scrubber is disabled")
+		return
+	}
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			report, err := s.Scrub(ctx, false, nil)
+			if err == ErrBlobScrubRunning {
+				log.Debugw(err.Error())
+				continue
+			}
+			if err != nil {
+				log.Warnw("blob scrub failed", "err", err)
+			}
+			if report != nil {
+				log.Infow("blob scrub finished", "checked", report.Checked, "bytes", report.Bytes,
+					"corrupt", len(report.Corrupt), "missing", len(report.Missing))
+			}
+		}
+	}
+}
+
+// Scrub runs one pass under the cluster lock, all re-hashes every blob instead of the ones older than the age
+func (s *BlobScrubber) Scrub(ctx context.Context, all bool, onProgress func(*v1.BlobScrubReport)) (*v1.BlobScrubReport, error) {
+	token, ok, err := dbredis.TryLock(ctx, s.redisClient, BLOB_SCRUB_LOCK, BLOB_SCRUB_LOCK_TTL)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrBlobScrubRunning
+	}
+	defer func() {
+		if err := dbredis.Unlock(context.Background(), s.redisClient, BLOB_SCRUB_LOCK, token); err != nil {
+			log.Warnw("blob scrubber unlock failed", "err", err)
+		}
+	}()
+
+	extendedAt := time.Now()
+	keepLock := func() error {
+		if time.Since(extendedAt) < BLOB_SCRUB_LOCK_TTL/2 {
+			return nil
+		}
+		ok, err := dbredis.ExtendLock(ctx, s.redisClient, BLOB_SCRUB_LOCK, token, BLOB_SCRUB_LOCK_TTL)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errBlobScrubLost
+		}
+		extendedAt = time.Now()
+		return nil
+	}
+	before := time.Now().Add(-s.age)
+	if all {
+		before = time.Now()
+	}
+	return s.ScrubPass(ctx, before, keepLock, onProgress)
+}
+
+// ScrubPass hashes the blobs not scrubbed since before, check is called between blobs and stops the pass on error
+func (s *BlobScrubber) ScrubPass(ctx context.Context, before time.Time, check func() error, onProgress func(*v1.BlobScrubReport)) (*v1.BlobScrubReport, error) {
+	report := &v1.BlobScrubReport{}
+	total, err := s.fileRepo.CountMetaToScrub(ctx, before)
+	if err != nil {
+		return nil, err
+	}
+	report.Total = total
+
+	lastId := ""
+	for {
+		list, err := s.fileRepo.FindMetaToScrub(ctx, before, lastId, BLOB_SCRUB_BATCH_SIZE)
+		if err != nil {
+			return report, err
+		}
+		for i := range list {
+			if err := ctx.Err(); err != nil {
+				return report, err
+			}
+			if check != nil {
+				if err := check(); err != nil {
+					return report, err
+				}
+			}
+			meta := &list[i]
+			lastId = meta.Id
+			status, read, err := s.scrubBlob(ctx, meta)
+			report.Bytes += read
+			if err != nil {
+				// an unreadable volume isn't a verdict on the blob, it is tried again next pass
+				log.Warnw("blob scrub read failed", "metaId", meta.Id, "location", meta.Location, "err", err)
+				continue
+			}
+			report.Checked++
+			switch status {
+			case common.SCRUB_STATUS_CORRUPT:
+				report.Corrupt = append(report.Corrupt, meta.Location)
+			case common.SCRUB_STATUS_MISSING:
+				report.Missing = append(report.Missing, meta.Location)
+			}
+			if onProgress != nil {
+				onProgress(report)
+			}
+		}
+		if int64(len(list)) < BLOB_SCRUB_BATCH_SIZE {
+			return report, nil
+		}
+	}
+}
+
+// scrubBlob hashes one blob at the rate limit and records the result
+func (s *BlobScrubber) scrubBlob(ctx context.Context, meta *model.FileMeta) (common.ScrubStatus, int64, error) {
+	status, read, actual, err := s.hashBlob(ctx, meta)
+	if err != nil {
+		return "", read, err
+	}
+	if err := s.fileRepo.UpdateMetaScrub(ctx, meta.Id, status, time.Now()); err != nil {
+		return "", read, err
+	}
+	switch {
+	case status == common.SCRUB_STATUS_CORRUPT:
+		log.Errorw("blob corrupt", "metaId", meta.Id, "location", meta.Location,
+			"sha", meta.Sha, "actualSha", actual, "size", meta.Size, "actualSize", read)
+	case status == common.SCRUB_STATUS_MISSING:
+		log.Errorw("blob missing", "metaId", meta.Id, "location", meta.Location)
+	case meta.Damaged():
+		log.Infow("blob repaired", "metaId", meta.Id, "location", meta.Location, "was", meta.ScrubStatus)
+	}
+	return status, read, nil
+}
+
+func (s *BlobScrubber) hashBlob(ctx context.Context, meta *model.FileMeta) (common.ScrubStatus, int64, string, error) {
+	file, err := os.Open(filepath.Join(s.root, meta.Location))
+	if os.IsNotExist(err) {
+		return common.SCRUB_STATUS_MISSING, 0, "", nil
+	}
+	if err != nil {
+		return "", 0, "", err
+	}
+	defer file.Close()
+
+	hash := sha1.New()
+	buf := make([]byte, BLOB_SCRUB_CHUNK)
+	var read int64
+	for {
+		n, err := file.Read(buf)
+		if n > 0 {
+			if err := s.limiter.WaitN(ctx, n); err != nil {
+				return "", read, "", err
+			}
+			hash.Write(buf[:n])
+			read += int64(n)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", read, "", err
+		}
+	}
+	actual := fmt.Sprintf("%x", hash.Sum(nil))
+	if read != meta.Size || actual != meta.Sha {
+		return common.SCRUB_STATUS_CORRUPT, read, actual, nil
+	}
+	return common.SCRUB_STATUS_OK, read, actual, nil
+}
+
+// checkBlob refuses a blob the scrubber found damaged, with scrub.serve-damaged it is served and logged
+func checkBlob(ctx context.Context, meta *model.FileMeta) (bool, error) {
+	if !meta.Damaged() {
+		return false, nil
+	}
+	if !SERVE_DAMAGED_BLOB {
+		log.C(ctx).Errorw("refuse damaged blob", "metaId", meta.Id, "location", meta.Location, "status", meta.ScrubStatus)
+		return true, errBlobDamaged
+	}
+	log.C(ctx).Warnw("serve damaged blob", "metaId", meta.Id, "location", meta.Location, "status", meta.ScrubStatus)
+	return true, nil
+}
@@ -0,0 +1,63 @@
+package service
+
+import (
+	"context"
+	"file-transfer/internal/file-transfer/repo/repotest"
+	v1 "file-transfer/pkg/api/v1"
+	"file-transfer/pkg/common"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlobScrubber(t *testing.T) {
+	SAVE_FILE_PATH = t.TempDir()
+	ctx := context.Background()
+	fileRepo := repotest.NewMemFileRepo()
+	fileServ := &fileService{fileRepo: fileRepo}
+	upload := func(name string, content string) (string, string) {
+		id, err := fileServ.UploadFile(ctx, strings.NewReader(content), &v1.FileUploadParam{UserId: "u1", Name: name})
+		assert.Nil(t, err)
+		return id, fileRepo.Metas[fileRepo.Files[id].MetaId].Id
+	}
+	upload("good.txt", "good content")
+	corruptId, corruptMeta := upload("corrupt.txt", "corrupt content")
+	_, missingMeta := upload("missing.txt", "missing content")
+	corruptPath := filepath.Join(SAVE_FILE_PATH, fileRepo.Metas[corruptMeta].Location)
+	assert.Nil(t, os.WriteFile(corruptPath, []byte("c0rrupt content"), 0644))
+	assert.Nil(t, os.Remove(filepath.Join(SAVE_FILE_PATH, fileRepo.Metas[missingMeta].Location)))
+
+	scrubber := &BlobScrubber{fileRepo: fileRepo, root: SAVE_FILE_PATH}
+	scrubber.SetRate(0)
+	report, err := scrubber.ScrubPass(ctx, time.Now(), nil, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), report.Total)
+	assert.Equal(t, int64(3), report.Checked)
+	assert.Equal(t, []string{fileRepo.Metas[corruptMeta].Location}, report.Corrupt)
+	assert.Equal(t, []string{fileRepo.Metas[missingMeta].Location}, report.Missing)
+	assert.Equal(t, common.SCRUB_STATUS_CORRUPT, fileRepo.Metas[corruptMeta].ScrubStatus)
+
+	// scrubbed blobs wait for the next age
+	report, err = scrubber.ScrubPass(ctx, time.Now().Add(-time.Hour), nil, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), report.Checked)
+
+	_, err = fileServ.DownloadFile(ctx, corruptId, "u1")
+	assert.Equal(t, errBlobDamaged, err)
+	SERVE_DAMAGED_BLOB = true
+	data, err := fileServ.DownloadFile(ctx, corruptId, "u1")
+	SERVE_DAMAGED_BLOB = false
+	assert.Nil(t, err)
+	assert.True(t, data.Damaged)
+
+	// uploading the same content again puts a good copy in place
+	upload("again.txt", "corrupt content")
+	assert.Equal(t, common.SCRUB_STATUS_OK, fileRepo.Metas[corruptMeta].ScrubStatus)
+	content, err := os.ReadFile(corruptPath)
+	assert.Nil(t, err)
+	assert.Equal(t, "corrupt content", string(content))
+}
@@ -0,0 +1,262 @@
+package service
+
+import (
+	"context"
+	v1 "file-transfer/pkg/api/v1"
+	"file-transfer/pkg/common"
+	"file-transfer/pkg/errno"
+	"file-transfer/pkg/log"
+	"file-transfer/pkg/model"
+	"file-transfer/pkg/util"
+	"net/http"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// files of a collection share given by id at most
+	COLLECTION_SHARE_MAX_FILES = 1000
+	// the item of the download sessions of the zip, the files have their ids
+	COLLECTION_ZIP_SESSION_ITEM = "zip"
+)
+
+var (
+	errCollectionInvalid = &errno.Errno{HTTP: http.StatusBadRequest, Code: "InvalidParameter.Collection",
+		Message: "a collection needs a folder or 1 to 1000 files of the user"}
+)
+
+type collectionItem struct {
+	file model.UserFile
+	meta model.FileMeta
+	path string
+}
+
+// ShareCollection shares a folder or a set of files under one link.
+// Each download, of one item or of the zip, uses up one of the times of the link.
+func (f *fileService) ShareCollection(ctx context.Context, userId string, param *v1.CollectionShareParam) (*v1.ShareLink, error) {
+	if err := checkShareLimits(&param.ShareLimits); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	collection := &model.ShareCollection{UserId: userId, CreatedAt: now}
+	if len(param.FileIds) > 0 {
+		if len(param.FileIds) > COLLECTION_SHARE_MAX_FILES {
+			return nil, errCollectionInvalid
+		}
+		seen := make(map[string]bool, len(param.FileIds))
+		for _, id := range param.FileIds {
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			file, err := f.fileRepo.QueryUserFileById(ctx, id)
+			if err != nil || file.UserId != userId {
+				return nil, errCollectionInvalid
+			}
+			collection.FileIds = append(collection.FileIds, id)
+		}
+	} else {
+		// the whole drive isn't shared this way
+		folder, err := util.NormalizeFolder(param.Folder)
+		if err != nil || len(folder) < 1 {
+			return nil, errCollectionInvalid
+		}
+		if _, err := f.fileRepo.FindUserFolder(ctx, userId, folder); err != nil {
+			files, err := f.fileRepo.QueryUserFileUnder(ctx, userId, folder)
+			if err != nil || len(files) == 0 {
+				return nil, errno.ErrPageNotFound
+			}
+		}
+		collection.Folder = folder
+	}
+
+	expire := FILE_SHARE_LINK_EXPIRE
+	if param.ExpireType == common.SHARE_EXPIRE_TYPE_DURATION {
+		expire = time.Duration(param.Expire * int64(time.Minute))
+	}
+	collection.ExpireAt = now.Add(expire + SHARE_ENDED_RETAIN)
+	id, err := f.fileRepo.InsertShareCollection(ctx, collection)
+	if err != nil {
+		log.C(ctx).Errorw("insert share collection failed", "err", err)
+		return nil, errno.InternalServerError
+	}
+	var link *v1.ShareLink
+	switch param.ExpireType {
+	case common.SHARE_EXPIRE_TYPE_DURATION:
+		link, err = f.shareServ.CreateShareUrl(ctx, common.SHARE_TYPE_COLLECTION, userId, id, expire, param.Password)
+	case common.SHARE_EXPIRE_TYPE_TIMES:
+		link, err = f.shareServ.CreateShareUrlWithTimes(ctx, common.SHARE_TYPE_COLLECTION, userId, id, expire, int8(param.Expire), param.Password)
+	default:
+		return nil, &errno.Errno{HTTP: http.StatusMethodNotAllowed, Message: "invalid type"}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return f.limitShare(ctx, userId, link, &param.ShareLimits)
+}
+
+// ReadCollection lists the collection, it doesn't count as an access of the link
+func (f *fileService) ReadCollection(ctx context.Context, key string, password string) (*v1.CollectionShareResponse, error) {
+	collectionId, err := f.shareServ.PeekShareUrl(ctx, common.SHARE_TYPE_COLLECTION, key, FILE_SHARE_LINK_EXPIRE, password)
+	if err != nil {
+		return nil, shareAccessError(err)
+	}
+	collection, items, err := f.collectionItems(ctx, collectionId)
+	if err != nil {
+		return nil, err
+	}
+	result := &v1.CollectionShareResponse{Name: collectionName(collection), Files: make([]v1.CollectionItem, len(items))}
+	for i, item := range items {
+		result.Files[i] = v1.CollectionItem{Id: item.file.Id, Path: item.path, Size: item.meta.Size}
+		result.Size += item.meta.Size
+	}
+	return result, nil
+}
+
+func (f *fileService) ReadCollectionFile(ctx context.Context, key string, password string, session string, userFileId string) (*v1.FileDownloadData, error) {
+	lease, err := f.leaseShareDownload(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := f.readCollectionFile(ctx, key, password, session, userFileId)
+	if err != nil {
+		lease.Release()
+		return nil, err
+	}
+	data.Lease = lease
+	return data, nil
+}
+
+func (f *fileService) readCollectionFile(ctx context.Context, key string, password string, session string, userFileId string) (*v1.FileDownloadData, error) {
+	// a session reads one file of the collection
+	opened, err := f.shareServ.ConsumeShareSession(ctx, common.SHARE_TYPE_COLLECTION, key, userFileId, FILE_SHARE_LINK_EXPIRE, password, session)
+	if err != nil {
+		return nil, shareAccessError(err)
+	}
+	_, items, err := f.collectionItems(ctx, opened.Value)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range items {
+		if item.file.Id != userFileId {
+			continue
+		}
+		damaged, err := checkBlob(ctx, &item.meta)
+		if err != nil {
+			return nil, err
+		}
+		return &v1.FileDownloadData{
+			Location:      filepath.Join(SAVE_FILE_PATH, item.meta.Location),
+			Size:          item.meta.Size,
+			Name:          item.file.Name,
+			StripMetadata: item.file.StripOnShare,
+			Damaged:       damaged,
+			Session:       opened.Token,
+			Resumed:       opened.Resumed,
+		}, nil
+	}
+	return nil, errno.ErrPageNotFound
+}
+
+// ReadCollectionZip checks every file before the zip is streamed, a damaged one fails it as a whole
+func (f *fileService) ReadCollectionZip(ctx context.Context, key string, password string, session string) (*v1.CollectionZipData, error) {
+	lease, err := f.leaseShareDownload(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := f.readCollectionZip(ctx, key, password, session)
+	if err != nil {
+		lease.Release()
+		return nil, err
+	}
+	data.Lease = lease
+	return data, nil
+}
+
+func (f *fileService) readCollectionZip(ctx context.Context, key string, password string, session string) (*v1.CollectionZipData, error) {
+	// the zip can't be read in ranges, its session lets a broken download start over
+	opened, err := f.shareServ.ConsumeShareSession(ctx, common.SHARE_TYPE_COLLECTION, key, COLLECTION_ZIP_SESSION_ITEM, FILE_SHARE_LINK_EXPIRE, password, session)
+	if err != nil {
+		return nil, shareAccessError(err)
+	}
+	collection, items, err := f.collectionItems(ctx, opened.Value)
+	if err != nil {
+		return nil, err
+	}
+	result := &v1.CollectionZipData{Name: collectionName(collection), Files: make([]v1.FileDownloadData, len(items)),
+		Session: opened.Token, Resumed: opened.Resumed}
+	for i, item := range items {
+		if _, err := checkBlob(ctx, &item.meta); err != nil {
+			return nil, err
+		}
+		result.Files[i] = v1.FileDownloadData{
+			Location:      filepath.Join(SAVE_FILE_PATH, item.meta.Location),
+			Size:          item.meta.Size,
+			Name:          item.path,
+			StripMetadata: item.file.StripOnShare,
+		}
+	}
+	return result, nil
+}
+
+func collectionName(collection *model.ShareCollection) string {
+	if len(collection.Folder) > 0 {
+		return path.Base(collection.Folder)
+	}
+	return "files"
+}
+
+// collectionItems resolves the files of a collection now, files deleted since it was shared are left out
+func (f *fileService) collectionItems(ctx context.Context, collectionId string) (*model.ShareCollection, []collectionItem, error) {
+	collection, err := f.fileRepo.FindShareCollection(ctx, collectionId)
+	if err != nil {
+		return nil, nil, errno.ErrPageNotFound
+	}
+	var files []model.UserFile
+	if len(collection.FileIds) > 0 {
+		for _, id := range collection.FileIds {
+			file, err := f.fileRepo.QueryUserFileById(ctx, id)
+			if err != nil || file.UserId != collection.UserId {
+				continue
+			}
+			files = append(files, *file)
+		}
+	} else {
+		files, err = f.fileRepo.QueryUserFileUnder(ctx, collection.UserId, collection.Folder)
+		if err != nil {
+			log.C(ctx).Errorw("query collection files failed", "collection", collectionId, "err", err)
+			return nil, nil, errno.InternalServerError
+		}
+	}
+
+	metaIds := make([]string, len(files))
+	for i, file := range files {
+		metaIds[i] = file.MetaId
+	}
+	metas, err := f.fileRepo.FindByMetaId(ctx, metaIds)
+	if err != nil {
+		log.C(ctx).Errorw("query collection metas failed", "collection", collectionId, "err", err)
+		return nil, nil, errno.InternalServerError
+	}
+	metaMap := make(map[string]model.FileMeta, len(metas))
+	for _, meta := range metas {
+		metaMap[meta.Id] = meta
+	}
+	items := make([]collectionItem, 0, len(files))
+	for _, file := range files {
+		meta, ok := metaMap[file.MetaId]
+		if !ok {
+			continue
+		}
+		p := path.Join(file.Folder, file.Name)
+		if len(collection.Folder) > 0 {
+			p = strings.TrimPrefix(p, collection.Folder+"/")
+		}
+		items = append(items, collectionItem{file: file, meta: meta, path: p})
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].path < items[j].path })
+	return collection, items, nil
+}
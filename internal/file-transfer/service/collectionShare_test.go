@@ -0,0 +1,91 @@
+package service
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"file-transfer/internal/file-transfer/repo/repotest"
+	v1 "file-transfer/pkg/api/v1"
+	"file-transfer/pkg/common"
+	"file-transfer/pkg/errno"
+	"file-transfer/pkg/util"
+	"io"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCollectionShare(t *testing.T) {
+	SAVE_FILE_PATH = t.TempDir()
+	ctx := context.Background()
+	fileRepo := repotest.NewMemFileRepo()
+	fileServ := &fileService{fileRepo: fileRepo, shareServ: newTestShareService(t)}
+	upload := func(userId string, folder string, name string, content string) string {
+		id, err := fileServ.UploadFile(ctx, strings.NewReader(content), &v1.FileUploadParam{UserId: userId, Folder: folder, Name: name})
+		assert.Nil(t, err)
+		return id
+	}
+	aId := upload("u1", "docs", "a.txt", "aaa")
+	bId := upload("u1", "docs/sub", "b.txt", "bbbb")
+	topId := upload("u1", "", "top.txt", "top")
+	otherId := upload("u2", "", "other.txt", "other")
+
+	_, err := fileServ.ShareCollection(ctx, "u1", &v1.CollectionShareParam{FileIds: []string{aId, otherId}})
+	assert.Equal(t, errCollectionInvalid, err)
+	for _, root := range []string{"", "/", "./"} {
+		_, err = fileServ.ShareCollection(ctx, "u1", &v1.CollectionShareParam{Folder: root})
+		assert.Equal(t, errCollectionInvalid, err, "not the whole drive")
+	}
+	_, err = fileServ.ShareCollection(ctx, "u1", &v1.CollectionShareParam{Folder: "nothing"})
+	assert.Equal(t, errno.ErrPageNotFound, err)
+
+	link, err := fileServ.ShareCollection(ctx, "u1", &v1.CollectionShareParam{
+		MessageShareParam: v1.MessageShareParam{ExpireType: common.SHARE_EXPIRE_TYPE_TIMES, Expire: 2},
+		Folder:            "docs",
+	})
+	assert.Nil(t, err)
+	key := path.Base(link.Path)
+	listing, err := fileServ.ReadCollection(ctx, key, "")
+	assert.Nil(t, err)
+	assert.Equal(t, "docs", listing.Name)
+	assert.Equal(t, []v1.CollectionItem{{Id: aId, Path: "a.txt", Size: 3}, {Id: bId, Path: "sub/b.txt", Size: 4}}, listing.Files)
+	assert.Equal(t, int64(7), listing.Size)
+
+	// one item and the zip, each uses up one of the times
+	data, err := fileServ.ReadCollectionFile(ctx, key, "", "", bId)
+	assert.Nil(t, err)
+	assert.Equal(t, "b.txt", data.Name)
+	zipData, err := fileServ.ReadCollectionZip(ctx, key, "", "")
+	assert.Nil(t, err)
+	w := httptest.NewRecorder()
+	sent := util.ZipFileHandler(ctx, w, zipData)
+	assert.Equal(t, int64(w.Body.Len()), sent)
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	assert.Nil(t, err)
+	contents := make(map[string]string)
+	for _, entry := range zr.File {
+		rc, _ := entry.Open()
+		content, _ := io.ReadAll(rc)
+		rc.Close()
+		contents[entry.Name] = string(content)
+	}
+	assert.Equal(t, map[string]string{"a.txt": "aaa", "sub/b.txt": "bbbb"}, contents)
+	_, err = fileServ.ReadCollectionZip(ctx, key, "", "")
+	assert.NotNil(t, err)
+
+	// a set of files keeps their full paths, a deleted one is left out
+	link, err = fileServ.ShareCollection(ctx, "u1", &v1.CollectionShareParam{
+		MessageShareParam: v1.MessageShareParam{ExpireType: common.SHARE_EXPIRE_TYPE_DURATION, Expire: 10},
+		FileIds:           []string{topId, aId, bId},
+	})
+	assert.Nil(t, err)
+	delete(fileRepo.Files, bId)
+	listing, err = fileServ.ReadCollection(ctx, path.Base(link.Path), "")
+	assert.Nil(t, err)
+	assert.Equal(t, []v1.CollectionItem{{Id: aId, Path: "docs/a.txt", Size: 3}, {Id: topId, Path: "top.txt", Size: 3}}, listing.Files)
+	_, err = fileServ.ReadCollectionFile(ctx, path.Base(link.Path), "", "", otherId)
+	assert.Equal(t, errno.ErrPageNotFound, err)
+}
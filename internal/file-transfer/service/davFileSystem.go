@@ -0,0 +1,592 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"file-transfer/internal/file-transfer/repo"
+	v1 "file-transfer/pkg/api/v1"
+	"file-transfer/pkg/common"
+	"file-transfer/pkg/log"
+	"file-transfer/pkg/model"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+// ErrDavTooLarge refuses a PUT body over the upload size limit
+var ErrDavTooLarge = errors.New("file too large")
+
+// davFileSystem maps the WebDAV tree of the request user onto UserFile and UserFolder
+type davFileSystem struct {
+	fileRepo repo.FileRepo
+	fileServ FileService
+}
+
+var _ webdav.FileSystem = (*davFileSystem)(nil)
+
+func NewDavFileSystem(fileRepo repo.FileRepo, fileServ FileService) webdav.FileSystem {
+	return &davFileSystem{fileRepo: fileRepo, fileServ: fileServ}
+}
+
+func davUserId(ctx context.Context) (string, error) {
+	userId, _ := ctx.Value(common.Trace_request_uid{}).(string)
+	if len(userId) < 1 {
+		return "", os.ErrPermission
+	}
+	return userId, nil
+}
+
+// davPath cleans a WebDAV name to "a/b/c", the root is ""
+func davPath(name string) (string, error) {
+	segments := make([]string, 0)
+	for _, seg := range strings.Split(name, "/") {
+		switch seg {
+		case "", ".":
+			continue
+		case "..":
+			return "", os.ErrInvalid
+		}
+		segments = append(segments, seg)
+	}
+	return strings.Join(segments, "/"), nil
+}
+
+func splitDavPath(p string) (string, string) {
+	idx := strings.LastIndex(p, "/")
+	if idx < 0 {
+		return "", p
+	}
+	return p[:idx], p[idx+1:]
+}
+
+func (d *davFileSystem) findFile(ctx context.Context, userId string, p string) *model.UserFile {
+	if p == "" {
+		return nil
+	}
+	folder, name := splitDavPath(p)
+	userFile, err := d.fileRepo.FindOneByNameAndUser(ctx, name, folder, userId)
+	if err != nil {
+		return nil
+	}
+	return userFile
+}
+
+func (d *davFileSystem) folderExists(ctx context.Context, userId string, p string) (bool, error) {
+	return userFolderExists(ctx, d.fileRepo, userId, p)
+}
+
+// userFolderExists is true for created folders and for folders holding files
+func userFolderExists(ctx context.Context, fileRepo repo.FileRepo, userId string, p string) (bool, error) {
+	if p == "" {
+		return true, nil
+	}
+	if folder, _ := fileRepo.FindUserFolder(ctx, userId, p); folder != nil {
+		return true, nil
+	}
+	files, err := fileRepo.QueryUserFileUnder(ctx, userId, p)
+	if err != nil {
+		return false, err
+	}
+	if len(files) > 0 {
+		return true, nil
+	}
+	folders, err := fileRepo.QueryUserFolderUnder(ctx, userId, p)
+	if err != nil {
+		return false, err
+	}
+	return len(folders) > 0, nil
+}
+
+func (d *davFileSystem) fileInfo(ctx context.Context, userFile *model.UserFile) (*davFileInfo, *model.FileMeta, error) {
+	metas, err := d.fileRepo.FindByMetaId(ctx, []string{userFile.MetaId})
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(metas) != 1 {
+		return nil, nil, os.ErrNotExist
+	}
+	return &davFileInfo{
+		name:    userFile.Name,
+		size:    metas[0].Size,
+		modTime: userFile.CreatedAt,
+		sha:     metas[0].Sha,
+		damaged: metas[0].Damaged(),
+	}, &metas[0], nil
+}
+
+func davDirInfo(p string) *davFileInfo {
+	_, name := splitDavPath(p)
+	if name == "" {
+		name = "/"
+	}
+	return &davFileInfo{name: name, dir: true}
+}
+
+func (d *davFileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	userId, err := davUserId(ctx)
+	if err != nil {
+		return err
+	}
+	p, err := davPath(name)
+	if err != nil {
+		return err
+	}
+	if p == "" || d.findFile(ctx, userId, p) != nil {
+		return os.ErrExist
+	}
+	if exist, err := d.folderExists(ctx, userId, p); err != nil || exist {
+		if err != nil {
+			return err
+		}
+		return os.ErrExist
+	}
+	parent, _ := splitDavPath(p)
+	if exist, err := d.folderExists(ctx, userId, parent); err != nil || !exist {
+		if err != nil {
+			return err
+		}
+		return os.ErrNotExist
+	}
+	_, err = d.fileRepo.InsertUserFolder(ctx, &model.UserFolder{UserId: userId, Path: p, CreatedAt: time.Now()})
+	if err != nil {
+		log.C(ctx).Errorw("dav mkdir failed", "path", p, "err", err)
+		return err
+	}
+	return nil
+}
+
+func (d *davFileSystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	userId, err := davUserId(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p, err := davPath(name)
+	if err != nil {
+		return nil, err
+	}
+	userFile := d.findFile(ctx, userId, p)
+
+	if flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+		if p == "" {
+			return nil, os.ErrInvalid
+		}
+		if userFile != nil && flag&os.O_EXCL != 0 {
+			return nil, os.ErrExist
+		}
+		if userFile == nil && flag&os.O_CREATE == 0 {
+			return nil, os.ErrNotExist
+		}
+		folder, fileName := splitDavPath(p)
+		if exist, err := d.folderExists(ctx, userId, folder); err != nil || !exist {
+			if err != nil {
+				return nil, err
+			}
+			return nil, os.ErrNotExist
+		}
+		temp, err := os.CreateTemp(TEMP_FILE_DIR, TEMP_FILE_PATTERN)
+		if err != nil {
+			return nil, err
+		}
+		return &davWriteFile{
+			ctx:  ctx,
+			temp: temp,
+			serv: d.fileServ,
+			param: v1.FileUploadParam{
+				UserId:    userId,
+				Name:      fileName,
+				Folder:    folder,
+				Overwrite: true,
+			},
+		}, nil
+	}
+
+	if userFile != nil {
+		info, meta, err := d.fileInfo(ctx, userFile)
+		if err != nil {
+			return nil, err
+		}
+		// PROPFIND opens every file for its properties, a damaged blob is refused once it is read
+		if info.damaged && !SERVE_DAMAGED_BLOB {
+			return &davDamagedFile{ctx: ctx, info: info, meta: meta}, nil
+		}
+		if _, err := checkBlob(ctx, meta); err != nil {
+			return nil, err
+		}
+		file, err := os.Open(filepath.Join(SAVE_FILE_PATH, meta.Location))
+		if err != nil {
+			log.C(ctx).Errorw("dav open file failed", "location", meta.Location, "err", err)
+			return nil, err
+		}
+		return &davReadFile{File: file, info: info}, nil
+	}
+	exist, err := d.folderExists(ctx, userId, p)
+	if err != nil {
+		return nil, err
+	}
+	if !exist {
+		return nil, os.ErrNotExist
+	}
+	return &davDir{ctx: ctx, fs: d, userId: userId, path: p}, nil
+}
+
+func (d *davFileSystem) RemoveAll(ctx context.Context, name string) error {
+	userId, err := davUserId(ctx)
+	if err != nil {
+		return err
+	}
+	p, err := davPath(name)
+	if err != nil {
+		return err
+	}
+	if p == "" {
+		return os.ErrPermission
+	}
+	if userFile := d.findFile(ctx, userId, p); userFile != nil {
+		return d.fileServ.DeleteFile(ctx, userFile.Id, userId)
+	}
+	files, err := d.fileRepo.QueryUserFileUnder(ctx, userId, p)
+	if err != nil {
+		return err
+	}
+	for _, userFile := range files {
+		if err := d.fileServ.DeleteFile(ctx, userFile.Id, userId); err != nil {
+			return err
+		}
+	}
+	return d.fileRepo.DeleteUserFolderUnder(ctx, userId, p)
+}
+
+func (d *davFileSystem) Rename(ctx context.Context, oldName, newName string) error {
+	userId, err := davUserId(ctx)
+	if err != nil {
+		return err
+	}
+	op, err := davPath(oldName)
+	if err != nil {
+		return err
+	}
+	np, err := davPath(newName)
+	if err != nil {
+		return err
+	}
+	if op == "" || np == "" || strings.HasPrefix(np+"/", op+"/") {
+		return os.ErrInvalid
+	}
+	folder, fileName := splitDavPath(np)
+	if exist, err := d.folderExists(ctx, userId, folder); err != nil || !exist {
+		if err != nil {
+			return err
+		}
+		return os.ErrNotExist
+	}
+	if d.findFile(ctx, userId, np) != nil {
+		return os.ErrExist
+	}
+
+	if userFile := d.findFile(ctx, userId, op); userFile != nil {
+		return d.fileRepo.MoveUserFile(ctx, userFile.Id, folder, fileName)
+	}
+	exist, err := d.folderExists(ctx, userId, op)
+	if err != nil {
+		return err
+	}
+	if !exist {
+		return os.ErrNotExist
+	}
+	files, err := d.fileRepo.QueryUserFileUnder(ctx, userId, op)
+	if err != nil {
+		return err
+	}
+	for _, userFile := range files {
+		if err := d.fileRepo.MoveUserFile(ctx, userFile.Id, np+strings.TrimPrefix(userFile.Folder, op), userFile.Name); err != nil {
+			return err
+		}
+	}
+	folders, err := d.fileRepo.QueryUserFolderUnder(ctx, userId, op)
+	if err != nil {
+		return err
+	}
+	for _, f := range folders {
+		if err := d.fileRepo.MoveUserFolder(ctx, f.Id, np+strings.TrimPrefix(f.Path, op)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *davFileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	userId, err := davUserId(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p, err := davPath(name)
+	if err != nil {
+		return nil, err
+	}
+	if userFile := d.findFile(ctx, userId, p); userFile != nil {
+		info, _, err := d.fileInfo(ctx, userFile)
+		return info, err
+	}
+	exist, err := d.folderExists(ctx, userId, p)
+	if err != nil {
+		return nil, err
+	}
+	if !exist {
+		return nil, os.ErrNotExist
+	}
+	return davDirInfo(p), nil
+}
+
+// readDir lists files and direct sub folders of p
+func (d *davFileSystem) readDir(ctx context.Context, userId string, p string) ([]fs.FileInfo, error) {
+	files, err := d.fileRepo.QueryUserFileInFolder(ctx, userId, p)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, len(files))
+	for i, item := range files {
+		ids[i] = item.MetaId
+	}
+	metas, err := d.fileRepo.FindByMetaId(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	metaMap := make(map[string]model.FileMeta)
+	for _, obj := range metas {
+		metaMap[obj.Id] = obj
+	}
+	result := make([]fs.FileInfo, 0, len(files))
+	for _, item := range files {
+		meta := metaMap[item.MetaId]
+		result = append(result, &davFileInfo{
+			name:    item.Name,
+			size:    meta.Size,
+			modTime: item.CreatedAt,
+			sha:     meta.Sha,
+			damaged: meta.Damaged(),
+		})
+	}
+
+	children := make(map[string]bool)
+	addChild := func(folder string) {
+		rest := folder
+		if p != "" {
+			if !strings.HasPrefix(folder, p+"/") {
+				return
+			}
+			rest = strings.TrimPrefix(folder, p+"/")
+		}
+		if child := strings.SplitN(rest, "/", 2)[0]; child != "" {
+			children[child] = true
+		}
+	}
+	folders, err := d.fileRepo.QueryUserFolderUnder(ctx, userId, p)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range folders {
+		addChild(f.Path)
+	}
+	under, err := d.fileRepo.QueryUserFileUnder(ctx, userId, p)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range under {
+		addChild(f.Folder)
+	}
+	names := make([]string, 0, len(children))
+	for name := range children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		result = append(result, &davFileInfo{name: name, dir: true})
+	}
+	return result, nil
+}
+
+type davFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+	sha     string
+	damaged bool
+}
+
+var _ webdav.ETager = (*davFileInfo)(nil)
+var _ webdav.ContentTyper = (*davFileInfo)(nil)
+
+func (i *davFileInfo) Name() string       { return i.name }
+func (i *davFileInfo) Size() int64        { return i.size }
+func (i *davFileInfo) ModTime() time.Time { return i.modTime }
+func (i *davFileInfo) IsDir() bool        { return i.dir }
+func (i *davFileInfo) Sys() interface{}   { return nil }
+
+func (i *davFileInfo) Mode() os.FileMode {
+	if i.dir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
+
+// ETag uses the content hash, so a deduplicated blob keeps its tag
+func (i *davFileInfo) ETag(ctx context.Context) (string, error) {
+	if i.dir || i.sha == "" {
+		return "", webdav.ErrNotImplemented
+	}
+	return fmt.Sprintf(`"%s"`, i.sha), nil
+}
+
+// ContentType keeps PROPFIND from opening a damaged blob, which is refused
+func (i *davFileInfo) ContentType(ctx context.Context) (string, error) {
+	if !i.damaged {
+		return "", webdav.ErrNotImplemented
+	}
+	if ctype := mime.TypeByExtension(filepath.Ext(i.name)); ctype != "" {
+		return ctype, nil
+	}
+	return "application/octet-stream", nil
+}
+
+type davReadFile struct {
+	*os.File
+	info *davFileInfo
+}
+
+func (f *davReadFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+
+func (f *davReadFile) Readdir(count int) ([]fs.FileInfo, error) { return nil, os.ErrInvalid }
+
+func (f *davReadFile) Write(p []byte) (int, error) { return 0, os.ErrPermission }
+
+// davDamagedFile describes a damaged blob, reading or seeking it fails. GET answers 500 before any
+// byte is sent, because ServeContent seeks first
+type davDamagedFile struct {
+	ctx  context.Context
+	info *davFileInfo
+	meta *model.FileMeta
+}
+
+func (f *davDamagedFile) refuse() error {
+	_, err := checkBlob(f.ctx, f.meta)
+	return err
+}
+
+func (f *davDamagedFile) Close() error                                 { return nil }
+func (f *davDamagedFile) Read(p []byte) (int, error)                   { return 0, f.refuse() }
+func (f *davDamagedFile) Seek(offset int64, whence int) (int64, error) { return 0, f.refuse() }
+func (f *davDamagedFile) Write(p []byte) (int, error)                  { return 0, os.ErrPermission }
+func (f *davDamagedFile) Readdir(count int) ([]fs.FileInfo, error)     { return nil, os.ErrInvalid }
+func (f *davDamagedFile) Stat() (fs.FileInfo, error)                   { return f.info, nil }
+
+type davDir struct {
+	ctx     context.Context
+	fs      *davFileSystem
+	userId  string
+	path    string
+	entries []fs.FileInfo
+	loaded  bool
+}
+
+func (d *davDir) Close() error                                 { return nil }
+func (d *davDir) Read(p []byte) (int, error)                   { return 0, os.ErrInvalid }
+func (d *davDir) Write(p []byte) (int, error)                  { return 0, os.ErrInvalid }
+func (d *davDir) Seek(offset int64, whence int) (int64, error) { return 0, nil }
+func (d *davDir) Stat() (fs.FileInfo, error)                   { return davDirInfo(d.path), nil }
+
+func (d *davDir) Readdir(count int) ([]fs.FileInfo, error) {
+	if !d.loaded {
+		entries, err := d.fs.readDir(d.ctx, d.userId, d.path)
+		if err != nil {
+			return nil, err
+		}
+		d.entries, d.loaded = entries, true
+	}
+	if count <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(d.entries) {
+		count = len(d.entries)
+	}
+	entries := d.entries[:count]
+	d.entries = d.entries[count:]
+	return entries, nil
+}
+
+// davWriteFile buffers a PUT to a temp file, it is stored through the upload on Close.
+// The temp file stops growing at the upload size limit
+type davWriteFile struct {
+	ctx   context.Context
+	temp  *os.File
+	serv  FileService
+	param v1.FileUploadParam
+	size  int64
+	// the copy failed, a part of the content isn't stored
+	err error
+}
+
+func (f *davWriteFile) Write(p []byte) (int, error) {
+	if f.size+int64(len(p)) >= MAX_SINGLE_FILE_SIZE {
+		f.err = ErrDavTooLarge
+		return 0, f.err
+	}
+	n, err := f.temp.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// ReadFrom is used by io.Copy for the body of a PUT and the source of a COPY, so a broken
+// source fails Close too
+func (f *davWriteFile) ReadFrom(r io.Reader) (int64, error) {
+	n, err := io.Copy(struct{ io.Writer }{f}, r)
+	if err != nil && f.err == nil {
+		f.err = err
+	}
+	return n, err
+}
+
+func (f *davWriteFile) Read(p []byte) (int, error) { return 0, os.ErrInvalid }
+
+func (f *davWriteFile) Seek(offset int64, whence int) (int64, error) {
+	return f.temp.Seek(offset, whence)
+}
+
+func (f *davWriteFile) Readdir(count int) ([]fs.FileInfo, error) { return nil, os.ErrInvalid }
+
+func (f *davWriteFile) Stat() (fs.FileInfo, error) {
+	info, err := f.temp.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return &davFileInfo{name: f.param.Name, size: info.Size(), modTime: info.ModTime()}, nil
+}
+
+func (f *davWriteFile) Close() error {
+	defer func() {
+		f.temp.Close()
+		os.Remove(f.temp.Name())
+	}()
+	if f.err != nil {
+		return f.err
+	}
+	if _, err := f.temp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	param := f.param
+	_, err := f.serv.UploadFile(f.ctx, f.temp, &param)
+	return err
+}
@@ -0,0 +1,144 @@
+package service
+
+import (
+	"context"
+	"file-transfer/internal/file-transfer/repo/repotest"
+	"file-transfer/pkg/common"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/webdav"
+)
+
+func newDavTestServer(t *testing.T) (*httptest.Server, *repotest.MemFileRepo) {
+	SAVE_FILE_PATH = t.TempDir()
+	fileRepo := repotest.NewMemFileRepo()
+	fileServ := &fileService{fileRepo: fileRepo}
+	handler := &webdav.Handler{
+		Prefix:     "/dav",
+		FileSystem: NewDavFileSystem(fileRepo, fileServ),
+		LockSystem: webdav.NewMemLS(),
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), common.Trace_request_uid{}, "u1")
+		handler.ServeHTTP(w, r.WithContext(ctx))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, fileRepo
+}
+
+func davDo(t *testing.T, srv *httptest.Server, method string, path string, body string, header map[string]string) (int, string) {
+	req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+	assert.Nil(t, err)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, err := srv.Client().Do(req)
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(data)
+}
+
+func TestDavFileSystem(t *testing.T) {
+	srv, fileRepo := newDavTestServer(t)
+
+	code, _ := davDo(t, srv, "MKCOL", "/dav/docs", "", nil)
+	assert.Equal(t, http.StatusCreated, code)
+	code, _ = davDo(t, srv, "MKCOL", "/dav/missing/child", "", nil)
+	assert.Equal(t, http.StatusConflict, code)
+
+	code, _ = davDo(t, srv, "PUT", "/dav/docs/a.txt", "hello", nil)
+	assert.Equal(t, http.StatusCreated, code)
+
+	code, body := davDo(t, srv, "PROPFIND", "/dav/docs", "", map[string]string{"Depth": "1"})
+	assert.Equal(t, http.StatusMultiStatus, code)
+	assert.Contains(t, body, "/dav/docs/a.txt")
+
+	code, body = davDo(t, srv, "GET", "/dav/docs/a.txt", "", nil)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "hello", body)
+
+	// copy is deduplicated to the same blob
+	code, _ = davDo(t, srv, "COPY", "/dav/docs/a.txt", "", map[string]string{"Destination": srv.URL + "/dav/docs/b.txt"})
+	assert.Equal(t, http.StatusCreated, code)
+	assert.Len(t, fileRepo.Files, 2)
+	assert.Len(t, fileRepo.Metas, 1)
+
+	// overwrite replaces the old user file
+	code, _ = davDo(t, srv, "PUT", "/dav/docs/b.txt", "world", nil)
+	assert.Equal(t, http.StatusCreated, code)
+	assert.Len(t, fileRepo.Files, 2)
+	assert.Len(t, fileRepo.Metas, 2)
+
+	code, _ = davDo(t, srv, "MOVE", "/dav/docs", "", map[string]string{"Destination": srv.URL + "/dav/moved"})
+	assert.Equal(t, http.StatusCreated, code)
+	code, body = davDo(t, srv, "GET", "/dav/moved/b.txt", "", nil)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "world", body)
+	code, _ = davDo(t, srv, "GET", "/dav/docs/a.txt", "", nil)
+	assert.Equal(t, http.StatusNotFound, code)
+
+	code, _ = davDo(t, srv, "DELETE", "/dav/moved", "", nil)
+	assert.Equal(t, http.StatusNoContent, code)
+	assert.Len(t, fileRepo.Files, 0)
+	assert.Len(t, fileRepo.Metas, 0)
+	assert.Len(t, fileRepo.Folders, 0)
+	blobs, _ := os.ReadDir(filepath.Clean(SAVE_FILE_PATH))
+	assert.Len(t, blobs, 0)
+}
+
+func TestDavPutTooLarge(t *testing.T) {
+	srv, fileRepo := newDavTestServer(t)
+	maxSize := MAX_SINGLE_FILE_SIZE
+	MAX_SINGLE_FILE_SIZE = 6
+	defer func() { MAX_SINGLE_FILE_SIZE = maxSize }()
+
+	code, _ := davDo(t, srv, "PUT", "/dav/big.txt", "hello world", nil)
+	assert.NotEqual(t, http.StatusCreated, code)
+	assert.Len(t, fileRepo.Files, 0, "a part of the body isn't stored")
+
+	code, _ = davDo(t, srv, "PUT", "/dav/small.txt", "hello", nil)
+	assert.Equal(t, http.StatusCreated, code)
+}
+
+func TestDavPropfindDamaged(t *testing.T) {
+	srv, fileRepo := newDavTestServer(t)
+	code, _ := davDo(t, srv, "MKCOL", "/dav/docs", "", nil)
+	assert.Equal(t, http.StatusCreated, code)
+	code, _ = davDo(t, srv, "PUT", "/dav/docs/a.txt", "hello", nil)
+	assert.Equal(t, http.StatusCreated, code)
+	code, _ = davDo(t, srv, "PUT", "/dav/docs/b.txt", "world", nil)
+	assert.Equal(t, http.StatusCreated, code)
+	for _, file := range fileRepo.Files {
+		if file.Name == "a.txt" {
+			meta := fileRepo.Metas[file.MetaId]
+			meta.ScrubStatus = common.SCRUB_STATUS_CORRUPT
+			fileRepo.Metas[file.MetaId] = meta
+		}
+	}
+
+	// the listing doesn't open the damaged blob
+	code, body := davDo(t, srv, "PROPFIND", "/dav/docs", "", map[string]string{"Depth": "1"})
+	assert.Equal(t, http.StatusMultiStatus, code)
+	assert.Contains(t, body, "/dav/docs/a.txt")
+	assert.Contains(t, body, "/dav/docs/b.txt")
+	assert.Contains(t, body, "text/plain")
+	code, _ = davDo(t, srv, "PROPFIND", "/dav/docs/a.txt", "", map[string]string{"Depth": "0"})
+	assert.Equal(t, http.StatusMultiStatus, code)
+
+	code, _ = davDo(t, srv, "GET", "/dav/docs/a.txt", "", nil)
+	assert.Equal(t, http.StatusInternalServerError, code)
+	code, _ = davDo(t, srv, "COPY", "/dav/docs/a.txt", "", map[string]string{"Destination": srv.URL + "/dav/docs/c.txt"})
+	assert.NotEqual(t, http.StatusCreated, code)
+	assert.Len(t, fileRepo.Files, 2, "a failed copy isn't stored")
+	code, body = davDo(t, srv, "GET", "/dav/docs/b.txt", "", nil)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "world", body)
+}
@@ -0,0 +1,160 @@
+package service
+
+import (
+	"context"
+	v1 "file-transfer/pkg/api/v1"
+	"file-transfer/pkg/bandwidth"
+	"file-transfer/pkg/common"
+	"file-transfer/pkg/db/dbredis"
+	"file-transfer/pkg/errno"
+	"file-transfer/pkg/log"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// the limits apply to each instance, the uplink they protect is the one of the instance
+var (
+	// bytes per second of one link, and its downloads at the same time, when its owner set none. 0 is unlimited
+	DOWNLOAD_SHARE_RATE       int64 = 0
+	DOWNLOAD_SHARE_CONCURRENT int64 = 0
+	// bytes per second of all downloads together, 0 is unlimited
+	DOWNLOAD_EGRESS_RATE int64 = 0
+	// a download which would wait longer for its first bytes is refused with 429
+	DOWNLOAD_MAX_WAIT time.Duration = 5 * time.Second
+)
+
+var (
+	ErrDownloadLimited = &errno.Errno{HTTP: http.StatusTooManyRequests, Code: "TooManyRequests.Download", Message: "too many downloads, try again later"}
+
+	errShareLimitsInvalid = &errno.Errno{HTTP: http.StatusBadRequest, Code: "InvalidParameter.ShareLimits", Message: "limits can't be negative and apply to file and collection links only"}
+)
+
+func newDownloadThrottle() *bandwidth.Throttle {
+	if viper.IsSet("download.share-rate") {
+		DOWNLOAD_SHARE_RATE = viper.GetInt64("download.share-rate")
+	}
+	if viper.IsSet("download.share-concurrent") {
+		DOWNLOAD_SHARE_CONCURRENT = viper.GetInt64("download.share-concurrent")
+	}
+	if viper.IsSet("download.egress-rate") {
+		DOWNLOAD_EGRESS_RATE = viper.GetInt64("download.egress-rate")
+	}
+	if wait := viper.GetDuration("download.max-wait"); wait > 0 {
+		DOWNLOAD_MAX_WAIT = wait
+	}
+	log.Infow(fmt.Sprintf("Read download share rate: %d, share concurrent: %d, egress rate: %d, max wait: %s",
+		DOWNLOAD_SHARE_RATE, DOWNLOAD_SHARE_CONCURRENT, DOWNLOAD_EGRESS_RATE, DOWNLOAD_MAX_WAIT))
+	return bandwidth.NewThrottle(DOWNLOAD_EGRESS_RATE, DOWNLOAD_MAX_WAIT)
+}
+
+func checkShareLimits(limits *v1.ShareLimits) error {
+	if limits.RateLimit < 0 || limits.ConcurrentLimit < 0 {
+		return errShareLimitsInvalid
+	}
+	return nil
+}
+
+// SetShareLimits keeps the limits with the info of the link, 0 goes back to the server default
+func (s *shareService) SetShareLimits(ctx context.Context, userId string, key string, limits *v1.ShareLimits) error {
+	if err := checkShareLimits(limits); err != nil {
+		return err
+	}
+	record, err := s.ownShare(ctx, userId, key)
+	if err != nil {
+		return err
+	}
+	if record.shareType != common.SHARE_TYPE_FILE && record.shareType != common.SHARE_TYPE_COLLECTION {
+		return errShareLimitsInvalid
+	}
+	infoKey := dbredis.REDIS_SHARE_INFO_PREFIX + key
+	pipe := s.redisClient.TxPipeline()
+	for field, value := range map[string]int64{"rateLimit": limits.RateLimit, "concurrentLimit": limits.ConcurrentLimit} {
+		if value > 0 {
+			pipe.HSet(ctx, infoKey, field, value)
+		} else {
+			pipe.HDel(ctx, infoKey, field)
+		}
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.C(ctx).Warnw("set share limits failed", "key", key, "err", err)
+		return errno.InternalServerError
+	}
+	log.C(ctx).Infow("share limits set", "key", key, "rateLimit", limits.RateLimit, "concurrentLimit", limits.ConcurrentLimit)
+	return nil
+}
+
+func (s *shareService) ShareLimits(ctx context.Context, key string) (*v1.ShareLimits, error) {
+	values, err := s.redisClient.HMGet(ctx, dbredis.REDIS_SHARE_INFO_PREFIX+key, "rateLimit", "concurrentLimit").Result()
+	if err != nil {
+		log.C(ctx).Warnw("read share limits failed", "key", key, "err", err)
+		return nil, errno.InternalServerError
+	}
+	fields := make(map[string]string, len(values))
+	for i, field := range []string{"rateLimit", "concurrentLimit"} {
+		if value, ok := values[i].(string); ok {
+			fields[field] = value
+		}
+	}
+	if limits := readShareLimits(fields); limits != nil {
+		return limits, nil
+	}
+	return &v1.ShareLimits{}, nil
+}
+
+// readShareLimits is the part of the info loadShares shows, nil when the owner set none
+func readShareLimits(fields map[string]string) *v1.ShareLimits {
+	rateLimit, _ := strconv.ParseInt(fields["rateLimit"], 10, 64)
+	concurrentLimit, _ := strconv.ParseInt(fields["concurrentLimit"], 10, 64)
+	if rateLimit == 0 && concurrentLimit == 0 {
+		return nil
+	}
+	return &v1.ShareLimits{RateLimit: rateLimit, ConcurrentLimit: concurrentLimit}
+}
+
+// limitShare sets the limits asked for with a new link
+func (f *fileService) limitShare(ctx context.Context, userId string, link *v1.ShareLink, limits *v1.ShareLimits) (*v1.ShareLink, error) {
+	if limits.RateLimit == 0 && limits.ConcurrentLimit == 0 {
+		return link, nil
+	}
+	if err := f.shareServ.SetShareLimits(ctx, userId, path.Base(link.Path), limits); err != nil {
+		return nil, err
+	}
+	return link, nil
+}
+
+// leaseShareDownload takes a download slot of the link before it is opened, so a refused download
+// doesn't use up one of its times. The slot is released once the file is sent
+func (f *fileService) leaseShareDownload(ctx context.Context, key string) (*bandwidth.Lease, error) {
+	limits, err := f.shareServ.ShareLimits(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	rate, concurrent := limits.RateLimit, limits.ConcurrentLimit
+	if rate == 0 {
+		rate = DOWNLOAD_SHARE_RATE
+	}
+	if concurrent == 0 {
+		concurrent = DOWNLOAD_SHARE_CONCURRENT
+	}
+	lease, err := f.throttle.Acquire(key, bandwidth.Limits{Rate: rate, Concurrent: concurrent})
+	if err != nil {
+		log.C(ctx).Infow("share download limited", "key", key, "err", err)
+		return nil, err
+	}
+	return lease, nil
+}
+
+// leaseDownload takes a slot of the egress budget for a download of the owner
+func (f *fileService) leaseDownload(ctx context.Context) (*bandwidth.Lease, error) {
+	lease, err := f.throttle.Acquire("", bandwidth.Limits{})
+	if err != nil {
+		log.C(ctx).Infow("download limited", "err", err)
+		return nil, err
+	}
+	return lease, nil
+}
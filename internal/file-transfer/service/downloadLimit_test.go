@@ -0,0 +1,58 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"file-transfer/internal/file-transfer/repo/repotest"
+	v1 "file-transfer/pkg/api/v1"
+	"file-transfer/pkg/bandwidth"
+	"file-transfer/pkg/common"
+	"file-transfer/pkg/errno"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShareDownloadLimits(t *testing.T) {
+	SAVE_FILE_PATH = t.TempDir()
+	ctx := context.Background()
+	shareServ := newTestShareService(t)
+	fileServ := &fileService{fileRepo: repotest.NewMemFileRepo(), shareServ: shareServ, throttle: bandwidth.NewThrottle(0, time.Second)}
+	fileId, err := fileServ.UploadFile(ctx, bytes.NewReader([]byte("content")), &v1.FileUploadParam{UserId: "u1", Name: "a.txt"})
+	assert.Nil(t, err)
+
+	_, err = fileServ.Share(ctx, fileId, "u1", &v1.MessageShareParam{ExpireType: common.SHARE_EXPIRE_TYPE_TIMES, Expire: 2,
+		ShareLimits: v1.ShareLimits{ConcurrentLimit: -1}})
+	assert.Equal(t, errShareLimitsInvalid, err)
+	link, err := fileServ.Share(ctx, fileId, "u1", &v1.MessageShareParam{ExpireType: common.SHARE_EXPIRE_TYPE_TIMES, Expire: 2,
+		ShareLimits: v1.ShareLimits{ConcurrentLimit: 1}})
+	assert.Nil(t, err)
+	key := path.Base(link.Path)
+	info, err := shareServ.GetShare(ctx, "u1", key)
+	assert.Nil(t, err)
+	assert.Equal(t, &v1.ShareLimits{ConcurrentLimit: 1}, info.Limits)
+
+	first, err := fileServ.ReadShare(ctx, key, "", "")
+	assert.Nil(t, err)
+	_, err = fileServ.ReadShare(ctx, key, "", "")
+	_, limited := err.(*bandwidth.LimitError)
+	assert.True(t, limited)
+	// the refused download didn't use up the link
+	first.Lease.Release()
+	second, err := fileServ.ReadShare(ctx, key, "", "")
+	assert.Nil(t, err)
+	second.Lease.Release()
+
+	// the owner lifts the limit of the link
+	assert.Nil(t, shareServ.SetShareLimits(ctx, "u1", key, &v1.ShareLimits{}))
+	info, err = shareServ.GetShare(ctx, "u1", key)
+	assert.Nil(t, err)
+	assert.Nil(t, info.Limits)
+	assert.Equal(t, errno.ErrPageNotFound, shareServ.SetShareLimits(ctx, "u2", key, &v1.ShareLimits{RateLimit: 1}))
+
+	message, err := shareServ.CreateShareUrl(ctx, common.SHARE_TYPE_MESSAGE, "u1", "m1", time.Minute, "")
+	assert.Nil(t, err)
+	assert.Equal(t, errShareLimitsInvalid, shareServ.SetShareLimits(ctx, "u1", path.Base(message.Path), &v1.ShareLimits{RateLimit: 1}))
+}
@@ -0,0 +1,113 @@
+package service
+
+import (
+	"context"
+	"file-transfer/pkg/common"
+	"file-transfer/pkg/db/dbredis"
+	"file-transfer/pkg/log"
+	"file-transfer/pkg/util"
+	"strconv"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const DOWNLOAD_SESSION_LEN = 32
+
+// a download session lives this long after it started, as long as its link at most. Its requests don't extend it
+var DOWNLOAD_SESSION_EXPIRE time.Duration = 30 * time.Minute
+
+// ShareSession is a link opened for a download
+type ShareSession struct {
+	Value string
+	// the session started by the request, given to the client for its next requests
+	Token string
+	// the request belonged to an earlier session, the link wasn't used up
+	Resumed bool
+}
+
+func loadDownloadSessionConfig() {
+	if expire := viper.GetDuration("share.session-expire"); expire > 0 {
+		DOWNLOAD_SESSION_EXPIRE = expire
+	}
+}
+
+// ConsumeShareSession opens a link for the download of one item. A request with the session of an
+// earlier download of the same item reads it again without using up the link, the caller passes the
+// session of range and resume requests only. Only links limited by times start sessions
+func (s *shareService) ConsumeShareSession(ctx context.Context, shareType common.ShareKey, key string, item string, expire time.Duration, password string, token string) (*ShareSession, error) {
+	if len(token) > 0 {
+		if value, ok := s.resumeSession(ctx, key, item, token); ok {
+			return &ShareSession{Value: value, Resumed: true}, nil
+		}
+	}
+	value, remaining, err := s.consumeShare(ctx, shareType, key, expire, password)
+	if err != nil {
+		return nil, err
+	}
+	session := &ShareSession{Value: value}
+	if remaining < 0 {
+		return session, nil
+	}
+	// without a session the download still goes on, its ranges count as downloads then
+	session.Token, _ = s.startSession(ctx, key, item, value)
+	return session, nil
+}
+
+func (s *shareService) startSession(ctx context.Context, key string, item string, value string) (string, error) {
+	ttl, ok := s.sessionTTL(ctx, key)
+	if !ok {
+		return "", nil
+	}
+	token, err := util.GenerateRandomString(DOWNLOAD_SESSION_LEN)
+	if err != nil {
+		return "", err
+	}
+	sessionKey := dbredis.REDIS_DOWNLOAD_SESSION_PREFIX + token
+	pipe := s.redisClient.TxPipeline()
+	pipe.HSet(ctx, sessionKey, "key", key, "item", item, "value", value)
+	pipe.PExpire(ctx, sessionKey, ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.C(ctx).Warnw("start download session failed", "key", key, "err", err)
+		return "", err
+	}
+	return token, nil
+}
+
+// resumeSession returns the value of the session if it is one of the item. The session ends at the
+// deadline set when it started, so a client can't keep a used up link open by asking again and again
+func (s *shareService) resumeSession(ctx context.Context, key string, item string, token string) (string, bool) {
+	sessionKey := dbredis.REDIS_DOWNLOAD_SESSION_PREFIX + token
+	fields, err := s.redisClient.HMGet(ctx, sessionKey, "key", "item", "value").Result()
+	if err != nil {
+		log.C(ctx).Warnw("read download session failed", "key", key, "err", err)
+		return "", false
+	}
+	sessionLink, _ := fields[0].(string)
+	sessionItem, _ := fields[1].(string)
+	value, _ := fields[2].(string)
+	if sessionLink != key || sessionItem != item || len(value) == 0 {
+		return "", false
+	}
+	// a revoked link ends its sessions
+	if _, ok := s.sessionTTL(ctx, key); !ok {
+		s.redisClient.Del(ctx, sessionKey)
+		return "", false
+	}
+	return value, true
+}
+
+// sessionTTL ends the sessions of a link with it. A used up link keeps its info and its sessions,
+// the info of a revoked one is gone
+func (s *shareService) sessionTTL(ctx context.Context, key string) (time.Duration, bool) {
+	expireStr, err := s.redisClient.HGet(ctx, dbredis.REDIS_SHARE_INFO_PREFIX+key, "expireAt").Result()
+	if err != nil {
+		return 0, false
+	}
+	expireAt, _ := strconv.ParseInt(expireStr, 10, 64)
+	left := time.Until(time.UnixMilli(expireAt))
+	if left <= 0 {
+		return 0, false
+	}
+	return min(left, DOWNLOAD_SESSION_EXPIRE), true
+}
@@ -0,0 +1,69 @@
+package service
+
+import (
+	"context"
+	v1 "file-transfer/pkg/api/v1"
+	"file-transfer/pkg/common"
+	"file-transfer/pkg/errno"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDownloadSession(t *testing.T) {
+	ctx := context.Background()
+	s, mr := newTestShareServiceRedis(t)
+
+	key := createTestShare(t, func() (*v1.ShareLink, error) {
+		return s.CreateShareUrlWithTimes(ctx, common.SHARE_TYPE_COLLECTION, "u1", "c1", time.Hour, 1, "")
+	})
+	first, err := s.ConsumeShareSession(ctx, common.SHARE_TYPE_COLLECTION, key, "f1", time.Hour, "", "")
+	assert.Nil(t, err)
+	assert.Equal(t, "c1", first.Value)
+	assert.False(t, first.Resumed)
+	assert.NotEmpty(t, first.Token)
+
+	// the ranges of the download read the used up link
+	for i := 0; i < 3; i++ {
+		again, err := s.ConsumeShareSession(ctx, common.SHARE_TYPE_COLLECTION, key, "f1", time.Hour, "", first.Token)
+		assert.Nil(t, err)
+		assert.Equal(t, "c1", again.Value)
+		assert.True(t, again.Resumed)
+		assert.Empty(t, again.Token)
+	}
+	// the session is for one file, and it can't be made up
+	_, err = s.ConsumeShareSession(ctx, common.SHARE_TYPE_COLLECTION, key, "f2", time.Hour, "", first.Token)
+	assert.Equal(t, errno.ErrInvalidParameter, err)
+	_, err = s.ConsumeShareSession(ctx, common.SHARE_TYPE_COLLECTION, key, "f1", time.Hour, "", "made-up")
+	assert.Equal(t, errno.ErrInvalidParameter, err)
+
+	info, err := s.GetShare(ctx, "u1", key)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), *info.Remaining)
+
+	// asking again doesn't keep the session open
+	mr.FastForward(DOWNLOAD_SESSION_EXPIRE / 2)
+	_, err = s.ConsumeShareSession(ctx, common.SHARE_TYPE_COLLECTION, key, "f1", time.Hour, "", first.Token)
+	assert.Nil(t, err)
+	mr.FastForward(DOWNLOAD_SESSION_EXPIRE/2 + time.Second)
+	_, err = s.ConsumeShareSession(ctx, common.SHARE_TYPE_COLLECTION, key, "f1", time.Hour, "", first.Token)
+	assert.Equal(t, errno.ErrInvalidParameter, err)
+
+	key = createTestShare(t, func() (*v1.ShareLink, error) {
+		return s.CreateShareUrlWithTimes(ctx, common.SHARE_TYPE_FILE, "u1", "f1", time.Hour, 2, "")
+	})
+	first, err = s.ConsumeShareSession(ctx, common.SHARE_TYPE_FILE, key, "", time.Hour, "", "")
+	assert.Nil(t, err)
+	assert.Nil(t, s.RevokeShare(ctx, "u1", key))
+	_, err = s.ConsumeShareSession(ctx, common.SHARE_TYPE_FILE, key, "", time.Hour, "", first.Token)
+	assert.NotNil(t, err)
+
+	// a link limited by time counts nothing, it needs no session
+	link, err := s.CreateShareUrl(ctx, common.SHARE_TYPE_FILE, "u1", "f1", time.Hour, "")
+	assert.Nil(t, err)
+	opened, err := s.ConsumeShareSession(ctx, common.SHARE_TYPE_FILE, path.Base(link.Path), "", time.Hour, "", "")
+	assert.Nil(t, err)
+	assert.Empty(t, opened.Token)
+}
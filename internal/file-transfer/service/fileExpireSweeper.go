@@ -0,0 +1,98 @@
+package service
+
+import (
+	"context"
+	"file-transfer/internal/file-transfer/repo"
+	"file-transfer/pkg/db/dbredis"
+	"file-transfer/pkg/log"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/spf13/viper"
+)
+
+const FILE_EXPIRE_LOCK string = "file-expire-sweeper"
+
+var (
+	FILE_EXPIRE_SWEEP_INTERVAL time.Duration = time.Minute
+	FILE_EXPIRE_BATCH_SIZE     int64         = 100
+)
+
+// FileExpireSweeper removes expired user files, only one instance sweeps at a time
+type FileExpireSweeper struct {
+	fileRepo    repo.FileRepo
+	fileServ    FileService
+	redisClient *redis.Client
+	interval    time.Duration
+}
+
+func NewFileExpireSweeper(fileRepo repo.FileRepo, fileServ FileService, rClient *redis.Client) *FileExpireSweeper {
+	interval := viper.GetDuration("upload.expire-sweep-interval")
+	if interval <= 0 {
+		interval = FILE_EXPIRE_SWEEP_INTERVAL
+	}
+	log.Infow("Read file expire sweep interval: " + interval.String())
+	return &FileExpireSweeper{fileRepo: fileRepo, fileServ: fileServ, redisClient: rClient, interval: interval}
+}
+
+// Run sweeps until ctx is done
+func (s *FileExpireSweeper) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.Sweep(ctx)
+		}
+	}
+}
+
+func (s *FileExpireSweeper) Sweep(ctx context.Context) {
+	// the lock is extended between batches, an instance crashed while holding it blocks others for one ttl only
+	lockTTL := 5 * s.interval
+	token, ok, err := dbredis.TryLock(ctx, s.redisClient, FILE_EXPIRE_LOCK, lockTTL)
+	if err != nil {
+		log.Warnw("file expire sweeper lock failed", "err", err)
+		return
+	}
+	if !ok {
+		log.Debugw("file expire sweeper is running on another instance")
+		return
+	}
+	defer func() {
+		if err := dbredis.Unlock(context.Background(), s.redisClient, FILE_EXPIRE_LOCK, token); err != nil {
+			log.Warnw("file expire sweeper unlock failed", "err", err)
+		}
+	}()
+
+	// a sweep goes through the expired files once, the ones failing to be removed are retried next round
+	now := time.Now()
+	lastId := ""
+	for ctx.Err() == nil {
+		list, err := s.fileRepo.FindExpiredUserFile(ctx, now, lastId, FILE_EXPIRE_BATCH_SIZE)
+		if err != nil {
+			log.Warnw("find expired file failed", "err", err)
+			return
+		}
+		for _, userFile := range list {
+			lastId = userFile.Id
+			// the normal delete path takes care of the meta refcount
+			if err := s.fileServ.DeleteFile(ctx, userFile.Id, userFile.UserId); err != nil {
+				log.Warnw("remove expired file failed", "userFileId", userFile.Id, "err", err)
+				continue
+			}
+			log.Infow("removed expired file", "userFileId", userFile.Id, "expireAt", userFile.ExpireAt)
+		}
+		if int64(len(list)) < FILE_EXPIRE_BATCH_SIZE {
+			return
+		}
+		// another instance may sweep once the lock expired, two sweepers would delete the same files
+		ok, err := dbredis.ExtendLock(ctx, s.redisClient, FILE_EXPIRE_LOCK, token, lockTTL)
+		if err != nil || !ok {
+			log.Warnw("file expire sweeper lock lost, stop sweeping", "err", err)
+			return
+		}
+	}
+}
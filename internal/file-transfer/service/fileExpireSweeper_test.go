@@ -0,0 +1,131 @@
+package service
+
+import (
+	"context"
+	"file-transfer/internal/file-transfer/repo/repotest"
+	v1 "file-transfer/pkg/api/v1"
+	"file-transfer/pkg/db/dbredis"
+	"file-transfer/pkg/errno"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+// lockLosingFileService lets the sweeper lock expire after the first delete
+type lockLosingFileService struct {
+	FileService
+	mr      *miniredis.Miniredis
+	deleted int
+}
+
+func (f *lockLosingFileService) DeleteFile(ctx context.Context, userFileId string, userId string) error {
+	f.deleted++
+	f.mr.Del(dbredis.REDIS_LOCK_PREFIX + FILE_EXPIRE_LOCK)
+	return f.FileService.DeleteFile(ctx, userFileId, userId)
+}
+
+// failingFileService can't remove some files, like ones whose blob is gone
+type failingFileService struct {
+	FileService
+	fail map[string]bool
+}
+
+func (f *failingFileService) DeleteFile(ctx context.Context, userFileId string, userId string) error {
+	if f.fail[userFileId] {
+		return errno.InternalServerError
+	}
+	return f.FileService.DeleteFile(ctx, userFileId, userId)
+}
+
+func TestFileExpireSweeper(t *testing.T) {
+	SAVE_FILE_PATH = t.TempDir()
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	rClient := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	fileRepo := repotest.NewMemFileRepo()
+	fileServ := &fileService{fileRepo: fileRepo}
+	upload := func(name string, expire int64) string {
+		id, err := fileServ.UploadFile(ctx, strings.NewReader(name), &v1.FileUploadParam{UserId: "u1", Name: name, Expire: expire})
+		assert.Nil(t, err)
+		return id
+	}
+	expired := []string{upload("a.txt", 1), upload("b.txt", 1), upload("c.txt", 1)}
+	past := time.Now().Add(-time.Hour)
+	for _, id := range expired {
+		file := fileRepo.Files[id]
+		file.ExpireAt = &past
+		fileRepo.Files[id] = file
+	}
+	live := upload("d.txt", 60)
+	kept := upload("e.txt", 0)
+	sweeper := &FileExpireSweeper{fileRepo: fileRepo, fileServ: fileServ, redisClient: rClient, interval: time.Minute}
+
+	// another instance holds the lock
+	token, ok, err := dbredis.TryLock(ctx, rClient, FILE_EXPIRE_LOCK, time.Minute)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	sweeper.Sweep(ctx)
+	assert.Equal(t, 5, len(fileRepo.Files))
+	assert.Nil(t, dbredis.Unlock(ctx, rClient, FILE_EXPIRE_LOCK, token))
+
+	// the lock is lost after the first batch, the next one is left to the new holder
+	batchSize := FILE_EXPIRE_BATCH_SIZE
+	FILE_EXPIRE_BATCH_SIZE = 1
+	defer func() { FILE_EXPIRE_BATCH_SIZE = batchSize }()
+	losing := &lockLosingFileService{FileService: fileServ, mr: mr}
+	sweeper.fileServ = losing
+	sweeper.Sweep(ctx)
+	assert.Equal(t, 1, losing.deleted)
+	assert.Equal(t, 4, len(fileRepo.Files))
+
+	// holding the lock, batch after batch
+	sweeper.fileServ = fileServ
+	sweeper.Sweep(ctx)
+	for _, id := range expired {
+		assert.NotContains(t, fileRepo.Files, id)
+	}
+	assert.Contains(t, fileRepo.Files, live)
+	assert.Contains(t, fileRepo.Files, kept)
+	assert.False(t, mr.Exists(dbredis.REDIS_LOCK_PREFIX+FILE_EXPIRE_LOCK), "the lock is released")
+}
+
+func TestFileExpireSweeperFailedBatch(t *testing.T) {
+	SAVE_FILE_PATH = t.TempDir()
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	rClient := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	fileRepo := repotest.NewMemFileRepo()
+	fileServ := &fileService{fileRepo: fileRepo}
+	past := time.Now().Add(-time.Hour)
+	ids := make([]string, 0)
+	for _, name := range []string{"a.txt", "b.txt", "c.txt", "d.txt", "e.txt"} {
+		id, err := fileServ.UploadFile(ctx, strings.NewReader(name), &v1.FileUploadParam{UserId: "u1", Name: name, Expire: 1})
+		assert.Nil(t, err)
+		file := fileRepo.Files[id]
+		file.ExpireAt = &past
+		fileRepo.Files[id] = file
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	batchSize := FILE_EXPIRE_BATCH_SIZE
+	FILE_EXPIRE_BATCH_SIZE = 2
+	defer func() { FILE_EXPIRE_BATCH_SIZE = batchSize }()
+	// the whole first batch fails, the files after it are removed anyway
+	failing := &failingFileService{FileService: fileServ, fail: map[string]bool{ids[0]: true, ids[1]: true}}
+	sweeper := &FileExpireSweeper{fileRepo: fileRepo, fileServ: failing, redisClient: rClient, interval: time.Minute}
+	sweeper.Sweep(ctx)
+	assert.Equal(t, 2, len(fileRepo.Files))
+	assert.Contains(t, fileRepo.Files, ids[0])
+	assert.Contains(t, fileRepo.Files, ids[1])
+
+	// and the failed ones are tried again next round
+	sweeper.fileServ = fileServ
+	sweeper.Sweep(ctx)
+	assert.Empty(t, fileRepo.Files)
+}
@@ -0,0 +1,266 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"file-transfer/internal/file-transfer/repo"
+	v1 "file-transfer/pkg/api/v1"
+	"file-transfer/pkg/common"
+	"file-transfer/pkg/errno"
+	"file-transfer/pkg/log"
+	"file-transfer/pkg/model"
+	"file-transfer/pkg/util"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	errGrantInvalid  = &errno.Errno{HTTP: http.StatusBadRequest, Code: "InvalidParameter.Grant", Message: "a grant needs another user, a file or a folder, and read or write access"}
+	errGrantUser     = &errno.Errno{HTTP: http.StatusNotFound, Code: "ResourceNotFound.User", Message: "user not found"}
+	errGrantReadOnly = &errno.Errno{HTTP: http.StatusForbidden, Code: "Forbidden.Grant", Message: "uploads need write access to a shared folder"}
+)
+
+// FileGrantService shares files and folders with other users of the instance. The recipients read
+// the files of the owner through the grant, the blobs aren't copied
+type FileGrantService interface {
+	Grant(ctx context.Context, ownerId string, param *v1.FileGrantParam) (*v1.FileGrantInfo, error)
+	ListGrants(ctx context.Context, ownerId string) ([]v1.FileGrantInfo, error)
+	RevokeGrant(ctx context.Context, ownerId string, grantId string) error
+	// SharedWithMe lists what other users shared with the user
+	SharedWithMe(ctx context.Context, userId string) ([]v1.FileGrantInfo, error)
+	// SharedFiles lists the files of a grant, the shared file or the files under the shared folder
+	SharedFiles(ctx context.Context, userId string, grantId string) ([]v1.FileResponse, error)
+	// UploadShared stores files into a folder shared with write access, they belong to its owner.
+	// The recipient may delete the files they uploaded, not the others
+	UploadShared(ctx context.Context, userId string, grantId string, folder string, reader *multipart.Reader) ([]v1.FileUploadResult, error)
+}
+
+type fileGrantService struct {
+	fileRepo repo.FileRepo
+	userRepo repo.UserRepo
+	fileServ FileService
+}
+
+var _ FileGrantService = (*fileGrantService)(nil)
+
+func NewFileGrantService(fileRepo repo.FileRepo, userRepo repo.UserRepo, fileServ FileService) FileGrantService {
+	return &fileGrantService{fileRepo: fileRepo, userRepo: userRepo, fileServ: fileServ}
+}
+
+func (s *fileGrantService) Grant(ctx context.Context, ownerId string, param *v1.FileGrantParam) (*v1.FileGrantInfo, error) {
+	if len(param.Username) < 1 || (len(param.FileId) > 0) == (len(param.Folder) > 0) ||
+		(param.Access != common.GRANT_ACCESS_READ && param.Access != common.GRANT_ACCESS_WRITE) {
+		return nil, errGrantInvalid
+	}
+	grantee, err := s.userRepo.FindByUsername(ctx, param.Username)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, errGrantUser
+	}
+	if err != nil {
+		log.C(ctx).Warnw("grant find user failed", "username", param.Username, "err", err)
+		return nil, errno.InternalServerError
+	}
+	if grantee.Id == ownerId {
+		return nil, errGrantInvalid
+	}
+
+	grant := &model.FileGrant{OwnerId: ownerId, GranteeId: grantee.Id, Access: param.Access, CreatedAt: time.Now()}
+	var file *model.UserFile
+	if len(param.FileId) > 0 {
+		file, err = s.fileRepo.QueryUserFileById(ctx, param.FileId)
+		if err != nil || file.UserId != ownerId {
+			return nil, errno.ErrPageNotFound
+		}
+		grant.FileId = file.Id
+	} else {
+		// the whole drive isn't shared this way
+		folder, err := util.NormalizeFolder(param.Folder)
+		if err != nil || len(folder) < 1 {
+			return nil, errGrantInvalid
+		}
+		if _, err := s.fileRepo.FindUserFolder(ctx, ownerId, folder); err != nil {
+			files, err := s.fileRepo.QueryUserFileUnder(ctx, ownerId, folder)
+			if err != nil || len(files) == 0 {
+				return nil, errno.ErrPageNotFound
+			}
+		}
+		grant.Folder = folder
+	}
+	grant, err = s.fileRepo.SaveFileGrant(ctx, grant)
+	if err != nil {
+		log.C(ctx).Errorw("save file grant failed", "err", err)
+		return nil, errno.InternalServerError
+	}
+	log.C(ctx).Infow("file granted", "grantId", grant.Id, "granteeId", grant.GranteeId, "fileId", grant.FileId, "folder", grant.Folder, "access", grant.Access)
+	info := &v1.FileGrantInfo{Id: grant.Id, Grantee: grantee.Username, Access: grant.Access, Folder: grant.Folder, CreatedAt: grant.CreatedAt}
+	if file != nil {
+		files, err := fileResponses(ctx, s.fileRepo, []model.UserFile{*file})
+		if err != nil {
+			return nil, err
+		}
+		info.File = &files[0]
+	}
+	return info, nil
+}
+
+func (s *fileGrantService) ListGrants(ctx context.Context, ownerId string) ([]v1.FileGrantInfo, error) {
+	grants, err := s.fileRepo.QueryFileGrantByOwner(ctx, ownerId)
+	if err != nil {
+		log.C(ctx).Errorw("query file grants failed", "ownerId", ownerId, "err", err)
+		return nil, errno.InternalServerError
+	}
+	return s.grantInfos(ctx, grants, false)
+}
+
+func (s *fileGrantService) SharedWithMe(ctx context.Context, userId string) ([]v1.FileGrantInfo, error) {
+	grants, err := s.fileRepo.QueryFileGrantByGrantee(ctx, userId)
+	if err != nil {
+		log.C(ctx).Errorw("query file grants failed", "granteeId", userId, "err", err)
+		return nil, errno.InternalServerError
+	}
+	return s.grantInfos(ctx, grants, true)
+}
+
+// grantInfos names the other user of each grant, the owner for the recipient and the recipient for
+// the owner, and describes the shared files. A file grant whose file is gone is left without file
+func (s *fileGrantService) grantInfos(ctx context.Context, grants []model.FileGrant, received bool) ([]v1.FileGrantInfo, error) {
+	userOf := func(g *model.FileGrant) string {
+		if received {
+			return g.OwnerId
+		}
+		return g.GranteeId
+	}
+	usernames := make(map[string]string)
+	files := make([]model.UserFile, 0)
+	for _, g := range grants {
+		id := userOf(&g)
+		if _, ok := usernames[id]; !ok {
+			usernames[id] = ""
+			if user, err := s.userRepo.FindById(ctx, id); err == nil {
+				usernames[id] = user.Username
+			}
+		}
+		if len(g.FileId) > 0 {
+			if file, err := s.fileRepo.QueryUserFileById(ctx, g.FileId); err == nil {
+				files = append(files, *file)
+			}
+		}
+	}
+	responses, err := fileResponses(ctx, s.fileRepo, files)
+	if err != nil {
+		return nil, err
+	}
+	fileMap := make(map[string]*v1.FileResponse, len(responses))
+	for i := range responses {
+		fileMap[responses[i].Id] = &responses[i]
+	}
+
+	results := make([]v1.FileGrantInfo, len(grants))
+	for i, g := range grants {
+		results[i] = v1.FileGrantInfo{Id: g.Id, Access: g.Access, Folder: g.Folder, CreatedAt: g.CreatedAt}
+		if len(g.FileId) > 0 {
+			results[i].File = fileMap[g.FileId]
+		}
+		if received {
+			results[i].Owner = usernames[g.OwnerId]
+		} else {
+			results[i].Grantee = usernames[g.GranteeId]
+		}
+	}
+	return results, nil
+}
+
+// RevokeGrant ends the access at once, the recipient's next request is refused
+func (s *fileGrantService) RevokeGrant(ctx context.Context, ownerId string, grantId string) error {
+	grant, err := s.fileRepo.FindFileGrant(ctx, grantId)
+	if err != nil || grant.OwnerId != ownerId {
+		return errno.ErrPageNotFound
+	}
+	if err := s.fileRepo.DeleteFileGrant(ctx, grantId); err != nil {
+		log.C(ctx).Errorw("delete file grant failed", "grantId", grantId, "err", err)
+		return errno.InternalServerError
+	}
+	log.C(ctx).Infow("file grant revoked", "grantId", grantId, "granteeId", grant.GranteeId)
+	return nil
+}
+
+func (s *fileGrantService) SharedFiles(ctx context.Context, userId string, grantId string) ([]v1.FileResponse, error) {
+	grant, err := s.receivedGrant(ctx, userId, grantId)
+	if err != nil {
+		return nil, err
+	}
+	var files []model.UserFile
+	if len(grant.FileId) > 0 {
+		file, err := s.fileRepo.QueryUserFileById(ctx, grant.FileId)
+		if err != nil {
+			return nil, errno.ErrPageNotFound
+		}
+		files = []model.UserFile{*file}
+	} else {
+		files, err = s.fileRepo.QueryUserFileUnder(ctx, grant.OwnerId, grant.Folder)
+		if err != nil {
+			log.C(ctx).Errorw("query shared folder failed", "grantId", grantId, "err", err)
+			return nil, errno.InternalServerError
+		}
+	}
+	return fileResponses(ctx, s.fileRepo, files)
+}
+
+func (s *fileGrantService) UploadShared(ctx context.Context, userId string, grantId string, folder string, reader *multipart.Reader) ([]v1.FileUploadResult, error) {
+	grant, err := s.receivedGrant(ctx, userId, grantId)
+	if err != nil {
+		return nil, err
+	}
+	if len(grant.Folder) < 1 || grant.Access != common.GRANT_ACCESS_WRITE {
+		return nil, errGrantReadOnly
+	}
+	folder, err = util.NormalizeFolder(grant.Folder + "/" + folder)
+	if err != nil {
+		return nil, errno.ErrInvalidParameter
+	}
+	log.C(ctx).Infow("upload into shared folder", "grantId", grantId, "ownerId", grant.OwnerId, "folder", folder)
+	// the progress of the upload is published to the owner, so the recipient gets none
+	return s.fileServ.UploadFiles(ctx, reader, &v1.FileUploadParam{UserId: grant.OwnerId, UploaderId: userId, Folder: folder})
+}
+
+func (s *fileGrantService) receivedGrant(ctx context.Context, userId string, grantId string) (*model.FileGrant, error) {
+	grant, err := s.fileRepo.FindFileGrant(ctx, grantId)
+	if err != nil || grant.GranteeId != userId {
+		return nil, errno.ErrPageNotFound
+	}
+	return grant, nil
+}
+
+// allowsAccess tells whether the user may use the file with the access. The owner may do anything,
+// another user needs a grant of the file or of a folder it is under. Write access includes read
+func allowsAccess(ctx context.Context, fileRepo repo.FileRepo, userFile *model.UserFile, userId string, access common.GrantAccess) bool {
+	if userFile.UserId == userId {
+		return true
+	}
+	if len(userId) < 1 {
+		return false
+	}
+	grants, err := fileRepo.QueryFileGrantByGrantee(ctx, userId)
+	if err != nil {
+		log.C(ctx).Warnw("query file grants failed", "granteeId", userId, "err", err)
+		return false
+	}
+	for _, g := range grants {
+		if g.OwnerId != userFile.UserId || (access == common.GRANT_ACCESS_WRITE && g.Access != common.GRANT_ACCESS_WRITE) {
+			continue
+		}
+		if g.FileId == userFile.Id || (len(g.Folder) > 0 && inFolder(userFile.Folder, g.Folder)) {
+			return true
+		}
+	}
+	return false
+}
+
+// inFolder is true for the folder p and the folders under it
+func inFolder(folder string, p string) bool {
+	return folder == p || strings.HasPrefix(folder, p+"/")
+}
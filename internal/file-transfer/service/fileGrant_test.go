@@ -0,0 +1,138 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"file-transfer/internal/file-transfer/repo"
+	"file-transfer/internal/file-transfer/repo/repotest"
+	v1 "file-transfer/pkg/api/v1"
+	"file-transfer/pkg/common"
+	"file-transfer/pkg/errno"
+	"file-transfer/pkg/model"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type memNameUserRepo struct {
+	repo.UserRepo
+	names map[string]string
+}
+
+func (m *memNameUserRepo) FindById(ctx context.Context, id string) (*model.UserInfo, error) {
+	if name, ok := m.names[id]; ok {
+		return &model.UserInfo{Id: id, Username: name}, nil
+	}
+	return nil, mongo.ErrNoDocuments
+}
+
+func (m *memNameUserRepo) FindByUsername(ctx context.Context, username string) (*model.UserInfo, error) {
+	for id, name := range m.names {
+		if name == username {
+			return &model.UserInfo{Id: id, Username: name}, nil
+		}
+	}
+	return nil, mongo.ErrNoDocuments
+}
+
+func TestFileGrant(t *testing.T) {
+	SAVE_FILE_PATH = t.TempDir()
+	ctx := context.Background()
+	fileRepo := repotest.NewMemFileRepo()
+	fileServ := &fileService{fileRepo: fileRepo}
+	report, err := fileServ.UploadFile(ctx, bytes.NewReader([]byte("report")), &v1.FileUploadParam{UserId: "u1", Name: "r.txt", Folder: "work/q1"})
+	assert.Nil(t, err)
+	private, err := fileServ.UploadFile(ctx, bytes.NewReader([]byte("private")), &v1.FileUploadParam{UserId: "u1", Name: "p.txt", Folder: "workshop"})
+	assert.Nil(t, err)
+	s := NewFileGrantService(fileRepo, &memNameUserRepo{names: map[string]string{"u1": "alice", "u2": "bob"}}, fileServ)
+
+	_, err = fileServ.DownloadFile(ctx, report, "u2")
+	assert.Equal(t, errno.ErrPageNotFound, err)
+	_, err = s.Grant(ctx, "u1", &v1.FileGrantParam{Username: "alice", Folder: "work", Access: common.GRANT_ACCESS_READ})
+	assert.Equal(t, errGrantInvalid, err, "not with oneself")
+	_, err = s.Grant(ctx, "u1", &v1.FileGrantParam{Username: "carol", Folder: "work", Access: common.GRANT_ACCESS_READ})
+	assert.Equal(t, errGrantUser, err)
+	_, err = s.Grant(ctx, "u2", &v1.FileGrantParam{Username: "alice", FileId: report, Access: common.GRANT_ACCESS_READ})
+	assert.Equal(t, errno.ErrPageNotFound, err, "only own files")
+
+	grant, err := s.Grant(ctx, "u1", &v1.FileGrantParam{Username: "bob", Folder: "/work/", Access: common.GRANT_ACCESS_READ})
+	assert.Nil(t, err)
+	assert.Equal(t, "work", grant.Folder)
+	assert.Equal(t, "bob", grant.Grantee)
+
+	shared, err := s.SharedWithMe(ctx, "u2")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(shared))
+	assert.Equal(t, "alice", shared[0].Owner)
+	files, err := s.SharedFiles(ctx, "u2", grant.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(files))
+	assert.Equal(t, report, files[0].Id)
+	_, err = s.SharedFiles(ctx, "u1", grant.Id)
+	assert.Equal(t, errno.ErrPageNotFound, err, "the grant is listed by its recipient")
+
+	// the blob of the owner is read, under the folder only
+	data, err := fileServ.DownloadFile(ctx, report, "u2")
+	assert.Nil(t, err)
+	assert.Equal(t, "r.txt", data.Name)
+	_, err = fileServ.DownloadFile(ctx, private, "u2")
+	assert.Equal(t, errno.ErrPageNotFound, err)
+	assert.Equal(t, errno.ErrPageNotFound, fileServ.DeleteFile(ctx, report, "u2"), "read access can't delete")
+
+	// granting again changes the access
+	again, err := s.Grant(ctx, "u1", &v1.FileGrantParam{Username: "bob", Folder: "work", Access: common.GRANT_ACCESS_WRITE})
+	assert.Nil(t, err)
+	assert.Equal(t, grant.Id, again.Id)
+
+	assert.Equal(t, errno.ErrPageNotFound, s.RevokeGrant(ctx, "u2", grant.Id))
+	assert.Nil(t, s.RevokeGrant(ctx, "u1", grant.Id))
+	_, err = fileServ.DownloadFile(ctx, report, "u2")
+	assert.Equal(t, errno.ErrPageNotFound, err)
+	shared, err = s.SharedWithMe(ctx, "u2")
+	assert.Nil(t, err)
+	assert.Empty(t, shared)
+}
+
+func TestFileGrantWrite(t *testing.T) {
+	SAVE_FILE_PATH = t.TempDir()
+	ctx := context.Background()
+	fileRepo := repotest.NewMemFileRepo()
+	fileServ := &fileService{fileRepo: fileRepo}
+	fileId, err := fileServ.UploadFile(ctx, bytes.NewReader([]byte("draft")), &v1.FileUploadParam{UserId: "u1", Name: "d.txt"})
+	assert.Nil(t, err)
+	s := NewFileGrantService(fileRepo, &memNameUserRepo{names: map[string]string{"u1": "alice", "u2": "bob"}}, fileServ)
+
+	grant, err := s.Grant(ctx, "u1", &v1.FileGrantParam{Username: "bob", FileId: fileId, Access: common.GRANT_ACCESS_WRITE})
+	assert.Nil(t, err)
+	assert.Equal(t, "d.txt", grant.File.Name)
+	_, err = s.UploadShared(ctx, "u2", grant.Id, "", nil)
+	assert.Equal(t, errGrantReadOnly, err, "uploads go into shared folders")
+
+	assert.Equal(t, errno.ErrPageNotFound, fileServ.DeleteFile(ctx, fileId, "u2"), "the files of the owner stay")
+
+	// the recipient deletes what they uploaded into a shared folder
+	_, err = fileServ.UploadFile(ctx, bytes.NewReader([]byte("notes")), &v1.FileUploadParam{UserId: "u1", Name: "n.txt", Folder: "team"})
+	assert.Nil(t, err)
+	folderGrant, err := s.Grant(ctx, "u1", &v1.FileGrantParam{Username: "bob", Folder: "team", Access: common.GRANT_ACCESS_WRITE})
+	assert.Nil(t, err)
+	results, err := s.UploadShared(ctx, "u2", folderGrant.Id, "", multipartFiles(t, map[string]string{"b.txt": "bob's"}))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(results))
+	uploaded, err := fileRepo.QueryUserFileById(ctx, results[0].Id)
+	assert.Nil(t, err)
+	assert.Equal(t, "u1", uploaded.UserId)
+	assert.Equal(t, "u2", uploaded.UploaderId)
+	assert.Nil(t, fileServ.DeleteFile(ctx, uploaded.Id, "u2"))
+
+	// without the grant not even those
+	results, err = s.UploadShared(ctx, "u2", folderGrant.Id, "", multipartFiles(t, map[string]string{"c.txt": "bob's"}))
+	assert.Nil(t, err)
+	assert.Nil(t, s.RevokeGrant(ctx, "u1", folderGrant.Id))
+	assert.Equal(t, errno.ErrPageNotFound, fileServ.DeleteFile(ctx, results[0].Id, "u2"))
+
+	assert.Nil(t, fileServ.DeleteFile(ctx, fileId, "u1"))
+	_, err = fileRepo.QueryUserFileById(ctx, fileId)
+	assert.NotNil(t, err)
+	assert.Empty(t, fileRepo.Grants, "the grants of a removed file go with it")
+}
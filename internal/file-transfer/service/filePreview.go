@@ -0,0 +1,113 @@
+package service
+
+import (
+	"context"
+	v1 "file-transfer/pkg/api/v1"
+	"file-transfer/pkg/common"
+	"file-transfer/pkg/errno"
+	"file-transfer/pkg/log"
+	"file-transfer/pkg/model"
+	"file-transfer/pkg/preview"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+var (
+	// bytes of a preview page without the "size" parameter
+	PREVIEW_DEFAULT_SIZE int64 = 64 * 1024
+	// bytes of a preview page at most
+	PREVIEW_MAX_SIZE int64 = 1024 * 1024
+)
+
+var (
+	errPreviewNotText = &errno.Errno{HTTP: http.StatusUnsupportedMediaType, Code: "UnsupportedMediaType.NotText", Message: "file is not text"}
+	errPreviewCharset = &errno.Errno{HTTP: http.StatusBadRequest, Code: "InvalidParameter.Charset", Message: "unknown charset"}
+	errPreviewOffset  = &errno.Errno{HTTP: http.StatusBadRequest, Code: "InvalidParameter.Offset", Message: "offset out of range"}
+	errPreviewShare   = &errno.Errno{HTTP: http.StatusForbidden, Code: "Forbidden.SharePreview", Message: "a share previews the first page only, download the file to read on"}
+)
+
+func (f *fileService) PreviewFile(ctx context.Context, userFileId string, userId string, param *v1.FilePreviewParam) (*v1.FilePreviewResponse, error) {
+	userFile, err := f.fileRepo.QueryUserFileById(ctx, userFileId)
+	if err != nil {
+		return nil, errno.ErrPageNotFound
+	}
+	if !allowsAccess(ctx, f.fileRepo, userFile, userId, common.GRANT_ACCESS_READ) {
+		return nil, errno.ErrPageNotFound
+	}
+	return f.previewUserFile(ctx, userFile, param)
+}
+
+// PreviewShare doesn't count as an access of the share link, the download still can be made.
+// So it shows the first page only, paging through the file would read it without using up the link
+func (f *fileService) PreviewShare(ctx context.Context, key string, password string, param *v1.FilePreviewParam) (*v1.FilePreviewResponse, error) {
+	if param.Tail || param.Offset != 0 {
+		return nil, errPreviewShare
+	}
+	page := *param
+	// no larger than the default page
+	if page.Size*1024 > PREVIEW_DEFAULT_SIZE {
+		page.Size = 0
+	}
+	userFileId, err := f.shareServ.PeekShareUrl(ctx, common.SHARE_TYPE_FILE, key, FILE_SHARE_LINK_EXPIRE, password)
+	if err != nil {
+		return nil, shareAccessError(err)
+	}
+	userFile, err := f.fileRepo.QueryUserFileById(ctx, userFileId)
+	if err != nil {
+		return nil, errno.ErrPageNotFound
+	}
+	return f.previewUserFile(ctx, userFile, &page)
+}
+
+func (f *fileService) previewUserFile(ctx context.Context, userFile *model.UserFile, param *v1.FilePreviewParam) (*v1.FilePreviewResponse, error) {
+	results, err := f.fileRepo.FindByMetaId(ctx, []string{userFile.MetaId})
+	if err != nil || len(results) != 1 {
+		return nil, errno.ErrPageNotFound
+	}
+	if _, err := checkBlob(ctx, &results[0]); err != nil {
+		return nil, err
+	}
+	file, err := os.Open(filepath.Join(SAVE_FILE_PATH, results[0].Location))
+	if err != nil {
+		log.C(ctx).Errorw("preview open file failed", "location", results[0].Location, "error", err)
+		return nil, errno.InternalServerError
+	}
+	defer file.Close()
+
+	limit := PREVIEW_DEFAULT_SIZE
+	if param.Size > 0 {
+		limit = min(param.Size*1024, PREVIEW_MAX_SIZE)
+	}
+	page, err := preview.Read(file, results[0].Size, preview.Options{
+		Name:      userFile.Name,
+		Limit:     limit,
+		Tail:      param.Tail,
+		Offset:    param.Offset,
+		Charset:   param.Charset,
+		Highlight: param.Highlight,
+	})
+	switch err {
+	case nil:
+	case preview.ErrNotText:
+		return nil, errPreviewNotText
+	case preview.ErrUnknownCharset:
+		return nil, errPreviewCharset
+	case preview.ErrOutOfRange:
+		return nil, errPreviewOffset
+	default:
+		log.C(ctx).Errorw("preview read file failed", "location", results[0].Location, "error", err)
+		return nil, errno.InternalServerError
+	}
+	return &v1.FilePreviewResponse{
+		Name:     userFile.Name,
+		Charset:  page.Charset,
+		Language: page.Language,
+		Offset:   page.Offset,
+		End:      page.End,
+		Size:     page.Size,
+		HasMore:  page.HasMore,
+		Content:  page.Text,
+		Html:     page.Html,
+	}, nil
+}
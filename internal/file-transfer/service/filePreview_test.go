@@ -0,0 +1,50 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"file-transfer/internal/file-transfer/repo/repotest"
+	v1 "file-transfer/pkg/api/v1"
+	"file-transfer/pkg/common"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPreviewShareFirstPage(t *testing.T) {
+	SAVE_FILE_PATH = t.TempDir()
+	ctx := context.Background()
+	defaultSize := PREVIEW_DEFAULT_SIZE
+	PREVIEW_DEFAULT_SIZE = 1024
+	defer func() { PREVIEW_DEFAULT_SIZE = defaultSize }()
+	fileServ := &fileService{fileRepo: repotest.NewMemFileRepo(), shareServ: newTestShareService(t)}
+	content := strings.Repeat("line of text\n", 1000)
+	fileId, err := fileServ.UploadFile(ctx, bytes.NewReader([]byte(content)), &v1.FileUploadParam{UserId: "u1", Name: "a.log"})
+	assert.Nil(t, err)
+	link, err := fileServ.Share(ctx, fileId, "u1", &v1.MessageShareParam{ExpireType: common.SHARE_EXPIRE_TYPE_TIMES, Expire: 1})
+	assert.Nil(t, err)
+	key := path.Base(link.Path)
+
+	page, err := fileServ.PreviewShare(ctx, key, "", &v1.FilePreviewParam{Size: 512})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), page.Offset)
+	assert.True(t, page.HasMore)
+	assert.LessOrEqual(t, page.End, PREVIEW_DEFAULT_SIZE, "no larger than the default page")
+
+	_, err = fileServ.PreviewShare(ctx, key, "", &v1.FilePreviewParam{Offset: page.End})
+	assert.Equal(t, errPreviewShare, err)
+	_, err = fileServ.PreviewShare(ctx, key, "", &v1.FilePreviewParam{Tail: true, Offset: -1})
+	assert.Equal(t, errPreviewShare, err)
+
+	// the owner pages through
+	own, err := fileServ.PreviewFile(ctx, fileId, "u1", &v1.FilePreviewParam{Offset: page.End})
+	assert.Nil(t, err)
+	assert.Equal(t, page.End, own.Offset)
+
+	// previews didn't use up the link
+	data, err := fileServ.ReadShare(ctx, key, "", "")
+	assert.Nil(t, err)
+	data.Lease.Release()
+}
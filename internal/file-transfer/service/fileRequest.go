@@ -0,0 +1,246 @@
+package service
+
+import (
+	"context"
+	v1 "file-transfer/pkg/api/v1"
+	"file-transfer/pkg/common"
+	"file-transfer/pkg/errno"
+	"file-transfer/pkg/log"
+	"file-transfer/pkg/model"
+	"file-transfer/pkg/util"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	FILE_REQUEST_MAX_EXTENSIONS = 32
+	FILE_REQUEST_MAX_NOTE       = 1024
+	// names tried with a " (n)" suffix when a visitor sends a name that exists
+	FILE_REQUEST_MAX_RENAME = 100
+)
+
+var (
+	errFileRequestInvalid = &errno.Errno{HTTP: http.StatusBadRequest, Code: "InvalidParameter.FileRequest", Message: "invalid file request"}
+	errFileRequestFull    = &errno.Errno{HTTP: http.StatusForbidden, Code: "Forbidden.FileRequestFull", Message: "the request received all its files"}
+	errFileRequestExt     = &errno.Errno{HTTP: http.StatusBadRequest, Code: "InvalidParameter.Extension", Message: "file type not accepted"}
+)
+
+// FILE_REQUEST_MAX_FILES caps the files one request receives from anyone, and is used when the user sets none
+var FILE_REQUEST_MAX_FILES int64 = 100
+
+// CreateFileRequest makes a link which lets anyone upload into a folder of the user
+func (f *fileService) CreateFileRequest(ctx context.Context, userId string, param *v1.FileRequestParam) (*v1.ShareLink, error) {
+	folder, err := util.NormalizeFolder(param.Folder)
+	if err != nil || param.MaxFiles < 0 || param.MaxFiles > FILE_REQUEST_MAX_FILES || param.MaxFileSize < 0 || param.Expire < 0 ||
+		len(param.Extensions) > FILE_REQUEST_MAX_EXTENSIONS || len(param.Note) > FILE_REQUEST_MAX_NOTE {
+		return nil, errFileRequestInvalid
+	}
+	expire := FILE_SHARE_LINK_EXPIRE
+	if param.Expire > 0 {
+		expire = time.Duration(param.Expire) * time.Minute
+		if expire > FILE_SHARE_LINK_EXPIRE {
+			return nil, errFileRequestInvalid
+		}
+	}
+	maxFiles := param.MaxFiles
+	if maxFiles == 0 {
+		maxFiles = FILE_REQUEST_MAX_FILES
+	}
+	maxFileSize := MAX_SINGLE_FILE_SIZE - 1
+	if param.MaxFileSize > 0 {
+		maxFileSize = min(param.MaxFileSize, maxFileSize)
+	}
+	now := time.Now()
+	request := &model.FileRequest{
+		UserId:      userId,
+		Folder:      folder,
+		Note:        param.Note,
+		MaxFileSize: maxFileSize,
+		MaxFiles:    maxFiles,
+		CreatedAt:   now,
+		ExpireAt:    now.Add(expire + SHARE_ENDED_RETAIN),
+	}
+	for _, ext := range param.Extensions {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if len(ext) == 0 {
+			continue
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		request.Extensions = append(request.Extensions, ext)
+	}
+	id, err := f.fileRepo.InsertFileRequest(ctx, request)
+	if err != nil {
+		log.C(ctx).Errorw("insert file request failed", "err", err)
+		return nil, errno.InternalServerError
+	}
+	return f.shareServ.CreateShareUrl(ctx, common.SHARE_TYPE_FILE_REQUEST, userId, id, expire, param.Password)
+}
+
+// ReadFileRequest tells a visitor what can be uploaded, it isn't logged as an access
+func (f *fileService) ReadFileRequest(ctx context.Context, key string, password string) (*v1.FileRequestInfo, error) {
+	requestId, err := f.shareServ.PeekShareUrl(ctx, common.SHARE_TYPE_FILE_REQUEST, key, FILE_SHARE_LINK_EXPIRE, password)
+	if err != nil {
+		return nil, shareAccessError(err)
+	}
+	request, err := f.fileRepo.FindFileRequest(ctx, requestId)
+	if err != nil {
+		return nil, errno.ErrPageNotFound
+	}
+	return &v1.FileRequestInfo{
+		Note:        request.Note,
+		MaxFileSize: request.MaxFileSize,
+		MaxFiles:    request.MaxFiles,
+		Received:    request.Received,
+		Extensions:  request.Extensions,
+		ExpireAt:    request.ExpireAt.Add(-SHARE_ENDED_RETAIN),
+	}, nil
+}
+
+// UploadFileRequest stores the file parts into the folder of the request as its owner.
+// Visitors can't pick a folder, a name taken already gets a " (n)" suffix. The owner gets a message.
+func (f *fileService) UploadFileRequest(ctx context.Context, key string, password string, reader *multipart.Reader) ([]v1.FileUploadResult, error) {
+	requestId, err := f.shareServ.ConsumeShareUrl(ctx, common.SHARE_TYPE_FILE_REQUEST, key, FILE_SHARE_LINK_EXPIRE, password)
+	if err != nil {
+		return nil, shareAccessError(err)
+	}
+	request, err := f.fileRepo.FindFileRequest(ctx, requestId)
+	if err != nil {
+		return nil, errno.ErrPageNotFound
+	}
+
+	results := make([]v1.FileUploadResult, 0)
+	names := make([]string, 0)
+	var bytes int64
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.C(ctx).Warnw("read multipart failed", "err", err)
+			results = append(results, v1.FileUploadResult{Code: "InvalidParameter.Multipart", Message: "broken multipart stream"})
+			break
+		}
+		if part.FormName() == UPLOAD_FORM_FILE || part.FormName() == UPLOAD_FORM_FILES {
+			counter := &countReader{r: part}
+			result := f.uploadRequestPart(ctx, request, util.RawPartFileName(part), counter)
+			results = append(results, result)
+			if len(result.Id) > 0 {
+				names = append(names, path.Join(result.Folder, result.Name))
+				bytes += counter.n
+			}
+		}
+		part.Close()
+	}
+	if len(results) < 1 {
+		return nil, &errno.Errno{HTTP: http.StatusBadRequest, Code: "InvalidParameter.NoFile", Message: "no file in request"}
+	}
+	if len(names) > 0 {
+		f.shareServ.RecordAccess(ctx, key, common.SHARE_OUTCOME_SERVED, bytes)
+		f.notifyFileRequest(ctx, request, names)
+	}
+	return results, nil
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+type countReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (f *fileService) uploadRequestPart(ctx context.Context, request *model.FileRequest, fileName string, file io.Reader) v1.FileUploadResult {
+	_, name, err := util.SplitUploadPath(fileName)
+	result := v1.FileUploadResult{Name: name, Folder: request.Folder}
+	if err != nil {
+		result.Code, result.Message = "InvalidParameter.Path", "invalid file name"
+		return result
+	}
+	if !acceptExtension(request.Extensions, name) {
+		_, result.Code, result.Message = errno.Decode(errFileRequestExt)
+		return result
+	}
+	reserved, err := f.fileRepo.ReserveFileRequestSlot(ctx, request.Id)
+	if err != nil || !reserved {
+		if err != nil {
+			log.C(ctx).Errorw("reserve file request slot failed", "request", request.Id, "err", err)
+		}
+		_, result.Code, result.Message = errno.Decode(errFileRequestFull)
+		return result
+	}
+	result.Name = f.freeFileName(ctx, request.UserId, request.Folder, name)
+	result.Id, err = f.UploadFile(ctx, file, &v1.FileUploadParam{
+		UserId:  request.UserId,
+		Folder:  request.Folder,
+		Name:    result.Name,
+		MaxSize: request.MaxFileSize,
+	})
+	if err != nil {
+		_, result.Code, result.Message = errno.Decode(err)
+		if err := f.fileRepo.ReleaseFileRequestSlot(ctx, request.Id); err != nil {
+			log.C(ctx).Warnw("release file request slot failed", "request", request.Id, "err", err)
+		}
+	}
+	return result
+}
+
+func acceptExtension(extensions []string, name string) bool {
+	if len(extensions) == 0 {
+		return true
+	}
+	ext := strings.ToLower(path.Ext(name))
+	for _, e := range extensions {
+		if e == ext {
+			return true
+		}
+	}
+	return false
+}
+
+// freeFileName returns name, or "name (n).ext" when the folder has a file called name
+func (f *fileService) freeFileName(ctx context.Context, userId string, folder string, name string) string {
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	candidate := name
+	for i := 1; i <= FILE_REQUEST_MAX_RENAME; i++ {
+		if exist, _ := f.fileRepo.FindOneByNameAndUser(ctx, candidate, folder, userId); exist == nil {
+			return candidate
+		}
+		candidate = fmt.Sprintf("%s (%d)%s", base, i, ext)
+	}
+	// uploadFile refuses it as existing
+	return name
+}
+
+func (f *fileService) notifyFileRequest(ctx context.Context, request *model.FileRequest, names []string) {
+	if f.messageServ == nil {
+		return
+	}
+	info := fmt.Sprintf("%d file(s) received by your file request to /%s:\n%s",
+		len(names), request.Folder, strings.Join(names, "\n"))
+	if err := f.messageServ.SendMessage(ctx, &v1.MessageSendRequest{Info: info}, request.UserId); err != nil {
+		log.C(ctx).Warnw("notify file request owner failed", "request", request.Id, "err", err)
+	}
+}
@@ -0,0 +1,107 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"file-transfer/internal/file-transfer/repo/repotest"
+	v1 "file-transfer/pkg/api/v1"
+	"mime/multipart"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type recordMessageService struct {
+	MessageService
+	sent map[string][]string
+}
+
+func (s *recordMessageService) SendMessage(ctx context.Context, r *v1.MessageSendRequest, userId string) error {
+	s.sent[userId] = append(s.sent[userId], r.Info)
+	return nil
+}
+
+func multipartFiles(t *testing.T, files map[string]string) *multipart.Reader {
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	for name, content := range files {
+		part, err := mw.CreateFormFile(UPLOAD_FORM_FILE, name)
+		assert.Nil(t, err)
+		part.Write([]byte(content))
+	}
+	assert.Nil(t, mw.Close())
+	return multipart.NewReader(body, mw.Boundary())
+}
+
+func TestFileRequest(t *testing.T) {
+	SAVE_FILE_PATH = t.TempDir()
+	ctx := context.Background()
+	fileRepo := repotest.NewMemFileRepo()
+	messages := &recordMessageService{sent: make(map[string][]string)}
+	fileServ := &fileService{fileRepo: fileRepo, shareServ: newTestShareService(t), messageServ: messages}
+	_, err := fileServ.UploadFile(ctx, bytes.NewReader([]byte("mine")), &v1.FileUploadParam{UserId: "u1", Folder: "inbox", Name: "a.pdf"})
+	assert.Nil(t, err)
+
+	link, err := fileServ.CreateFileRequest(ctx, "u1", &v1.FileRequestParam{
+		Folder: "inbox", MaxFiles: 2, MaxFileSize: 8, Extensions: []string{"PDF", ".txt"}, Password: "secret",
+	})
+	assert.Nil(t, err)
+	key := path.Base(link.Path)
+	_, err = fileServ.ReadFileRequest(ctx, key, "")
+	assert.Equal(t, ErrSharePasswordRequired, err)
+	info, err := fileServ.ReadFileRequest(ctx, key, "secret")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{".pdf", ".txt"}, info.Extensions)
+
+	results, err := fileServ.UploadFileRequest(ctx, key, "secret", multipartFiles(t, map[string]string{"a.pdf": "theirs"}))
+	assert.Nil(t, err)
+	// the owner's file is kept
+	assert.Equal(t, "a (1).pdf", results[0].Name)
+	assert.NotEmpty(t, results[0].Id)
+	assert.Len(t, messages.sent["u1"], 1)
+
+	results, err = fileServ.UploadFileRequest(ctx, key, "secret", multipartFiles(t, map[string]string{"b.exe": "x"}))
+	assert.Nil(t, err)
+	assert.Equal(t, "InvalidParameter.Extension", results[0].Code)
+	results, err = fileServ.UploadFileRequest(ctx, key, "secret", multipartFiles(t, map[string]string{"big.txt": "123456789"}))
+	assert.Nil(t, err)
+	assert.Equal(t, "InvalidParameter.FileTooLarge", results[0].Code)
+	// refused files don't count
+	results, err = fileServ.UploadFileRequest(ctx, key, "secret", multipartFiles(t, map[string]string{"c.txt": "c"}))
+	assert.Nil(t, err)
+	assert.Empty(t, results[0].Code)
+	results, err = fileServ.UploadFileRequest(ctx, key, "secret", multipartFiles(t, map[string]string{"d.txt": "d"}))
+	assert.Nil(t, err)
+	assert.Equal(t, "Forbidden.FileRequestFull", results[0].Code)
+	assert.Len(t, messages.sent["u1"], 2)
+
+	info, _ = fileServ.ReadFileRequest(ctx, key, "secret")
+	assert.Equal(t, int64(2), info.Received)
+}
+
+func TestFileRequestMaxFiles(t *testing.T) {
+	SAVE_FILE_PATH = t.TempDir()
+	ctx := context.Background()
+	maxFiles := FILE_REQUEST_MAX_FILES
+	FILE_REQUEST_MAX_FILES = 1
+	defer func() { FILE_REQUEST_MAX_FILES = maxFiles }()
+	fileServ := &fileService{fileRepo: repotest.NewMemFileRepo(), shareServ: newTestShareService(t), messageServ: &recordMessageService{sent: make(map[string][]string)}}
+
+	_, err := fileServ.CreateFileRequest(ctx, "u1", &v1.FileRequestParam{Folder: "inbox", MaxFiles: 2})
+	assert.Equal(t, errFileRequestInvalid, err)
+	// no number set is capped too
+	link, err := fileServ.CreateFileRequest(ctx, "u1", &v1.FileRequestParam{Folder: "inbox"})
+	assert.Nil(t, err)
+	key := path.Base(link.Path)
+	info, err := fileServ.ReadFileRequest(ctx, key, "")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), info.MaxFiles)
+
+	results, err := fileServ.UploadFileRequest(ctx, key, "", multipartFiles(t, map[string]string{"a.txt": "a"}))
+	assert.Nil(t, err)
+	assert.Empty(t, results[0].Code)
+	results, err = fileServ.UploadFileRequest(ctx, key, "", multipartFiles(t, map[string]string{"b.txt": "b"}))
+	assert.Nil(t, err)
+	assert.Equal(t, "Forbidden.FileRequestFull", results[0].Code)
+}
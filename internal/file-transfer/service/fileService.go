@@ -5,16 +5,20 @@ import (
 	"encoding/json"
 	"file-transfer/internal/file-transfer/repo"
 	v1 "file-transfer/pkg/api/v1"
+	"file-transfer/pkg/bandwidth"
 	"file-transfer/pkg/common"
 	"file-transfer/pkg/errno"
+	"file-transfer/pkg/imagemeta"
 	"file-transfer/pkg/log"
 	"file-transfer/pkg/model"
 	"file-transfer/pkg/util"
 	"fmt"
 	"io"
+	"mime"
 	"mime/multipart"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
 	"time"
 
@@ -25,32 +29,70 @@ import (
 const (
 	TEMP_FILE_DIR     string = "/tmp"
 	TEMP_FILE_PATTERN string = "file-transfer-upload-*.tmp"
+
+	UPLOAD_FORM_FILE       string = "file"
+	UPLOAD_FORM_FILES      string = "files"
+	UPLOAD_FORM_PATH       string = "path"
+	MAX_UPLOAD_PATH_LENGTH int64  = 4096
 )
 
 var SAVE_FILE_PATH string
 
+var (
+	// remove image metadata of uploads without the per-upload flag
+	STRIP_METADATA bool
+	// store the original and remove the metadata when it is served by a public share
+	KEEP_ORIGINAL bool
+)
+
+var (
+	errUploadSave  = &errno.Errno{HTTP: http.StatusInternalServerError, Code: "InternalError.Save", Message: "save error"}
+	errBrokenImage = &errno.Errno{HTTP: http.StatusBadRequest, Code: "InvalidParameter.Image", Message: "broken image, metadata can't be removed"}
+)
+
 // limit reader end with EOF, but don't know is it real end or reach the limit
 var MAX_SINGLE_FILE_SIZE int64 = 50*1024*1024 + 1
 
 type FileService interface {
-	UploadFile(ctx context.Context, file multipart.File, header *multipart.FileHeader, userId string) error
+	UploadFile(ctx context.Context, file io.Reader, param *v1.FileUploadParam) (string, error)
+	UploadFiles(ctx context.Context, reader *multipart.Reader, param *v1.FileUploadParam) ([]v1.FileUploadResult, error)
 	QueryUserFile(ctx context.Context, q *v1.UserFileQuery) ([]v1.FileResponse, error)
 	DownloadFile(ctx context.Context, userFileId string, userId string) (*v1.FileDownloadData, error)
-	Share(ctx context.Context, mId string, userId string, expireParam *v1.MessageShareParam) (string, error)
-	ReadShare(ctx context.Context, key string) (*v1.FileDownloadData, error)
+	Share(ctx context.Context, mId string, userId string, expireParam *v1.MessageShareParam) (*v1.ShareLink, error)
+	// session is the download session of an earlier request for the file, its ranges don't use up the link
+	ReadShare(ctx context.Context, key string, password string, session string) (*v1.FileDownloadData, error)
+	// ReadSharePage describes a shared file for its landing page, it doesn't use the link up
+	ReadSharePage(ctx context.Context, key string) (*v1.SharePage, error)
+	// RecordShareRead logs the bytes sent for a ReadShare once the download ended
+	RecordShareRead(ctx context.Context, key string, resumed bool, bytes int64)
+
+	ShareCollection(ctx context.Context, userId string, param *v1.CollectionShareParam) (*v1.ShareLink, error)
+	ReadCollection(ctx context.Context, key string, password string) (*v1.CollectionShareResponse, error)
+	ReadCollectionFile(ctx context.Context, key string, password string, session string, userFileId string) (*v1.FileDownloadData, error)
+	ReadCollectionZip(ctx context.Context, key string, password string, session string) (*v1.CollectionZipData, error)
+	CreateFileRequest(ctx context.Context, userId string, param *v1.FileRequestParam) (*v1.ShareLink, error)
+	ReadFileRequest(ctx context.Context, key string, password string) (*v1.FileRequestInfo, error)
+	UploadFileRequest(ctx context.Context, key string, password string, reader *multipart.Reader) ([]v1.FileUploadResult, error)
+	PreviewFile(ctx context.Context, userFileId string, userId string, param *v1.FilePreviewParam) (*v1.FilePreviewResponse, error)
+	PreviewShare(ctx context.Context, key string, password string, param *v1.FilePreviewParam) (*v1.FilePreviewResponse, error)
 	DeleteFile(ctx context.Context, userFileId string, userId string) error
+	SetFileExpire(ctx context.Context, userFileId string, userId string, param *v1.FileExpireParam) (*time.Time, error)
 
 	CloudinaryUploadFile(ctx context.Context, file multipart.File, header *multipart.FileHeader, req *v1.CloudinaryFileUpReq) (*model.CloudinaryFile, error)
 }
 
 type fileService struct {
-	fileRepo  repo.FileRepo
-	shareServ ShareService
+	fileRepo     repo.FileRepo
+	shareServ    ShareService
+	progressServ ProgressService
+	// tells the owner about uploads through a file request
+	messageServ MessageService
+	throttle    *bandwidth.Throttle
 }
 
 var _ FileService = (*fileService)(nil)
 
-func NewFileService(fileRepo repo.FileRepo, shareServ ShareService) FileService {
+func NewFileService(fileRepo repo.FileRepo, shareServ ShareService, progressServ ProgressService, messageServ MessageService) FileService {
 	workingPath, err := os.Getwd()
 	if err != nil {
 		fmt.Println("Error:", err)
@@ -72,41 +114,159 @@ func NewFileService(fileRepo repo.FileRepo, shareServ ShareService) FileService
 		MAX_SINGLE_FILE_SIZE = maxSize
 		log.Infow(fmt.Sprintf("Read Max file size: (use) %d", MAX_SINGLE_FILE_SIZE))
 	}
-	return &fileService{fileRepo: fileRepo, shareServ: shareServ}
+	if maxFiles := viper.GetInt64("upload.request-max-files"); maxFiles > 0 {
+		FILE_REQUEST_MAX_FILES = maxFiles
+	}
+	STRIP_METADATA = viper.GetBool("upload.strip-metadata")
+	KEEP_ORIGINAL = viper.GetBool("upload.keep-original")
+	log.Infow(fmt.Sprintf("Read strip metadata: %t, keep original: %t", STRIP_METADATA, KEEP_ORIGINAL))
+	if size := viper.GetInt64("preview.max-size"); size > 0 {
+		PREVIEW_MAX_SIZE = size
+	}
+	if size := viper.GetInt64("preview.default-size"); size > 0 {
+		PREVIEW_DEFAULT_SIZE = min(size, PREVIEW_MAX_SIZE)
+	}
+	SERVE_DAMAGED_BLOB = viper.GetBool("scrub.serve-damaged")
+	log.Infow(fmt.Sprintf("Read preview size: %d, max: %d", PREVIEW_DEFAULT_SIZE, PREVIEW_MAX_SIZE))
+	return &fileService{fileRepo: fileRepo, shareServ: shareServ, progressServ: progressServ, messageServ: messageServ, throttle: newDownloadThrottle()}
 }
 
-func (f *fileService) UploadFile(ctx context.Context, file multipart.File, header *multipart.FileHeader, userId string) error {
-	if header.Size >= MAX_SINGLE_FILE_SIZE {
-		msg := fmt.Sprintf("file size exceed %d", MAX_SINGLE_FILE_SIZE)
-		log.C(ctx).Warnw("upload failed, " + msg)
-		return &errno.Errno{HTTP: http.StatusBadRequest, Message: msg}
+func (f *fileService) publishProgress(ctx context.Context, param *v1.FileUploadParam, event v1.UploadProgressEvent) {
+	if f.progressServ == nil || len(param.UploadId) < 1 {
+		return
+	}
+	event.UploadId = param.UploadId
+	f.progressServ.Publish(ctx, param.UserId, &event)
+}
+
+func (f *fileService) UploadFile(ctx context.Context, file io.Reader, param *v1.FileUploadParam) (string, error) {
+	userFileId, err := f.uploadFile(ctx, file, param)
+	if err != nil {
+		_, code, message := errno.Decode(err)
+		f.publishProgress(ctx, param, v1.UploadProgressEvent{Stage: common.UPLOAD_STAGE_ERROR, Name: param.Name, Code: code, Message: message})
+		return "", err
+	}
+	f.publishProgress(ctx, param, v1.UploadProgressEvent{Stage: common.UPLOAD_STAGE_DONE, Name: param.Name, FileId: userFileId})
+	return userFileId, nil
+}
+
+// UploadFiles stores every file part of a multipart stream, a failed file doesn't stop the others
+func (f *fileService) UploadFiles(ctx context.Context, reader *multipart.Reader, param *v1.FileUploadParam) ([]v1.FileUploadResult, error) {
+	results := make([]v1.FileUploadResult, 0)
+	var nextPath string
+	var streamErr error
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.C(ctx).Warnw("read multipart failed", "err", err)
+			streamErr = &errno.Errno{HTTP: http.StatusBadRequest, Code: "InvalidParameter.Multipart", Message: "broken multipart stream"}
+			break
+		}
+		switch part.FormName() {
+		case UPLOAD_FORM_PATH:
+			// a path field describes the relative path of the next file part
+			value, _ := io.ReadAll(io.LimitReader(part, MAX_UPLOAD_PATH_LENGTH))
+			nextPath = string(value)
+		case UPLOAD_FORM_FILE, UPLOAD_FORM_FILES:
+			relPath := nextPath
+			nextPath = ""
+			if len(relPath) < 1 {
+				relPath = util.RawPartFileName(part)
+			}
+			results = append(results, f.uploadPart(ctx, part, relPath, param))
+		}
+		part.Close()
+	}
+
+	if streamErr == nil && len(results) < 1 {
+		streamErr = &errno.Errno{HTTP: http.StatusBadRequest, Code: "InvalidParameter.NoFile", Message: "no file in request"}
+	}
+	complete := v1.UploadProgressEvent{Stage: common.UPLOAD_STAGE_COMPLETE, Count: len(results)}
+	if streamErr != nil {
+		_, complete.Code, complete.Message = errno.Decode(streamErr)
+	}
+	f.publishProgress(ctx, param, complete)
+	if streamErr != nil && len(results) < 1 {
+		return nil, streamErr
+	}
+	if streamErr != nil {
+		_, code, message := errno.Decode(streamErr)
+		results = append(results, v1.FileUploadResult{Code: code, Message: message})
+	}
+	return results, nil
+}
+
+func (f *fileService) uploadPart(ctx context.Context, part io.Reader, relPath string, base *v1.FileUploadParam) v1.FileUploadResult {
+	param := *base
+	folder, name, err := util.SplitUploadPath(relPath)
+	if err == nil {
+		param.Folder, err = util.NormalizeFolder(base.Folder + "/" + folder)
+	}
+	param.Name = name
+	result := v1.FileUploadResult{Name: name, Folder: param.Folder}
+	if err != nil {
+		result.Name = relPath
+		result.Code, result.Message = "InvalidParameter.Path", "invalid file path"
+		return result
+	}
+	result.Id, err = f.UploadFile(ctx, part, &param)
+	if err != nil {
+		_, result.Code, result.Message = errno.Decode(err)
+	}
+	return result
+}
+
+func (f *fileService) uploadFile(ctx context.Context, file io.Reader, param *v1.FileUploadParam) (string, error) {
+	userId := param.UserId
+	if len(param.Name) < 1 {
+		return "", &errno.Errno{HTTP: http.StatusBadRequest, Code: "InvalidParameter.Path", Message: "invalid file name"}
 	}
 
 	// check exist file name
-	exist, _ := f.fileRepo.FindOneByNameAndUser(ctx, header.Filename, userId)
-	if exist != nil {
-		msg := fmt.Sprintf("upload exist: %s", header.Filename)
+	exist, _ := f.fileRepo.FindOneByNameAndUser(ctx, param.Name, param.Folder, userId)
+	if exist != nil && !param.Overwrite {
+		msg := fmt.Sprintf("upload exist: %s", path.Join(param.Folder, param.Name))
 		log.C(ctx).Infow(msg)
-		return &errno.Errno{HTTP: http.StatusBadRequest, Message: msg}
+		return "", &errno.Errno{HTTP: http.StatusBadRequest, Code: "InvalidParameter.FileExist", Message: msg}
 	}
 
 	createTime := time.Now()
 	fileMeta := &model.FileMeta{
 		CreatedAt: createTime,
-		Size:      header.Size,
 	}
 
 	userFile := &model.UserFile{
-		CreatedAt: createTime,
-		Name:      header.Filename,
-		UserId:    userId,
+		CreatedAt:  createTime,
+		Name:       param.Name,
+		Folder:     param.Folder,
+		UserId:     userId,
+		UploaderId: param.UploaderId,
+	}
+	if param.Expire > 0 {
+		expireAt := createTime.Add(time.Duration(param.Expire) * time.Minute)
+		userFile.ExpireAt = &expireAt
+	}
+	if exist != nil {
+		defer func() {
+			if userFile.Id == "" {
+				return
+			}
+			// the replaced file goes through the normal delete
+			if err := f.DeleteFile(ctx, exist.Id, userId); err != nil {
+				log.C(ctx).Warnw("remove overwritten file failed", "userFileId", exist.Id, "err", err)
+			}
+		}()
 	}
 
 	tempFile, err := os.CreateTemp(TEMP_FILE_DIR, TEMP_FILE_PATTERN)
-	log.C(ctx).Debugw("create temp: " + tempFile.Name())
 	if err != nil {
-		return err
+		log.C(ctx).Errorw("create temp failed", "err", err)
+		return "", errUploadSave
 	}
+	log.C(ctx).Debugw("create temp: " + tempFile.Name())
 	defer func() {
 		log.C(ctx).Debugw("close/remove temp: " + tempFile.Name())
 		tempFile.Close()
@@ -114,46 +274,75 @@ func (f *fileService) UploadFile(ctx context.Context, file multipart.File, heade
 	}()
 
 	// Copy file contents to a temporary file while checking the size
-	limitedReader := io.LimitReader(file, MAX_SINGLE_FILE_SIZE)
-	_, err = io.Copy(tempFile, limitedReader)
+	sizeLimit := MAX_SINGLE_FILE_SIZE
+	if param.MaxSize > 0 && param.MaxSize < sizeLimit {
+		sizeLimit = param.MaxSize + 1
+	}
+	limitedReader := io.LimitReader(file, sizeLimit)
+	var reader io.Reader = limitedReader
+	if f.progressServ != nil && len(param.UploadId) > 0 {
+		reader = newProgressReader(ctx, limitedReader, f.progressServ, userId,
+			v1.UploadProgressEvent{UploadId: param.UploadId, Name: param.Name})
+	}
+	_, err = io.Copy(tempFile, reader)
 	if err != nil {
 		// If there was an error while copying, remove the partially written file
 		// works in defer
-		return err
+		log.C(ctx).Warnw("upload copy failed", "err", err)
+		return "", errUploadSave
 	}
 
 	// Check the actual file size
 	fileInfo, _ := tempFile.Stat()
 	fileSize := fileInfo.Size()
-	if fileSize >= MAX_SINGLE_FILE_SIZE {
+	if fileSize >= sizeLimit {
 		// If the file size exceeds the limit, remove the file and return an error
 		// works in defer
-		msg := fmt.Sprintf("file size exceed %d", MAX_SINGLE_FILE_SIZE)
+		msg := fmt.Sprintf("file size exceed %d", sizeLimit-1)
 		log.C(ctx).Warnw("upload failed, " + msg)
-		return &errno.Errno{HTTP: http.StatusBadRequest, Message: msg}
+		return "", &errno.Errno{HTTP: http.StatusBadRequest, Code: "InvalidParameter.FileTooLarge", Message: msg}
+	}
+	if stripMetadata(param) {
+		if KEEP_ORIGINAL {
+			// only images with metadata are cleaned on the way out
+			if userFile.StripOnShare, err = hasStrippableMetadata(ctx, tempFile, fileSize); err != nil {
+				return "", err
+			}
+		} else if stripped, err := stripTempFile(ctx, tempFile); err != nil {
+			return "", err
+		} else if stripped != nil {
+			// the hash and size are of the stored bytes
+			tempFile.Close()
+			os.Remove(tempFile.Name())
+			tempFile = stripped
+			fileInfo, _ = tempFile.Stat()
+			fileSize = fileInfo.Size()
+		}
 	}
+	fileMeta.Size = fileSize
 
+	f.publishProgress(ctx, param, v1.UploadProgressEvent{Stage: common.UPLOAD_STAGE_HASHING, Name: param.Name, Bytes: fileSize})
 	sha, err := util.CalculateFileSHA1(tempFile.Name())
 	if err != nil {
 		log.C(ctx).Errorw("sha1 failed", err)
-		return &errno.Errno{HTTP: http.StatusInternalServerError, Message: "save error"}
+		return "", errUploadSave
 	}
 
+	f.publishProgress(ctx, param, v1.UploadProgressEvent{Stage: common.UPLOAD_STAGE_DEDUP, Name: param.Name, Bytes: fileSize})
 	result, _ := f.fileRepo.FindOneBySha(ctx, sha)
 	if result != nil {
 		msg := fmt.Sprintf("upload file exist: sha %s, path: %s", sha, result.Location)
 		log.C(ctx).Infow(msg)
+		if result.Damaged() {
+			f.repairBlob(ctx, result, tempFile.Name())
+		}
 		// rm tempfile // works in defer
 		// write userfile
 		userFile.MetaId = result.Id
-		_, err = f.fileRepo.InsertUserFile(ctx, userFile)
-		if err != nil {
-			log.C(ctx).Errorw("InsertUserFile failed", "userFile", userFile)
-			return &errno.Errno{HTTP: http.StatusInternalServerError, Message: "save error"}
-		}
-		return nil
+		return f.insertUserFile(ctx, userFile)
 	}
 
+	f.publishProgress(ctx, param, v1.UploadProgressEvent{Stage: common.UPLOAD_STAGE_STORING, Name: param.Name, Bytes: fileSize})
 	// Rename the temporary file to the desired location
 	finalFilename, _ := util.GenerateRandomString(16) // Replace with your desired file path
 	finalFilename = fmt.Sprintf("%d%d%d%d-%s", createTime.Year(), createTime.Month(), createTime.Day(), createTime.Hour(), finalFilename)
@@ -163,7 +352,7 @@ func (f *fileService) UploadFile(ctx context.Context, file multipart.File, heade
 		// If there was an error while renaming, remove the temporary file
 		// works in defer
 		log.C(ctx).Errorw("upload failed", err)
-		return &errno.Errno{HTTP: http.StatusInternalServerError, Message: "save error"}
+		return "", errUploadSave
 	}
 	fileMeta.Location = finalFilename
 	fileMeta.Sha = sha
@@ -171,24 +360,97 @@ func (f *fileService) UploadFile(ctx context.Context, file multipart.File, heade
 	res, err := f.fileRepo.InsertFileMeta(ctx, fileMeta)
 	if err != nil {
 		log.C(ctx).Errorw("InsertFileMeta failed", "fileMeta", fileMeta)
-		return &errno.Errno{HTTP: http.StatusInternalServerError, Message: "save error"}
+		return "", errUploadSave
 	}
 
 	fileId, ok := res.InsertedID.(primitive.ObjectID)
 	if !ok {
 		log.C(ctx).Errorw("FileMeta ID error", "fileMeta", fileMeta)
-		return &errno.Errno{HTTP: http.StatusInternalServerError, Message: "save error"}
+		return "", errUploadSave
 	}
 	userFile.MetaId = fileId.Hex()
 
-	_, err = f.fileRepo.InsertUserFile(ctx, userFile)
+	return f.insertUserFile(ctx, userFile)
+}
+
+func stripMetadata(param *v1.FileUploadParam) bool {
+	if param.StripMetadata != nil {
+		return *param.StripMetadata
+	}
+	return STRIP_METADATA
+}
+
+// hasStrippableMetadata tells whether temp is a known image which loses bytes when stripped
+func hasStrippableMetadata(ctx context.Context, temp *os.File, size int64) (bool, error) {
+	if _, err := temp.Seek(0, io.SeekStart); err != nil {
+		return false, errUploadSave
+	}
+	format, err := imagemeta.DetectReader(temp)
 	if err != nil {
-		log.C(ctx).Errorw("InsertUserFile failed", "userFile", userFile)
-		return &errno.Errno{HTTP: http.StatusInternalServerError, Message: "save error"}
+		return false, errUploadSave
+	}
+	if format == imagemeta.FORMAT_UNKNOWN {
+		return false, nil
+	}
+	counter := &countWriter{w: io.Discard}
+	if err := imagemeta.Strip(temp, counter); err != nil {
+		log.C(ctx).Warnw("strip metadata failed", "err", err)
+		// a share couldn't clean it either
+		return false, errBrokenImage
 	}
+	return counter.n != size, nil
+}
 
+// stripTempFile writes the image in temp without metadata to a new temp file, nil if it isn't a known image
+func stripTempFile(ctx context.Context, temp *os.File) (*os.File, error) {
+	if _, err := temp.Seek(0, io.SeekStart); err != nil {
+		return nil, errUploadSave
+	}
+	format, err := imagemeta.DetectReader(temp)
+	if err != nil {
+		return nil, errUploadSave
+	}
+	if format == imagemeta.FORMAT_UNKNOWN {
+		return nil, nil
+	}
+	stripped, err := os.CreateTemp(TEMP_FILE_DIR, TEMP_FILE_PATTERN)
+	if err != nil {
+		log.C(ctx).Errorw("create temp failed", "err", err)
+		return nil, errUploadSave
+	}
+	if err := imagemeta.Strip(temp, stripped); err != nil {
+		log.C(ctx).Warnw("strip metadata failed", "err", err)
+		stripped.Close()
+		os.Remove(stripped.Name())
+		return nil, errBrokenImage
+	}
+	return stripped, nil
+}
+
+// repairBlob puts the uploaded copy in place of a blob the scrubber found damaged, the sha already matches
+func (f *fileService) repairBlob(ctx context.Context, meta *model.FileMeta, tempPath string) {
+	if err := os.Rename(tempPath, filepath.Join(SAVE_FILE_PATH, meta.Location)); err != nil {
+		log.C(ctx).Errorw("repair damaged blob failed", "metaId", meta.Id, "location", meta.Location, "err", err)
+		return
+	}
+	if err := f.fileRepo.UpdateMetaScrub(ctx, meta.Id, common.SCRUB_STATUS_OK, time.Now()); err != nil {
+		log.C(ctx).Errorw("repair damaged blob update failed", "metaId", meta.Id, "err", err)
+		return
+	}
+	log.C(ctx).Infow("repaired damaged blob from upload", "metaId", meta.Id, "location", meta.Location, "was", meta.ScrubStatus)
+}
+
+func (f *fileService) insertUserFile(ctx context.Context, userFile *model.UserFile) (string, error) {
+	res, err := f.fileRepo.InsertUserFile(ctx, userFile)
+	if err != nil {
+		log.C(ctx).Errorw("InsertUserFile failed", "userFile", userFile)
+		return "", errUploadSave
+	}
+	if oid, ok := res.InsertedID.(primitive.ObjectID); ok {
+		userFile.Id = oid.Hex()
+	}
 	log.C(ctx).Infow("Upload suc", "userFile", userFile)
-	return nil
+	return userFile.Id, nil
 }
 
 func (f *fileService) QueryUserFile(ctx context.Context, q *v1.UserFileQuery) ([]v1.FileResponse, error) {
@@ -200,14 +462,19 @@ func (f *fileService) QueryUserFile(ctx context.Context, q *v1.UserFileQuery) ([
 		log.Errorw("QueryUserFile", err)
 		return nil, errno.InternalServerError
 	}
+	return fileResponses(ctx, f.fileRepo, list)
+}
+
+// fileResponses adds the size and the integrity of the metas to the files
+func fileResponses(ctx context.Context, fileRepo repo.FileRepo, list []model.UserFile) ([]v1.FileResponse, error) {
 	ids := make([]string, len(list))
 	for i, item := range list {
 		ids[i] = item.MetaId
 	}
 
-	fileList, err := f.fileRepo.FindByMetaId(ctx, ids)
+	fileList, err := fileRepo.FindByMetaId(ctx, ids)
 	if err != nil {
-		log.Errorw("QueryUserFile", err)
+		log.C(ctx).Errorw("find file metas failed", "err", err)
 		return nil, errno.InternalServerError
 	}
 	fileMap := make(map[string]model.FileMeta)
@@ -219,8 +486,13 @@ func (f *fileService) QueryUserFile(ctx context.Context, q *v1.UserFileQuery) ([
 		r := v1.FileResponse{
 			Id:        item.Id,
 			Name:      item.Name,
+			Folder:    item.Folder,
 			Size:      fileMap[item.MetaId].Size,
 			CreatedAt: item.CreatedAt,
+			ExpireAt:  item.ExpireAt,
+		}
+		if meta := fileMap[item.MetaId]; meta.Damaged() {
+			r.Integrity = meta.ScrubStatus
 		}
 		result[i] = r
 	}
@@ -228,6 +500,20 @@ func (f *fileService) QueryUserFile(ctx context.Context, q *v1.UserFileQuery) ([
 }
 
 func (f *fileService) DownloadFile(ctx context.Context, userFileId string, userId string) (*v1.FileDownloadData, error) {
+	lease, err := f.leaseDownload(ctx)
+	if err != nil {
+		return nil, err
+	}
+	data, err := f.downloadFile(ctx, userFileId, userId)
+	if err != nil {
+		lease.Release()
+		return nil, err
+	}
+	data.Lease = lease
+	return data, nil
+}
+
+func (f *fileService) downloadFile(ctx context.Context, userFileId string, userId string) (*v1.FileDownloadData, error) {
 	if len(userFileId) < 1 {
 		return nil, &errno.Errno{HTTP: http.StatusBadRequest, Message: "request illeagal"}
 	}
@@ -235,7 +521,8 @@ func (f *fileService) DownloadFile(ctx context.Context, userFileId string, userI
 	if err != nil {
 		return nil, errno.ErrPageNotFound
 	}
-	if userFile.UserId != userId {
+	// the owner, or a user the file or its folder is shared with
+	if !allowsAccess(ctx, f.fileRepo, userFile, userId, common.GRANT_ACCESS_READ) {
 		return nil, errno.ErrPageNotFound
 	}
 
@@ -243,11 +530,16 @@ func (f *fileService) DownloadFile(ctx context.Context, userFileId string, userI
 	if err != nil || len(results) != 1 {
 		return nil, errno.ErrPageNotFound
 	}
+	damaged, err := checkBlob(ctx, &results[0])
+	if err != nil {
+		return nil, err
+	}
 	finalFilepath := filepath.Join(SAVE_FILE_PATH, results[0].Location)
 	return &v1.FileDownloadData{
 		Location: finalFilepath,
 		Size:     results[0].Size,
 		Name:     userFile.Name,
+		Damaged:  damaged,
 	}, nil
 }
 
@@ -256,7 +548,9 @@ func (f *fileService) DeleteFile(ctx context.Context, userFileId string, userId
 	if err != nil {
 		return errno.ErrPageNotFound
 	}
-	if userFile.UserId != userId {
+	// write access lets a recipient remove what they uploaded, the files of the owner stay
+	if userFile.UserId != userId && (userFile.UploaderId != userId ||
+		!allowsAccess(ctx, f.fileRepo, userFile, userId, common.GRANT_ACCESS_WRITE)) {
 		return errno.ErrPageNotFound
 	}
 	userFile, err = f.fileRepo.DeleteUserFile(ctx, userFileId)
@@ -265,6 +559,15 @@ func (f *fileService) DeleteFile(ctx context.Context, userFileId string, userId
 	}
 	userFileData, _ := json.Marshal(userFile)
 	log.C(ctx).Infow(fmt.Sprintf("Remove User File: %s", string(userFileData)))
+	if f.shareServ != nil {
+		// a link to a deleted file must not come back with a new file of the same id
+		if err := f.shareServ.RevokeTargetShares(ctx, common.SHARE_TYPE_FILE, userFileId); err != nil {
+			log.C(ctx).Warnw("revoke shares of removed file failed", "userFileId", userFileId, "err", err)
+		}
+	}
+	if err := f.fileRepo.DeleteFileGrantByFile(ctx, userFileId); err != nil {
+		log.C(ctx).Warnw("remove grants of removed file failed", "userFileId", userFileId, "err", err)
+	}
 
 	meta, err := f.fileRepo.DeleteMetaFile(ctx, userFile.MetaId)
 	if err != nil {
@@ -286,30 +589,77 @@ func (f *fileService) DeleteFile(ctx context.Context, userFileId string, userId
 	return nil
 }
 
-func (f *fileService) Share(ctx context.Context, mId string, userId string, expireParam *v1.MessageShareParam) (string, error) {
+func (f *fileService) SetFileExpire(ctx context.Context, userFileId string, userId string, param *v1.FileExpireParam) (*time.Time, error) {
+	if param.Expire < 0 {
+		return nil, errno.ErrInvalidParameter
+	}
+	userFile, err := f.fileRepo.QueryUserFileById(ctx, userFileId)
+	if err != nil {
+		return nil, errno.ErrPageNotFound
+	}
+	if userFile.UserId != userId {
+		return nil, errno.ErrPageNotFound
+	}
+	var expireAt *time.Time
+	if param.Expire > 0 {
+		t := time.Now().Add(time.Duration(param.Expire) * time.Minute)
+		expireAt = &t
+	}
+	err = f.fileRepo.UpdateUserFileExpire(ctx, userFileId, expireAt)
+	if err != nil {
+		log.C(ctx).Errorw("UpdateUserFileExpire failed", "userFileId", userFileId, "err", err)
+		return nil, errno.InternalServerError
+	}
+	log.C(ctx).Infow("set file expire", "userFileId", userFileId, "expireAt", expireAt)
+	return expireAt, nil
+}
+
+func (f *fileService) Share(ctx context.Context, mId string, userId string, expireParam *v1.MessageShareParam) (*v1.ShareLink, error) {
 	file, err := f.fileRepo.QueryUserFileById(ctx, mId)
 	if err != nil {
-		return "", &errno.Errno{HTTP: http.StatusBadRequest, Message: "invalid"}
+		return nil, &errno.Errno{HTTP: http.StatusBadRequest, Message: "invalid"}
 	}
 	if file.UserId != userId {
-		return "", &errno.Errno{HTTP: http.StatusNotAcceptable, Message: "invalid File"}
+		return nil, &errno.Errno{HTTP: http.StatusNotAcceptable, Message: "invalid File"}
+	}
+	if err := checkShareLimits(&expireParam.ShareLimits); err != nil {
+		return nil, err
 	}
+	var link *v1.ShareLink
 	switch expireParam.ExpireType {
 	case common.SHARE_EXPIRE_TYPE_DURATION:
-		return f.shareServ.CreateShareUrl(ctx, common.SHARE_TYPE_FILE, mId, time.Duration(expireParam.Expire*int64(time.Minute)))
+		link, err = f.shareServ.CreateShareUrl(ctx, common.SHARE_TYPE_FILE, userId, mId, time.Duration(expireParam.Expire*int64(time.Minute)), expireParam.Password)
 	case common.SHARE_EXPIRE_TYPE_TIMES:
-		return f.shareServ.CreateShareUrlWithTimes(ctx, common.SHARE_TYPE_FILE, mId, FILE_SHARE_LINK_EXPIRE, int8(expireParam.Expire))
+		link, err = f.shareServ.CreateShareUrlWithTimes(ctx, common.SHARE_TYPE_FILE, userId, mId, FILE_SHARE_LINK_EXPIRE, int8(expireParam.Expire), expireParam.Password)
 	default:
-		return "", &errno.Errno{HTTP: http.StatusMethodNotAllowed, Message: "invalid type"}
+		return nil, &errno.Errno{HTTP: http.StatusMethodNotAllowed, Message: "invalid type"}
 	}
+	if err != nil {
+		return nil, err
+	}
+	return f.limitShare(ctx, userId, link, &expireParam.ShareLimits)
 }
 
-func (f *fileService) ReadShare(ctx context.Context, key string) (*v1.FileDownloadData, error) {
-	userFileId, err := f.shareServ.ConsumeShareUrl(ctx, common.SHARE_TYPE_FILE, key, FILE_SHARE_LINK_EXPIRE)
+func (f *fileService) ReadShare(ctx context.Context, key string, password string, session string) (*v1.FileDownloadData, error) {
+	lease, err := f.leaseShareDownload(ctx, key)
 	if err != nil {
-		return nil, &errno.Errno{HTTP: http.StatusBadRequest, Message: "invalid"}
+		return nil, err
 	}
-	userFile, err := f.fileRepo.QueryUserFileById(ctx, userFileId)
+	data, err := f.readShare(ctx, key, password, session)
+	if err != nil {
+		lease.Release()
+		return nil, err
+	}
+	data.Lease = lease
+	return data, nil
+}
+
+func (f *fileService) readShare(ctx context.Context, key string, password string, session string) (*v1.FileDownloadData, error) {
+	opened, err := f.shareServ.ConsumeShareSession(ctx, common.SHARE_TYPE_FILE, key, "", FILE_SHARE_LINK_EXPIRE, password, session)
+	if err != nil {
+		return nil, shareAccessError(err)
+	}
+	userFile, err := f.fileRepo.QueryUserFileById(ctx, opened.Value)
 	if err != nil {
 		return nil, errno.ErrPageNotFound
 	}
@@ -318,10 +668,58 @@ func (f *fileService) ReadShare(ctx context.Context, key string) (*v1.FileDownlo
 	if err != nil || len(results) != 1 {
 		return nil, errno.ErrPageNotFound
 	}
+	damaged, err := checkBlob(ctx, &results[0])
+	if err != nil {
+		return nil, err
+	}
 	finalFilepath := filepath.Join(SAVE_FILE_PATH, results[0].Location)
 	return &v1.FileDownloadData{
-		Location: finalFilepath,
-		Size:     results[0].Size,
-		Name:     userFile.Name,
+		Location:      finalFilepath,
+		Size:          results[0].Size,
+		Name:          userFile.Name,
+		StripMetadata: userFile.StripOnShare,
+		Damaged:       damaged,
+		Session:       opened.Token,
+		Resumed:       opened.Resumed,
 	}, nil
 }
+
+// ReadSharePage leaves out the file of a protected link, its name could tell too much
+func (f *fileService) ReadSharePage(ctx context.Context, key string) (*v1.SharePage, error) {
+	status, err := f.shareServ.ShareStatus(ctx, common.SHARE_TYPE_FILE, key, FILE_SHARE_LINK_EXPIRE)
+	if err != nil {
+		return nil, err
+	}
+	page := &v1.SharePage{
+		Path:      status.Path,
+		ExpireAt:  status.ExpireAt,
+		Remaining: status.Remaining,
+		Protected: status.Protected,
+	}
+	if status.Protected {
+		return page, nil
+	}
+	userFile, err := f.fileRepo.QueryUserFileById(ctx, status.Target)
+	if err != nil {
+		return nil, errno.ErrPageNotFound
+	}
+	metas, err := f.fileRepo.FindByMetaId(ctx, []string{userFile.MetaId})
+	if err != nil || len(metas) != 1 {
+		return nil, errno.ErrPageNotFound
+	}
+	page.Name = userFile.Name
+	page.Size = metas[0].Size
+	page.ContentType = mime.TypeByExtension(path.Ext(userFile.Name))
+	if len(page.ContentType) == 0 {
+		page.ContentType = "application/octet-stream"
+	}
+	return page, nil
+}
+
+func (f *fileService) RecordShareRead(ctx context.Context, key string, resumed bool, bytes int64) {
+	if resumed {
+		f.shareServ.RecordAccess(ctx, key, common.SHARE_OUTCOME_RESUMED, bytes)
+		return
+	}
+	f.shareServ.RecordAccess(ctx, key, common.SHARE_OUTCOME_SERVED, bytes)
+}
@@ -0,0 +1,110 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"file-transfer/internal/file-transfer/repo/repotest"
+	v1 "file-transfer/pkg/api/v1"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUploadFilesPartialFailure(t *testing.T) {
+	SAVE_FILE_PATH = t.TempDir()
+	ctx := context.Background()
+	fileRepo := repotest.NewMemFileRepo()
+	fileServ := &fileService{fileRepo: fileRepo}
+
+	// the parts are in order, a path field describes the file after it
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	addFile := func(path string, name string, content string) {
+		if len(path) > 0 {
+			assert.Nil(t, mw.WriteField(UPLOAD_FORM_PATH, path))
+		}
+		part, err := mw.CreateFormFile(UPLOAD_FORM_FILES, name)
+		assert.Nil(t, err)
+		part.Write([]byte(content))
+	}
+	addFile("docs/a.txt", "a.txt", "a")
+	addFile("../escape.txt", "escape.txt", "x")
+	addFile("", "b.txt", "b")
+	addFile("docs/a.txt", "a.txt", "again")
+	assert.Nil(t, mw.Close())
+
+	results, err := fileServ.UploadFiles(ctx, multipart.NewReader(body, mw.Boundary()), &v1.FileUploadParam{UserId: "u1", Folder: "up"})
+	assert.Nil(t, err, "failed files don't fail the batch")
+	assert.Equal(t, 4, len(results))
+
+	assert.Equal(t, "a.txt", results[0].Name)
+	assert.Equal(t, "up/docs", results[0].Folder)
+	assert.NotEmpty(t, results[0].Id)
+	assert.Empty(t, results[0].Code)
+
+	assert.Equal(t, "../escape.txt", results[1].Name)
+	assert.Equal(t, "InvalidParameter.Path", results[1].Code)
+	assert.Empty(t, results[1].Id)
+
+	assert.Equal(t, "b.txt", results[2].Name)
+	assert.Equal(t, "up", results[2].Folder)
+	assert.NotEmpty(t, results[2].Id)
+
+	assert.Equal(t, "InvalidParameter.FileExist", results[3].Code, "the first a.txt is kept")
+	assert.Empty(t, results[3].Id)
+
+	assert.Equal(t, 2, len(fileRepo.Files))
+	data, err := fileServ.DownloadFile(ctx, results[0].Id, "u1")
+	assert.Nil(t, err)
+	assert.Equal(t, "a.txt", data.Name)
+}
+
+func pngWithText(t *testing.T, text string) []byte {
+	buf := &bytes.Buffer{}
+	assert.Nil(t, png.Encode(buf, image.NewGray(image.Rect(0, 0, 2, 2))))
+	if len(text) < 1 {
+		return buf.Bytes()
+	}
+	data := buf.Bytes()
+	// the signature and IHDR come first
+	ihdrEnd := 8 + 8 + 13 + 4
+	chunk := make([]byte, 8, 12+len(text))
+	binary.BigEndian.PutUint32(chunk, uint32(len(text)))
+	copy(chunk[4:], "tEXt")
+	chunk = append(chunk, text...)
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+	return append(append(append([]byte(nil), data[:ihdrEnd]...), chunk...), data[ihdrEnd:]...)
+}
+
+func TestKeepOriginalStripOnShare(t *testing.T) {
+	SAVE_FILE_PATH = t.TempDir()
+	ctx := context.Background()
+	fileRepo := repotest.NewMemFileRepo()
+	fileServ := &fileService{fileRepo: fileRepo}
+	strip, keep := STRIP_METADATA, KEEP_ORIGINAL
+	STRIP_METADATA, KEEP_ORIGINAL = true, true
+	defer func() { STRIP_METADATA, KEEP_ORIGINAL = strip, keep }()
+
+	for _, tt := range []struct {
+		name    string
+		content []byte
+		strip   bool
+	}{
+		{"notes.txt", []byte("no image"), false},
+		{"clean.png", pngWithText(t, ""), false},
+		{"tagged.png", pngWithText(t, "Author\x00someone"), true},
+	} {
+		id, err := fileServ.UploadFile(ctx, bytes.NewReader(tt.content), &v1.FileUploadParam{UserId: "u1", Name: tt.name})
+		assert.Nil(t, err, tt.name)
+		assert.Equal(t, tt.strip, fileRepo.Files[id].StripOnShare, tt.name)
+		assert.Equal(t, int64(len(tt.content)), fileRepo.Metas[fileRepo.Files[id].MetaId].Size, "the original is kept")
+	}
+
+	_, err := fileServ.UploadFile(ctx, bytes.NewReader(pngWithText(t, "x")[:40]), &v1.FileUploadParam{UserId: "u1", Name: "broken.png"})
+	assert.Equal(t, errBrokenImage, err)
+}
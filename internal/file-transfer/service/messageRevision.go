@@ -0,0 +1,77 @@
+package service
+
+import (
+	"context"
+	"errors"
+	v1 "file-transfer/pkg/api/v1"
+	"file-transfer/pkg/errno"
+	"file-transfer/pkg/log"
+	"net/http"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// revisions kept for each message, the oldest go first
+var MESSAGE_MAX_REVISIONS = 20
+
+var (
+	errMessageVersion  = &errno.Errno{HTTP: http.StatusPreconditionFailed, Code: "PreconditionFailed.Version", Message: "the message was changed since, reload it and edit again"}
+	errMessageRevision = &errno.Errno{HTTP: http.StatusNotFound, Code: "ResourceNotFound.Revision", Message: "revision not found"}
+)
+
+// UpdateMessage keeps the id of the message, so its share links show the new text
+func (s *messageService) UpdateMessage(ctx context.Context, mId string, userId string, version int64, r *v1.MessageSendRequest) (*v1.MessageResponse, error) {
+	msg, err := s.messageRepo.QueryById(ctx, mId)
+	if err != nil || msg.UserId != userId {
+		return nil, errno.ErrPageNotFound
+	}
+	if msg.Version != version {
+		return nil, errMessageVersion
+	}
+	if msg.Info == r.Info {
+		return messageResponse(msg), nil
+	}
+	return s.updateMessage(ctx, mId, userId, version, r.Info)
+}
+
+func (s *messageService) updateMessage(ctx context.Context, mId string, userId string, version int64, info string) (*v1.MessageResponse, error) {
+	msg, err := s.messageRepo.Update(ctx, mId, userId, version, info, MESSAGE_MAX_REVISIONS)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		// another edit came in between
+		return nil, errMessageVersion
+	}
+	if err != nil {
+		log.C(ctx).Errorw("update message failed", "mId", mId, "err", err)
+		return nil, errno.InternalServerError
+	}
+	log.C(ctx).Infow("message updated", "mId", mId, "version", msg.Version)
+	return messageResponse(msg), nil
+}
+
+func (s *messageService) MessageRevisions(ctx context.Context, mId string, userId string) ([]v1.MessageRevision, error) {
+	msg, err := s.messageRepo.QueryById(ctx, mId)
+	if err != nil || msg.UserId != userId {
+		return nil, errno.ErrPageNotFound
+	}
+	results := make([]v1.MessageRevision, len(msg.Revisions))
+	for i, rev := range msg.Revisions {
+		results[len(results)-1-i] = v1.MessageRevision{Version: rev.Version, Info: rev.Info, UpdatedAt: rev.UpdatedAt}
+	}
+	return results, nil
+}
+
+func (s *messageService) RestoreMessage(ctx context.Context, mId string, userId string, version int64, revision int64) (*v1.MessageResponse, error) {
+	msg, err := s.messageRepo.QueryById(ctx, mId)
+	if err != nil || msg.UserId != userId {
+		return nil, errno.ErrPageNotFound
+	}
+	if msg.Version != version {
+		return nil, errMessageVersion
+	}
+	for _, rev := range msg.Revisions {
+		if rev.Version == revision {
+			return s.updateMessage(ctx, mId, userId, version, rev.Info)
+		}
+	}
+	return nil, errMessageRevision
+}
@@ -0,0 +1,75 @@
+package service
+
+import (
+	"context"
+	"file-transfer/internal/file-transfer/repo/repotest"
+	v1 "file-transfer/pkg/api/v1"
+	"file-transfer/pkg/errno"
+	"file-transfer/pkg/model"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpdateMessage(t *testing.T) {
+	ctx := context.Background()
+	messageRepo := repotest.NewMemMessageRepo()
+	s := &messageService{messageRepo: messageRepo}
+	msg := &model.Message{UserId: "u1", Info: "helo", CreatedAt: time.Now(), UpdatedAt: time.Now()}
+	_, err := messageRepo.Insert(ctx, msg)
+	assert.Nil(t, err)
+
+	_, err = s.UpdateMessage(ctx, msg.Id, "u2", 0, &v1.MessageSendRequest{Info: "hello"})
+	assert.Equal(t, errno.ErrPageNotFound, err)
+	updated, err := s.UpdateMessage(ctx, msg.Id, "u1", 0, &v1.MessageSendRequest{Info: "hello"})
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", updated.Info)
+	assert.Equal(t, int64(1), updated.Version)
+
+	// an edit made on the old version lost the race
+	_, err = s.UpdateMessage(ctx, msg.Id, "u1", 0, &v1.MessageSendRequest{Info: "hallo"})
+	assert.Equal(t, errMessageVersion, err)
+	_, err = s.UpdateMessage(ctx, msg.Id, "u1", 1, &v1.MessageSendRequest{Info: "hello world"})
+	assert.Nil(t, err)
+
+	revisions, err := s.MessageRevisions(ctx, msg.Id, "u1")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(revisions))
+	assert.Equal(t, "hello", revisions[0].Info, "newest first")
+	assert.Equal(t, "helo", revisions[1].Info)
+
+	_, err = s.RestoreMessage(ctx, msg.Id, "u1", 2, 7)
+	assert.Equal(t, errMessageRevision, err)
+	restored, err := s.RestoreMessage(ctx, msg.Id, "u1", 2, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", restored.Info)
+	assert.Equal(t, int64(3), restored.Version)
+	revisions, _ = s.MessageRevisions(ctx, msg.Id, "u1")
+	assert.Equal(t, 3, len(revisions), "restoring keeps the history")
+
+	// the share link reads the message by id and gets the latest text
+	stored, _ := messageRepo.QueryById(ctx, msg.Id)
+	assert.Equal(t, "hello", stored.Info)
+}
+
+func TestMessageRevisionsCapped(t *testing.T) {
+	ctx := context.Background()
+	old := MESSAGE_MAX_REVISIONS
+	MESSAGE_MAX_REVISIONS = 2
+	defer func() { MESSAGE_MAX_REVISIONS = old }()
+	messageRepo := repotest.NewMemMessageRepo()
+	s := &messageService{messageRepo: messageRepo}
+	msg := &model.Message{UserId: "u1", Info: "0"}
+	_, err := messageRepo.Insert(ctx, msg)
+	assert.Nil(t, err)
+	for i, info := range []string{"1", "2", "3"} {
+		_, err := s.UpdateMessage(ctx, msg.Id, "u1", int64(i), &v1.MessageSendRequest{Info: info})
+		assert.Nil(t, err)
+	}
+	revisions, err := s.MessageRevisions(ctx, msg.Id, "u1")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(revisions))
+	assert.Equal(t, "2", revisions[0].Info)
+	assert.Equal(t, int64(1), revisions[1].Version, "the oldest text was dropped")
+}
@@ -0,0 +1,79 @@
+package service
+
+import (
+	"context"
+	v1 "file-transfer/pkg/api/v1"
+	"file-transfer/pkg/errno"
+	"file-transfer/pkg/log"
+	"file-transfer/pkg/textsearch"
+	"net/http"
+	"time"
+)
+
+const (
+	MESSAGE_SEARCH_MAX_PAGE_SIZE = 100
+	// bytes around a match in a snippet, and snippets of a message
+	MESSAGE_SNIPPET_CONTEXT = 40
+	MESSAGE_SNIPPETS        = 3
+)
+
+// without the text index every search scans the messages of the user, for mongo-compatible
+// databases which have no text index
+var MESSAGE_SEARCH_INDEX = true
+
+var errMessageSearch = &errno.Errno{HTTP: http.StatusBadRequest, Code: "InvalidParameter.Query", Message: "the query needs words, \"phrases\" or prefix* words, 10 at most"}
+
+// useTextIndex creates the text index with the first search, and tells whether searches can use it
+func (s *messageService) useTextIndex(ctx context.Context) bool {
+	s.textIndexOnce.Do(func() {
+		if !MESSAGE_SEARCH_INDEX {
+			log.C(ctx).Infow("message search scans the messages, the text index is off")
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 20*time.Second)
+		defer cancel()
+		if err := s.messageRepo.EnsureTextIndex(ctx); err != nil {
+			log.C(ctx).Warnw("create message text index failed, message search scans the messages", "err", err)
+			return
+		}
+		s.textIndex = true
+	})
+	return s.textIndex
+}
+
+// SearchMessage pages through the messages holding all terms of the query, newest first
+func (s *messageService) SearchMessage(ctx context.Context, q *v1.MessageSearchQuery) ([]v1.MessageResponse, error) {
+	if len(q.UserId) < 1 {
+		return nil, &errno.Errno{Message: "request illeagal"}
+	}
+	terms, err := textsearch.Parse(q.Query)
+	if err != nil || (q.From != nil && q.To != nil && !q.From.Before(*q.To)) {
+		return nil, errMessageSearch
+	}
+	if q.PageNum < 1 {
+		q.PageNum = 1
+	}
+	if q.PageSize < 1 || q.PageSize > MESSAGE_SEARCH_MAX_PAGE_SIZE {
+		q.PageSize = 10
+	}
+	patterns := make([]string, len(terms))
+	for i, t := range terms {
+		patterns[i] = t.Pattern()
+	}
+	var words []string
+	if s.useTextIndex(ctx) {
+		words = textsearch.IndexWords(terms)
+	}
+	list, err := s.messageRepo.Search(ctx, q, patterns, words)
+	if err != nil {
+		log.C(ctx).Errorw("search message failed", "err", err)
+		return nil, errno.InternalServerError
+	}
+	res := textsearch.Compile(terms)
+	results := make([]v1.MessageResponse, len(list))
+	for i := range list {
+		results[i] = *messageResponse(&list[i])
+		results[i].Snippets = textsearch.Highlight(list[i].Info, res, MESSAGE_SNIPPET_CONTEXT, MESSAGE_SNIPPETS)
+	}
+	return results, nil
+}
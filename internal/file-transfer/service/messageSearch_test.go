@@ -0,0 +1,64 @@
+package service
+
+import (
+	"context"
+	"file-transfer/internal/file-transfer/repo/repotest"
+	v1 "file-transfer/pkg/api/v1"
+	"file-transfer/pkg/model"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSearchMessage(t *testing.T) {
+	ctx := context.Background()
+	messageRepo := repotest.NewMemMessageRepo()
+	s := &messageService{messageRepo: messageRepo}
+	day := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	for i, info := range []string{
+		"deploy token ghp_abc123 for the api",
+		"see https://example.com/docs?page=2 for the API key",
+		"deployment notes",
+		"someone else's token",
+	} {
+		userId := "u1"
+		if i == 3 {
+			userId = "u2"
+		}
+		_, err := messageRepo.Insert(ctx, &model.Message{UserId: userId, Info: info, CreatedAt: day.AddDate(0, 0, i)})
+		assert.Nil(t, err)
+	}
+	search := func(q *v1.MessageSearchQuery) []string {
+		q.UserId = "u1"
+		results, err := s.SearchMessage(ctx, q)
+		assert.Nil(t, err)
+		infos := make([]string, len(results))
+		for i, r := range results {
+			infos[i] = r.Info
+		}
+		return infos
+	}
+
+	assert.Equal(t, []string{"deploy token ghp_abc123 for the api"}, search(&v1.MessageSearchQuery{Query: "token"}))
+	assert.Equal(t, []string{"deployment notes", "deploy token ghp_abc123 for the api"}, search(&v1.MessageSearchQuery{Query: "deploy*"}), "newest first")
+	assert.Equal(t, []string{"see https://example.com/docs?page=2 for the API key"}, search(&v1.MessageSearchQuery{Query: `"api key"`}))
+	assert.Equal(t, []string{"see https://example.com/docs?page=2 for the API key"}, search(&v1.MessageSearchQuery{Query: "example.com/docs"}))
+	assert.Empty(t, search(&v1.MessageSearchQuery{Query: "deploy missing"}), "all terms are needed")
+
+	from, to := day.AddDate(0, 0, 1), day.AddDate(0, 0, 2)
+	assert.Equal(t, []string{"see https://example.com/docs?page=2 for the API key"}, search(&v1.MessageSearchQuery{Query: "the", From: &from}))
+	assert.Equal(t, []string{"deploy token ghp_abc123 for the api"}, search(&v1.MessageSearchQuery{Query: "the", To: &from}))
+	assert.Empty(t, search(&v1.MessageSearchQuery{Query: "deploy*", From: &from, To: &to}))
+	assert.Equal(t, []string{"deployment notes"}, search(&v1.MessageSearchQuery{Query: "deploy*", PageNum: 1, PageSize: 1}))
+	assert.Equal(t, []string{"deploy token ghp_abc123 for the api"}, search(&v1.MessageSearchQuery{Query: "deploy*", PageNum: 2, PageSize: 1}))
+
+	results, err := s.SearchMessage(ctx, &v1.MessageSearchQuery{UserId: "u1", Query: "ghp_abc123"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"deploy token <mark>ghp_abc123</mark> for the api"}, results[0].Snippets)
+
+	_, err = s.SearchMessage(ctx, &v1.MessageSearchQuery{UserId: "u1", Query: ` "" `})
+	assert.Equal(t, errMessageSearch, err)
+	_, err = s.SearchMessage(ctx, &v1.MessageSearchQuery{UserId: "u1", Query: "x", From: &to, To: &from})
+	assert.Equal(t, errMessageSearch, err)
+}
@@ -10,20 +10,43 @@ import (
 	"file-transfer/pkg/model"
 	"file-transfer/pkg/util"
 	"net/http"
+	"sync"
 	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/spf13/viper"
 )
 
 type MessageService interface {
 	QueryMessage(ctx context.Context, query *v1.MessageQuery) ([]v1.MessageResponse, error)
+	SearchMessage(ctx context.Context, query *v1.MessageSearchQuery) ([]v1.MessageResponse, error)
 	SendMessage(ctx context.Context, r *v1.MessageSendRequest, userId string) error
 	DeleteMessage(ctx context.Context, mId string, userId string) error
-	ShareMessage(ctx context.Context, mId string, userId string, expireParam *v1.MessageShareParam) (string, error)
-	ReadShareMessage(ctx context.Context, key string) (string, error)
+	ShareMessage(ctx context.Context, mId string, userId string, expireParam *v1.MessageShareParam) (*v1.ShareLink, error)
+	ReadShareMessage(ctx context.Context, key string, password string) (string, error)
+
+	CreateSecretNote(ctx context.Context, userId string, param *v1.SecretNoteParam) (*v1.SecretNoteLink, error)
+	// ReadSecretNote opens a note once, the note is removed as it is read
+	ReadSecretNote(ctx context.Context, key string, request *v1.SecretNoteReadRequest) (string, error)
+	// ReadSecretNotePage tells whether a note can be opened, without opening it
+	ReadSecretNotePage(ctx context.Context, key string) (*v1.SharePage, error)
+	SecretNoteStatus(ctx context.Context, userId string, key string) (*v1.SecretNoteStatus, error)
+
+	// UpdateMessage edits the message if it is still at version, the text it had is kept as a revision
+	UpdateMessage(ctx context.Context, mId string, userId string, version int64, r *v1.MessageSendRequest) (*v1.MessageResponse, error)
+	// MessageRevisions lists the texts the message had, newest first
+	MessageRevisions(ctx context.Context, mId string, userId string) ([]v1.MessageRevision, error)
+	// RestoreMessage edits the message back to the text of a revision, the history is kept
+	RestoreMessage(ctx context.Context, mId string, userId string, version int64, revision int64) (*v1.MessageResponse, error)
 }
 
 type messageService struct {
 	messageRepo repo.MessageRepo
 	shareServ   ShareService
+	redisClient *redis.Client
+	// searches are narrowed down by the text index of the messages, once it is created
+	textIndexOnce sync.Once
+	textIndex     bool
 }
 
 var (
@@ -33,8 +56,14 @@ var (
 
 var _ MessageService = (*messageService)(nil)
 
-func NewMessageService(repo repo.MessageRepo, shareServ ShareService) MessageService {
-	return &messageService{messageRepo: repo, shareServ: shareServ}
+func NewMessageService(repo repo.MessageRepo, shareServ ShareService, rClient *redis.Client) MessageService {
+	if revisions := viper.GetInt("message.max-revisions"); revisions > 0 {
+		MESSAGE_MAX_REVISIONS = revisions
+	}
+	if viper.IsSet("message.search-index") {
+		MESSAGE_SEARCH_INDEX = viper.GetBool("message.search-index")
+	}
+	return &messageService{messageRepo: repo, shareServ: shareServ, redisClient: rClient}
 }
 
 func (s *messageService) QueryMessage(ctx context.Context, query *v1.MessageQuery) ([]v1.MessageResponse, error) {
@@ -50,16 +79,22 @@ func (s *messageService) QueryMessage(ctx context.Context, query *v1.MessageQuer
 		return nil, errno.ErrBind
 	}
 	transformed := make([]v1.MessageResponse, len(list))
-	for i, msg := range list {
-		transformed[i] = v1.MessageResponse{
-			Id:        msg.Id,
-			Info:      msg.Info,
-			CreatedAt: msg.CreatedAt,
-		}
+	for i := range list {
+		transformed[i] = *messageResponse(&list[i])
 	}
 	return transformed, err
 }
 
+func messageResponse(msg *model.Message) *v1.MessageResponse {
+	return &v1.MessageResponse{
+		Id:        msg.Id,
+		Info:      msg.Info,
+		CreatedAt: msg.CreatedAt,
+		UpdatedAt: msg.UpdatedAt,
+		Version:   msg.Version,
+	}
+}
+
 func (s *messageService) SendMessage(ctx context.Context, r *v1.MessageSendRequest, userId string) error {
 	m := &model.Message{
 		UserId:    userId,
@@ -82,35 +117,42 @@ func (s *messageService) DeleteMessage(ctx context.Context, mId string, userId s
 		log.C(ctx).Debugw("DeleteMessage", result.DeletedCount)
 		return &errno.Errno{HTTP: http.StatusForbidden, Message: "invalid"}
 	}
-	return err
+	if err != nil {
+		return err
+	}
+	if err := s.shareServ.RevokeTargetShares(ctx, common.SHARE_TYPE_MESSAGE, mId); err != nil {
+		log.C(ctx).Warnw("revoke shares of removed message failed", "mId", mId, "err", err)
+	}
+	return nil
 }
 
-func (s *messageService) ShareMessage(ctx context.Context, mId string, userId string, expireParam *v1.MessageShareParam) (string, error) {
+func (s *messageService) ShareMessage(ctx context.Context, mId string, userId string, expireParam *v1.MessageShareParam) (*v1.ShareLink, error) {
 	msg, err := s.messageRepo.QueryById(ctx, mId)
 	if err != nil {
-		return "", &errno.Errno{HTTP: http.StatusBadRequest, Message: "invalid"}
+		return nil, &errno.Errno{HTTP: http.StatusBadRequest, Message: "invalid"}
 	}
 	if msg.UserId != userId {
-		return "", &errno.Errno{HTTP: http.StatusNotAcceptable, Message: "invalid Message"}
+		return nil, &errno.Errno{HTTP: http.StatusNotAcceptable, Message: "invalid Message"}
 	}
 	switch expireParam.ExpireType {
 	case common.SHARE_EXPIRE_TYPE_DURATION:
-		return s.shareServ.CreateShareUrl(ctx, common.SHARE_TYPE_MESSAGE, mId, time.Duration(expireParam.Expire*int64(time.Minute)))
+		return s.shareServ.CreateShareUrl(ctx, common.SHARE_TYPE_MESSAGE, userId, mId, time.Duration(expireParam.Expire*int64(time.Minute)), expireParam.Password)
 	case common.SHARE_EXPIRE_TYPE_TIMES:
-		return s.shareServ.CreateShareUrlWithTimes(ctx, common.SHARE_TYPE_MESSAGE, mId, MESSAGE_SHARE_LINK_EXPIRE, int8(expireParam.Expire))
+		return s.shareServ.CreateShareUrlWithTimes(ctx, common.SHARE_TYPE_MESSAGE, userId, mId, MESSAGE_SHARE_LINK_EXPIRE, int8(expireParam.Expire), expireParam.Password)
 	default:
-		return "", &errno.Errno{HTTP: http.StatusMethodNotAllowed, Message: "invalid type"}
+		return nil, &errno.Errno{HTTP: http.StatusMethodNotAllowed, Message: "invalid type"}
 	}
 }
 
-func (s *messageService) ReadShareMessage(ctx context.Context, key string) (string, error) {
-	mId, err := s.shareServ.ConsumeShareUrl(ctx, common.SHARE_TYPE_MESSAGE, key, MESSAGE_SHARE_LINK_EXPIRE)
+func (s *messageService) ReadShareMessage(ctx context.Context, key string, password string) (string, error) {
+	mId, err := s.shareServ.ConsumeShareUrl(ctx, common.SHARE_TYPE_MESSAGE, key, MESSAGE_SHARE_LINK_EXPIRE, password)
 	if err != nil {
-		return "", &errno.Errno{HTTP: http.StatusBadRequest, Message: "invalid"}
+		return "", shareAccessError(err)
 	}
 	msg, err := s.messageRepo.QueryById(ctx, mId)
 	if err != nil {
 		return "", &errno.Errno{HTTP: http.StatusBadRequest, Message: "invalid"}
 	}
+	s.shareServ.RecordAccess(ctx, key, common.SHARE_OUTCOME_SERVED, int64(len(msg.Info)))
 	return msg.Info, nil
 }
@@ -0,0 +1,206 @@
+package service
+
+import (
+	"context"
+	v1 "file-transfer/pkg/api/v1"
+	"file-transfer/pkg/db/dbredis"
+	"file-transfer/pkg/errno"
+	"file-transfer/pkg/log"
+	"file-transfer/pkg/pickupcode"
+	"net/http"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/spf13/viper"
+)
+
+const (
+	// path the codes are redeemed at
+	PICKUP_CODE_PATH = "code"
+	// codes drawn before giving up when all of them are taken
+	PICKUP_CODE_TRIES = 10
+	// the ip counted when the client ip is unknown, all such clients share the limit
+	PICKUP_CODE_UNKNOWN_IP = "unknown"
+)
+
+var (
+	PICKUP_CODE_EXPIRE     time.Duration = 10 * time.Minute
+	PICKUP_CODE_MAX_EXPIRE time.Duration = time.Hour
+	// failed redeems of one ip in the window, and of everyone, before redeems are refused for the rest of the window.
+	// 6 digits are a million codes, the global limit keeps guessing from many ips hopeless too
+	PICKUP_CODE_ATTEMPTS        int64         = 10
+	PICKUP_CODE_GLOBAL_ATTEMPTS int64         = 1000
+	PICKUP_CODE_WINDOW          time.Duration = 15 * time.Minute
+)
+
+var (
+	ErrPickupCodeLimited = &errno.Errno{HTTP: http.StatusTooManyRequests, Code: "TooManyRequests.PickupCode", Message: "too many wrong codes, try again later"}
+
+	errPickupCodeInvalid  = &errno.Errno{HTTP: http.StatusBadRequest, Code: "InvalidParameter.PickupCode", Message: "invalid pickup code parameter"}
+	errPickupCodeNotFound = &errno.Errno{HTTP: http.StatusNotFound, Code: "NotFound.PickupCode", Message: "unknown or expired code"}
+	errPickupCodeFull     = &errno.Errno{HTTP: http.StatusServiceUnavailable, Code: "Unavailable.PickupCode", Message: "no free code, try the other style"}
+)
+
+func loadPickupCodeConfig() {
+	if expire := viper.GetDuration("share.code-expire"); expire > 0 {
+		PICKUP_CODE_EXPIRE = expire
+	}
+	if expire := viper.GetDuration("share.code-max-expire"); expire > 0 {
+		PICKUP_CODE_MAX_EXPIRE = expire
+	}
+	if attempts := viper.GetInt64("share.code-attempts"); attempts > 0 {
+		PICKUP_CODE_ATTEMPTS = attempts
+	}
+	if attempts := viper.GetInt64("share.code-global-attempts"); attempts > 0 {
+		PICKUP_CODE_GLOBAL_ATTEMPTS = attempts
+	}
+	if window := viper.GetDuration("share.code-window"); window > 0 {
+		PICKUP_CODE_WINDOW = window
+	}
+}
+
+// CreatePickupCode allocates a free code with SETNX, it expires with the link at the latest
+func (s *shareService) CreatePickupCode(ctx context.Context, userId string, key string, param *v1.PickupCodeParam) (*v1.PickupCode, error) {
+	style := pickupcode.Style(param.Style)
+	if len(style) == 0 {
+		style = pickupcode.STYLE_DIGITS
+	}
+	if style != pickupcode.STYLE_DIGITS && style != pickupcode.STYLE_WORDS {
+		return nil, errPickupCodeInvalid
+	}
+	expire := PICKUP_CODE_EXPIRE
+	if param.Expire != 0 {
+		expire = time.Duration(param.Expire) * time.Minute
+		if expire <= 0 || expire > PICKUP_CODE_MAX_EXPIRE {
+			return nil, errPickupCodeInvalid
+		}
+	}
+	record, err := s.ownShare(ctx, userId, key)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkShareOpen(ctx, record); err != nil {
+		return nil, err
+	}
+	if left := time.Until(record.info.ExpireAt); left < expire {
+		expire = left
+	}
+
+	code := ""
+	for i := 0; i < PICKUP_CODE_TRIES && len(code) == 0; i++ {
+		candidate, err := pickupcode.New(style)
+		if err != nil {
+			log.C(ctx).Errorw("generate pickup code failed", "err", err)
+			return nil, errno.InternalServerError
+		}
+		ok, err := s.redisClient.SetNX(ctx, dbredis.REDIS_PICKUP_CODE_PREFIX+candidate, key, expire).Result()
+		if err != nil {
+			log.C(ctx).Warnw("allocate pickup code failed", "err", err)
+			return nil, errno.InternalServerError
+		}
+		if ok {
+			code = candidate
+		}
+	}
+	if len(code) == 0 {
+		log.C(ctx).Warnw("no free pickup code", "style", style)
+		return nil, errPickupCodeFull
+	}
+
+	infoKey := dbredis.REDIS_SHARE_INFO_PREFIX + key
+	if old, _ := s.redisClient.HGet(ctx, infoKey, "code").Result(); len(old) > 0 {
+		s.redisClient.Del(ctx, dbredis.REDIS_PICKUP_CODE_PREFIX+old)
+	}
+	if err := s.redisClient.HSet(ctx, infoKey, "code", code).Err(); err != nil {
+		log.C(ctx).Warnw("record pickup code failed", "key", key, "err", err)
+	}
+	log.C(ctx).Infow("created pickup code", "key", key, "userId", userId, "expire", expire)
+	return &v1.PickupCode{
+		Code:     code,
+		Path:     "/" + PICKUP_CODE_PATH + "/" + code,
+		ExpireAt: time.Now().Add(expire),
+	}, nil
+}
+
+// checkShareOpen refuses links which still have info but can't be opened any more
+func (s *shareService) checkShareOpen(ctx context.Context, record *shareRecord) error {
+	if record.info.Remaining != nil && *record.info.Remaining <= 0 || !record.info.ExpireAt.After(time.Now()) {
+		return errno.ErrPageNotFound
+	}
+	n, err := s.redisClient.Exists(ctx, shareTypePrefixMap[record.shareType]+record.info.Key).Result()
+	if err != nil {
+		log.C(ctx).Warnw("check share failed", "err", err)
+		return errno.InternalServerError
+	}
+	if n == 0 {
+		return errno.ErrPageNotFound
+	}
+	return nil
+}
+
+// RedeemPickupCode only resolves the code, opening the link counts and asks the password as usual.
+// Every try takes an attempt of the ip and of everyone first, a right code gives them back. So parallel
+// tries can't pass the limits, and one ip uses up no more of the global limit than its own
+func (s *shareService) RedeemPickupCode(ctx context.Context, code string, ip string) (string, error) {
+	if len(ip) == 0 {
+		ip = PICKUP_CODE_UNKNOWN_IP
+	}
+	failKey := dbredis.REDIS_PICKUP_CODE_FAIL_PREFIX + ip
+	globalKey := dbredis.REDIS_PICKUP_CODE_FAIL_PREFIX + "*"
+	attempts, err := reserveAttemptScript.Run(ctx, s.redisClient, []string{failKey}, PICKUP_CODE_WINDOW.Milliseconds()).Int64()
+	if err != nil {
+		log.C(ctx).Warnw("count pickup code attempt failed", "err", err)
+		return "", errno.InternalServerError
+	}
+	if attempts > PICKUP_CODE_ATTEMPTS {
+		return "", ErrPickupCodeLimited
+	}
+	globalAttempts, err := reserveAttemptScript.Run(ctx, s.redisClient, []string{globalKey}, PICKUP_CODE_WINDOW.Milliseconds()).Int64()
+	if err != nil {
+		log.C(ctx).Warnw("count pickup code attempt failed", "err", err)
+		s.releasePickupCodeAttempt(ctx, failKey)
+		return "", errno.InternalServerError
+	}
+	if globalAttempts > PICKUP_CODE_GLOBAL_ATTEMPTS {
+		// the ip didn't try
+		s.releasePickupCodeAttempt(ctx, failKey)
+		log.C(ctx).Warnw("pickup codes locked for everyone", "window", PICKUP_CODE_WINDOW)
+		return "", ErrPickupCodeLimited
+	}
+
+	code = pickupcode.Normalize(code)
+	key := ""
+	if len(code) > 0 {
+		key, err = s.redisClient.Get(ctx, dbredis.REDIS_PICKUP_CODE_PREFIX+code).Result()
+		if err != nil && err != redis.Nil {
+			log.C(ctx).Warnw("read pickup code failed", "err", err)
+			s.releasePickupCodeAttempt(ctx, failKey)
+			s.releasePickupCodeAttempt(ctx, globalKey)
+			return "", errno.InternalServerError
+		}
+	}
+	if len(key) == 0 {
+		log.C(ctx).Infow("wrong pickup code", "ip", ip, "attempts", attempts)
+		return "", errPickupCodeNotFound
+	}
+	s.releasePickupCodeAttempt(ctx, failKey)
+	s.releasePickupCodeAttempt(ctx, globalKey)
+
+	records, _, err := s.loadShares(ctx, []string{key})
+	if err != nil {
+		log.C(ctx).Warnw("load share failed", "err", err)
+		return "", errno.InternalServerError
+	}
+	// the code was right, the link behind it ended since
+	if len(records) != 1 || s.checkShareOpen(ctx, &records[0]) != nil {
+		s.redisClient.Del(ctx, dbredis.REDIS_PICKUP_CODE_PREFIX+code)
+		return "", errPickupCodeNotFound
+	}
+	return records[0].info.Path, nil
+}
+
+func (s *shareService) releasePickupCodeAttempt(ctx context.Context, failKey string) {
+	if err := releaseAttemptScript.Run(ctx, s.redisClient, []string{failKey}).Err(); err != nil {
+		log.C(ctx).Warnw("release pickup code attempt failed", "err", err)
+	}
+}
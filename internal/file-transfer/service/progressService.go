@@ -0,0 +1,163 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	v1 "file-transfer/pkg/api/v1"
+	"file-transfer/pkg/common"
+	"file-transfer/pkg/db/dbredis"
+	"file-transfer/pkg/errno"
+	"file-transfer/pkg/log"
+	"io"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	// keep the last event for late subscribers
+	UPLOAD_PROGRESS_EXPIRE time.Duration = 10 * time.Minute
+	// publish received bytes at most once per step or interval
+	UPLOAD_PROGRESS_STEP     int64         = 1024 * 1024
+	UPLOAD_PROGRESS_INTERVAL time.Duration = 500 * time.Millisecond
+)
+
+type ProgressService interface {
+	Publish(ctx context.Context, userId string, event *v1.UploadProgressEvent)
+	Subscribe(ctx context.Context, userId string, uploadId string) (<-chan v1.UploadProgressEvent, func(), error)
+}
+
+type progressService struct {
+	redisClient *redis.Client
+}
+
+var _ ProgressService = (*progressService)(nil)
+
+func NewProgressService(rClient *redis.Client) ProgressService {
+	return &progressService{redisClient: rClient}
+}
+
+// channel is scoped by user, so one can't subscribe to others upload
+func progressChannel(userId string, uploadId string) string {
+	return dbredis.REDIS_UPLOAD_PROGRESS_PREFIX + userId + "-" + uploadId
+}
+
+func progressLastKey(userId string, uploadId string) string {
+	return progressChannel(userId, uploadId) + "-last"
+}
+
+func (s *progressService) Publish(ctx context.Context, userId string, event *v1.UploadProgressEvent) {
+	if event == nil || len(event.UploadId) < 1 {
+		return
+	}
+	event.Time = time.Now()
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.C(ctx).Warnw("progress marshal failed", "err", err)
+		return
+	}
+	channel := progressChannel(userId, event.UploadId)
+	pipe := s.redisClient.Pipeline()
+	pipe.Set(ctx, progressLastKey(userId, event.UploadId), data, UPLOAD_PROGRESS_EXPIRE)
+	pipe.Publish(ctx, channel, data)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.C(ctx).Warnw("progress publish failed", "channel", channel, "err", err)
+	}
+}
+
+// Subscribe sends the last event published and the ones after it. The channel is closed after the
+// complete event of the upload, or by the close function
+func (s *progressService) Subscribe(ctx context.Context, userId string, uploadId string) (<-chan v1.UploadProgressEvent, func(), error) {
+	if len(uploadId) < 1 {
+		return nil, nil, errno.ErrInvalidParameter
+	}
+	pubsub := s.redisClient.Subscribe(ctx, progressChannel(userId, uploadId))
+	// wait for the confirmation, so no event is lost between reading last and listening
+	if _, err := pubsub.Receive(ctx); err != nil {
+		log.C(ctx).Warnw("progress subscribe failed", "err", err)
+		pubsub.Close()
+		return nil, nil, errno.InternalServerError
+	}
+
+	out := make(chan v1.UploadProgressEvent, 16)
+	done := make(chan struct{})
+	go func() {
+		defer close(out)
+		var last v1.UploadProgressEvent
+		data, err := s.redisClient.Get(ctx, progressLastKey(userId, uploadId)).Bytes()
+		if err == nil && json.Unmarshal(data, &last) == nil {
+			select {
+			case out <- last:
+			case <-done:
+				return
+			}
+			if last.Stage == common.UPLOAD_STAGE_COMPLETE {
+				return
+			}
+		}
+		messages := pubsub.Channel()
+		for {
+			select {
+			case <-done:
+				return
+			case msg, ok := <-messages:
+				if !ok {
+					return
+				}
+				var event v1.UploadProgressEvent
+				if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil {
+					continue
+				}
+				select {
+				case out <- event:
+				case <-done:
+					return
+				}
+				// the upload is over, nothing comes after
+				if event.Stage == common.UPLOAD_STAGE_COMPLETE {
+					return
+				}
+			}
+		}
+	}()
+	closeFn := func() {
+		close(done)
+		pubsub.Close()
+	}
+	return out, closeFn, nil
+}
+
+// progressReader reports the bytes read from an upload stream
+type progressReader struct {
+	ctx       context.Context
+	r         io.Reader
+	serv      ProgressService
+	userId    string
+	event     v1.UploadProgressEvent
+	published int64
+	lastTime  time.Time
+}
+
+func newProgressReader(ctx context.Context, r io.Reader, serv ProgressService, userId string, event v1.UploadProgressEvent) *progressReader {
+	event.Stage = common.UPLOAD_STAGE_RECEIVING
+	return &progressReader{ctx: ctx, r: r, serv: serv, userId: userId, event: event, lastTime: time.Now()}
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.event.Bytes += int64(n)
+	if err != nil || p.event.Bytes-p.published >= UPLOAD_PROGRESS_STEP || time.Since(p.lastTime) >= UPLOAD_PROGRESS_INTERVAL {
+		p.flush()
+	}
+	return n, err
+}
+
+func (p *progressReader) flush() {
+	if p.event.Bytes == p.published && p.published > 0 {
+		return
+	}
+	p.published = p.event.Bytes
+	p.lastTime = time.Now()
+	event := p.event
+	p.serv.Publish(p.ctx, p.userId, &event)
+}
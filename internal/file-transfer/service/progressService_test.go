@@ -0,0 +1,75 @@
+package service
+
+import (
+	"context"
+	"file-transfer/internal/file-transfer/repo/repotest"
+	v1 "file-transfer/pkg/api/v1"
+	"file-transfer/pkg/common"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUploadProgress(t *testing.T) {
+	SAVE_FILE_PATH = t.TempDir()
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	progressServ := NewProgressService(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+	fileServ := &fileService{fileRepo: repotest.NewMemFileRepo(), progressServ: progressServ}
+
+	_, _, err := progressServ.Subscribe(ctx, "u1", "")
+	assert.NotNil(t, err)
+	events, closeFn, err := progressServ.Subscribe(ctx, "u1", "up1")
+	assert.Nil(t, err)
+	defer closeFn()
+	// another user's channel gets nothing
+	others, closeOthers, err := progressServ.Subscribe(ctx, "u2", "up1")
+	assert.Nil(t, err)
+	defer closeOthers()
+
+	results, err := fileServ.UploadFiles(ctx, multipartFiles(t, map[string]string{"a.txt": "hello"}), &v1.FileUploadParam{UserId: "u1", UploadId: "up1"})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(results))
+
+	stages := make([]common.UploadStage, 0)
+	var done, complete v1.UploadProgressEvent
+	timeout := time.After(5 * time.Second)
+	for open := true; open; {
+		select {
+		case event, ok := <-events:
+			open = ok
+			if !ok {
+				break
+			}
+			assert.Equal(t, "up1", event.UploadId)
+			stages = append(stages, event.Stage)
+			switch event.Stage {
+			case common.UPLOAD_STAGE_DONE:
+				done = event
+			case common.UPLOAD_STAGE_COMPLETE:
+				complete = event
+			}
+		case <-timeout:
+			t.Fatal("the subscription didn't end with the upload")
+		}
+	}
+	assert.Equal(t, common.UPLOAD_STAGE_RECEIVING, stages[0])
+	assert.Equal(t, common.UPLOAD_STAGE_COMPLETE, stages[len(stages)-1], "the channel is closed after complete")
+	assert.Equal(t, "a.txt", done.Name)
+	assert.Equal(t, results[0].Id, done.FileId)
+	assert.Equal(t, 1, complete.Count)
+	assert.Empty(t, complete.Code)
+	assert.Empty(t, others)
+
+	// a late subscriber gets the complete event kept as the last one and is done
+	late, closeLate, err := progressServ.Subscribe(ctx, "u1", "up1")
+	assert.Nil(t, err)
+	defer closeLate()
+	event := <-late
+	assert.Equal(t, common.UPLOAD_STAGE_COMPLETE, event.Stage)
+	_, ok := <-late
+	assert.False(t, ok)
+}
@@ -0,0 +1,551 @@
+package service
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"file-transfer/internal/file-transfer/repo"
+	v1 "file-transfer/pkg/api/v1"
+	"file-transfer/pkg/encrypt/aesencrypt"
+	"file-transfer/pkg/errno"
+	"file-transfer/pkg/log"
+	"file-transfer/pkg/model"
+	"file-transfer/pkg/util"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	S3_MULTIPART_DIR   = ".s3-multipart"
+	S3_UPLOAD_FILE     = "upload.json"
+	S3_UPLOAD_ID_LEN   = 32
+	S3_MAX_PART_NUMBER = 10000
+	// sha1 of no content
+	S3_EMPTY_ETAG = "da39a3ee5e6b4b0d3255bfef95601890afd80709"
+)
+
+// abandoned multipart uploads are removed when a new one starts
+var S3_MULTIPART_EXPIRE = 7 * 24 * time.Hour
+
+var (
+	s3UploadIdPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	s3PartETagPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+	errS3InvalidAccessKeyId = &errno.Errno{HTTP: http.StatusForbidden, Code: "InvalidAccessKeyId", Message: "The access key Id you provided does not exist in our records."}
+	errS3InvalidBucketName  = &errno.Errno{HTTP: http.StatusBadRequest, Code: "InvalidBucketName", Message: "The specified bucket is not valid."}
+	errS3InvalidArgument    = &errno.Errno{HTTP: http.StatusBadRequest, Code: "InvalidArgument", Message: "Invalid argument."}
+	errS3InvalidPart        = &errno.Errno{HTTP: http.StatusBadRequest, Code: "InvalidPart", Message: "One or more of the specified parts could not be found."}
+	errS3InvalidPartOrder   = &errno.Errno{HTTP: http.StatusBadRequest, Code: "InvalidPartOrder", Message: "The list of parts was not in ascending order."}
+	errS3EntityTooLarge     = &errno.Errno{HTTP: http.StatusBadRequest, Code: "EntityTooLarge", Message: "Your proposed upload exceeds the maximum allowed size."}
+	errS3NoSuchBucket       = &errno.Errno{HTTP: http.StatusNotFound, Code: "NoSuchBucket", Message: "The specified bucket does not exist."}
+	errS3NoSuchKey          = &errno.Errno{HTTP: http.StatusNotFound, Code: "NoSuchKey", Message: "The specified key does not exist."}
+	errS3NoSuchUpload       = &errno.Errno{HTTP: http.StatusNotFound, Code: "NoSuchUpload", Message: "The specified multipart upload does not exist."}
+)
+
+// S3Service maps buckets to the top level folders of a user and objects to UserFile
+type S3Service interface {
+	LookupAccessKey(ctx context.Context, accessKeyId string) (string, string, error)
+
+	ListBuckets(ctx context.Context, userId string) ([]v1.S3Bucket, error)
+	CreateBucket(ctx context.Context, userId string, bucket string) error
+	HeadBucket(ctx context.Context, userId string, bucket string) error
+	ListObjects(ctx context.Context, userId string, bucket string, q *v1.S3ListQuery) (*v1.S3ListResult, error)
+
+	PutObject(ctx context.Context, userId string, bucket string, key string, body io.Reader) (*v1.S3Object, error)
+	GetObject(ctx context.Context, userId string, bucket string, key string) (*v1.S3Object, error)
+	DeleteObject(ctx context.Context, userId string, bucket string, key string) error
+
+	CreateMultipartUpload(ctx context.Context, userId string, bucket string, key string) (string, error)
+	UploadPart(ctx context.Context, userId string, bucket string, key string, uploadId string, partNumber int, body io.Reader) (string, error)
+	CompleteMultipartUpload(ctx context.Context, userId string, bucket string, key string, uploadId string, parts []v1.S3CompletedPart) (*v1.S3Object, error)
+	AbortMultipartUpload(ctx context.Context, userId string, bucket string, key string, uploadId string) error
+}
+
+type s3Service struct {
+	fileRepo repo.FileRepo
+	userRepo repo.UserRepo
+	fileServ FileService
+}
+
+var _ S3Service = (*s3Service)(nil)
+
+func NewS3Service(fileRepo repo.FileRepo, userRepo repo.UserRepo, fileServ FileService) S3Service {
+	return &s3Service{fileRepo: fileRepo, userRepo: userRepo, fileServ: fileServ}
+}
+
+// s3Upload is kept with the parts of a multipart upload, so any instance sharing the upload path can finish it
+type s3Upload struct {
+	UserId    string    `json:"userId"`
+	Bucket    string    `json:"bucket"`
+	Key       string    `json:"key"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// LookupAccessKey returns the user id and the plain secret of an access key
+func (s *s3Service) LookupAccessKey(ctx context.Context, accessKeyId string) (string, string, error) {
+	key, err := s.userRepo.FindAccessKey(ctx, accessKeyId)
+	if err != nil {
+		log.C(ctx).Warnw("s3 access key not found", "accessKeyId", accessKeyId)
+		return "", "", errS3InvalidAccessKeyId
+	}
+	secret, err := aesencrypt.GetAESDecrypted(key.Secret)
+	if err != nil {
+		log.C(ctx).Errorw("s3 access key decrypt failed", "accessKeyId", accessKeyId, "err", err)
+		return "", "", errno.InternalServerError
+	}
+	return key.UserId, secret, nil
+}
+
+func validBucket(bucket string) bool {
+	return len(bucket) > 0 && len(bucket) <= 255 && bucket != "." && bucket != ".." &&
+		!strings.ContainsAny(bucket, "/\\")
+}
+
+// objectPath maps bucket and key to the folder and name of a UserFile
+func objectPath(bucket string, key string) (string, string, error) {
+	if !validBucket(bucket) {
+		return "", "", errS3InvalidBucketName
+	}
+	folder, name, err := util.SplitUploadPath(key)
+	if err != nil {
+		return "", "", errS3InvalidArgument
+	}
+	folder, err = util.NormalizeFolder(bucket + "/" + folder)
+	if err != nil {
+		return "", "", errS3InvalidArgument
+	}
+	return folder, name, nil
+}
+
+func objectKey(bucket string, userFile *model.UserFile) string {
+	rel := strings.TrimPrefix(strings.TrimPrefix(userFile.Folder, bucket), "/")
+	if rel == "" {
+		return userFile.Name
+	}
+	return rel + "/" + userFile.Name
+}
+
+func (s *s3Service) objectOf(ctx context.Context, bucket string, userFile *model.UserFile) (*v1.S3Object, error) {
+	metas, err := s.fileRepo.FindByMetaId(ctx, []string{userFile.MetaId})
+	if err != nil {
+		log.C(ctx).Errorw("s3 find meta failed", "metaId", userFile.MetaId, "err", err)
+		return nil, errno.InternalServerError
+	}
+	if len(metas) != 1 {
+		return nil, errS3NoSuchKey
+	}
+	return &v1.S3Object{
+		Key:          objectKey(bucket, userFile),
+		Location:     filepath.Join(SAVE_FILE_PATH, metas[0].Location),
+		Size:         metas[0].Size,
+		ETag:         metas[0].Sha,
+		LastModified: userFile.CreatedAt,
+	}, nil
+}
+
+func (s *s3Service) ListBuckets(ctx context.Context, userId string) ([]v1.S3Bucket, error) {
+	folders, err := s.fileRepo.QueryUserFolderUnder(ctx, userId, "")
+	if err != nil {
+		log.C(ctx).Errorw("s3 list buckets failed", "err", err)
+		return nil, errno.InternalServerError
+	}
+	files, err := s.fileRepo.QueryUserFileUnder(ctx, userId, "")
+	if err != nil {
+		log.C(ctx).Errorw("s3 list buckets failed", "err", err)
+		return nil, errno.InternalServerError
+	}
+	created := make(map[string]time.Time)
+	add := func(folder string, t time.Time) {
+		name, _, _ := strings.Cut(folder, "/")
+		if name == "" {
+			return
+		}
+		if old, ok := created[name]; !ok || t.Before(old) {
+			created[name] = t
+		}
+	}
+	for _, f := range folders {
+		add(f.Path, f.CreatedAt)
+	}
+	for _, f := range files {
+		add(f.Folder, f.CreatedAt)
+	}
+	buckets := make([]v1.S3Bucket, 0, len(created))
+	for name, t := range created {
+		buckets = append(buckets, v1.S3Bucket{Name: name, CreationDate: t})
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].Name < buckets[j].Name })
+	return buckets, nil
+}
+
+func (s *s3Service) CreateBucket(ctx context.Context, userId string, bucket string) error {
+	if !validBucket(bucket) {
+		return errS3InvalidBucketName
+	}
+	if folder, _ := s.fileRepo.FindUserFolder(ctx, userId, bucket); folder != nil {
+		return nil
+	}
+	_, err := s.fileRepo.InsertUserFolder(ctx, &model.UserFolder{UserId: userId, Path: bucket, CreatedAt: time.Now()})
+	if err != nil {
+		log.C(ctx).Errorw("s3 create bucket failed", "bucket", bucket, "err", err)
+		return errno.InternalServerError
+	}
+	return nil
+}
+
+func (s *s3Service) HeadBucket(ctx context.Context, userId string, bucket string) error {
+	if !validBucket(bucket) {
+		return errS3InvalidBucketName
+	}
+	exist, err := userFolderExists(ctx, s.fileRepo, userId, bucket)
+	if err != nil {
+		log.C(ctx).Errorw("s3 head bucket failed", "bucket", bucket, "err", err)
+		return errno.InternalServerError
+	}
+	if !exist {
+		return errS3NoSuchBucket
+	}
+	return nil
+}
+
+// ListObjects pages the keys under the bucket in order, keys containing the delimiter after the prefix
+// are rolled up to common prefixes
+func (s *s3Service) ListObjects(ctx context.Context, userId string, bucket string, q *v1.S3ListQuery) (*v1.S3ListResult, error) {
+	if err := s.HeadBucket(ctx, userId, bucket); err != nil {
+		return nil, err
+	}
+	files, err := s.fileRepo.QueryUserFileUnder(ctx, userId, bucket)
+	if err != nil {
+		log.C(ctx).Errorw("s3 list objects failed", "bucket", bucket, "err", err)
+		return nil, errno.InternalServerError
+	}
+	byKey := make(map[string]*model.UserFile)
+	keys := make([]string, 0, len(files))
+	for i := range files {
+		key := objectKey(bucket, &files[i])
+		if strings.HasPrefix(key, q.Prefix) {
+			byKey[key] = &files[i]
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	result := &v1.S3ListResult{CommonPrefixes: make([]string, 0)}
+	page := make([]*model.UserFile, 0)
+	seen := make(map[string]bool)
+	count := 0
+	for _, key := range keys {
+		if key <= q.Marker {
+			continue
+		}
+		// a common prefix as marker skips all its keys
+		if q.Delimiter != "" && strings.HasSuffix(q.Marker, q.Delimiter) && strings.HasPrefix(key, q.Marker) {
+			continue
+		}
+		entry, isPrefix := key, false
+		if q.Delimiter != "" {
+			if idx := strings.Index(key[len(q.Prefix):], q.Delimiter); idx >= 0 {
+				entry, isPrefix = key[:len(q.Prefix)+idx+len(q.Delimiter)], true
+			}
+		}
+		if isPrefix && seen[entry] {
+			continue
+		}
+		if count >= q.MaxKeys {
+			result.IsTruncated = true
+			break
+		}
+		count++
+		result.NextMarker = entry
+		if isPrefix {
+			seen[entry] = true
+			result.CommonPrefixes = append(result.CommonPrefixes, entry)
+		} else {
+			page = append(page, byKey[key])
+		}
+	}
+	if !result.IsTruncated {
+		result.NextMarker = ""
+	}
+
+	ids := make([]string, len(page))
+	for i, f := range page {
+		ids[i] = f.MetaId
+	}
+	metas, err := s.fileRepo.FindByMetaId(ctx, ids)
+	if err != nil {
+		log.C(ctx).Errorw("s3 list objects failed", "bucket", bucket, "err", err)
+		return nil, errno.InternalServerError
+	}
+	metaMap := make(map[string]model.FileMeta)
+	for _, m := range metas {
+		metaMap[m.Id] = m
+	}
+	result.Objects = make([]v1.S3Object, len(page))
+	for i, f := range page {
+		result.Objects[i] = v1.S3Object{
+			Key:          objectKey(bucket, f),
+			Size:         metaMap[f.MetaId].Size,
+			ETag:         metaMap[f.MetaId].Sha,
+			LastModified: f.CreatedAt,
+		}
+	}
+	return result, nil
+}
+
+func (s *s3Service) PutObject(ctx context.Context, userId string, bucket string, key string, body io.Reader) (*v1.S3Object, error) {
+	if err := s.HeadBucket(ctx, userId, bucket); err != nil {
+		return nil, err
+	}
+	// clients create folders with an empty object ending in "/"
+	if strings.HasSuffix(key, "/") {
+		folder, err := util.NormalizeFolder(bucket + "/" + key)
+		if err != nil {
+			return nil, errS3InvalidArgument
+		}
+		io.Copy(io.Discard, body)
+		if exist, _ := s.fileRepo.FindUserFolder(ctx, userId, folder); exist == nil {
+			if _, err := s.fileRepo.InsertUserFolder(ctx, &model.UserFolder{UserId: userId, Path: folder, CreatedAt: time.Now()}); err != nil {
+				log.C(ctx).Errorw("s3 create folder failed", "folder", folder, "err", err)
+				return nil, errno.InternalServerError
+			}
+		}
+		return &v1.S3Object{Key: key, ETag: S3_EMPTY_ETAG, LastModified: time.Now()}, nil
+	}
+	return s.putObject(ctx, userId, bucket, key, body)
+}
+
+func (s *s3Service) putObject(ctx context.Context, userId string, bucket string, key string, body io.Reader) (*v1.S3Object, error) {
+	folder, name, err := objectPath(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	userFileId, err := s.fileServ.UploadFile(ctx, body, &v1.FileUploadParam{
+		UserId:    userId,
+		Name:      name,
+		Folder:    folder,
+		Overwrite: true,
+	})
+	if err != nil {
+		if _, code, _ := errno.Decode(err); code == "InvalidParameter.FileTooLarge" {
+			return nil, errS3EntityTooLarge
+		}
+		return nil, err
+	}
+	userFile, err := s.fileRepo.QueryUserFileById(ctx, userFileId)
+	if err != nil {
+		return nil, errS3NoSuchKey
+	}
+	return s.objectOf(ctx, bucket, userFile)
+}
+
+func (s *s3Service) findObject(ctx context.Context, userId string, bucket string, key string) (*model.UserFile, error) {
+	folder, name, err := objectPath(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	userFile, err := s.fileRepo.FindOneByNameAndUser(ctx, name, folder, userId)
+	if err != nil {
+		return nil, errS3NoSuchKey
+	}
+	return userFile, nil
+}
+
+func (s *s3Service) GetObject(ctx context.Context, userId string, bucket string, key string) (*v1.S3Object, error) {
+	userFile, err := s.findObject(ctx, userId, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	metas, err := s.fileRepo.FindByMetaId(ctx, []string{userFile.MetaId})
+	if err == nil && len(metas) == 1 {
+		if _, err := checkBlob(ctx, &metas[0]); err != nil {
+			return nil, err
+		}
+	}
+	return s.objectOf(ctx, bucket, userFile)
+}
+
+// DeleteObject succeeds for missing keys like S3 does
+func (s *s3Service) DeleteObject(ctx context.Context, userId string, bucket string, key string) error {
+	userFile, err := s.findObject(ctx, userId, bucket, key)
+	if err == errS3NoSuchKey {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return s.fileServ.DeleteFile(ctx, userFile.Id, userId)
+}
+
+func multipartDir(uploadId string) string {
+	return filepath.Join(SAVE_FILE_PATH, S3_MULTIPART_DIR, uploadId)
+}
+
+func (s *s3Service) CreateMultipartUpload(ctx context.Context, userId string, bucket string, key string) (string, error) {
+	if _, _, err := objectPath(bucket, key); err != nil {
+		return "", err
+	}
+	if err := s.HeadBucket(ctx, userId, bucket); err != nil {
+		return "", err
+	}
+	s.cleanStaleUploads(ctx)
+
+	uploadId, err := util.GenerateRandomString(S3_UPLOAD_ID_LEN)
+	if err != nil {
+		return "", errno.InternalServerError
+	}
+	dir := multipartDir(uploadId)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		log.C(ctx).Errorw("s3 create multipart dir failed", "dir", dir, "err", err)
+		return "", errUploadSave
+	}
+	data, _ := json.Marshal(&s3Upload{UserId: userId, Bucket: bucket, Key: key, CreatedAt: time.Now()})
+	if err := os.WriteFile(filepath.Join(dir, S3_UPLOAD_FILE), data, 0o600); err != nil {
+		log.C(ctx).Errorw("s3 create multipart failed", "dir", dir, "err", err)
+		os.RemoveAll(dir)
+		return "", errUploadSave
+	}
+	log.C(ctx).Infow("s3 multipart upload created", "uploadId", uploadId, "bucket", bucket, "key", key)
+	return uploadId, nil
+}
+
+func (s *s3Service) cleanStaleUploads(ctx context.Context) {
+	root := filepath.Join(SAVE_FILE_PATH, S3_MULTIPART_DIR)
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || time.Since(info.ModTime()) < S3_MULTIPART_EXPIRE {
+			continue
+		}
+		log.C(ctx).Infow("s3 remove stale multipart upload", "uploadId", entry.Name())
+		os.RemoveAll(filepath.Join(root, entry.Name()))
+	}
+}
+
+// loadUpload returns the part dir of an upload started by the user for the same object
+func loadUpload(userId string, bucket string, key string, uploadId string) (string, error) {
+	if len(uploadId) != S3_UPLOAD_ID_LEN || !s3UploadIdPattern.MatchString(uploadId) {
+		return "", errS3NoSuchUpload
+	}
+	dir := multipartDir(uploadId)
+	data, err := os.ReadFile(filepath.Join(dir, S3_UPLOAD_FILE))
+	if err != nil {
+		return "", errS3NoSuchUpload
+	}
+	upload := &s3Upload{}
+	if err := json.Unmarshal(data, upload); err != nil {
+		return "", errS3NoSuchUpload
+	}
+	if upload.UserId != userId || upload.Bucket != bucket || upload.Key != key {
+		return "", errS3NoSuchUpload
+	}
+	return dir, nil
+}
+
+func partPattern(partNumber int) string {
+	return fmt.Sprintf("%05d.*", partNumber)
+}
+
+// UploadPart stores a part as "<number>.<md5>", the md5 is the ETag the client sends back on complete
+func (s *s3Service) UploadPart(ctx context.Context, userId string, bucket string, key string, uploadId string, partNumber int, body io.Reader) (string, error) {
+	if partNumber < 1 || partNumber > S3_MAX_PART_NUMBER {
+		return "", errS3InvalidArgument
+	}
+	dir, err := loadUpload(userId, bucket, key, uploadId)
+	if err != nil {
+		return "", err
+	}
+	temp, err := os.CreateTemp(dir, TEMP_FILE_PATTERN)
+	if err != nil {
+		log.C(ctx).Errorw("s3 create part failed", "uploadId", uploadId, "err", err)
+		return "", errUploadSave
+	}
+	defer func() {
+		temp.Close()
+		os.Remove(temp.Name())
+	}()
+
+	h := md5.New()
+	size, err := io.Copy(io.MultiWriter(temp, h), io.LimitReader(body, MAX_SINGLE_FILE_SIZE))
+	if err != nil {
+		log.C(ctx).Warnw("s3 part copy failed", "uploadId", uploadId, "part", partNumber, "err", err)
+		return "", errUploadSave
+	}
+	if size >= MAX_SINGLE_FILE_SIZE {
+		return "", errS3EntityTooLarge
+	}
+	etag := hex.EncodeToString(h.Sum(nil))
+
+	// a part uploaded again replaces the old one
+	old, _ := filepath.Glob(filepath.Join(dir, partPattern(partNumber)))
+	for _, p := range old {
+		os.Remove(p)
+	}
+	if err := os.Rename(temp.Name(), filepath.Join(dir, fmt.Sprintf("%05d.%s", partNumber, etag))); err != nil {
+		log.C(ctx).Errorw("s3 save part failed", "uploadId", uploadId, "part", partNumber, "err", err)
+		return "", errUploadSave
+	}
+	return etag, nil
+}
+
+func (s *s3Service) CompleteMultipartUpload(ctx context.Context, userId string, bucket string, key string, uploadId string, parts []v1.S3CompletedPart) (*v1.S3Object, error) {
+	dir, err := loadUpload(userId, bucket, key, uploadId)
+	if err != nil {
+		return nil, err
+	}
+	if len(parts) < 1 {
+		return nil, errS3InvalidPart
+	}
+	readers := make([]io.Reader, 0, len(parts))
+	defer func() {
+		for _, r := range readers {
+			r.(*os.File).Close()
+		}
+	}()
+	for i, part := range parts {
+		if i > 0 && part.PartNumber <= parts[i-1].PartNumber {
+			return nil, errS3InvalidPartOrder
+		}
+		etag := strings.Trim(part.ETag, `"`)
+		if part.PartNumber < 1 || part.PartNumber > S3_MAX_PART_NUMBER || !s3PartETagPattern.MatchString(etag) {
+			return nil, errS3InvalidPart
+		}
+		file, err := os.Open(filepath.Join(dir, fmt.Sprintf("%05d.%s", part.PartNumber, etag)))
+		if err != nil {
+			return nil, errS3InvalidPart
+		}
+		readers = append(readers, file)
+	}
+
+	object, err := s.putObject(ctx, userId, bucket, key, io.MultiReader(readers...))
+	if err != nil {
+		return nil, err
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		log.C(ctx).Warnw("s3 remove multipart dir failed", "dir", dir, "err", err)
+	}
+	return object, nil
+}
+
+func (s *s3Service) AbortMultipartUpload(ctx context.Context, userId string, bucket string, key string, uploadId string) error {
+	dir, err := loadUpload(userId, bucket, key, uploadId)
+	if err != nil {
+		return err
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		log.C(ctx).Errorw("s3 abort multipart failed", "dir", dir, "err", err)
+		return errno.InternalServerError
+	}
+	return nil
+}
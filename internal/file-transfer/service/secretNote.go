@@ -0,0 +1,189 @@
+package service
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	v1 "file-transfer/pkg/api/v1"
+	"file-transfer/pkg/common"
+	"file-transfer/pkg/db/dbredis"
+	"file-transfer/pkg/errno"
+	"file-transfer/pkg/log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	SECRET_NOTE_MAX_SIZE = 64 * 1024
+	// bytes of the key of a note
+	SECRET_NOTE_SECRET_SIZE = 32
+)
+
+var (
+	SECRET_NOTE_EXPIRE     time.Duration = 24 * time.Hour
+	SECRET_NOTE_MAX_EXPIRE time.Duration = MESSAGE_SHARE_LINK_EXPIRE
+)
+
+var (
+	errSecretNoteInvalid = &errno.Errno{HTTP: http.StatusBadRequest, Code: "InvalidParameter.SecretNote",
+		Message: "a note needs 1 to 65536 bytes and an expire up to 7 days"}
+	// a wrong secret and a note gone answer the same
+	errSecretNoteGone = &errno.Errno{HTTP: http.StatusNotFound, Code: "NotFound.SecretNote", Message: "the note was read, expired or never existed"}
+)
+
+// CreateSecretNote encrypts the note with a random key which is returned and forgotten. Redis keeps
+// the sealed note and the sha256 of the key, to refuse wrong keys without using the link up.
+// With a passphrase the note is sealed by HMAC(key, passphrase), so the link alone can't open it
+func (s *messageService) CreateSecretNote(ctx context.Context, userId string, param *v1.SecretNoteParam) (*v1.SecretNoteLink, error) {
+	expire := SECRET_NOTE_EXPIRE
+	if param.Expire != 0 {
+		expire = time.Duration(param.Expire) * time.Minute
+	}
+	if len(param.Info) == 0 || len(param.Info) > SECRET_NOTE_MAX_SIZE || expire <= 0 || expire > SECRET_NOTE_MAX_EXPIRE {
+		return nil, errSecretNoteInvalid
+	}
+	secret := make([]byte, SECRET_NOTE_SECRET_SIZE)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, errno.InternalServerError
+	}
+	noteId := uuid.New().String()
+	sealed, err := sealNote(noteKey(secret, param.Passphrase), noteId, []byte(param.Info))
+	if err != nil {
+		log.C(ctx).Errorw("seal secret note failed", "err", err)
+		return nil, errno.InternalServerError
+	}
+	check := sha256.Sum256(secret)
+	redisKey := dbredis.REDIS_SECRET_NOTE_PREFIX + noteId
+	pipe := s.redisClient.TxPipeline()
+	pipe.HSet(ctx, redisKey, "data", sealed, "check", check[:])
+	pipe.Expire(ctx, redisKey, expire)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.C(ctx).Warnw("store secret note failed", "err", err)
+		return nil, errno.InternalServerError
+	}
+	link, err := s.shareServ.CreateShareUrlWithTimes(ctx, common.SHARE_TYPE_SECRET_NOTE, userId, noteId, expire, 1, param.Passphrase)
+	if err != nil {
+		s.redisClient.Del(ctx, redisKey)
+		return nil, err
+	}
+	return &v1.SecretNoteLink{ShareLink: *link, Secret: base64.RawURLEncoding.EncodeToString(secret)}, nil
+}
+
+func (s *messageService) ReadSecretNote(ctx context.Context, key string, request *v1.SecretNoteReadRequest) (string, error) {
+	secret, err := base64.RawURLEncoding.DecodeString(request.Secret)
+	if err != nil || len(secret) != SECRET_NOTE_SECRET_SIZE {
+		return "", errSecretNoteGone
+	}
+	// the passphrase and the key are checked before the link is used up
+	noteId, err := s.shareServ.PeekShareUrl(ctx, common.SHARE_TYPE_SECRET_NOTE, key, SECRET_NOTE_MAX_EXPIRE, request.Passphrase)
+	if err != nil {
+		switch err {
+		case ErrSharePasswordWrong, ErrSharePasswordLimited:
+			s.shareServ.RecordAccess(ctx, key, common.SHARE_OUTCOME_WRONG_PASSWORD, 0)
+			return "", err
+		case ErrSharePasswordRequired:
+			return "", err
+		}
+		return "", errSecretNoteGone
+	}
+	redisKey := dbredis.REDIS_SECRET_NOTE_PREFIX + noteId
+	stored, err := s.redisClient.HGet(ctx, redisKey, "check").Bytes()
+	check := sha256.Sum256(secret)
+	if err != nil || subtle.ConstantTimeCompare(stored, check[:]) != 1 {
+		log.C(ctx).Infow("wrong secret note key", "key", key)
+		return "", errSecretNoteGone
+	}
+
+	// only one reader gets past the consume, the note goes in the same step as it is read
+	if _, err := s.shareServ.ConsumeShareUrl(ctx, common.SHARE_TYPE_SECRET_NOTE, key, SECRET_NOTE_MAX_EXPIRE, request.Passphrase); err != nil {
+		return "", errSecretNoteGone
+	}
+	pipe := s.redisClient.TxPipeline()
+	data := pipe.HGet(ctx, redisKey, "data")
+	pipe.Del(ctx, redisKey)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.C(ctx).Warnw("take secret note failed", "err", err)
+		return "", errSecretNoteGone
+	}
+	sealed, _ := data.Bytes()
+	info, err := openNote(noteKey(secret, request.Passphrase), noteId, sealed)
+	if err != nil {
+		log.C(ctx).Warnw("open secret note failed", "key", key, "err", err)
+		return "", errSecretNoteGone
+	}
+	s.shareServ.RecordAccess(ctx, key, common.SHARE_OUTCOME_SERVED, int64(len(info)))
+	return string(info), nil
+}
+
+func (s *messageService) ReadSecretNotePage(ctx context.Context, key string) (*v1.SharePage, error) {
+	status, err := s.shareServ.ShareStatus(ctx, common.SHARE_TYPE_SECRET_NOTE, key, SECRET_NOTE_MAX_EXPIRE)
+	if err != nil {
+		return nil, err
+	}
+	return &v1.SharePage{Path: status.Path, ExpireAt: status.ExpireAt, Protected: status.Protected}, nil
+}
+
+// SecretNoteStatus tells the sender whether the note was read, until the link is forgotten after SHARE_ENDED_RETAIN
+func (s *messageService) SecretNoteStatus(ctx context.Context, userId string, key string) (*v1.SecretNoteStatus, error) {
+	info, err := s.shareServ.GetShare(ctx, userId, key)
+	if err != nil {
+		return nil, err
+	}
+	if info.Type != "note" {
+		return nil, errno.ErrPageNotFound
+	}
+	return &v1.SecretNoteStatus{
+		Read:     info.Access.Served > 0,
+		ReadAt:   info.Access.LastAccessAt,
+		Expired:  info.Access.Served == 0 && !info.ExpireAt.After(time.Now()),
+		ExpireAt: info.ExpireAt,
+	}, nil
+}
+
+func noteKey(secret []byte, passphrase string) []byte {
+	if len(passphrase) == 0 {
+		return secret
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(passphrase))
+	return mac.Sum(nil)
+}
+
+// sealNote is AES-256-GCM, nonce first, the note id is authenticated with it
+func sealNote(key []byte, noteId string, plain []byte) ([]byte, error) {
+	gcm, err := newNoteCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plain, []byte(noteId)), nil
+}
+
+func openNote(key []byte, noteId string, sealed []byte) ([]byte, error) {
+	gcm, err := newNoteCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errSecretNoteGone
+	}
+	return gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], []byte(noteId))
+}
+
+func newNoteCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,148 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	v1 "file-transfer/pkg/api/v1"
+	"file-transfer/pkg/common"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestNoteService(t *testing.T) (MessageService, *miniredis.Miniredis) {
+	shareServ, mr := newTestShareServiceRedis(t)
+	return NewMessageService(nil, shareServ, redis.NewClient(&redis.Options{Addr: mr.Addr()})), mr
+}
+
+// storedNote is everything redis holds, keys and values
+func storedNote(mr *miniredis.Miniredis) string {
+	var sb strings.Builder
+	for _, key := range mr.Keys() {
+		sb.WriteString(key)
+		if value, err := mr.Get(key); err == nil {
+			sb.WriteString(value)
+		}
+		fields, _ := mr.HKeys(key)
+		for _, field := range fields {
+			sb.WriteString(field + mr.HGet(key, field))
+		}
+	}
+	return sb.String()
+}
+
+func TestSecretNoteReadOnce(t *testing.T) {
+	ctx := context.Background()
+	s, mr := newTestNoteService(t)
+	const text = "the door code is 4711"
+	link, err := s.CreateSecretNote(ctx, "u1", &v1.SecretNoteParam{Info: text})
+	assert.Nil(t, err)
+	key := path.Base(link.Path)
+	secret, _ := base64.RawURLEncoding.DecodeString(link.Secret)
+	assert.Len(t, secret, SECRET_NOTE_SECRET_SIZE)
+
+	// neither the text nor its key are stored
+	stored := storedNote(mr)
+	assert.NotContains(t, stored, text)
+	assert.NotContains(t, stored, string(secret))
+	assert.NotContains(t, stored, link.Secret)
+
+	// a wrong key doesn't open the note and doesn't use it up
+	wrong := make([]byte, SECRET_NOTE_SECRET_SIZE)
+	_, err = s.ReadSecretNote(ctx, key, &v1.SecretNoteReadRequest{Secret: base64.RawURLEncoding.EncodeToString(wrong)})
+	assert.Equal(t, errSecretNoteGone, err)
+	_, err = s.ReadSecretNote(ctx, key, &v1.SecretNoteReadRequest{Secret: "short"})
+	assert.Equal(t, errSecretNoteGone, err)
+	status, err := s.SecretNoteStatus(ctx, "u1", key)
+	assert.Nil(t, err)
+	assert.False(t, status.Read)
+
+	// readers racing for the note, one gets it
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	read := make([]string, 0)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if info, err := s.ReadSecretNote(ctx, key, &v1.SecretNoteReadRequest{Secret: link.Secret}); err == nil {
+				mu.Lock()
+				read = append(read, info)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, []string{text}, read)
+	for _, k := range mr.Keys() {
+		assert.False(t, strings.HasPrefix(k, "note-"), k)
+	}
+	_, err = s.ReadSecretNote(ctx, key, &v1.SecretNoteReadRequest{Secret: link.Secret})
+	assert.Equal(t, errSecretNoteGone, err)
+	_, err = s.ReadSecretNotePage(ctx, key)
+	assert.NotNil(t, err)
+
+	status, err = s.SecretNoteStatus(ctx, "u1", key)
+	assert.Nil(t, err)
+	assert.True(t, status.Read)
+	assert.NotNil(t, status.ReadAt)
+	_, err = s.SecretNoteStatus(ctx, "u2", key)
+	assert.NotNil(t, err)
+}
+
+func TestSecretNotePassphrase(t *testing.T) {
+	ctx := context.Background()
+	s, mr := newTestNoteService(t)
+	link, err := s.CreateSecretNote(ctx, "u1", &v1.SecretNoteParam{Info: "secret", Passphrase: "horse staple"})
+	assert.Nil(t, err)
+	key := path.Base(link.Path)
+
+	page, err := s.ReadSecretNotePage(ctx, key)
+	assert.Nil(t, err)
+	assert.True(t, page.Protected)
+	_, err = s.ReadSecretNote(ctx, key, &v1.SecretNoteReadRequest{Secret: link.Secret})
+	assert.Equal(t, ErrSharePasswordRequired, err)
+	_, err = s.ReadSecretNote(ctx, key, &v1.SecretNoteReadRequest{Secret: link.Secret, Passphrase: "battery"})
+	assert.Equal(t, ErrSharePasswordWrong, err)
+
+	// the stored note doesn't open with the link alone
+	var noteId, sealed string
+	for _, k := range mr.Keys() {
+		if strings.HasPrefix(k, "note-") {
+			noteId, sealed = strings.TrimPrefix(k, "note-"), mr.HGet(k, "data")
+		}
+	}
+	secret, _ := base64.RawURLEncoding.DecodeString(link.Secret)
+	_, err = openNote(noteKey(secret, ""), noteId, []byte(sealed))
+	assert.NotNil(t, err)
+
+	info, err := s.ReadSecretNote(ctx, key, &v1.SecretNoteReadRequest{Secret: link.Secret, Passphrase: "horse staple"})
+	assert.Nil(t, err)
+	assert.Equal(t, "secret", info)
+}
+
+func TestSecretNoteExpire(t *testing.T) {
+	ctx := context.Background()
+	s, mr := newTestNoteService(t)
+	_, err := s.CreateSecretNote(ctx, "u1", &v1.SecretNoteParam{Info: ""})
+	assert.Equal(t, errSecretNoteInvalid, err)
+	_, err = s.CreateSecretNote(ctx, "u1", &v1.SecretNoteParam{Info: "x", Expire: int64(SECRET_NOTE_MAX_EXPIRE/time.Minute) + 1})
+	assert.Equal(t, errSecretNoteInvalid, err)
+
+	link, err := s.CreateSecretNote(ctx, "u1", &v1.SecretNoteParam{Info: "soon gone", Expire: 5})
+	assert.Nil(t, err)
+	mr.FastForward(5 * time.Minute)
+	for _, k := range mr.Keys() {
+		assert.False(t, strings.HasPrefix(k, "note-"), k)
+		assert.False(t, strings.HasPrefix(k, common.SECRET_NOTE_PATH+"-"), k)
+	}
+	status, err := s.SecretNoteStatus(ctx, "u1", path.Base(link.Path))
+	assert.Nil(t, err)
+	assert.False(t, status.Read)
+}
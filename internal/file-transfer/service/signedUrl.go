@@ -0,0 +1,185 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"file-transfer/internal/file-transfer/repo"
+	v1 "file-transfer/pkg/api/v1"
+	"file-transfer/pkg/common"
+	"file-transfer/pkg/errno"
+	"file-transfer/pkg/log"
+	"file-transfer/pkg/urlsign"
+	"file-transfer/pkg/util"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	SIGNED_URL_SECRET_MIN_LEN = 32
+	SIGNED_URL_SALT_LEN       = 32
+	// network of the client the urls are bound to with bindIp
+	SIGNED_URL_BIND_BITS  = 24
+	SIGNED_URL_BIND_BITS6 = 64
+)
+
+var (
+	SIGNED_URL_EXPIRE     time.Duration = 15 * time.Minute
+	SIGNED_URL_MAX_EXPIRE time.Duration = 24 * time.Hour
+	// files signed by one request, enough for a page of a listing
+	SIGNED_URL_MAX_FILES = 200
+)
+
+var (
+	errSignedUrlInvalid  = &errno.Errno{HTTP: http.StatusBadRequest, Code: "InvalidParameter.SignedUrl", Message: "invalid signed url parameter"}
+	errSignedUrlDenied   = &errno.Errno{HTTP: http.StatusForbidden, Code: "Forbidden.SignedUrl", Message: "invalid signed url"}
+	errSignedUrlExpired  = &errno.Errno{HTTP: http.StatusForbidden, Code: "Forbidden.SignedUrlExpired", Message: "signed url expired"}
+	errSignedUrlIpDenied = &errno.Errno{HTTP: http.StatusForbidden, Code: "Forbidden.SignedUrlIp", Message: "signed url not valid from this network"}
+)
+
+// SignedUrlService signs download urls of own files. They are checked with the signature and the
+// salt of the user, without redis and without the auth header
+type SignedUrlService interface {
+	SignDownloadUrls(ctx context.Context, userId string, clientIp string, param *v1.SignedUrlParam) ([]v1.SignedUrl, error)
+	VerifyDownloadUrl(ctx context.Context, fileId string, query url.Values, clientIp string) (string, error)
+	RevokeDownloadUrls(ctx context.Context, userId string) error
+}
+
+type signedUrlService struct {
+	userRepo repo.UserRepo
+	fileRepo repo.FileRepo
+	secret   []byte
+}
+
+var _ SignedUrlService = (*signedUrlService)(nil)
+
+func NewSignedUrlService(userRepo repo.UserRepo, fileRepo repo.FileRepo) SignedUrlService {
+	if expire := viper.GetDuration("signed-url.expire"); expire > 0 {
+		SIGNED_URL_EXPIRE = expire
+	}
+	if expire := viper.GetDuration("signed-url.max-expire"); expire > 0 {
+		SIGNED_URL_MAX_EXPIRE = expire
+	}
+	if files := viper.GetInt("signed-url.max-files"); files > 0 {
+		SIGNED_URL_MAX_FILES = files
+	}
+	return &signedUrlService{userRepo: userRepo, fileRepo: fileRepo, secret: signedUrlSecret()}
+}
+
+// signedUrlSecret is "signed-url.secret", or a secret derived from the aes key when it isn't set.
+// Changing it revokes the signed urls of everyone
+func signedUrlSecret() []byte {
+	secret := viper.GetString("signed-url.secret")
+	if len(secret) == 0 {
+		log.Warnw("signed-url.secret is not configured, download urls are signed with a secret derived from the aes key")
+		mac := hmac.New(sha256.New, []byte(viper.GetString(common.VIPER_AES_KEY)))
+		mac.Write([]byte("signed-url"))
+		return mac.Sum(nil)
+	}
+	if len(secret) < SIGNED_URL_SECRET_MIN_LEN {
+		log.Fatalw("signed-url.secret is shorter than 32 bytes")
+	}
+	return []byte(secret)
+}
+
+// SignDownloadUrls signs the files of the user one by one, a file the user doesn't own gets an error instead of a url
+func (s *signedUrlService) SignDownloadUrls(ctx context.Context, userId string, clientIp string, param *v1.SignedUrlParam) ([]v1.SignedUrl, error) {
+	if len(param.FileIds) == 0 || len(param.FileIds) > SIGNED_URL_MAX_FILES {
+		return nil, errSignedUrlInvalid
+	}
+	expire := SIGNED_URL_EXPIRE
+	if param.Expire != 0 {
+		expire = time.Duration(param.Expire) * time.Minute
+		if expire <= 0 || expire > SIGNED_URL_MAX_EXPIRE {
+			return nil, errSignedUrlInvalid
+		}
+	}
+	ipPrefix, err := signedUrlIpPrefix(clientIp, param)
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.userRepo.FindById(ctx, userId)
+	if err != nil {
+		log.C(ctx).Warnw("signed url find user failed", "userId", userId, "err", err)
+		return nil, errno.InternalServerError
+	}
+	key := urlsign.UserKey(s.secret, userId, user.UrlSalt)
+
+	expireAt := time.Now().Add(expire)
+	results := make([]v1.SignedUrl, 0, len(param.FileIds))
+	for _, fileId := range param.FileIds {
+		result := v1.SignedUrl{FileId: fileId, ExpireAt: expireAt}
+		userFile, err := s.fileRepo.QueryUserFileById(ctx, fileId)
+		if err != nil || userFile.UserId != userId {
+			result.Error = errno.ErrPageNotFound.Message
+			results = append(results, result)
+			continue
+		}
+		query := urlsign.Sign(key, &urlsign.Claims{FileId: fileId, UserId: userId, Expire: expireAt, IpPrefix: ipPrefix})
+		result.Path = "/file/" + url.PathEscape(fileId) + "?" + query.Encode()
+		results = append(results, result)
+	}
+	log.C(ctx).Infow("signed download urls", "userId", userId, "files", len(results), "expireAt", expireAt, "ipPrefix", ipPrefix)
+	return results, nil
+}
+
+func signedUrlIpPrefix(clientIp string, param *v1.SignedUrlParam) (string, error) {
+	if param.BindIp {
+		prefix, err := urlsign.IpPrefix(clientIp, SIGNED_URL_BIND_BITS, SIGNED_URL_BIND_BITS6)
+		if err != nil {
+			return "", errSignedUrlInvalid
+		}
+		return prefix, nil
+	}
+	if len(param.IpPrefix) == 0 {
+		return "", nil
+	}
+	_, network, err := net.ParseCIDR(param.IpPrefix)
+	if err != nil {
+		return "", errSignedUrlInvalid
+	}
+	return network.String(), nil
+}
+
+// VerifyDownloadUrl returns the user the url was signed for
+func (s *signedUrlService) VerifyDownloadUrl(ctx context.Context, fileId string, query url.Values, clientIp string) (string, error) {
+	claims, err := urlsign.Parse(fileId, query)
+	if err != nil {
+		return "", errSignedUrlDenied
+	}
+	user, err := s.userRepo.FindById(ctx, claims.UserId)
+	if err != nil {
+		return "", errSignedUrlDenied
+	}
+	err = urlsign.Verify(urlsign.UserKey(s.secret, claims.UserId, user.UrlSalt), claims, query.Get(urlsign.PARAM_SIG), clientIp, time.Now())
+	switch err {
+	case nil:
+		return claims.UserId, nil
+	case urlsign.ErrExpired:
+		return "", errSignedUrlExpired
+	case urlsign.ErrIp:
+		log.C(ctx).Infow("signed url used from another network", "fileId", fileId, "ipPrefix", claims.IpPrefix)
+		return "", errSignedUrlIpDenied
+	default:
+		log.C(ctx).Warnw("signed url signature mismatch", "fileId", fileId, "userId", claims.UserId)
+		return "", errSignedUrlDenied
+	}
+}
+
+// RevokeDownloadUrls gives the user a new salt, the urls signed before stop working
+func (s *signedUrlService) RevokeDownloadUrls(ctx context.Context, userId string) error {
+	salt, err := util.GenerateRandomString(SIGNED_URL_SALT_LEN)
+	if err != nil {
+		return err
+	}
+	if err := s.userRepo.SetUrlSalt(ctx, userId, salt); err != nil {
+		log.C(ctx).Warnw("set url salt failed", "userId", userId, "err", err)
+		return errno.InternalServerError
+	}
+	log.C(ctx).Infow("signed download urls revoked", "userId", userId)
+	return nil
+}
@@ -0,0 +1,94 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"file-transfer/internal/file-transfer/repo"
+	"file-transfer/internal/file-transfer/repo/repotest"
+	v1 "file-transfer/pkg/api/v1"
+	"file-transfer/pkg/model"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type memSaltUserRepo struct {
+	repo.UserRepo
+	salts map[string]string
+}
+
+func (m *memSaltUserRepo) FindById(ctx context.Context, id string) (*model.UserInfo, error) {
+	salt, ok := m.salts[id]
+	if !ok {
+		return nil, mongo.ErrNoDocuments
+	}
+	return &model.UserInfo{Id: id, UrlSalt: salt}, nil
+}
+
+func (m *memSaltUserRepo) SetUrlSalt(ctx context.Context, id string, salt string) error {
+	m.salts[id] = salt
+	return nil
+}
+
+func signedQuery(t *testing.T, signed v1.SignedUrl) (string, url.Values) {
+	p, err := url.Parse(signed.Path)
+	assert.Nil(t, err)
+	return strings.TrimPrefix(p.Path, "/file/"), p.Query()
+}
+
+func TestSignedUrl(t *testing.T) {
+	SAVE_FILE_PATH = t.TempDir()
+	ctx := context.Background()
+	fileRepo := repotest.NewMemFileRepo()
+	fileServ := &fileService{fileRepo: fileRepo}
+	mine, err := fileServ.UploadFile(ctx, bytes.NewReader([]byte("mine")), &v1.FileUploadParam{UserId: "u1", Name: "a.png"})
+	assert.Nil(t, err)
+	theirs, err := fileServ.UploadFile(ctx, bytes.NewReader([]byte("theirs")), &v1.FileUploadParam{UserId: "u2", Name: "b.png"})
+	assert.Nil(t, err)
+	users := &memSaltUserRepo{salts: map[string]string{"u1": "", "u2": ""}}
+	s := &signedUrlService{userRepo: users, fileRepo: fileRepo, secret: []byte(strings.Repeat("s", 32))}
+
+	results, err := s.SignDownloadUrls(ctx, "u1", "203.0.113.9", &v1.SignedUrlParam{FileIds: []string{mine, theirs}, BindIp: true})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(results))
+	assert.NotEmpty(t, results[1].Error)
+	assert.Empty(t, results[1].Path)
+
+	fileId, q := signedQuery(t, results[0])
+	assert.Equal(t, mine, fileId)
+	assert.Equal(t, "203.0.113.0/24", q.Get("ip"))
+	userId, err := s.VerifyDownloadUrl(ctx, fileId, q, "203.0.113.77")
+	assert.Nil(t, err)
+	assert.Equal(t, "u1", userId)
+	_, err = s.VerifyDownloadUrl(ctx, fileId, q, "198.51.100.1")
+	assert.Equal(t, errSignedUrlIpDenied, err)
+	// the url can't be moved to another file or user
+	_, err = s.VerifyDownloadUrl(ctx, theirs, q, "203.0.113.77")
+	assert.Equal(t, errSignedUrlDenied, err)
+	q.Set("uid", "u2")
+	_, err = s.VerifyDownloadUrl(ctx, fileId, q, "203.0.113.77")
+	assert.Equal(t, errSignedUrlDenied, err)
+
+	_, err = s.SignDownloadUrls(ctx, "u1", "", &v1.SignedUrlParam{FileIds: []string{mine}, Expire: 25 * 60})
+	assert.Equal(t, errSignedUrlInvalid, err)
+	_, err = s.SignDownloadUrls(ctx, "u1", "", &v1.SignedUrlParam{FileIds: []string{mine}, IpPrefix: "nope"})
+	assert.Equal(t, errSignedUrlInvalid, err)
+
+	// a new salt revokes what was signed before, not what is signed after
+	results, err = s.SignDownloadUrls(ctx, "u1", "", &v1.SignedUrlParam{FileIds: []string{mine}})
+	assert.Nil(t, err)
+	fileId, q = signedQuery(t, results[0])
+	_, err = s.VerifyDownloadUrl(ctx, fileId, q, "198.51.100.1")
+	assert.Nil(t, err)
+	assert.Nil(t, s.RevokeDownloadUrls(ctx, "u1"))
+	_, err = s.VerifyDownloadUrl(ctx, fileId, q, "198.51.100.1")
+	assert.Equal(t, errSignedUrlDenied, err)
+	results, err = s.SignDownloadUrls(ctx, "u1", "", &v1.SignedUrlParam{FileIds: []string{mine}})
+	assert.Nil(t, err)
+	fileId, q = signedQuery(t, results[0])
+	_, err = s.VerifyDownloadUrl(ctx, fileId, q, "198.51.100.1")
+	assert.Nil(t, err)
+}
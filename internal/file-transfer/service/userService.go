@@ -2,14 +2,18 @@ package service
 
 import (
 	"context"
+	"crypto/rand"
 	"crypto/subtle"
 	"file-transfer/internal/file-transfer/repo"
 	v1 "file-transfer/pkg/api/v1"
 	"file-transfer/pkg/common"
+	"file-transfer/pkg/encrypt/aesencrypt"
 	"file-transfer/pkg/errno"
 	"file-transfer/pkg/log"
 	"file-transfer/pkg/model"
+	"file-transfer/pkg/token"
 	"file-transfer/pkg/util"
+	"net/http"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -22,8 +26,17 @@ var (
 type UserService interface {
 	CreateUser(ctx context.Context, username string) (*model.UserInfo, error)
 	Login(ctx context.Context, request v1.UserLoginRequest) (*model.UserInfo, error)
-	CreateLoginUrl(ctx context.Context, userId string) (string, error)
+	CreateLoginUrl(ctx context.Context, userId string) (*v1.ShareLink, error)
 	LoginByLoginUrl(ctx context.Context, key string) (*model.UserInfo, error)
+
+	CreateAppPassword(ctx context.Context, userId string, name string) (*v1.AppPasswordCreateResponse, error)
+	QueryAppPassword(ctx context.Context, userId string) ([]model.AppPassword, error)
+	DeleteAppPassword(ctx context.Context, id string, userId string) error
+	AuthenticateClient(ctx context.Context, username string, secret string) (*model.UserInfo, error)
+
+	CreateAccessKey(ctx context.Context, userId string) (*v1.AccessKeyCreateResponse, error)
+	QueryAccessKey(ctx context.Context, userId string) ([]model.AccessKey, error)
+	DeleteAccessKey(ctx context.Context, id string, userId string) error
 }
 
 type userService struct {
@@ -34,7 +47,15 @@ type userService struct {
 
 var _ UserService = (*userService)(nil)
 
-const DEFAULT_PASSWORD_LENGTH = 32
+const (
+	DEFAULT_PASSWORD_LENGTH     = 32
+	DEFAULT_APP_PASSWORD_LENGTH = 32
+
+	ACCESS_KEY_ID_PREFIX  = "FT"
+	ACCESS_KEY_ID_LENGTH  = 20
+	ACCESS_KEY_ID_CHARS   = "ABCDEFGHIJKLMNOPQRSTUVWXYZ234567"
+	SECRET_ACCESS_KEY_LEN = 40
+)
 
 func NewUserService(repo repo.UserRepo, rClient *redis.Client, shareServ ShareService) UserService {
 	return &userService{userRepo: repo, redisClient: rClient, shareServ: shareServ}
@@ -82,8 +103,8 @@ func (s *userService) Login(ctx context.Context, request v1.UserLoginRequest) (*
 	return user, nil
 }
 
-func (s *userService) CreateLoginUrl(ctx context.Context, userId string) (string, error) {
-	return s.shareServ.CreateShareUrl(ctx, common.SHARE_TYPE_LOGIN, userId, SHARE_LINK_EXPIRE)
+func (s *userService) CreateLoginUrl(ctx context.Context, userId string) (*v1.ShareLink, error) {
+	return s.shareServ.CreateShareUrl(ctx, common.SHARE_TYPE_LOGIN, userId, userId, SHARE_LINK_EXPIRE, "")
 }
 
 func (s *userService) LoginByLoginUrl(ctx context.Context, key string) (*model.UserInfo, error) {
@@ -99,3 +120,127 @@ func (s *userService) LoginByLoginUrl(ctx context.Context, key string) (*model.U
 	log.C(ctx).Infow("login by share link: " + user.Username)
 	return user, nil
 }
+
+func (s *userService) CreateAppPassword(ctx context.Context, userId string, name string) (*v1.AppPasswordCreateResponse, error) {
+	if len(name) < 1 || len(name) > 64 {
+		return nil, errno.ErrInvalidParameter
+	}
+	password, err := util.GenerateRandomString(DEFAULT_APP_PASSWORD_LENGTH)
+	if err != nil {
+		return nil, errno.InternalServerError
+	}
+	m := &model.AppPassword{
+		UserId:    userId,
+		Name:      name,
+		Hash:      util.HashSHA256(password),
+		CreatedAt: time.Now(),
+	}
+	id, err := s.userRepo.CreateAppPassword(ctx, m)
+	if err != nil {
+		log.C(ctx).Errorw("CreateAppPassword failed", "err", err)
+		return nil, errno.InternalServerError
+	}
+	// the plain password is only shown once
+	return &v1.AppPasswordCreateResponse{Id: id, Name: name, Password: password}, nil
+}
+
+func (s *userService) QueryAppPassword(ctx context.Context, userId string) ([]model.AppPassword, error) {
+	list, err := s.userRepo.QueryAppPassword(ctx, userId)
+	if err != nil {
+		log.C(ctx).Errorw("QueryAppPassword failed", "err", err)
+		return nil, errno.InternalServerError
+	}
+	return list, nil
+}
+
+func (s *userService) DeleteAppPassword(ctx context.Context, id string, userId string) error {
+	result, err := s.userRepo.DeleteAppPassword(ctx, id, userId)
+	if err != nil || result.DeletedCount != 1 {
+		return &errno.Errno{HTTP: http.StatusNotFound, Message: "invalid"}
+	}
+	return nil
+}
+
+// AuthenticateClient checks the secret of a client which can't do the login flow, it is an app password or a token
+func (s *userService) AuthenticateClient(ctx context.Context, username string, secret string) (*model.UserInfo, error) {
+	if len(username) < 1 || len(secret) < 1 {
+		return nil, errno.ErrAuthFail
+	}
+	user, err := s.userRepo.FindByUsername(ctx, username)
+	if err != nil {
+		log.C(ctx).Warnw("client authentication failed", "username", username)
+		return nil, errno.ErrAuthFail
+	}
+	passwords, err := s.userRepo.QueryAppPassword(ctx, user.Id)
+	if err != nil {
+		return nil, errno.InternalServerError
+	}
+	hash := util.HashSHA256(secret)
+	for _, p := range passwords {
+		if subtle.ConstantTimeCompare([]byte(p.Hash), []byte(hash)) == 1 {
+			return user, nil
+		}
+	}
+	if idKey, _, err := token.ParseString(secret); err == nil && idKey == user.Id {
+		return user, nil
+	}
+	log.C(ctx).Warnw("client authentication failed, bad secret", "username", username)
+	return nil, errno.ErrAuthFail
+}
+
+func newAccessKeyId() (string, error) {
+	b := make([]byte, ACCESS_KEY_ID_LENGTH-len(ACCESS_KEY_ID_PREFIX))
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	for i := range b {
+		b[i] = ACCESS_KEY_ID_CHARS[int(b[i])%len(ACCESS_KEY_ID_CHARS)]
+	}
+	return ACCESS_KEY_ID_PREFIX + string(b), nil
+}
+
+func (s *userService) CreateAccessKey(ctx context.Context, userId string) (*v1.AccessKeyCreateResponse, error) {
+	accessKeyId, err := newAccessKeyId()
+	if err != nil {
+		return nil, errno.InternalServerError
+	}
+	secret, err := util.GenerateRandomString(SECRET_ACCESS_KEY_LEN)
+	if err != nil {
+		return nil, errno.InternalServerError
+	}
+	encrypted, err := aesencrypt.GetAESEncrypted(secret)
+	if err != nil {
+		log.C(ctx).Errorw("CreateAccessKey encrypt failed", "err", err)
+		return nil, errno.InternalServerError
+	}
+	m := &model.AccessKey{
+		UserId:      userId,
+		AccessKeyId: accessKeyId,
+		Secret:      encrypted,
+		CreatedAt:   time.Now(),
+	}
+	id, err := s.userRepo.CreateAccessKey(ctx, m)
+	if err != nil {
+		log.C(ctx).Errorw("CreateAccessKey failed", "err", err)
+		return nil, errno.InternalServerError
+	}
+	// the secret is only shown once
+	return &v1.AccessKeyCreateResponse{Id: id, AccessKeyId: accessKeyId, SecretAccessKey: secret}, nil
+}
+
+func (s *userService) QueryAccessKey(ctx context.Context, userId string) ([]model.AccessKey, error) {
+	list, err := s.userRepo.QueryAccessKey(ctx, userId)
+	if err != nil {
+		log.C(ctx).Errorw("QueryAccessKey failed", "err", err)
+		return nil, errno.InternalServerError
+	}
+	return list, nil
+}
+
+func (s *userService) DeleteAccessKey(ctx context.Context, id string, userId string) error {
+	result, err := s.userRepo.DeleteAccessKey(ctx, id, userId)
+	if err != nil || result.DeletedCount != 1 {
+		return &errno.Errno{HTTP: http.StatusNotFound, Message: "invalid"}
+	}
+	return nil
+}
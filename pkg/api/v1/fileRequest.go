@@ -1,6 +1,7 @@
 package v1
 
 import (
+	"file-transfer/pkg/bandwidth"
 	"file-transfer/pkg/common"
 	"time"
 )
@@ -12,10 +13,61 @@ type UserFileQuery struct {
 }
 
 type FileResponse struct {
-	Id        string    `json:"id,omitempty"`
-	Name      string    `json:"name"`
-	Size      int64     `json:"size"`
-	CreatedAt time.Time `json:"createdAt"`
+	Id        string     `json:"id,omitempty"`
+	Name      string     `json:"name"`
+	Folder    string     `json:"folder,omitempty"`
+	Size      int64      `json:"size"`
+	CreatedAt time.Time  `json:"createdAt"`
+	ExpireAt  *time.Time `json:"expireAt,omitempty"`
+	// set only when the scrubber found the stored content damaged
+	Integrity common.ScrubStatus `json:"integrity,omitempty"`
+}
+
+type FileUploadParam struct {
+	UserId string
+	// the recipient of a grant uploading for the owner UserId
+	UploaderId string
+	UploadId   string
+	Name       string
+	Folder     string
+	// minutes until the file is removed, 0 keeps it
+	Expire int64
+	// replace a file with the same name once the new one is stored
+	Overwrite bool
+	// remove image metadata, nil uses upload.strip-metadata
+	StripMetadata *bool
+	// bytes, lower than upload.max-size, 0 uses upload.max-size
+	MaxSize int64
+}
+
+type FileExpireParam struct {
+	// minutes from now, 0 removes the expiry
+	Expire int64 `json:"expire"`
+}
+
+type FileExpireResponse struct {
+	ExpireAt *time.Time `json:"expireAt"`
+}
+
+type FileUploadResult struct {
+	Id      string `json:"id,omitempty"`
+	Name    string `json:"name"`
+	Folder  string `json:"folder,omitempty"`
+	Code    string `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type UploadProgressEvent struct {
+	UploadId string             `json:"uploadId"`
+	Stage    common.UploadStage `json:"stage"`
+	Name     string             `json:"name,omitempty"`
+	Bytes    int64              `json:"bytes"`
+	Total    int64              `json:"total,omitempty"`
+	FileId   string             `json:"fileId,omitempty"`
+	Count    int                `json:"count,omitempty"`
+	Code     string             `json:"code,omitempty"`
+	Message  string             `json:"message,omitempty"`
+	Time     time.Time          `json:"time"`
 }
 
 type FileShareParam struct {
@@ -23,10 +75,149 @@ type FileShareParam struct {
 	Expire     int64                     `json:"expire,omitempty"`
 }
 
+type CollectionShareParam struct {
+	MessageShareParam
+	// the files under the folder when the link is opened, used when there are no FileIds
+	Folder  string   `json:"folder,omitempty"`
+	FileIds []string `json:"fileIds,omitempty"`
+}
+
+type CollectionItem struct {
+	Id string `json:"id"`
+	// relative to the shared folder, or the full path for a set of files
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+type CollectionShareResponse struct {
+	Name  string           `json:"name"`
+	Files []CollectionItem `json:"files"`
+	Size  int64            `json:"size"`
+}
+
+// CollectionZipData is streamed as one zip, the Name of each file is its path in the zip
+type CollectionZipData struct {
+	Name  string
+	Files []FileDownloadData
+	// the slot of the download, released when the zip is sent
+	Lease *bandwidth.Lease
+	// the download session started by the request, and whether it continued an earlier one
+	Session string
+	Resumed bool
+}
+
+type FileRequestParam struct {
+	Folder string `json:"folder,omitempty"`
+	Note   string `json:"note,omitempty"`
+	// minutes, 0 uses the longest
+	Expire   int64  `json:"expire,omitempty"`
+	Password string `json:"password,omitempty"`
+	// bytes of one file, 0 uses upload.max-size
+	MaxFileSize int64 `json:"maxFileSize,omitempty"`
+	// 0 uses upload.request-max-files, which is the most allowed too
+	MaxFiles   int64    `json:"maxFiles,omitempty"`
+	Extensions []string `json:"extensions,omitempty"`
+}
+
+// FileRequestInfo is what a visitor of a file request link sees before the upload
+type FileRequestInfo struct {
+	Note        string    `json:"note,omitempty"`
+	MaxFileSize int64     `json:"maxFileSize"`
+	MaxFiles    int64     `json:"maxFiles"`
+	Received    int64     `json:"received"`
+	Extensions  []string  `json:"extensions,omitempty"`
+	ExpireAt    time.Time `json:"expireAt"`
+}
+
+// SignedUrlParam asks for download urls of own files which work without the auth header
+type SignedUrlParam struct {
+	FileIds []string `json:"fileIds"`
+	// minutes, 0 uses signed-url.expire
+	Expire int64 `json:"expire,omitempty"`
+	// network the downloads must come from, like 203.0.113.0/24
+	IpPrefix string `json:"ipPrefix,omitempty"`
+	// binds the urls to the network of the asking client instead, a /24 for ipv4 and a /64 for ipv6
+	BindIp bool `json:"bindIp,omitempty"`
+}
+
+type SignedUrl struct {
+	FileId   string    `json:"fileId"`
+	Url      string    `json:"url,omitempty"`
+	Path     string    `json:"path,omitempty"`
+	ExpireAt time.Time `json:"expireAt"`
+	// set instead of the url when the file can't be signed
+	Error string `json:"error,omitempty"`
+}
+
+// FileGrantParam shares a file or a folder with another user of the instance
+type FileGrantParam struct {
+	Username string `json:"username"`
+	// one of them
+	FileId string             `json:"fileId,omitempty"`
+	Folder string             `json:"folder,omitempty"`
+	Access common.GrantAccess `json:"access"`
+}
+
+// FileGrantInfo is a grant as its owner lists it, or as its recipient sees it in "shared with me"
+type FileGrantInfo struct {
+	Id      string             `json:"id"`
+	Owner   string             `json:"owner,omitempty"`
+	Grantee string             `json:"grantee,omitempty"`
+	Access  common.GrantAccess `json:"access"`
+	// the shared file, gone when the owner removed it
+	File      *FileResponse `json:"file,omitempty"`
+	Folder    string        `json:"folder,omitempty"`
+	CreatedAt time.Time     `json:"createdAt"`
+}
+
 type FileDownloadData struct {
 	Location string `json:"location"`
 	Name     string `json:"name"`
 	Size     int64  `json:"size"`
+	// the stored file keeps its metadata, it is removed while serving
+	StripMetadata bool `json:"-"`
+	// served although the scrubber found it damaged, the response carries a warning
+	Damaged bool `json:"-"`
+	// the slot of the download, released when the file is sent
+	Lease *bandwidth.Lease `json:"-"`
+	// the download session started by the request, and whether it continued an earlier one
+	Session string `json:"-"`
+	Resumed bool   `json:"-"`
+}
+
+type BlobScrubReport struct {
+	// blobs due for a scrub when the pass started
+	Total   int64 `json:"total"`
+	Checked int64 `json:"checked"`
+	Bytes   int64 `json:"bytes"`
+	// locations of the damaged blobs found by this pass
+	Corrupt []string `json:"corrupt,omitempty"`
+	Missing []string `json:"missing,omitempty"`
+}
+
+type FilePreviewParam struct {
+	// KB of the file in one page, 0 uses preview.default-size
+	Size int64
+	// page backwards from the end of the file, for logs
+	Tail bool
+	// start of a head page or end of a tail page, -1 is the end of the file
+	Offset int64
+	// skip the charset detection
+	Charset   string
+	Highlight bool
+}
+
+type FilePreviewResponse struct {
+	Name     string `json:"name"`
+	Charset  string `json:"charset"`
+	Language string `json:"language"`
+	// file bytes [offset, end) are in the content, the next page starts at end or ends at offset for tail
+	Offset  int64  `json:"offset"`
+	End     int64  `json:"end"`
+	Size    int64  `json:"size"`
+	HasMore bool   `json:"hasMore"`
+	Content string `json:"content"`
+	Html    string `json:"html,omitempty"`
 }
 
 type CloudinaryFileUpReq struct {
@@ -19,9 +19,63 @@ type MessageResponse struct {
 	Id        string    `json:"id,omitempty"`
 	Info      string    `json:"info"`
 	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	// sent back in If-Match to edit the message
+	Version int64 `json:"version"`
+	// parts of a message found by a search, html escaped with the matches in <mark></mark>
+	Snippets []string `json:"snippets,omitempty"`
+}
+
+// MessageSearchQuery finds the messages holding all words, "phrases" and prefix* words of Query
+type MessageSearchQuery struct {
+	UserId string `json:"userId,omitempty"`
+	Query  string `json:"query"`
+	// created from, and before to
+	From     *time.Time `json:"from,omitempty"`
+	To       *time.Time `json:"to,omitempty"`
+	PageNum  int64      `json:"pageNum,omitempty"`
+	PageSize int64      `json:"pageSize,omitempty"`
+}
+
+// MessageRevision is a text the message had before an edit
+type MessageRevision struct {
+	Version   int64     `json:"version"`
+	Info      string    `json:"info"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// SecretNoteParam is a note for one read, expire in minutes, a day by default
+type SecretNoteParam struct {
+	Info   string `json:"info"`
+	Expire int64  `json:"expire,omitempty"`
+	// optional, asked before the note opens and part of its key
+	Passphrase string `json:"passphrase,omitempty"`
+}
+
+// SecretNoteLink is the link of a note, the secret is its key and isn't stored.
+// PageUrl carries it after "#", so it isn't sent to the server when the page is opened
+type SecretNoteLink struct {
+	ShareLink
+	Secret string `json:"secret"`
+}
+
+type SecretNoteReadRequest struct {
+	Secret     string `json:"secret"`
+	Passphrase string `json:"passphrase,omitempty"`
+}
+
+type SecretNoteStatus struct {
+	Read     bool       `json:"read"`
+	ReadAt   *time.Time `json:"readAt,omitempty"`
+	Expired  bool       `json:"expired"`
+	ExpireAt time.Time  `json:"expireAt"`
 }
 
 type MessageShareParam struct {
 	ExpireType common.ShareExpireTypeKey `json:"expireType,omitempty"`
 	Expire     int64                     `json:"expire,omitempty"`
+	// optional password or PIN asked before the link opens, only its hash is stored
+	Password string `json:"password,omitempty"`
+	// download limits of file and collection links, 0 uses the server default
+	ShareLimits
 }
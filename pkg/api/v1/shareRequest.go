@@ -0,0 +1,95 @@
+package v1
+
+import (
+	"file-transfer/pkg/common"
+	"time"
+)
+
+type ShareQuery struct {
+	// login, message or file, empty for all
+	Type   string `json:"type,omitempty"`
+	Target string `json:"target,omitempty"`
+}
+
+// ShareLink is a created link. Path is relative to the api, Url and PageUrl are absolute,
+// for programs and for people opening the link in a browser
+type ShareLink struct {
+	Url     string `json:"url"`
+	PageUrl string `json:"pageUrl"`
+	Path    string `json:"path"`
+	// nil when the link only expires by time
+	Remaining *int64    `json:"remaining,omitempty"`
+	ExpireAt  time.Time `json:"expireAt"`
+}
+
+type ShareInfo struct {
+	Key     string `json:"key"`
+	Type    string `json:"type"`
+	Target  string `json:"target"`
+	Path    string `json:"path"`
+	Url     string `json:"url"`
+	PageUrl string `json:"pageUrl"`
+	// nil when the link only expires by time
+	Remaining *int64           `json:"remaining,omitempty"`
+	Protected bool             `json:"protected"`
+	CreatedAt time.Time        `json:"createdAt"`
+	ExpireAt  time.Time        `json:"expireAt"`
+	Access    ShareAccessStats `json:"access"`
+	// the limits the owner set, the server defaults apply to the others
+	Limits *ShareLimits `json:"limits,omitempty"`
+}
+
+// ShareLimits throttles the downloads of a link on each server instance, 0 uses the server default
+type ShareLimits struct {
+	// bytes per second of all downloads of the link together
+	RateLimit int64 `json:"rateLimit,omitempty"`
+	// downloads at the same time
+	ConcurrentLimit int64 `json:"concurrentLimit,omitempty"`
+}
+
+// ShareAccessStats counts the accesses of a link by outcome
+type ShareAccessStats struct {
+	Served        int64      `json:"served"`
+	Expired       int64      `json:"expired"`
+	Exhausted     int64      `json:"exhausted"`
+	WrongPassword int64      `json:"wrongPassword"`
+	Resumed       int64      `json:"resumed"`
+	Bytes         int64      `json:"bytes"`
+	LastAccessAt  *time.Time `json:"lastAccessAt,omitempty"`
+}
+
+type ShareAccessQuery struct {
+	UserId   string              `json:"-"`
+	ShareKey string              `json:"shareKey,omitempty"`
+	Outcome  common.ShareOutcome `json:"outcome,omitempty"`
+	PageNum  int64               `json:"pageNum,omitempty"`
+	PageSize int64               `json:"pageSize,omitempty"`
+}
+
+// PickupCodeParam asks for a short code of a link, style is "digits" (default) or "words", expire in minutes
+type PickupCodeParam struct {
+	Style  string `json:"style,omitempty"`
+	Expire int64  `json:"expire,omitempty"`
+}
+
+type PickupCode struct {
+	Code     string    `json:"code"`
+	Url      string    `json:"url"`
+	Path     string    `json:"path"`
+	ExpireAt time.Time `json:"expireAt"`
+}
+
+// SharePage is shown on the landing page of a link, name, size and type are empty when it has a password
+type SharePage struct {
+	Path        string
+	Name        string
+	Size        int64
+	ContentType string
+	ExpireAt    time.Time
+	Remaining   *int64
+	Protected   bool
+}
+
+type ShareRevokeResponse struct {
+	Revoked int `json:"revoked"`
+}
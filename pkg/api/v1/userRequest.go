@@ -14,3 +14,19 @@ type UserLoginResponse struct {
 	Privileges string `json:"privileges,omitempty"`
 	IdKey      string `json:"idKey,omitempty"`
 }
+
+type AppPasswordCreateRequest struct {
+	Name string `json:"name"`
+}
+
+type AppPasswordCreateResponse struct {
+	Id       string `json:"id"`
+	Name     string `json:"name"`
+	Password string `json:"password"`
+}
+
+type AccessKeyCreateResponse struct {
+	Id              string `json:"id"`
+	AccessKeyId     string `json:"accessKeyId"`
+	SecretAccessKey string `json:"secretAccessKey"`
+}
@@ -0,0 +1,209 @@
+// Package bandwidth limits the downloads of this instance: the bytes per second and the
+// downloads at the same time of each share, and the bytes per second of all downloads together.
+package bandwidth
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// CHUNK is the most bytes written at once, the buckets hold one chunk
+const CHUNK = 32 * 1024
+
+// BUSY_RETRY_AFTER is told to a client refused because the share has no free download
+var BUSY_RETRY_AFTER = 10 * time.Second
+
+type Limits struct {
+	// bytes per second, 0 is unlimited
+	Rate int64
+	// downloads at the same time, 0 is unlimited
+	Concurrent int64
+}
+
+// LimitError refuses a download, the client may retry after RetryAfter
+type LimitError struct {
+	Reason     string
+	RetryAfter time.Duration
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("download limited by %s, retry after %s", e.Reason, e.RetryAfter)
+}
+
+// RetryAfterSeconds is the value of a Retry-After header, at least 1
+func (e *LimitError) RetryAfterSeconds() int {
+	return max(1, int(math.Ceil(e.RetryAfter.Seconds())))
+}
+
+// Throttle hands out the leases of downloads. A nil Throttle limits nothing
+type Throttle struct {
+	mu     sync.Mutex
+	egress *rate.Limiter
+	// a download which would wait longer than this for its first chunk is refused
+	maxWait time.Duration
+	shares  map[string]*shareState
+}
+
+type shareState struct {
+	limiter *rate.Limiter
+	active  int64
+}
+
+// NewThrottle limits all downloads together to egressRate bytes per second, 0 is unlimited
+func NewThrottle(egressRate int64, maxWait time.Duration) *Throttle {
+	return &Throttle{egress: newLimiter(egressRate), maxWait: maxWait, shares: make(map[string]*shareState)}
+}
+
+func newLimiter(bytesPerSecond int64) *rate.Limiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	return rate.NewLimiter(rate.Limit(bytesPerSecond), CHUNK)
+}
+
+// backlog is how long a new chunk would wait behind the ones already reserved
+func backlog(limiter *rate.Limiter, now time.Time) time.Duration {
+	if limiter == nil {
+		return 0
+	}
+	missing := float64(CHUNK) - limiter.TokensAt(now)
+	if missing <= 0 {
+		return 0
+	}
+	return time.Duration(missing / float64(limiter.Limit()) * float64(time.Second))
+}
+
+// Acquire takes a download slot of the share, the empty key only counts against the egress budget.
+// It fails with a *LimitError when the share is busy or the queue of the budget is too long
+func (t *Throttle) Acquire(key string, limits Limits) (*Lease, error) {
+	if t == nil {
+		return nil, nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	if wait := backlog(t.egress, now); wait > t.maxWait {
+		return nil, &LimitError{Reason: "egress budget", RetryAfter: wait}
+	}
+	lease := &Lease{throttle: t, key: key, egress: t.egress}
+	if len(key) == 0 {
+		return lease, nil
+	}
+	state, ok := t.shares[key]
+	if !ok {
+		state = &shareState{}
+		t.shares[key] = state
+	}
+	if limits.Concurrent > 0 && state.active >= limits.Concurrent {
+		return nil, &LimitError{Reason: "concurrent downloads", RetryAfter: BUSY_RETRY_AFTER}
+	}
+	// the limit may have been changed by the owner meanwhile, the running downloads follow it
+	if limits.Rate > 0 {
+		if state.limiter == nil {
+			state.limiter = newLimiter(limits.Rate)
+		} else if state.limiter.Limit() != rate.Limit(limits.Rate) {
+			state.limiter.SetLimitAt(now, rate.Limit(limits.Rate))
+		}
+		if wait := backlog(state.limiter, now); wait > t.maxWait {
+			if state.active == 0 {
+				delete(t.shares, key)
+			}
+			return nil, &LimitError{Reason: "share rate", RetryAfter: wait}
+		}
+		lease.limiter = state.limiter
+	}
+	state.active++
+	lease.share = state
+	return lease, nil
+}
+
+func (t *Throttle) release(key string, state *shareState) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	state.active--
+	if state.active <= 0 && t.shares[key] == state {
+		delete(t.shares, key)
+	}
+}
+
+// Active is the number of downloads of the share now
+func (t *Throttle) Active(key string) int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if state, ok := t.shares[key]; ok {
+		return state.active
+	}
+	return 0
+}
+
+// Lease is one download, its writes wait for the buckets of the share and of the budget.
+// A nil Lease limits nothing
+type Lease struct {
+	throttle *Throttle
+	key      string
+	share    *shareState
+	limiter  *rate.Limiter
+	egress   *rate.Limiter
+	once     sync.Once
+}
+
+// Writer wraps the response of the download
+func (l *Lease) Writer(ctx context.Context, w io.Writer) io.Writer {
+	if l == nil {
+		return w
+	}
+	var limiters []*rate.Limiter
+	if l.limiter != nil {
+		limiters = append(limiters, l.limiter)
+	}
+	if l.egress != nil {
+		limiters = append(limiters, l.egress)
+	}
+	if len(limiters) == 0 {
+		return w
+	}
+	return &limitedWriter{ctx: ctx, w: w, limiters: limiters}
+}
+
+// Release frees the slot of the download, more calls do nothing
+func (l *Lease) Release() {
+	if l == nil {
+		return
+	}
+	l.once.Do(func() {
+		if l.share != nil {
+			l.throttle.release(l.key, l.share)
+		}
+	})
+}
+
+type limitedWriter struct {
+	ctx      context.Context
+	w        io.Writer
+	limiters []*rate.Limiter
+}
+
+func (lw *limitedWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), CHUNK)
+		for _, limiter := range lw.limiters {
+			if err := limiter.WaitN(lw.ctx, n); err != nil {
+				return written, err
+			}
+		}
+		m, err := lw.w.Write(p[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
@@ -0,0 +1,69 @@
+package bandwidth
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConcurrent(t *testing.T) {
+	throttle := NewThrottle(0, time.Second)
+	first, err := throttle.Acquire("k1", Limits{Concurrent: 2})
+	assert.Nil(t, err)
+	second, err := throttle.Acquire("k1", Limits{Concurrent: 2})
+	assert.Nil(t, err)
+	_, err = throttle.Acquire("k1", Limits{Concurrent: 2})
+	assert.Equal(t, BUSY_RETRY_AFTER, err.(*LimitError).RetryAfter)
+	// other shares have their own slots
+	other, err := throttle.Acquire("k2", Limits{Concurrent: 1})
+	assert.Nil(t, err)
+	other.Release()
+
+	first.Release()
+	first.Release()
+	assert.Equal(t, int64(1), throttle.Active("k1"))
+	third, err := throttle.Acquire("k1", Limits{Concurrent: 2})
+	assert.Nil(t, err)
+	second.Release()
+	third.Release()
+	assert.Equal(t, 0, len(throttle.shares))
+
+	var none *Throttle
+	lease, err := none.Acquire("k1", Limits{Concurrent: 1})
+	assert.Nil(t, err)
+	lease.Release()
+}
+
+func TestRate(t *testing.T) {
+	throttle := NewThrottle(0, time.Second)
+	lease, err := throttle.Acquire("k1", Limits{Rate: 10 * CHUNK})
+	assert.Nil(t, err)
+	defer lease.Release()
+
+	out := &bytes.Buffer{}
+	start := time.Now()
+	n, err := lease.Writer(context.Background(), out).Write(make([]byte, 4*CHUNK))
+	assert.Nil(t, err)
+	assert.Equal(t, 4*CHUNK, n)
+	assert.Equal(t, 4*CHUNK, out.Len())
+	// the first chunk is the burst, the others take a tenth of a second each
+	assert.GreaterOrEqual(t, time.Since(start), 250*time.Millisecond)
+}
+
+func TestEgressBudget(t *testing.T) {
+	throttle := NewThrottle(CHUNK, 100*time.Millisecond)
+	lease, err := throttle.Acquire("", Limits{})
+	assert.Nil(t, err)
+	_, err = lease.Writer(context.Background(), &bytes.Buffer{}).Write(make([]byte, CHUNK))
+	assert.Nil(t, err)
+	lease.Release()
+
+	// the bucket is empty, the next chunk waits about a second
+	_, err = throttle.Acquire("k1", Limits{})
+	limited := err.(*LimitError)
+	assert.Equal(t, "egress budget", limited.Reason)
+	assert.Equal(t, 1, limited.RetryAfterSeconds())
+}
@@ -4,6 +4,10 @@ import "fmt"
 
 type ShareKey int
 type ShareExpireTypeKey int
+type UploadStage string
+type ScrubStatus string
+type ShareOutcome string
+type GrantAccess string
 
 type Trace_request_user struct{}
 type Trace_request_uid struct{}
@@ -17,11 +21,20 @@ const (
 	LOGIN_SHARE_PATH   = "ls"
 	MESSAGE_SHARE_PATH = "ms"
 	FILE_SHARE_PATH    = "fs"
+	// a folder or a set of files
+	COLLECTION_SHARE_PATH = "cs"
+	// anonymous uploads into a folder
+	FILE_REQUEST_PATH = "fr"
+	// encrypted notes removed once read
+	SECRET_NOTE_PATH = "sn"
 )
 const (
 	SHARE_TYPE_LOGIN ShareKey = iota
 	SHARE_TYPE_MESSAGE
 	SHARE_TYPE_FILE
+	SHARE_TYPE_COLLECTION
+	SHARE_TYPE_FILE_REQUEST
+	SHARE_TYPE_SECRET_NOTE
 )
 
 const (
@@ -29,6 +42,42 @@ const (
 	SHARE_EXPIRE_TYPE_DURATION
 )
 
+const (
+	UPLOAD_STAGE_RECEIVING UploadStage = "receiving"
+	UPLOAD_STAGE_HASHING   UploadStage = "hashing"
+	UPLOAD_STAGE_DEDUP     UploadStage = "dedup"
+	UPLOAD_STAGE_STORING   UploadStage = "storing"
+	// thumbnail and scan are reserved, there is no such step in the pipeline yet
+	UPLOAD_STAGE_THUMBNAIL UploadStage = "thumbnail"
+	UPLOAD_STAGE_SCAN      UploadStage = "scan"
+	UPLOAD_STAGE_DONE      UploadStage = "done"
+	UPLOAD_STAGE_ERROR     UploadStage = "error"
+	// all files of one request are handled
+	UPLOAD_STAGE_COMPLETE UploadStage = "complete"
+)
+
+const (
+	SCRUB_STATUS_OK ScrubStatus = "ok"
+	// the content no longer matches the sha of the meta
+	SCRUB_STATUS_CORRUPT ScrubStatus = "corrupt"
+	SCRUB_STATUS_MISSING ScrubStatus = "missing"
+)
+
+const (
+	SHARE_OUTCOME_SERVED         ShareOutcome = "served"
+	SHARE_OUTCOME_EXPIRED        ShareOutcome = "expired"
+	SHARE_OUTCOME_EXHAUSTED      ShareOutcome = "exhausted"
+	SHARE_OUTCOME_WRONG_PASSWORD ShareOutcome = "wrong_password"
+	// a range or resume request of a download session, the link wasn't used up again
+	SHARE_OUTCOME_RESUMED ShareOutcome = "resumed"
+)
+
+const (
+	GRANT_ACCESS_READ GrantAccess = "read"
+	// read, upload into a shared folder and delete what is shared
+	GRANT_ACCESS_WRITE GrantAccess = "write"
+)
+
 func init() {
 	if FLAG_DEBUG {
 		fmt.Printf("SHARE_TYPE_LOGIN %d\n", SHARE_TYPE_LOGIN)
@@ -9,4 +9,5 @@ const (
 	REQUEST_METHOD   = "R-METHOD"
 	REQUEST_TIMEZONE = "R-TZ"
 	RESOURCE_IP      = "R-IP"
+	USER_AGENT       = "R-UA"
 )
@@ -18,6 +18,14 @@ var (
 	COLL_FILE_META = "filemeta"
 	COLL_USER_FILE = "userfile"
 
+	COLL_USER_FOLDER      = "userfolder"
+	COLL_APP_PASSWORD     = "apppassword"
+	COLL_ACCESS_KEY       = "accesskey"
+	COLL_SHARE_ACCESS     = "shareaccess"
+	COLL_SHARE_COLLECTION = "sharecollection"
+	COLL_FILE_REQUEST     = "filerequest"
+	COLL_FILE_GRANT       = "filegrant"
+
 	client     *mongo.Client
 	clientOnce sync.Once
 )
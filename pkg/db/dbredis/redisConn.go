@@ -10,9 +10,29 @@ import (
 )
 
 var (
-	REDIS_LOGIN_SHARE_KEY_PREFIX   = "ls-"
-	REDIS_MESSAGE_SHARE_KEY_PREFIX = "ms-"
-	REDIS_FILE_SHARE_KEY_PREFIX    = "fs-"
+	REDIS_LOGIN_SHARE_KEY_PREFIX      = "ls-"
+	REDIS_MESSAGE_SHARE_KEY_PREFIX    = "ms-"
+	REDIS_FILE_SHARE_KEY_PREFIX       = "fs-"
+	REDIS_COLLECTION_SHARE_KEY_PREFIX = "cs-"
+	REDIS_FILE_REQUEST_KEY_PREFIX     = "fr-"
+	REDIS_SECRET_NOTE_KEY_PREFIX      = "sn-"
+	REDIS_UPLOAD_PROGRESS_PREFIX      = "up-"
+	// the encrypted text of a secret note
+	REDIS_SECRET_NOTE_PREFIX = "note-"
+	// times left of a share link, followed by the prefix of the link, e.g. "count-ms-"
+	REDIS_SHARE_COUNT_PREFIX = "count-"
+	// owner, target and times of a share link
+	REDIS_SHARE_INFO_PREFIX = "si-"
+	// share links of a user and of a target, scored by expire time
+	REDIS_USER_SHARE_PREFIX   = "us-"
+	REDIS_TARGET_SHARE_PREFIX = "st-"
+	// wrong password attempts of a share link
+	REDIS_SHARE_PASSWORD_FAIL_PREFIX = "spf-"
+	// pickup code to the share key it opens, and failed redeems of a client ip
+	REDIS_PICKUP_CODE_PREFIX      = "pc-"
+	REDIS_PICKUP_CODE_FAIL_PREFIX = "pcf-"
+	// the link and item a download session reads
+	REDIS_DOWNLOAD_SESSION_PREFIX = "dls-"
 
 	client     *redis.Client
 	clientOnce sync.Once
@@ -0,0 +1,48 @@
+package dbredis
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	REDIS_LOCK_PREFIX = "lock-"
+
+	// only the holder of the token can release the lock
+	unlockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+	extendScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+)
+
+// TryLock acquires a lock shared by all instances, returns the token to release it
+func TryLock(ctx context.Context, client *redis.Client, name string, ttl time.Duration) (string, bool, error) {
+	token := uuid.New().String()
+	ok, err := client.SetNX(ctx, REDIS_LOCK_PREFIX+name, token, ttl).Result()
+	if err != nil || !ok {
+		return "", false, err
+	}
+	return token, true, nil
+}
+
+func Unlock(ctx context.Context, client *redis.Client, name string, token string) error {
+	return unlockScript.Run(ctx, client, []string{REDIS_LOCK_PREFIX + name}, token).Err()
+}
+
+// ExtendLock resets the ttl of a held lock, false if the lock was lost meanwhile
+func ExtendLock(ctx context.Context, client *redis.Client, name string, token string, ttl time.Duration) (bool, error) {
+	n, err := extendScript.Run(ctx, client, []string{REDIS_LOCK_PREFIX + name}, token, ttl.Milliseconds()).Int()
+	return n == 1, err
+}
@@ -0,0 +1,316 @@
+// Package imagemeta removes EXIF, XMP and IPTC metadata from images without re-encoding them.
+package imagemeta
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+type Format int
+
+const (
+	FORMAT_UNKNOWN Format = iota
+	FORMAT_JPEG
+	FORMAT_PNG
+	FORMAT_WEBP
+)
+
+// HEADER_SIZE is enough bytes for Detect
+const HEADER_SIZE = 12
+
+var ErrMalformed = errors.New("malformed image")
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+func Detect(header []byte) Format {
+	switch {
+	case len(header) >= 3 && header[0] == 0xFF && header[1] == 0xD8 && header[2] == 0xFF:
+		return FORMAT_JPEG
+	case bytes.HasPrefix(header, pngSignature):
+		return FORMAT_PNG
+	case len(header) >= 12 && string(header[0:4]) == "RIFF" && string(header[8:12]) == "WEBP":
+		return FORMAT_WEBP
+	}
+	return FORMAT_UNKNOWN
+}
+
+// DetectReader reads the header of src and seeks back to the start
+func DetectReader(src io.ReadSeeker) (Format, error) {
+	header := make([]byte, HEADER_SIZE)
+	n, err := io.ReadFull(src, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return FORMAT_UNKNOWN, err
+	}
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return FORMAT_UNKNOWN, err
+	}
+	return Detect(header[:n]), nil
+}
+
+// Strip copies src to dst without metadata, other formats than JPEG, PNG and WebP are copied unchanged
+func Strip(src io.ReadSeeker, dst io.Writer) error {
+	format, err := DetectReader(src)
+	if err != nil {
+		return err
+	}
+	switch format {
+	case FORMAT_JPEG:
+		return stripJPEG(src, dst)
+	case FORMAT_PNG:
+		return stripPNG(src, dst)
+	case FORMAT_WEBP:
+		return stripWebP(src, dst)
+	}
+	_, err = io.Copy(dst, src)
+	return err
+}
+
+// stripJPEG drops APP1 (EXIF, XMP), APP3-APP13 (IPTC, maker data), APP15, COM, the MPF APP2 and anything after EOI.
+// The orientation of the EXIF is kept in a minimal EXIF segment, otherwise phone photos turn sideways.
+func stripJPEG(src io.Reader, dst io.Writer) error {
+	r := bufio.NewReader(src)
+	w := bufio.NewWriter(dst)
+	soi := make([]byte, 2)
+	if _, err := io.ReadFull(r, soi); err != nil {
+		return ErrMalformed
+	}
+	w.Write(soi)
+
+	for {
+		marker, err := readMarker(r)
+		if err != nil {
+			return err
+		}
+		if marker == 0xD9 {
+			w.Write([]byte{0xFF, marker})
+			return w.Flush()
+		}
+		if marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			w.Write([]byte{0xFF, marker})
+			continue
+		}
+		lenBytes := make([]byte, 2)
+		if _, err := io.ReadFull(r, lenBytes); err != nil {
+			return ErrMalformed
+		}
+		length := int(binary.BigEndian.Uint16(lenBytes))
+		if length < 2 {
+			return ErrMalformed
+		}
+		payload := make([]byte, length-2)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return ErrMalformed
+		}
+
+		switch {
+		case marker == 0xE1:
+			if orientation := exifOrientation(payload); orientation > 1 {
+				w.Write(orientationSegment(orientation))
+			}
+		case marker == 0xE2 && bytes.HasPrefix(payload, []byte("MPF\x00")):
+		case marker >= 0xE3 && marker <= 0xED, marker == 0xEF, marker == 0xFE:
+		default:
+			w.Write([]byte{0xFF, marker})
+			w.Write(lenBytes)
+			w.Write(payload)
+		}
+		if marker == 0xDA {
+			// entropy coded data runs until the next marker which isn't a stuffed byte or restart
+			if err := copyScan(r, w); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func readMarker(r *bufio.Reader) (byte, error) {
+	b, err := r.ReadByte()
+	if err != nil || b != 0xFF {
+		return 0, ErrMalformed
+	}
+	for {
+		b, err = r.ReadByte()
+		if err != nil {
+			return 0, ErrMalformed
+		}
+		if b != 0xFF {
+			return b, nil
+		}
+	}
+}
+
+func copyScan(r *bufio.Reader, w *bufio.Writer) error {
+	for {
+		pair, err := r.Peek(2)
+		if err != nil {
+			return ErrMalformed
+		}
+		if pair[0] != 0xFF {
+			w.WriteByte(pair[0])
+			r.Discard(1)
+			continue
+		}
+		if pair[1] == 0x00 || (pair[1] >= 0xD0 && pair[1] <= 0xD7) {
+			w.Write(pair)
+			r.Discard(2)
+			continue
+		}
+		// the marker is left for readMarker
+		return nil
+	}
+}
+
+// exifOrientation reads tag 0x0112 of IFD0, 0 if there is none
+func exifOrientation(payload []byte) int {
+	if !bytes.HasPrefix(payload, []byte("Exif\x00\x00")) {
+		return 0
+	}
+	tiff := payload[6:]
+	if len(tiff) < 8 {
+		return 0
+	}
+	var order binary.ByteOrder
+	switch string(tiff[0:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+	ifd := int(order.Uint32(tiff[4:8]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 0
+	}
+	count := int(order.Uint16(tiff[ifd : ifd+2]))
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 0
+		}
+		if order.Uint16(tiff[entry:entry+2]) == 0x0112 && order.Uint16(tiff[entry+2:entry+4]) == 3 {
+			if v := int(order.Uint16(tiff[entry+8 : entry+10])); v >= 1 && v <= 8 {
+				return v
+			}
+			return 0
+		}
+	}
+	return 0
+}
+
+// orientationSegment is an APP1 EXIF segment holding only the orientation
+func orientationSegment(orientation int) []byte {
+	tiff := []byte{
+		'M', 'M', 0x00, 0x2A, 0x00, 0x00, 0x00, 0x08,
+		0x00, 0x01,
+		0x01, 0x12, 0x00, 0x03, 0x00, 0x00, 0x00, 0x01, 0x00, byte(orientation), 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00,
+	}
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	segment := []byte{0xFF, 0xE1, 0x00, 0x00}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+var pngMetaChunks = map[string]bool{"tEXt": true, "zTXt": true, "iTXt": true, "eXIf": true, "tIME": true}
+
+// stripPNG drops the text, eXIf and tIME chunks, chunks are copied whole so their CRCs stay valid
+func stripPNG(src io.Reader, dst io.Writer) error {
+	r := bufio.NewReader(src)
+	w := bufio.NewWriter(dst)
+	sig := make([]byte, len(pngSignature))
+	if _, err := io.ReadFull(r, sig); err != nil {
+		return ErrMalformed
+	}
+	w.Write(sig)
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			return ErrMalformed
+		}
+		length := int64(binary.BigEndian.Uint32(header[0:4]))
+		chunkType := string(header[4:8])
+		if pngMetaChunks[chunkType] {
+			if _, err := r.Discard(int(length + 4)); err != nil {
+				return ErrMalformed
+			}
+			continue
+		}
+		w.Write(header)
+		if n, err := io.CopyN(w, r, length+4); err != nil || n != length+4 {
+			return ErrMalformed
+		}
+		if chunkType == "IEND" {
+			return w.Flush()
+		}
+	}
+}
+
+type webpChunk struct {
+	fourCC string
+	offset int64
+	size   int64
+}
+
+// stripWebP drops the EXIF and XMP chunks and clears their flags in VP8X
+func stripWebP(src io.ReadSeeker, dst io.Writer) error {
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(src, header); err != nil {
+		return ErrMalformed
+	}
+	end := int64(binary.LittleEndian.Uint32(header[4:8])) + 8
+
+	// the RIFF size goes first, so list the chunks before writing
+	chunks := make([]webpChunk, 0)
+	riffSize := int64(4)
+	for pos := int64(12); pos+8 <= end; {
+		chunkHeader := make([]byte, 8)
+		if _, err := src.Seek(pos, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(src, chunkHeader); err != nil {
+			return ErrMalformed
+		}
+		chunk := webpChunk{
+			fourCC: string(chunkHeader[0:4]),
+			offset: pos,
+			size:   int64(binary.LittleEndian.Uint32(chunkHeader[4:8])),
+		}
+		padded := chunk.size + chunk.size&1
+		pos += 8 + padded
+		if pos > end {
+			return ErrMalformed
+		}
+		if chunk.fourCC == "EXIF" || chunk.fourCC == "XMP " {
+			continue
+		}
+		chunks = append(chunks, chunk)
+		riffSize += 8 + padded
+	}
+
+	w := bufio.NewWriter(dst)
+	binary.LittleEndian.PutUint32(header[4:8], uint32(riffSize))
+	w.Write(header)
+	for _, chunk := range chunks {
+		padded := chunk.size + chunk.size&1
+		if _, err := src.Seek(chunk.offset, io.SeekStart); err != nil {
+			return err
+		}
+		data := io.LimitReader(src, 8+padded)
+		if chunk.fourCC == "VP8X" && chunk.size >= 1 {
+			head := make([]byte, 9)
+			if _, err := io.ReadFull(data, head); err != nil {
+				return ErrMalformed
+			}
+			head[8] &^= 0x08 | 0x04
+			w.Write(head)
+		}
+		if _, err := io.Copy(w, data); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
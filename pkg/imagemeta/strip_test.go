@@ -0,0 +1,143 @@
+package imagemeta
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testImage() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for x := 0; x < 16; x++ {
+		img.Set(x, x, color.RGBA{R: 255, A: 255})
+	}
+	return img
+}
+
+// exifSegment has the orientation and a fake GPS latitude ref in IFD0
+func exifSegment(orientation int) []byte {
+	tiff := []byte{
+		'I', 'I', 0x2A, 0x00, 0x08, 0x00, 0x00, 0x00,
+		0x02, 0x00,
+		0x12, 0x01, 0x03, 0x00, 0x01, 0x00, 0x00, 0x00, byte(orientation), 0x00, 0x00, 0x00,
+		0x25, 0x88, 0x02, 0x00, 0x02, 0x00, 0x00, 0x00, 'N', 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00,
+	}
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	payload = append(payload, []byte("GPS-SECRET")...)
+	return segment(0xE1, payload)
+}
+
+func segment(marker byte, payload []byte) []byte {
+	s := []byte{0xFF, marker, 0, 0}
+	binary.BigEndian.PutUint16(s[2:], uint16(len(payload)+2))
+	return append(s, payload...)
+}
+
+func TestStripJPEG(t *testing.T) {
+	buf := &bytes.Buffer{}
+	assert.Nil(t, jpeg.Encode(buf, testImage(), nil))
+	encoded := buf.Bytes()
+
+	src := append([]byte{}, encoded[:2]...)
+	src = append(src, exifSegment(6)...)
+	src = append(src, segment(0xED, []byte("Photoshop 3.0\x00IPTC-SECRET"))...)
+	src = append(src, segment(0xFE, []byte("COMMENT-SECRET"))...)
+	src = append(src, encoded[2:]...)
+	src = append(src, []byte("TRAILER-SECRET")...)
+
+	out := &bytes.Buffer{}
+	assert.Nil(t, Strip(bytes.NewReader(src), out))
+	for _, secret := range []string{"GPS-SECRET", "IPTC-SECRET", "COMMENT-SECRET", "TRAILER-SECRET"} {
+		assert.NotContains(t, out.String(), secret)
+	}
+	assert.Equal(t, 6, exifOrientation(out.Bytes()[6:]))
+
+	img, err := jpeg.Decode(bytes.NewReader(out.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, 16, img.Bounds().Dx())
+}
+
+func pngChunk(chunkType string, data []byte) []byte {
+	c := make([]byte, 4)
+	binary.BigEndian.PutUint32(c, uint32(len(data)))
+	c = append(c, chunkType...)
+	c = append(c, data...)
+	crc := make([]byte, 4)
+	binary.BigEndian.PutUint32(crc, crc32.ChecksumIEEE(append([]byte(chunkType), data...)))
+	return append(c, crc...)
+}
+
+func TestStripPNG(t *testing.T) {
+	buf := &bytes.Buffer{}
+	assert.Nil(t, png.Encode(buf, testImage()))
+	encoded := buf.Bytes()
+
+	// after the signature and IHDR
+	idx := 8 + 25
+	src := append([]byte{}, encoded[:idx]...)
+	src = append(src, pngChunk("tEXt", []byte("Author\x00TEXT-SECRET"))...)
+	src = append(src, pngChunk("eXIf", []byte("MM\x00\x2aEXIF-SECRET"))...)
+	src = append(src, encoded[idx:]...)
+
+	out := &bytes.Buffer{}
+	assert.Nil(t, Strip(bytes.NewReader(src), out))
+	assert.NotContains(t, out.String(), "TEXT-SECRET")
+	assert.NotContains(t, out.String(), "EXIF-SECRET")
+	assert.Equal(t, encoded, out.Bytes())
+
+	_, err := png.Decode(bytes.NewReader(out.Bytes()))
+	assert.Nil(t, err)
+}
+
+func webpChunkBytes(fourCC string, data []byte) []byte {
+	c := append([]byte(fourCC), 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(c[4:], uint32(len(data)))
+	c = append(c, data...)
+	if len(data)%2 == 1 {
+		c = append(c, 0)
+	}
+	return c
+}
+
+func webpFile(chunks ...[]byte) []byte {
+	body := []byte("WEBP")
+	for _, c := range chunks {
+		body = append(body, c...)
+	}
+	f := append([]byte("RIFF"), 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(f[4:], uint32(len(body)))
+	return append(f, body...)
+}
+
+func TestStripWebP(t *testing.T) {
+	vp8x := []byte{0x08 | 0x04 | 0x10, 0, 0, 0, 15, 0, 0, 15, 0, 0}
+	image := []byte("fake-vp8l-bitstream")
+	src := webpFile(
+		webpChunkBytes("VP8X", vp8x),
+		webpChunkBytes("VP8L", image),
+		webpChunkBytes("EXIF", []byte("EXIF-SECRET")),
+		webpChunkBytes("XMP ", []byte("XMP-SECRET")),
+	)
+
+	out := &bytes.Buffer{}
+	assert.Nil(t, Strip(bytes.NewReader(src), out))
+	expected := webpFile(
+		webpChunkBytes("VP8X", append([]byte{0x10}, vp8x[1:]...)),
+		webpChunkBytes("VP8L", image),
+	)
+	assert.Equal(t, expected, out.Bytes())
+}
+
+func TestStripUnknown(t *testing.T) {
+	out := &bytes.Buffer{}
+	assert.Nil(t, Strip(bytes.NewReader([]byte("plain text")), out))
+	assert.Equal(t, "plain text", out.String())
+}
@@ -22,6 +22,7 @@ func RequestFilter(pf FiletransferHandlerFactory) FiletransferHandlerFactory {
 			}
 			ip := getClientIP(r)
 			ctx = context.WithValue(ctx, common.RESOURCE_IP, ip)
+			ctx = context.WithValue(ctx, common.USER_AGENT, r.UserAgent())
 
 			rId := getRequestId(r)
 			ctx = context.WithValue(ctx, common.REQUEST_ID, rId)
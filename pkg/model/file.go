@@ -1,6 +1,9 @@
 package model
 
-import "time"
+import (
+	"file-transfer/pkg/common"
+	"time"
+)
 
 type FileMeta struct {
 	Id        string    `bson:"_id,omitempty" json:"_id,omitempty"`
@@ -8,12 +11,34 @@ type FileMeta struct {
 	Size      int64     `bson:"size" json:"size"`
 	Location  string    `bson:"location" json:"location"`
 	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+	// result of the last re-hash by the scrubber, empty if it never ran
+	ScrubbedAt  *time.Time         `bson:"scrubbedAt,omitempty" json:"scrubbedAt,omitempty"`
+	ScrubStatus common.ScrubStatus `bson:"scrubStatus,omitempty" json:"scrubStatus,omitempty"`
+}
+
+// Damaged is true when the scrubber found the blob corrupt or missing
+func (m *FileMeta) Damaged() bool {
+	return m.ScrubStatus == common.SCRUB_STATUS_CORRUPT || m.ScrubStatus == common.SCRUB_STATUS_MISSING
 }
 
 type UserFile struct {
+	Id        string     `bson:"_id,omitempty" json:"_id,omitempty"`
+	MetaId    string     `bson:"metaId" json:"metaId"`
+	UserId    string     `bson:"userId" json:"userId"`
+	Name      string     `bson:"name" json:"name"`
+	Folder    string     `bson:"folder,omitempty" json:"folder,omitempty"`
+	CreatedAt time.Time  `bson:"createdAt" json:"createdAt"`
+	ExpireAt  *time.Time `bson:"expireAt,omitempty" json:"expireAt,omitempty"`
+	// the original is kept for the owner, public shares get it without metadata
+	StripOnShare bool `bson:"stripOnShare,omitempty" json:"stripOnShare,omitempty"`
+	// the recipient of a grant who uploaded the file into the shared folder, empty for the owner
+	UploaderId string `bson:"uploaderId,omitempty" json:"uploaderId,omitempty"`
+}
+
+// UserFolder keeps a folder alive without files in it
+type UserFolder struct {
 	Id        string    `bson:"_id,omitempty" json:"_id,omitempty"`
-	MetaId    string    `bson:"metaId" json:"metaId"`
 	UserId    string    `bson:"userId" json:"userId"`
-	Name      string    `bson:"name" json:"name"`
+	Path      string    `bson:"path" json:"path"`
 	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
 }
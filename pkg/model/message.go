@@ -8,6 +8,17 @@ type Message struct {
 	UserId    string    `bson:"userId" json:"userId"`
 	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
 	UpdatedAt time.Time `bson:"updatedAt" json:"updatedAt"`
+	// counts the edits, an edit names the version it was made on. Messages from before editing have 0
+	Version int64 `bson:"version" json:"version"`
+	// the texts the message had before, oldest first
+	Revisions []MessageRevision `bson:"revisions,omitempty" json:"revisions,omitempty"`
+}
+
+// MessageRevision is the text of a message at a version, UpdatedAt is when it was written
+type MessageRevision struct {
+	Version   int64     `bson:"version" json:"version"`
+	Info      string    `bson:"info" json:"info"`
+	UpdatedAt time.Time `bson:"updatedAt" json:"updatedAt"`
 }
 
 type ShareMessage struct {
@@ -0,0 +1,64 @@
+package model
+
+import (
+	"file-transfer/pkg/common"
+	"time"
+)
+
+// ShareAccess is one access of a share link, kept for the owner of the link
+type ShareAccess struct {
+	Id        string              `bson:"_id,omitempty" json:"id,omitempty"`
+	ShareKey  string              `bson:"shareKey" json:"shareKey"`
+	UserId    string              `bson:"userId" json:"-"`
+	Type      string              `bson:"type" json:"type"`
+	Target    string              `bson:"target" json:"target"`
+	Time      time.Time           `bson:"time" json:"time"`
+	Ip        string              `bson:"ip" json:"ip"`
+	UserAgent string              `bson:"userAgent" json:"userAgent"`
+	Outcome   common.ShareOutcome `bson:"outcome" json:"outcome"`
+	// sent to the client, less than the file when the download was cut
+	Bytes int64 `bson:"bytes" json:"bytes"`
+}
+
+// ShareCollection is what a collection share points at: the files under a folder at access time,
+// or a fixed set of files
+type ShareCollection struct {
+	Id      string   `bson:"_id,omitempty" json:"id,omitempty"`
+	UserId  string   `bson:"userId" json:"userId"`
+	Folder  string   `bson:"folder" json:"folder"`
+	FileIds []string `bson:"fileIds,omitempty" json:"fileIds,omitempty"`
+	// removed by mongo once the link is gone
+	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+	ExpireAt  time.Time `bson:"expireAt" json:"expireAt"`
+}
+
+// FileRequest lets anonymous visitors upload into a folder of the owner
+type FileRequest struct {
+	Id     string `bson:"_id,omitempty" json:"id,omitempty"`
+	UserId string `bson:"userId" json:"userId"`
+	Folder string `bson:"folder" json:"folder"`
+	// shown to the visitors
+	Note        string `bson:"note,omitempty" json:"note,omitempty"`
+	MaxFileSize int64  `bson:"maxFileSize" json:"maxFileSize"`
+	// 0 is unlimited
+	MaxFiles int64 `bson:"maxFiles" json:"maxFiles"`
+	// lower case with the dot, empty allows any
+	Extensions []string `bson:"extensions,omitempty" json:"extensions,omitempty"`
+	// files stored or being stored
+	Received  int64     `bson:"received" json:"received"`
+	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+	ExpireAt  time.Time `bson:"expireAt" json:"expireAt"`
+}
+
+// FileGrant gives another user of the instance access to a file, or to a folder and what is under it.
+// The recipient reads the files of the owner, nothing is copied
+type FileGrant struct {
+	Id        string `bson:"_id,omitempty" json:"id,omitempty"`
+	OwnerId   string `bson:"ownerId" json:"ownerId"`
+	GranteeId string `bson:"granteeId" json:"granteeId"`
+	// one of them is set
+	FileId    string             `bson:"fileId,omitempty" json:"fileId,omitempty"`
+	Folder    string             `bson:"folder,omitempty" json:"folder,omitempty"`
+	Access    common.GrantAccess `bson:"access" json:"access"`
+	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+}
@@ -9,4 +9,24 @@ type UserInfo struct {
 	Email     string    `bson:"email"  json:"email"`
 	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
 	UpdatedAt time.Time `bson:"updatedAt" json:"updatedAt"`
+	// part of the key of the signed download urls, a new salt revokes them all
+	UrlSalt string `bson:"urlSalt,omitempty" json:"-"`
+}
+
+// AppPassword lets clients like WebDAV log in without the account password
+type AppPassword struct {
+	Id        string    `bson:"_id,omitempty" json:"id,omitempty"`
+	UserId    string    `bson:"userId" json:"-"`
+	Name      string    `bson:"name" json:"name"`
+	Hash      string    `bson:"hash" json:"-"`
+	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+}
+
+// AccessKey signs S3 requests, SigV4 needs the plain secret so it is stored AES encrypted
+type AccessKey struct {
+	Id          string    `bson:"_id,omitempty" json:"id,omitempty"`
+	UserId      string    `bson:"userId" json:"-"`
+	AccessKeyId string    `bson:"accessKeyId" json:"accessKeyId"`
+	Secret      string    `bson:"secret" json:"-"`
+	CreatedAt   time.Time `bson:"createdAt" json:"createdAt"`
 }
@@ -0,0 +1,119 @@
+// Package pickupcode makes short codes that are easy to read out, like "482913" or "apple-river-42".
+package pickupcode
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+type Style string
+
+const (
+	STYLE_DIGITS Style = "digits"
+	STYLE_WORDS  Style = "words"
+
+	DIGITS_LEN = 6
+)
+
+// short words that don't sound alike, 256 of them
+var words = strings.Fields(`
+	acid acorn actor adult agent alarm album alert alpha amber angle apple april arena arrow atlas
+	audio award bacon badge baker bamboo banjo baron basil beach beard berry bible bingo birch blade
+	blank blaze bloom board bonus brain brass bread brick bride broom brush bucket buddy bugle cabin
+	cable cactus camel candy canoe canvas cargo carol cedar chalk charm chess chief cider cigar cinema
+	circle civil clock cloud clown coach cobra cocoa comet coral cotton couch cover crane crown cubic
+	curry daisy dance delta denim depot diary diesel dingo disco diver dock dragon drama dream eagle
+	early earth easel echo elbow elder empire engine envoy epoch equal event fable falcon fancy farm
+	feast fence ferry fever fiber field finch flame flask fleet flute focus forest fossil fox frame
+	frost fruit galaxy garden gecko ghost giant ginger glass globe glory goose grape gravel guitar gravy
+	habit harbor harp hazel heart hedge helmet hero honey hotel humor husky igloo image index indigo
+	inlet iron island ivory jacket jaguar jelly jewel jockey judge juice jungle kayak kettle kidney
+	kiosk kitten koala label ladder lagoon lake lemon lens lily limit linen lion llama lobby locket
+	lotus lunar magnet mango maple marble market meadow melon mercy metal meteor mint mirror mocha
+	modem moose motor mural museum nectar needle nickel ninja noble north novel nylon oasis ocean
+	olive onion opera orbit orchid otter oxide paddle palace panda paper parrot pasta peach pearl
+	pepper piano pilot pixel planet plaza plum polar pony radar radio raven river robin rocket royal
+	saddle salmon scarf shadow
+`)
+
+// Words returns two words and a number below 100, 6.5 million codes
+func Words() (string, error) {
+	first, err := randInt(len(words))
+	if err != nil {
+		return "", err
+	}
+	second, err := randInt(len(words))
+	if err != nil {
+		return "", err
+	}
+	number, err := randInt(100)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s-%s-%d", words[first], words[second], number), nil
+}
+
+// Digits returns DIGITS_LEN digits, leading zeros included
+func Digits() (string, error) {
+	var sb strings.Builder
+	for i := 0; i < DIGITS_LEN; i++ {
+		d, err := randInt(10)
+		if err != nil {
+			return "", err
+		}
+		sb.WriteByte(byte('0' + d))
+	}
+	return sb.String(), nil
+}
+
+func New(style Style) (string, error) {
+	switch style {
+	case STYLE_DIGITS:
+		return Digits()
+	case STYLE_WORDS:
+		return Words()
+	}
+	return "", fmt.Errorf("unknown pickup code style %q", style)
+}
+
+// Normalize reads a code as typed: case, spaces and dots between words don't matter.
+// The result is empty if it can't be a code.
+func Normalize(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	fields := strings.FieldsFunc(code, func(r rune) bool {
+		return r == ' ' || r == '-' || r == '.' || r == '_'
+	})
+	code = strings.Join(fields, "-")
+	if len(code) == 0 || len(code) > 32 {
+		return ""
+	}
+	for _, c := range code {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-') {
+			return ""
+		}
+	}
+	// digit codes are read out in groups, "482 913"
+	if digits := strings.ReplaceAll(code, "-", ""); isDigits(digits) {
+		return digits
+	}
+	return code
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return len(s) > 0
+}
+
+func randInt(n int) (int, error) {
+	v, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, err
+	}
+	return int(v.Int64()), nil
+}
@@ -0,0 +1,35 @@
+package pickupcode
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNew(t *testing.T) {
+	assert.Len(t, words, 256)
+	seen := make(map[string]bool)
+	for _, w := range words {
+		assert.False(t, seen[w], w)
+		seen[w] = true
+	}
+	code, err := New(STYLE_DIGITS)
+	assert.Nil(t, err)
+	assert.Regexp(t, regexp.MustCompile(`^[0-9]{6}$`), code)
+	code, err = New(STYLE_WORDS)
+	assert.Nil(t, err)
+	assert.Regexp(t, regexp.MustCompile(`^[a-z]+-[a-z]+-[0-9]{1,2}$`), code)
+	assert.Equal(t, code, Normalize(code))
+	_, err = New("emoji")
+	assert.NotNil(t, err)
+}
+
+func TestNormalize(t *testing.T) {
+	assert.Equal(t, "482913", Normalize(" 482 913 "))
+	assert.Equal(t, "482913", Normalize("482-913"))
+	assert.Equal(t, "apple-river-42", Normalize("Apple River 42"))
+	assert.Equal(t, "apple-river-42", Normalize("apple.river.42"))
+	assert.Equal(t, "", Normalize("apple/../42"))
+	assert.Equal(t, "", Normalize(" "))
+}
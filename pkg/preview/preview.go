@@ -0,0 +1,321 @@
+// Package preview reads a page of a text file as UTF-8, guesses its language and highlights it.
+package preview
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/alecthomas/chroma/v2"
+	"github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	textunicode "golang.org/x/text/encoding/unicode"
+)
+
+const (
+	// SNIFF_SIZE is read from the start of the file to detect the charset and binary content
+	SNIFF_SIZE int64 = 8 * 1024
+	// MAX_BINARY_RATIO of control characters in the sniffed text before the file counts as binary
+	MAX_BINARY_RATIO float64 = 0.02
+
+	LANGUAGE_PLAINTEXT string = "plaintext"
+	HIGHLIGHT_STYLE    string = "github"
+)
+
+var (
+	ErrNotText        = errors.New("not a text file")
+	ErrUnknownCharset = errors.New("unknown charset")
+	ErrOutOfRange     = errors.New("offset out of range")
+)
+
+type Options struct {
+	// file name, its extension is the first hint of the language
+	Name string
+	// bytes of the file in one page
+	Limit int64
+	// read the page ending at Offset instead of the one starting there
+	Tail bool
+	// start of a head page or end of a tail page, a negative tail offset is the end of the file
+	Offset int64
+	// skip the detection, any name of the WHATWG encoding index
+	Charset   string
+	Highlight bool
+}
+
+type Page struct {
+	Charset  string
+	Language string
+	// file bytes [Offset, End) are in the page
+	Offset  int64
+	End     int64
+	Size    int64
+	HasMore bool
+	Text    string
+	Html    string
+}
+
+type charset struct {
+	name     string
+	encoding encoding.Encoding
+	bom      int64
+	// bytes of one code unit and of a newline, pages are cut only between them
+	unit    int64
+	newline []byte
+}
+
+var (
+	charsetUTF8    = charset{name: "utf-8", encoding: textunicode.UTF8, unit: 1, newline: []byte{'\n'}}
+	charsetUTF16LE = charset{name: "utf-16le", encoding: textunicode.UTF16(textunicode.LittleEndian, textunicode.IgnoreBOM), bom: 2, unit: 2, newline: []byte{'\n', 0}}
+	charsetUTF16BE = charset{name: "utf-16be", encoding: textunicode.UTF16(textunicode.BigEndian, textunicode.IgnoreBOM), bom: 2, unit: 2, newline: []byte{0, '\n'}}
+
+	// tried in order when the file isn't UTF-8, the last one decodes anything
+	legacyCharsets = []charset{
+		{name: "gb18030", encoding: simplifiedchinese.GB18030, unit: 1, newline: []byte{'\n'}},
+		{name: "shift_jis", encoding: japanese.ShiftJIS, unit: 1, newline: []byte{'\n'}},
+		{name: "euc-kr", encoding: korean.EUCKR, unit: 1, newline: []byte{'\n'}},
+		{name: "windows-1252", encoding: charmap.Windows1252, unit: 1, newline: []byte{'\n'}},
+	}
+)
+
+// Read returns one page of src, which has size bytes
+func Read(src io.ReaderAt, size int64, opt Options) (*Page, error) {
+	sniff := make([]byte, min(SNIFF_SIZE, size))
+	if _, err := src.ReadAt(sniff, 0); err != nil && err != io.EOF {
+		return nil, err
+	}
+	cs, err := detectCharset(sniff, opt.Charset)
+	if err != nil {
+		return nil, err
+	}
+	sample, _ := cs.encoding.NewDecoder().Bytes(sniff[cs.bom:])
+	if !isText(sample, int64(len(sniff)) < size) {
+		return nil, ErrNotText
+	}
+
+	limit := max(opt.Limit-opt.Limit%cs.unit, cs.unit)
+	var start, end int64
+	if opt.Tail {
+		end = size
+		if opt.Offset >= 0 {
+			end = opt.Offset
+		}
+		if end > size || (end <= cs.bom && size > cs.bom) {
+			return nil, ErrOutOfRange
+		}
+		end = cs.bom + (end-cs.bom)/cs.unit*cs.unit
+		start = max(end-limit, cs.bom)
+	} else {
+		start = max(opt.Offset, cs.bom)
+		if start >= size && size > cs.bom {
+			return nil, ErrOutOfRange
+		}
+		start = cs.bom + (start-cs.bom)/cs.unit*cs.unit
+		end = min(start+limit, size)
+	}
+
+	raw := make([]byte, max(end-start, 0))
+	if _, err := src.ReadAt(raw, start); err != nil && err != io.EOF {
+		return nil, err
+	}
+	// keep whole lines at the cut side, a single line longer than the page is cut anyway
+	if opt.Tail && start > cs.bom {
+		if i := indexUnit(raw, cs.newline, cs.unit); i >= 0 && i+int64(len(cs.newline)) < int64(len(raw)) {
+			cut := i + int64(len(cs.newline))
+			raw, start = raw[cut:], start+cut
+		}
+	}
+	if !opt.Tail && end < size {
+		if i := lastIndexUnit(raw, cs.newline, cs.unit); i > 0 {
+			cut := i + int64(len(cs.newline))
+			raw, end = raw[:cut], start+cut
+		}
+	}
+	if cs.name == charsetUTF8.name {
+		raw, start, end = trimRunes(raw, start, end, start > cs.bom, end < size)
+	}
+
+	decoded, err := cs.encoding.NewDecoder().Bytes(raw)
+	if err != nil {
+		return nil, err
+	}
+	text := string(decoded)
+	// a multi-byte character of a legacy charset cut in half
+	if start > cs.bom {
+		text = strings.TrimPrefix(text, string(utf8.RuneError))
+	}
+	if end < size {
+		text = strings.TrimSuffix(text, string(utf8.RuneError))
+	}
+
+	page := &Page{
+		Charset: cs.name,
+		Offset:  start,
+		End:     end,
+		Size:    size,
+		HasMore: end < size,
+		Text:    text,
+	}
+	if opt.Tail {
+		page.HasMore = start > cs.bom
+	}
+	lexer := guessLexer(opt.Name, string(sample))
+	page.Language = LANGUAGE_PLAINTEXT
+	if lexer != nil {
+		page.Language = strings.ToLower(lexer.Config().Name)
+	}
+	if opt.Highlight {
+		if page.Html, err = Highlight(lexer, text); err != nil {
+			return nil, err
+		}
+	}
+	return page, nil
+}
+
+func detectCharset(sniff []byte, name string) (charset, error) {
+	if len(name) > 0 {
+		enc, err := htmlindex.Get(name)
+		if err != nil {
+			return charset{}, ErrUnknownCharset
+		}
+		canonical, _ := htmlindex.Name(enc)
+		switch canonical {
+		case charsetUTF8.name:
+			return withUTF8BOM(sniff), nil
+		case charsetUTF16LE.name:
+			return charsetUTF16LE.withBOM(sniff, []byte{0xFF, 0xFE}), nil
+		case charsetUTF16BE.name:
+			return charsetUTF16BE.withBOM(sniff, []byte{0xFE, 0xFF}), nil
+		}
+		return charset{name: canonical, encoding: enc, unit: 1, newline: []byte{'\n'}}, nil
+	}
+
+	switch {
+	case bytes.HasPrefix(sniff, []byte{0xEF, 0xBB, 0xBF}):
+		return withUTF8BOM(sniff), nil
+	case bytes.HasPrefix(sniff, []byte{0xFF, 0xFE}):
+		return charsetUTF16LE.withBOM(sniff, []byte{0xFF, 0xFE}), nil
+	case bytes.HasPrefix(sniff, []byte{0xFE, 0xFF}):
+		return charsetUTF16BE.withBOM(sniff, []byte{0xFE, 0xFF}), nil
+	}
+	// the sniffed bytes may end in the middle of a character
+	if valid, _, _ := trimRunes(sniff, 0, 0, false, true); utf8.Valid(valid) {
+		return charsetUTF8, nil
+	}
+	for _, cs := range legacyCharsets {
+		decoded, err := cs.encoding.NewDecoder().Bytes(sniff)
+		if err != nil {
+			continue
+		}
+		decoded = bytes.TrimSuffix(decoded, []byte(string(utf8.RuneError)))
+		if !bytes.ContainsRune(decoded, utf8.RuneError) {
+			return cs, nil
+		}
+	}
+	return legacyCharsets[len(legacyCharsets)-1], nil
+}
+
+func withUTF8BOM(sniff []byte) charset {
+	return charsetUTF8.withBOM(sniff, []byte{0xEF, 0xBB, 0xBF})
+}
+
+func (cs charset) withBOM(sniff []byte, bom []byte) charset {
+	cs.bom = 0
+	if bytes.HasPrefix(sniff, bom) {
+		cs.bom = int64(len(bom))
+	}
+	return cs
+}
+
+// isText counts the control characters, binary files are full of them
+func isText(sample []byte, truncated bool) bool {
+	total, control := 0, 0
+	for i := 0; i < len(sample); {
+		r, n := utf8.DecodeRune(sample[i:])
+		i += n
+		total++
+		switch {
+		case r == 0:
+			return false
+		case r == utf8.RuneError && n == 1 && truncated && i >= len(sample)-utf8.UTFMax:
+		case r == '\t', r == '\n', r == '\r', r == '\f', r == '\v', r == '\x1b':
+		case r == utf8.RuneError, unicode.IsControl(r):
+			control++
+		}
+	}
+	return total == 0 || float64(control)/float64(total) <= MAX_BINARY_RATIO
+}
+
+// trimRunes drops the partial UTF-8 characters at the cut ends of raw
+func trimRunes(raw []byte, start, end int64, head, tail bool) ([]byte, int64, int64) {
+	if head {
+		for i := 0; i < utf8.UTFMax-1 && len(raw) > 0 && !utf8.RuneStart(raw[0]); i++ {
+			raw, start = raw[1:], start+1
+		}
+	}
+	if tail {
+		for i := 1; i < utf8.UTFMax && i <= len(raw); i++ {
+			if utf8.RuneStart(raw[len(raw)-i]) {
+				if !utf8.FullRune(raw[len(raw)-i:]) {
+					end -= int64(i)
+					raw = raw[:len(raw)-i]
+				}
+				break
+			}
+		}
+	}
+	return raw, start, end
+}
+
+func indexUnit(raw, sep []byte, unit int64) int64 {
+	for i := int64(0); i+int64(len(sep)) <= int64(len(raw)); i += unit {
+		if bytes.Equal(raw[i:i+int64(len(sep))], sep) {
+			return i
+		}
+	}
+	return -1
+}
+
+func lastIndexUnit(raw, sep []byte, unit int64) int64 {
+	last := int64(len(raw)) - int64(len(sep))
+	for i := last - last%unit; i >= 0; i -= unit {
+		if bytes.Equal(raw[i:i+int64(len(sep))], sep) {
+			return i
+		}
+	}
+	return -1
+}
+
+// guessLexer trusts the file name first and the content second, nil for plain text
+func guessLexer(name string, sample string) chroma.Lexer {
+	if lexer := lexers.Match(name); lexer != nil {
+		return lexer
+	}
+	return lexers.Analyse(sample)
+}
+
+// Highlight renders text as HTML with inline styles, so it needs no stylesheet
+func Highlight(lexer chroma.Lexer, text string) (string, error) {
+	if lexer == nil {
+		lexer = lexers.Fallback
+	}
+	iterator, err := chroma.Coalesce(lexer).Tokenise(nil, text)
+	if err != nil {
+		return "", err
+	}
+	buf := &strings.Builder{}
+	formatter := html.New(html.WithClasses(false), html.TabWidth(4))
+	if err := formatter.Format(buf, styles.Get(HIGHLIGHT_STYLE), iterator); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
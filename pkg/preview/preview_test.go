@@ -0,0 +1,81 @@
+package preview
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	textunicode "golang.org/x/text/encoding/unicode"
+)
+
+func read(t *testing.T, content []byte, opt Options) *Page {
+	page, err := Read(bytes.NewReader(content), int64(len(content)), opt)
+	assert.Nil(t, err)
+	return page
+}
+
+func TestReadHead(t *testing.T) {
+	content := []byte("server:\n  port: 8089\n  addr: 0.0.0.0\n")
+	page := read(t, content, Options{Name: "config.yaml", Limit: 64 * 1024, Highlight: true})
+	assert.Equal(t, "utf-8", page.Charset)
+	assert.Equal(t, "yaml", page.Language)
+	assert.Equal(t, string(content), page.Text)
+	assert.False(t, page.HasMore)
+	assert.Contains(t, page.Html, "<span")
+
+	// the page ends after the last whole line
+	page = read(t, content, Options{Limit: 16})
+	assert.Equal(t, "server:\n", page.Text)
+	assert.True(t, page.HasMore)
+	page = read(t, content, Options{Limit: 16, Offset: page.End})
+	assert.Equal(t, "  port: 8089\n", page.Text)
+}
+
+func TestReadTail(t *testing.T) {
+	lines := make([]string, 100)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("2024-01-01 line %03d", i)
+	}
+	content := []byte(strings.Join(lines, "\n") + "\n")
+
+	// walk back to the start, every line once
+	got := make([]string, 0)
+	page := read(t, content, Options{Name: "app.log", Limit: 100, Tail: true, Offset: -1})
+	for {
+		got = append(strings.Split(strings.TrimSuffix(page.Text, "\n"), "\n"), got...)
+		if !page.HasMore {
+			break
+		}
+		page = read(t, content, Options{Name: "app.log", Limit: 100, Tail: true, Offset: page.Offset})
+	}
+	assert.Equal(t, lines, got)
+}
+
+func TestReadCharset(t *testing.T) {
+	gbk, _ := simplifiedchinese.GBK.NewEncoder().String("配置文件\n名称=测试\n")
+	page := read(t, []byte(gbk), Options{Name: "a.txt", Limit: 1024})
+	assert.Equal(t, "gb18030", page.Charset)
+	assert.Equal(t, "配置文件\n名称=测试\n", page.Text)
+
+	utf16, _ := textunicode.UTF16(textunicode.LittleEndian, textunicode.UseBOM).NewEncoder().String("hello\nworld\n")
+	page = read(t, []byte(utf16), Options{Limit: 12})
+	assert.Equal(t, "utf-16le", page.Charset)
+	assert.Equal(t, "hello\n", page.Text)
+
+	// a cut in the middle of a character is moved to the character start
+	page = read(t, []byte("测试测试"), Options{Limit: 4})
+	assert.Equal(t, "测", page.Text)
+	assert.Equal(t, int64(3), page.End)
+
+	_, err := Read(bytes.NewReader([]byte("x")), 1, Options{Limit: 10, Charset: "no-such-charset"})
+	assert.Equal(t, ErrUnknownCharset, err)
+}
+
+func TestReadBinary(t *testing.T) {
+	content := []byte{0x7F, 'E', 'L', 'F', 0x02, 0x01, 0x01, 0x00, 0x00}
+	_, err := Read(bytes.NewReader(content), int64(len(content)), Options{Limit: 1024})
+	assert.Equal(t, ErrNotText, err)
+}
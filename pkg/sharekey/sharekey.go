@@ -0,0 +1,115 @@
+// Package sharekey issues and verifies the random keys of share links.
+//
+// A key is "<kid>.<body>", the body is the url safe base64 of 16 random bytes, the issue time
+// in unix milliseconds and the first 16 bytes of their HMAC-SHA256 under the secret named kid.
+// Several secrets can be known at once while new keys are signed with the active one, so a
+// secret is rotated by adding a new one, making it active, and dropping the old one once the
+// links signed with it have expired.
+package sharekey
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	RANDOM_SIZE = 16
+	MAC_SIZE    = 16
+	// secrets shorter than this are refused
+	MIN_SECRET_SIZE = 32
+	MAX_KID_LEN     = 16
+
+	bodySize = RANDOM_SIZE + 8 + MAC_SIZE
+)
+
+var (
+	ErrMalformed  = errors.New("malformed share key")
+	ErrUnknownKid = errors.New("unknown share key id")
+	ErrSignature  = errors.New("share key signature mismatch")
+)
+
+type Signer struct {
+	secrets map[string][]byte
+	active  string
+}
+
+// NewSigner takes the secrets by key id, new keys are signed with the active one
+func NewSigner(secrets map[string][]byte, active string) (*Signer, error) {
+	s := &Signer{secrets: make(map[string][]byte, len(secrets)), active: active}
+	for kid, secret := range secrets {
+		if !validKid(kid) {
+			return nil, fmt.Errorf("share key id %q needs 1 to %d letters, digits, '-' or '_'", kid, MAX_KID_LEN)
+		}
+		if len(secret) < MIN_SECRET_SIZE {
+			return nil, fmt.Errorf("share key secret %q needs %d bytes at least", kid, MIN_SECRET_SIZE)
+		}
+		s.secrets[kid] = secret
+	}
+	if _, ok := s.secrets[active]; !ok {
+		return nil, fmt.Errorf("active share key %q is not configured", active)
+	}
+	return s, nil
+}
+
+// IsKey tells a key of this package from the keys made before it, which have no '.'
+func IsKey(key string) bool {
+	return strings.IndexByte(key, '.') > 0
+}
+
+// Issue makes a new key signed with the active secret
+func (s *Signer) Issue(now time.Time) (string, error) {
+	body := make([]byte, bodySize)
+	if _, err := rand.Read(body[:RANDOM_SIZE]); err != nil {
+		return "", err
+	}
+	binary.BigEndian.PutUint64(body[RANDOM_SIZE:], uint64(now.UnixMilli()))
+	copy(body[RANDOM_SIZE+8:], s.mac(s.active, body[:RANDOM_SIZE+8]))
+	return s.active + "." + base64.RawURLEncoding.EncodeToString(body), nil
+}
+
+// Verify checks the signature of the key and returns when it was issued
+func (s *Signer) Verify(key string) (time.Time, error) {
+	kid, encoded, ok := strings.Cut(key, ".")
+	if !ok || !validKid(kid) || base64.RawURLEncoding.DecodedLen(len(encoded)) != bodySize {
+		return time.Time{}, ErrMalformed
+	}
+	body, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || len(body) != bodySize {
+		return time.Time{}, ErrMalformed
+	}
+	if _, ok := s.secrets[kid]; !ok {
+		return time.Time{}, ErrUnknownKid
+	}
+	if !hmac.Equal(body[RANDOM_SIZE+8:], s.mac(kid, body[:RANDOM_SIZE+8])) {
+		return time.Time{}, ErrSignature
+	}
+	return time.UnixMilli(int64(binary.BigEndian.Uint64(body[RANDOM_SIZE:]))), nil
+}
+
+// mac binds the key id too, a body can't be moved under another secret
+func (s *Signer) mac(kid string, data []byte) []byte {
+	h := hmac.New(sha256.New, s.secrets[kid])
+	h.Write([]byte(kid))
+	h.Write([]byte{'.'})
+	h.Write(data)
+	return h.Sum(nil)[:MAC_SIZE]
+}
+
+func validKid(kid string) bool {
+	if len(kid) < 1 || len(kid) > MAX_KID_LEN {
+		return false
+	}
+	for _, c := range kid {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
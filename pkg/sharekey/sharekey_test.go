@@ -0,0 +1,64 @@
+package sharekey
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	secretA = []byte(strings.Repeat("a", MIN_SECRET_SIZE))
+	secretB = []byte(strings.Repeat("b", MIN_SECRET_SIZE))
+)
+
+func TestIssueVerify(t *testing.T) {
+	s, err := NewSigner(map[string][]byte{"k1": secretA}, "k1")
+	assert.Nil(t, err)
+	now := time.UnixMilli(time.Now().UnixMilli())
+	key, err := s.Issue(now)
+	assert.Nil(t, err)
+	assert.True(t, IsKey(key))
+	assert.True(t, strings.HasPrefix(key, "k1."))
+	issued, err := s.Verify(key)
+	assert.Nil(t, err)
+	assert.Equal(t, now, issued)
+
+	// same millisecond, still different keys
+	other, _ := s.Issue(now)
+	assert.NotEqual(t, key, other)
+
+	tampered := []byte(key)
+	tampered[len(tampered)-1] ^= 1
+	_, err = s.Verify(string(tampered))
+	assert.NotNil(t, err)
+	_, err = s.Verify("k1.abc")
+	assert.Equal(t, ErrMalformed, err)
+	assert.False(t, IsKey("c29tZSBvbGQga2V5"))
+}
+
+func TestRotation(t *testing.T) {
+	old, _ := NewSigner(map[string][]byte{"k1": secretA}, "k1")
+	oldKey, _ := old.Issue(time.Now())
+
+	rotated, err := NewSigner(map[string][]byte{"k1": secretA, "k2": secretB}, "k2")
+	assert.Nil(t, err)
+	_, err = rotated.Verify(oldKey)
+	assert.Nil(t, err)
+	newKey, _ := rotated.Issue(time.Now())
+	assert.True(t, strings.HasPrefix(newKey, "k2."))
+
+	// a body signed by k2 doesn't pass as k1
+	_, err = rotated.Verify("k1" + newKey[2:])
+	assert.Equal(t, ErrSignature, err)
+
+	retired, _ := NewSigner(map[string][]byte{"k2": secretB}, "k2")
+	_, err = retired.Verify(oldKey)
+	assert.Equal(t, ErrUnknownKid, err)
+
+	_, err = NewSigner(map[string][]byte{"k1": []byte("short")}, "k1")
+	assert.NotNil(t, err)
+	_, err = NewSigner(map[string][]byte{"k1": secretA}, "k2")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,166 @@
+package sigv4
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	STREAMING_PAYLOAD          = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	STREAMING_UNSIGNED_TRAILER = "STREAMING-UNSIGNED-PAYLOAD-TRAILER"
+
+	CHUNK_ALGORITHM = "AWS4-HMAC-SHA256-PAYLOAD"
+	EMPTY_SHA256    = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+var (
+	ErrUnsupportedPayload = errors.New("unsupported payload")
+	ErrMalformedChunk     = errors.New("malformed chunk")
+)
+
+// Body returns the request body which fails at the end if it doesn't match x-amz-content-sha256
+func Body(r *http.Request, auth *Authorization, secret string) (io.Reader, error) {
+	payloadHash := r.Header.Get(HEADER_CONTENT_HASH)
+	switch {
+	case payloadHash == "" || payloadHash == UNSIGNED_PAYLOAD:
+		return r.Body, nil
+	case payloadHash == STREAMING_PAYLOAD:
+		return NewSignedChunkedReader(r.Body, auth, secret, r.Header.Get(HEADER_DATE)), nil
+	case payloadHash == STREAMING_UNSIGNED_TRAILER:
+		return NewChunkedReader(r.Body), nil
+	case strings.HasPrefix(payloadHash, STREAMING_PREFIX):
+		return nil, ErrUnsupportedPayload
+	}
+	return NewPayloadReader(r.Body, payloadHash), nil
+}
+
+// ChunkedReader decodes an aws-chunked body, chunk signatures are checked if it has a signing key.
+// Trailers are skipped.
+type ChunkedReader struct {
+	r         *bufio.Reader
+	remaining int64
+	started   bool
+	err       error
+
+	key     []byte
+	amzDate string
+	scope   string
+	prevSig string
+	sig     string
+	h       hash.Hash
+}
+
+func NewChunkedReader(r io.Reader) *ChunkedReader {
+	return &ChunkedReader{r: bufio.NewReader(r)}
+}
+
+// NewSignedChunkedReader chains the chunk signatures from the seed signature of the Authorization header
+func NewSignedChunkedReader(r io.Reader, auth *Authorization, secret string, amzDate string) *ChunkedReader {
+	return &ChunkedReader{
+		r:       bufio.NewReader(r),
+		key:     SigningKey(secret, auth.Date, auth.Region, auth.Service),
+		amzDate: amzDate,
+		scope:   auth.scope(),
+		prevSig: auth.Signature,
+	}
+}
+
+func (c *ChunkedReader) Read(p []byte) (int, error) {
+	for c.remaining == 0 {
+		if c.err != nil {
+			return 0, c.err
+		}
+		c.err = c.nextChunk()
+	}
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.r.Read(p)
+	c.remaining -= int64(n)
+	if c.h != nil {
+		c.h.Write(p[:n])
+	}
+	if err == io.EOF {
+		c.err = io.ErrUnexpectedEOF
+		return n, c.err
+	}
+	return n, err
+}
+
+func (c *ChunkedReader) nextChunk() error {
+	if c.started {
+		if err := c.verify(); err != nil {
+			return err
+		}
+		if line, err := c.readLine(); err != nil || line != "" {
+			return ErrMalformedChunk
+		}
+	}
+	c.started = true
+
+	line, err := c.readLine()
+	if err != nil {
+		return ErrMalformedChunk
+	}
+	sizeStr, ext, _ := strings.Cut(line, ";")
+	size, err := strconv.ParseInt(sizeStr, 16, 64)
+	if err != nil || size < 0 {
+		return ErrMalformedChunk
+	}
+	c.sig = strings.TrimPrefix(ext, "chunk-signature=")
+	if c.key != nil {
+		c.h = sha256.New()
+	}
+	if size > 0 {
+		c.remaining = size
+		return nil
+	}
+
+	if err := c.verify(); err != nil {
+		return err
+	}
+	// the trailer section ends with an empty line
+	for {
+		line, err := c.readLine()
+		if err == io.EOF || (err == nil && line == "") {
+			return io.EOF
+		}
+		if err != nil {
+			return ErrMalformedChunk
+		}
+	}
+}
+
+func (c *ChunkedReader) readLine() (string, error) {
+	line, err := c.r.ReadSlice('\n')
+	if err != nil {
+		if err == io.EOF && len(line) > 0 {
+			err = ErrMalformedChunk
+		}
+		return "", err
+	}
+	return strings.TrimSuffix(string(line), "\r\n"), nil
+}
+
+func (c *ChunkedReader) verify() error {
+	if c.key == nil {
+		return nil
+	}
+	stringToSign := strings.Join([]string{
+		CHUNK_ALGORITHM, c.amzDate, c.scope, c.prevSig, EMPTY_SHA256, hex.EncodeToString(c.h.Sum(nil)),
+	}, "\n")
+	expected := hex.EncodeToString(hmacSHA256(c.key, []byte(stringToSign)))
+	if !hmac.Equal([]byte(expected), []byte(c.sig)) {
+		return ErrSignatureMismatch
+	}
+	c.prevSig = c.sig
+	return nil
+}
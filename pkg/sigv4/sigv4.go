@@ -0,0 +1,214 @@
+// Package sigv4 verifies AWS Signature Version 4 signed requests, as sent by S3 clients.
+package sigv4
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ALGORITHM        = "AWS4-HMAC-SHA256"
+	TIME_FORMAT      = "20060102T150405Z"
+	UNSIGNED_PAYLOAD = "UNSIGNED-PAYLOAD"
+	STREAMING_PREFIX = "STREAMING-"
+
+	HEADER_DATE         = "X-Amz-Date"
+	HEADER_CONTENT_HASH = "X-Amz-Content-Sha256"
+)
+
+var (
+	MAX_CLOCK_SKEW = 15 * time.Minute
+
+	ErrMissingAuth       = errors.New("missing authorization")
+	ErrMalformedAuth     = errors.New("malformed authorization")
+	ErrRequestTimeSkewed = errors.New("request time too skewed")
+	ErrSignatureMismatch = errors.New("signature does not match")
+	ErrContentMismatch   = errors.New("content sha256 does not match")
+)
+
+// Authorization is the parsed Authorization header
+type Authorization struct {
+	AccessKeyId   string
+	Date          string
+	Region        string
+	Service       string
+	SignedHeaders []string
+	Signature     string
+}
+
+func (a *Authorization) scope() string {
+	return strings.Join([]string{a.Date, a.Region, a.Service, "aws4_request"}, "/")
+}
+
+// ParseAuthorization reads
+// "AWS4-HMAC-SHA256 Credential=AKID/20130524/us-east-1/s3/aws4_request, SignedHeaders=host;x-amz-date, Signature=..."
+func ParseAuthorization(header string) (*Authorization, error) {
+	if len(header) < 1 {
+		return nil, ErrMissingAuth
+	}
+	if !strings.HasPrefix(header, ALGORITHM+" ") {
+		return nil, ErrMalformedAuth
+	}
+	auth := &Authorization{}
+	for _, field := range strings.Split(strings.TrimPrefix(header, ALGORITHM+" "), ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if !ok {
+			return nil, ErrMalformedAuth
+		}
+		switch k {
+		case "Credential":
+			parts := strings.Split(v, "/")
+			if len(parts) != 5 || parts[4] != "aws4_request" {
+				return nil, ErrMalformedAuth
+			}
+			auth.AccessKeyId, auth.Date, auth.Region, auth.Service = parts[0], parts[1], parts[2], parts[3]
+		case "SignedHeaders":
+			auth.SignedHeaders = strings.Split(v, ";")
+		case "Signature":
+			auth.Signature = v
+		}
+	}
+	if auth.AccessKeyId == "" || len(auth.SignedHeaders) < 1 || auth.Signature == "" {
+		return nil, ErrMalformedAuth
+	}
+	return auth, nil
+}
+
+// Verify checks the header signature of r with the secret of auth.AccessKeyId
+func Verify(r *http.Request, auth *Authorization, secret string, now time.Time) error {
+	amzDate := r.Header.Get(HEADER_DATE)
+	t, err := time.Parse(TIME_FORMAT, amzDate)
+	if err != nil || !strings.HasPrefix(amzDate, auth.Date) {
+		return ErrMalformedAuth
+	}
+	if skew := now.Sub(t); skew > MAX_CLOCK_SKEW || skew < -MAX_CLOCK_SKEW {
+		return ErrRequestTimeSkewed
+	}
+	signedHost := false
+	for _, h := range auth.SignedHeaders {
+		signedHost = signedHost || h == "host"
+	}
+	if !signedHost {
+		return ErrMalformedAuth
+	}
+	payloadHash := r.Header.Get(HEADER_CONTENT_HASH)
+	if payloadHash == "" {
+		payloadHash = UNSIGNED_PAYLOAD
+	}
+
+	canonical := CanonicalRequest(r, auth.SignedHeaders, payloadHash)
+	stringToSign := strings.Join([]string{ALGORITHM, amzDate, auth.scope(), hexSHA256([]byte(canonical))}, "\n")
+	key := SigningKey(secret, auth.Date, auth.Region, auth.Service)
+	expected := hex.EncodeToString(hmacSHA256(key, []byte(stringToSign)))
+	if !hmac.Equal([]byte(expected), []byte(auth.Signature)) {
+		return ErrSignatureMismatch
+	}
+	return nil
+}
+
+func CanonicalRequest(r *http.Request, signedHeaders []string, payloadHash string) string {
+	headers := make([]string, len(signedHeaders))
+	for i, name := range signedHeaders {
+		var value string
+		switch {
+		case name == "host":
+			value = r.Host
+		case name == "content-length" && r.Header.Get(name) == "":
+			// the server moves it out of the header
+			value = strconv.FormatInt(r.ContentLength, 10)
+		default:
+			value = strings.Join(r.Header.Values(name), ",")
+		}
+		headers[i] = name + ":" + strings.Join(strings.Fields(value), " ") + "\n"
+	}
+	return strings.Join([]string{
+		r.Method,
+		encodePath(r.URL.Path),
+		canonicalQuery(r.URL.Query()),
+		strings.Join(headers, ""),
+		strings.Join(signedHeaders, ";"),
+		payloadHash,
+	}, "\n")
+}
+
+func SigningKey(secret string, date string, region string, service string) []byte {
+	key := hmacSHA256([]byte("AWS4"+secret), []byte(date))
+	key = hmacSHA256(key, []byte(region))
+	key = hmacSHA256(key, []byte(service))
+	return hmacSHA256(key, []byte("aws4_request"))
+}
+
+func canonicalQuery(query url.Values) string {
+	pairs := make([]string, 0, len(query))
+	for k, values := range query {
+		for _, v := range values {
+			pairs = append(pairs, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+func encodePath(path string) string {
+	if path == "" {
+		return "/"
+	}
+	return uriEncode(path, false)
+}
+
+// uriEncode escapes everything but the RFC 3986 unreserved characters
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !encodeSlash) {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+func hexSHA256(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// PayloadReader fails at EOF if the body doesn't match the signed sha256
+type PayloadReader struct {
+	r        io.Reader
+	h        hash.Hash
+	expected string
+}
+
+func NewPayloadReader(r io.Reader, expected string) *PayloadReader {
+	return &PayloadReader{r: r, h: sha256.New(), expected: expected}
+}
+
+func (p *PayloadReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.h.Write(b[:n])
+	if err == io.EOF && hex.EncodeToString(p.h.Sum(nil)) != p.expected {
+		return n, ErrContentMismatch
+	}
+	return n, err
+}
@@ -0,0 +1,195 @@
+// Package textsearch parses search queries of words, "quoted phrases" and prefix* words, matches them
+// case-insensitively as whole words and cuts highlighted snippets out of the text found.
+// The patterns work with Go regexp and with the PCRE of mongo.
+package textsearch
+
+import (
+	"errors"
+	"html"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	MAX_QUERY_LENGTH = 256
+	MAX_TERMS        = 10
+)
+
+var (
+	ErrEmpty    = errors.New("empty query")
+	ErrTooLarge = errors.New("query too large")
+)
+
+// Term is a word or a phrase, all terms of a query must be found
+type Term struct {
+	Text   string
+	Phrase bool
+	// the word may go on, only for words
+	Prefix bool
+}
+
+// Parse splits the query to terms: "a phrase" in quotes, a word, or a word ending with * for words starting with it
+func Parse(query string) ([]Term, error) {
+	if len(query) > MAX_QUERY_LENGTH {
+		return nil, ErrTooLarge
+	}
+	terms := make([]Term, 0)
+	rest := query
+	for {
+		rest = strings.TrimLeft(rest, " \t\r\n")
+		if len(rest) == 0 {
+			break
+		}
+		if rest[0] == '"' {
+			// an unclosed quote takes the rest
+			phrase, after, _ := strings.Cut(rest[1:], `"`)
+			if words := strings.Fields(phrase); len(words) > 0 {
+				terms = append(terms, Term{Text: strings.Join(words, " "), Phrase: true})
+			}
+			rest = after
+			continue
+		}
+		end := strings.IndexAny(rest, " \t\r\n\"")
+		if end < 0 {
+			end = len(rest)
+		}
+		word := rest[:end]
+		rest = rest[end:]
+		term := Term{Text: strings.TrimRight(word, "*")}
+		term.Prefix = len(term.Text) < len(word)
+		if len(term.Text) > 0 {
+			terms = append(terms, term)
+		}
+	}
+	if len(terms) == 0 {
+		return nil, ErrEmpty
+	}
+	if len(terms) > MAX_TERMS {
+		return nil, ErrTooLarge
+	}
+	return terms, nil
+}
+
+func isWordByte(b byte) bool {
+	return b == '_' || ('0' <= b && b <= '9') || ('a' <= b && b <= 'z') || ('A' <= b && b <= 'Z')
+}
+
+// Pattern matches the term without case-insensitivity, the caller adds it. An end of the term
+// which is a letter or digit has to be an end of a word, other text like CJK matches anywhere
+func (t Term) Pattern() string {
+	words := strings.Fields(t.Text)
+	for i := range words {
+		words[i] = regexp.QuoteMeta(words[i])
+	}
+	p := strings.Join(words, `\s+`)
+	if isWordByte(t.Text[0]) {
+		p = `\b` + p
+	}
+	if !t.Prefix && isWordByte(t.Text[len(t.Text)-1]) {
+		p += `\b`
+	}
+	return p
+}
+
+// IndexWords are the whole words of the terms a text index finds as they are. Text holding all terms
+// holds one of them at least, so they narrow the search down before the patterns are checked
+func IndexWords(terms []Term) []string {
+	words := make([]string, 0)
+	for _, t := range terms {
+		if t.Prefix {
+			continue
+		}
+		for _, w := range strings.Fields(t.Text) {
+			if isIndexWord(w) {
+				words = append(words, w)
+			}
+		}
+	}
+	return words
+}
+
+func isIndexWord(w string) bool {
+	for i := 0; i < len(w); i++ {
+		if w[i] == '_' || !isWordByte(w[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Compile returns the case-insensitive patterns of the terms
+func Compile(terms []Term) []*regexp.Regexp {
+	res := make([]*regexp.Regexp, len(terms))
+	for i, t := range terms {
+		res[i] = regexp.MustCompile(`(?i)` + t.Pattern())
+	}
+	return res
+}
+
+// Match tells whether the text holds all terms
+func Match(text string, res []*regexp.Regexp) bool {
+	for _, re := range res {
+		if !re.MatchString(text) {
+			return false
+		}
+	}
+	return true
+}
+
+// Highlight cuts up to limit snippets around the matches, with about context bytes on each side.
+// The snippets are html escaped and the matches are wrapped in <mark></mark>
+func Highlight(text string, res []*regexp.Regexp, context int, limit int) []string {
+	matches := make([][]int, 0)
+	for _, re := range res {
+		matches = append(matches, re.FindAllStringIndex(text, -1)...)
+	}
+	if len(matches) == 0 {
+		return nil
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i][0] < matches[j][0] })
+	// overlapping matches are marked once
+	merged := [][]int{matches[0]}
+	for _, m := range matches[1:] {
+		last := merged[len(merged)-1]
+		if m[0] <= last[1] {
+			last[1] = max(last[1], m[1])
+			continue
+		}
+		merged = append(merged, m)
+	}
+
+	snippets := make([]string, 0, limit)
+	for i := 0; i < len(merged) && len(snippets) < limit; {
+		start := runeStart(text, max(merged[i][0]-context, 0))
+		end := merged[i][1]
+		var b strings.Builder
+		if start > 0 {
+			b.WriteString("…")
+		}
+		// matches close to each other share a snippet
+		for ; i < len(merged) && merged[i][0] <= end+context; i++ {
+			b.WriteString(html.EscapeString(text[start:merged[i][0]]))
+			b.WriteString("<mark>")
+			b.WriteString(html.EscapeString(text[merged[i][0]:merged[i][1]]))
+			b.WriteString("</mark>")
+			start, end = merged[i][1], merged[i][1]
+		}
+		end = runeStart(text, min(end+context, len(text)))
+		b.WriteString(html.EscapeString(text[start:end]))
+		if end < len(text) {
+			b.WriteString("…")
+		}
+		snippets = append(snippets, b.String())
+	}
+	return snippets
+}
+
+// runeStart moves i back to the start of the rune it is in
+func runeStart(s string, i int) int {
+	for i > 0 && i < len(s) && !utf8.RuneStart(s[i]) {
+		i--
+	}
+	return i
+}
@@ -0,0 +1,61 @@
+package textsearch
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	terms, err := Parse(`token  "api   key" deploy* "unclosed phrase`)
+	assert.Nil(t, err)
+	assert.Equal(t, []Term{
+		{Text: "token"},
+		{Text: "api key", Phrase: true},
+		{Text: "deploy", Prefix: true},
+		{Text: "unclosed phrase", Phrase: true},
+	}, terms)
+
+	_, err = Parse(`  "" * `)
+	assert.Equal(t, ErrEmpty, err)
+	_, err = Parse(strings.Repeat("a ", MAX_TERMS+1))
+	assert.Equal(t, ErrTooLarge, err)
+}
+
+func TestMatch(t *testing.T) {
+	for query, want := range map[string]bool{
+		"TOKEN":                        true,
+		"tok":                          false,
+		"tok*":                         true,
+		`"api key"`:                    true,
+		`"key api"`:                    false,
+		"https://example.com/a?b=1":    true,
+		"example.com token":            true,
+		"example.com missing":          false,
+		"文件":                           true,
+		`"the api key is" "api key"`:   true,
+		`"the  api key  is" https://*`: true,
+	} {
+		terms, err := Parse(query)
+		assert.Nil(t, err)
+		assert.Equal(t, want, Match("The API key is a token from https://example.com/a?b=1 发送文件", Compile(terms)), query)
+	}
+}
+
+func TestIndexWords(t *testing.T) {
+	terms, _ := Parse(`token deploy* "api key" example.com 文件 snake_case`)
+	assert.Equal(t, []string{"token", "api", "key"}, IndexWords(terms))
+}
+
+func TestHighlight(t *testing.T) {
+	terms, _ := Parse("key tok*")
+	text := "<b>key</b> " + strings.Repeat("x", 50) + " and the token, key again"
+	snippets := Highlight(text, Compile(terms), 10, 3)
+	assert.Equal(t, []string{
+		"&lt;b&gt;<mark>key</mark>&lt;/b&gt; xxxxx…",
+		"…x and the <mark>tok</mark>en, <mark>key</mark> again",
+	}, snippets)
+	assert.Equal(t, 1, len(Highlight(text, Compile(terms), 10, 1)))
+	assert.Nil(t, Highlight("nothing", Compile(terms), 10, 3))
+}
@@ -87,6 +87,11 @@ func ParseRequest(r *http.Request) (string, string, error) {
 	return Parse(t, config.key)
 }
 
+// ParseString 使用包级别配置的密钥解析 token 字符串.
+func ParseString(tokenString string) (string, string, error) {
+	return Parse(tokenString, config.key)
+}
+
 // Sign 使用 jwtSecret 签发 token，token 的 claims 中会存放传入的 subject.
 func Sign(id string, username string) (tokenString string, err error) {
 	// Token 的内容
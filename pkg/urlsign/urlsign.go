@@ -0,0 +1,110 @@
+// Package urlsign makes download urls which carry their own authorization:
+// the file, the user, the expire time and an optional client ip prefix, signed with HMAC-SHA256.
+package urlsign
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	PARAM_USER   = "uid"
+	PARAM_EXPIRE = "exp"
+	PARAM_IP     = "ip"
+	PARAM_SIG    = "sig"
+)
+
+var (
+	ErrMalformed = errors.New("malformed signed url")
+	ErrSignature = errors.New("signature mismatch")
+	ErrExpired   = errors.New("signed url expired")
+	ErrIp        = errors.New("client ip not allowed")
+)
+
+type Claims struct {
+	FileId string
+	UserId string
+	Expire time.Time
+	// a cidr like 203.0.113.0/24, empty allows any client
+	IpPrefix string
+}
+
+// UserKey is the signing key of a user, a new salt makes all urls signed before invalid
+func UserKey(secret []byte, userId string, salt string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(userId))
+	mac.Write([]byte{0})
+	mac.Write([]byte(salt))
+	return mac.Sum(nil)
+}
+
+// Sign returns the query of the signed url
+func Sign(key []byte, c *Claims) url.Values {
+	q := url.Values{}
+	q.Set(PARAM_USER, c.UserId)
+	q.Set(PARAM_EXPIRE, strconv.FormatInt(c.Expire.Unix(), 10))
+	if len(c.IpPrefix) > 0 {
+		q.Set(PARAM_IP, c.IpPrefix)
+	}
+	q.Set(PARAM_SIG, base64.RawURLEncoding.EncodeToString(signature(key, c)))
+	return q
+}
+
+// Parse reads the claims of a file from the query, they are trusted only after Verify
+func Parse(fileId string, q url.Values) (*Claims, error) {
+	exp, err := strconv.ParseInt(q.Get(PARAM_EXPIRE), 10, 64)
+	if err != nil || len(fileId) == 0 || len(q.Get(PARAM_USER)) == 0 || len(q.Get(PARAM_SIG)) == 0 {
+		return nil, ErrMalformed
+	}
+	c := &Claims{FileId: fileId, UserId: q.Get(PARAM_USER), Expire: time.Unix(exp, 0), IpPrefix: q.Get(PARAM_IP)}
+	if len(c.IpPrefix) > 0 {
+		if _, _, err := net.ParseCIDR(c.IpPrefix); err != nil {
+			return nil, ErrMalformed
+		}
+	}
+	return c, nil
+}
+
+// Verify checks the signature first, then the expire time and the client ip
+func Verify(key []byte, c *Claims, sig string, ip string, now time.Time) error {
+	got, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(got, signature(key, c)) {
+		return ErrSignature
+	}
+	if !now.Before(c.Expire) {
+		return ErrExpired
+	}
+	if len(c.IpPrefix) > 0 {
+		_, network, err := net.ParseCIDR(c.IpPrefix)
+		clientIp := net.ParseIP(ip)
+		if err != nil || clientIp == nil || !network.Contains(clientIp) {
+			return ErrIp
+		}
+	}
+	return nil
+}
+
+// IpPrefix is the network of an ip, bits are used for ipv4 and bits6 for ipv6
+func IpPrefix(ip string, bits int, bits6 int) (string, error) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return "", ErrMalformed
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		return (&net.IPNet{IP: v4.Mask(net.CIDRMask(bits, 32)), Mask: net.CIDRMask(bits, 32)}).String(), nil
+	}
+	return (&net.IPNet{IP: parsed.Mask(net.CIDRMask(bits6, 128)), Mask: net.CIDRMask(bits6, 128)}).String(), nil
+}
+
+func signature(key []byte, c *Claims) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(strings.Join([]string{c.FileId, c.UserId, strconv.FormatInt(c.Expire.Unix(), 10), c.IpPrefix}, "\n")))
+	return mac.Sum(nil)
+}
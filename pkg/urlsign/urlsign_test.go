@@ -0,0 +1,54 @@
+package urlsign
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var secret = []byte(strings.Repeat("s", 32))
+
+func TestSignVerify(t *testing.T) {
+	now := time.Now()
+	key := UserKey(secret, "u1", "")
+	q := Sign(key, &Claims{FileId: "f1", UserId: "u1", Expire: now.Add(time.Minute), IpPrefix: "203.0.113.0/24"})
+
+	c, err := Parse("f1", q)
+	assert.Nil(t, err)
+	assert.Nil(t, Verify(key, c, q.Get(PARAM_SIG), "203.0.113.9", now))
+	assert.Equal(t, ErrIp, Verify(key, c, q.Get(PARAM_SIG), "198.51.100.1", now))
+	assert.Equal(t, ErrExpired, Verify(key, c, q.Get(PARAM_SIG), "203.0.113.9", now.Add(time.Minute)))
+
+	// nothing of the url can be changed
+	other, _ := Parse("f2", q)
+	assert.Equal(t, ErrSignature, Verify(key, other, q.Get(PARAM_SIG), "203.0.113.9", now))
+	q.Set(PARAM_IP, "0.0.0.0/0")
+	widened, _ := Parse("f1", q)
+	assert.Equal(t, ErrSignature, Verify(key, widened, q.Get(PARAM_SIG), "203.0.113.9", now))
+	q.Del(PARAM_IP)
+	unbound, _ := Parse("f1", q)
+	assert.Equal(t, ErrSignature, Verify(key, unbound, q.Get(PARAM_SIG), "203.0.113.9", now))
+
+	// a new salt revokes
+	q = Sign(key, &Claims{FileId: "f1", UserId: "u1", Expire: now.Add(time.Minute)})
+	c, _ = Parse("f1", q)
+	assert.Nil(t, Verify(key, c, q.Get(PARAM_SIG), "", now))
+	assert.Equal(t, ErrSignature, Verify(UserKey(secret, "u1", "new"), c, q.Get(PARAM_SIG), "", now))
+
+	q.Set(PARAM_IP, "garbage")
+	_, err = Parse("f1", q)
+	assert.Equal(t, ErrMalformed, err)
+}
+
+func TestIpPrefix(t *testing.T) {
+	prefix, err := IpPrefix("203.0.113.77", 24, 64)
+	assert.Nil(t, err)
+	assert.Equal(t, "203.0.113.0/24", prefix)
+	prefix, err = IpPrefix("2001:db8:1:2:3::4", 24, 64)
+	assert.Nil(t, err)
+	assert.Equal(t, "2001:db8:1:2::/64", prefix)
+	_, err = IpPrefix("unknown", 24, 64)
+	assert.NotNil(t, err)
+}
@@ -1,16 +1,20 @@
 package util
 
 import (
+	"archive/zip"
 	"context"
 	"encoding/json"
+	"errors"
 	v1 "file-transfer/pkg/api/v1"
 	"file-transfer/pkg/errno"
+	"file-transfer/pkg/imagemeta"
 	"file-transfer/pkg/log"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"os"
+	"time"
 )
 
 func HttpReadBody(r *http.Request, customType interface{}) error {
@@ -32,25 +36,140 @@ func HttpReadBody(r *http.Request, customType interface{}) error {
 	return nil
 }
 
-func DownloadFileHandler(ctx context.Context, w http.ResponseWriter, data *v1.FileDownloadData) {
+// HEADER_BLOB_INTEGRITY warns that the served file failed its integrity scrub
+const HEADER_BLOB_INTEGRITY = "X-Blob-Integrity"
+
+// DOWNLOAD_WRITE_TIMEOUT is the time each chunk of a download has to be written. A throttled download
+// takes longer than the WriteTimeout of the server, so its deadline moves with every chunk
+var DOWNLOAD_WRITE_TIMEOUT = 15 * time.Second
+
+// DownloadFileHandler streams the file, or the ranges asked for, at the pace of its lease and returns the bytes sent
+func DownloadFileHandler(ctx context.Context, w http.ResponseWriter, r *http.Request, data *v1.FileDownloadData) int64 {
+	defer data.Lease.Release()
 	// Open the file
 	file, err := os.Open(data.Location)
 	if err != nil {
 		log.C(ctx).Errorw("downloadFileHandler open file failed", "file", data.Location)
 		errno.WriteErrorResponse(ctx, w, errno.InternalServerError)
-		return
+		return 0
 	}
 	defer file.Close()
+	// the stripped copy is the same for every request, If-Range can go by the stored file
+	var modTime time.Time
+	if info, err := file.Stat(); err == nil {
+		modTime = info.ModTime()
+	}
+
+	if data.StripMetadata {
+		// strip to a temp file first, a broken image must not be sent half cleaned
+		stripped, err := os.CreateTemp("", "file-transfer-strip-*.tmp")
+		if err != nil {
+			log.C(ctx).Errorw("downloadFileHandler create temp failed", "error", err)
+			errno.WriteErrorResponse(ctx, w, errno.InternalServerError)
+			return 0
+		}
+		defer func() {
+			stripped.Close()
+			os.Remove(stripped.Name())
+		}()
+		if err := imagemeta.Strip(file, stripped); err != nil {
+			log.C(ctx).Errorw("downloadFileHandler strip metadata failed", "file", data.Location, "error", err)
+			errno.WriteErrorResponse(ctx, w, errno.InternalServerError)
+			return 0
+		}
+		stripped.Seek(0, io.SeekStart)
+		file = stripped
+	}
 
 	// Set the headers
 	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", url.PathEscape(data.Name))) // Replace with the desired filename
-	w.Header().Set("Content-Length", fmt.Sprintf("%d", data.Size))
+	if data.Damaged {
+		w.Header().Set(HEADER_BLOB_INTEGRITY, "damaged")
+	}
+
+	// Stream the file to the response, ServeContent answers Range and If-Range and sets the length
+	body := &bodyWriter{ResponseWriter: w, body: countWriter{w: data.Lease.Writer(ctx, newDeadlineWriter(w))}}
+	http.ServeContent(body, r, data.Name, modTime, file)
+	return body.body.n
+}
+
+// bodyWriter sends the body of a response through its own writer
+type bodyWriter struct {
+	http.ResponseWriter
+	body countWriter
+}
+
+func (b *bodyWriter) Write(p []byte) (int, error) {
+	return b.body.Write(p)
+}
+
+// Unwrap lets http.ResponseController reach the connection
+func (b *bodyWriter) Unwrap() http.ResponseWriter {
+	return b.ResponseWriter
+}
+
+// deadlineWriter gives each write DOWNLOAD_WRITE_TIMEOUT, the lease waits between the writes
+type deadlineWriter struct {
+	w  http.ResponseWriter
+	rc *http.ResponseController
+}
+
+func newDeadlineWriter(w http.ResponseWriter) *deadlineWriter {
+	return &deadlineWriter{w: w, rc: http.NewResponseController(w)}
+}
 
-	// Stream the file to the response
-	_, err = io.Copy(w, file)
+func (d *deadlineWriter) Write(p []byte) (int, error) {
+	// a writer without deadlines, like a recorder, is written as it is
+	if err := d.rc.SetWriteDeadline(time.Now().Add(DOWNLOAD_WRITE_TIMEOUT)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return 0, err
+	}
+	return d.w.Write(p)
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// ZipFileHandler streams the files as one zip without compression, most shared files are compressed
+// already. It returns the bytes sent, a failure after the headers can only cut the zip short.
+func ZipFileHandler(ctx context.Context, w http.ResponseWriter, data *v1.CollectionZipData) int64 {
+	defer data.Lease.Release()
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", url.PathEscape(data.Name+".zip")))
+	counter := &countWriter{w: data.Lease.Writer(ctx, newDeadlineWriter(w))}
+	zw := zip.NewWriter(counter)
+	for _, item := range data.Files {
+		if err := writeZipEntry(zw, &item); err != nil {
+			log.C(ctx).Errorw("zipFileHandler failed to stream file", "file", item.Location, "error", err)
+			return counter.n
+		}
+	}
+	if err := zw.Close(); err != nil {
+		log.C(ctx).Errorw("zipFileHandler failed to finish zip", "error", err)
+	}
+	return counter.n
+}
+
+func writeZipEntry(zw *zip.Writer, item *v1.FileDownloadData) error {
+	file, err := os.Open(item.Location)
 	if err != nil {
-		log.C(ctx).Errorw("downloadFileHandler Failed to stream file", "file", data.Location, "error", err)
-		errno.WriteErrorResponse(ctx, w, errno.InternalServerError)
-		return
+		return err
+	}
+	defer file.Close()
+	entry, err := zw.CreateHeader(&zip.FileHeader{Name: item.Name, Method: zip.Store})
+	if err != nil {
+		return err
+	}
+	if item.StripMetadata {
+		return imagemeta.Strip(file, entry)
 	}
+	_, err = io.Copy(entry, file)
+	return err
 }
@@ -0,0 +1,66 @@
+package util
+
+import (
+	"bytes"
+	"context"
+	v1 "file-transfer/pkg/api/v1"
+	"file-transfer/pkg/bandwidth"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDownloadFileRange(t *testing.T) {
+	location := filepath.Join(t.TempDir(), "blob")
+	assert.Nil(t, os.WriteFile(location, []byte("0123456789"), 0644))
+	data := &v1.FileDownloadData{Location: location, Name: "a.txt", Size: 10}
+
+	r := httptest.NewRequest("GET", "/fs/key", nil)
+	r.Header.Set("Range", "bytes=4-")
+	w := httptest.NewRecorder()
+	sent := DownloadFileHandler(context.Background(), w, r, data)
+	assert.Equal(t, http.StatusPartialContent, w.Code)
+	assert.Equal(t, "bytes 4-9/10", w.Header().Get("Content-Range"))
+	body, _ := io.ReadAll(w.Body)
+	assert.Equal(t, "456789", string(body))
+	assert.Equal(t, int64(6), sent)
+
+	w = httptest.NewRecorder()
+	sent = DownloadFileHandler(context.Background(), w, httptest.NewRequest("GET", "/fs/key", nil), data)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "bytes", w.Header().Get("Accept-Ranges"))
+	assert.Equal(t, "10", w.Header().Get("Content-Length"))
+	assert.Equal(t, int64(10), sent)
+}
+
+func TestDownloadFileSlowLease(t *testing.T) {
+	defer func(timeout time.Duration) { DOWNLOAD_WRITE_TIMEOUT = timeout }(DOWNLOAD_WRITE_TIMEOUT)
+	DOWNLOAD_WRITE_TIMEOUT = time.Second
+	content := bytes.Repeat([]byte("x"), 4*bandwidth.CHUNK)
+	location := filepath.Join(t.TempDir(), "blob")
+	assert.Nil(t, os.WriteFile(location, content, 0644))
+
+	throttle := bandwidth.NewThrottle(0, time.Second)
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the whole download takes longer than the server lets a response take
+		lease, err := throttle.Acquire("key", bandwidth.Limits{Rate: 5 * bandwidth.CHUNK})
+		assert.Nil(t, err)
+		DownloadFileHandler(r.Context(), w, r, &v1.FileDownloadData{Location: location, Name: "a.bin", Size: int64(len(content)), Lease: lease})
+	}))
+	srv.Config.WriteTimeout = 200 * time.Millisecond
+	srv.Start()
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	assert.Nil(t, err)
+	assert.Equal(t, len(content), len(body))
+}
@@ -0,0 +1,51 @@
+package util
+
+import (
+	"errors"
+	"mime"
+	"mime/multipart"
+	"strings"
+)
+
+var ErrInvalidPath = errors.New("invalid path")
+
+// RawPartFileName returns the filename of a part as sent, folder uploads keep the relative path there.
+// multipart.Part.FileName only returns the base name.
+func RawPartFileName(part *multipart.Part) string {
+	_, params, err := mime.ParseMediaType(part.Header.Get("Content-Disposition"))
+	if err != nil {
+		return part.FileName()
+	}
+	return params["filename"]
+}
+
+// NormalizeFolder cleans a relative folder path to "a/b", the root folder is ""
+func NormalizeFolder(folder string) (string, error) {
+	folder = strings.ReplaceAll(folder, "\\", "/")
+	segments := make([]string, 0)
+	for _, seg := range strings.Split(folder, "/") {
+		switch seg {
+		case "", ".":
+			continue
+		case "..":
+			return "", ErrInvalidPath
+		}
+		segments = append(segments, seg)
+	}
+	return strings.Join(segments, "/"), nil
+}
+
+// SplitUploadPath splits a relative file path to folder and file name
+func SplitUploadPath(relPath string) (string, string, error) {
+	relPath = strings.ReplaceAll(relPath, "\\", "/")
+	idx := strings.LastIndex(relPath, "/")
+	name := relPath[idx+1:]
+	if name == "" || name == "." || name == ".." {
+		return "", "", ErrInvalidPath
+	}
+	folder, err := NormalizeFolder(relPath[:idx+1])
+	if err != nil {
+		return "", "", err
+	}
+	return folder, name, nil
+}
@@ -0,0 +1,60 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeFolder(t *testing.T) {
+	tests := []struct {
+		folder string
+		want   string
+		err    error
+	}{
+		{"", "", nil},
+		{"/", "", nil},
+		{"a", "a", nil},
+		{"/a/b/", "a/b", nil},
+		{"a//b///c", "a/b/c", nil},
+		{"./a/./b", "a/b", nil},
+		{`a\b`, "a/b", nil},
+		{"..", "", ErrInvalidPath},
+		{"a/../b", "", ErrInvalidPath},
+		{`a\..\b`, "", ErrInvalidPath},
+		{"a..b/..c", "a..b/..c", nil},
+	}
+	for _, tt := range tests {
+		got, err := NormalizeFolder(tt.folder)
+		assert.Equal(t, tt.err, err, tt.folder)
+		assert.Equal(t, tt.want, got, tt.folder)
+	}
+}
+
+func TestSplitUploadPath(t *testing.T) {
+	tests := []struct {
+		relPath string
+		folder  string
+		name    string
+		err     error
+	}{
+		{"a.txt", "", "a.txt", nil},
+		{"/a.txt", "", "a.txt", nil},
+		{"docs/a.txt", "docs", "a.txt", nil},
+		{"docs//sub///a.txt", "docs/sub", "a.txt", nil},
+		{`docs\a.txt`, "docs", "a.txt", nil},
+		{"", "", "", ErrInvalidPath},
+		{"/", "", "", ErrInvalidPath},
+		{"docs/", "", "", ErrInvalidPath},
+		{".", "", "", ErrInvalidPath},
+		{"docs/..", "", "", ErrInvalidPath},
+		{"../a.txt", "", "", ErrInvalidPath},
+		{"docs/../../a.txt", "", "", ErrInvalidPath},
+	}
+	for _, tt := range tests {
+		folder, name, err := SplitUploadPath(tt.relPath)
+		assert.Equal(t, tt.err, err, tt.relPath)
+		assert.Equal(t, tt.folder, folder, tt.relPath)
+		assert.Equal(t, tt.name, name, tt.relPath)
+	}
+}
@@ -1,6 +1,8 @@
 package util
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"strings"
 )
 
@@ -15,3 +17,9 @@ func Base64urlDecode(encoded string) string {
 	encoded = strings.ReplaceAll(encoded, "-", "+")
 	return strings.ReplaceAll(encoded, "_", "/")
 }
+
+// HashSHA256 returns the hex sha256 of a high entropy secret, not for user chosen passwords
+func HashSHA256(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
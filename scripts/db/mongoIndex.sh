@@ -1,5 +1,16 @@
 db.message.createIndex( { userId: 1, createdAt: -1 } )
 db.user.createIndex( { username: 1 }, { unique: true } )
+db.userfile.createIndex( { userId: 1, folder: 1, name: 1 } )
+db.userfile.createIndex( { expireAt: 1 }, { sparse: true } )
+db.filemeta.createIndex( { scrubbedAt: 1 } )
+db.userfolder.createIndex( { userId: 1, path: 1 }, { unique: true } )
+db.apppassword.createIndex( { userId: 1 } )
+db.accesskey.createIndex( { accessKeyId: 1 }, { unique: true } )
+db.accesskey.createIndex( { userId: 1 } )
+db.shareaccess.createIndex( { userId: 1, shareKey: 1, time: -1 } )
+db.shareaccess.createIndex( { time: 1 }, { expireAfterSeconds: 7776000 } )
+db.sharecollection.createIndex( { expireAt: 1 }, { expireAfterSeconds: 0 } )
+db.filerequest.createIndex( { expireAt: 1 }, { expireAfterSeconds: 0 } )
 
 # create cloudinary
 db("luce").createCollection("images")